@@ -0,0 +1,280 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/gomuks"
+	"go.mau.fi/gomuks/pkg/hicli"
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+// App tracks the parts of the websocket event stream that the subcommands need.
+type App struct {
+	Client *Client
+	JSON   bool
+	Output io.Writer
+
+	lock      sync.Mutex
+	accountID string
+	// The initial room list is sent for every account before the account is selected,
+	// so rooms are tracked for all accounts.
+	rooms        map[string]map[id.RoomID]*database.Room
+	initComplete map[string]chan struct{}
+	// sent contains send_complete results for events sent by this process.
+	sent        map[database.EventRowID]*database.Event
+	sentUpdated chan struct{}
+	tail        *tailState
+}
+
+type tailState struct {
+	roomID  id.RoomID
+	ready   bool
+	queued  []*database.Event
+	printed map[database.EventRowID]struct{}
+	// undecrypted contains events that are printed once they're decrypted.
+	undecrypted map[database.EventRowID]struct{}
+}
+
+func NewApp(cli *Client, jsonOutput bool) *App {
+	app := &App{
+		Client:       cli,
+		JSON:         jsonOutput,
+		Output:       os.Stdout,
+		rooms:        make(map[string]map[id.RoomID]*database.Room),
+		initComplete: make(map[string]chan struct{}),
+		sent:         make(map[database.EventRowID]*database.Event),
+		sentUpdated:  make(chan struct{}, 1),
+	}
+	cli.EventHandler = app.handleEvent
+	return app
+}
+
+func (a *App) getInitComplete(accountID string) chan struct{} {
+	ch, ok := a.initComplete[accountID]
+	if !ok {
+		ch = make(chan struct{})
+		a.initComplete[accountID] = ch
+	}
+	return ch
+}
+
+// selectAccount ensures the account the connection was opened for is logged in.
+func (a *App) selectAccount(ctx context.Context) error {
+	accountID := a.Client.AccountID
+	if accountID == "" {
+		accountID = gomuks.DefaultAccountID
+	}
+	var accounts []*gomuks.AccountInfo
+	err := a.Client.Request(ctx, "list_accounts", nil, &accounts)
+	if err != nil {
+		return err
+	}
+	idx := slices.IndexFunc(accounts, func(account *gomuks.AccountInfo) bool {
+		return account.AccountID == accountID
+	})
+	if idx < 0 {
+		return fmt.Errorf("account %q not found", accountID)
+	} else if !accounts[idx].IsLoggedIn {
+		return fmt.Errorf("account %s is not logged in", accountID)
+	}
+	a.lock.Lock()
+	a.accountID = accountID
+	a.lock.Unlock()
+	return nil
+}
+
+// waitForRooms waits until the initial room list of the selected account has been received.
+func (a *App) waitForRooms(ctx context.Context) ([]*database.Room, error) {
+	a.lock.Lock()
+	initComplete := a.getInitComplete(a.accountID)
+	a.lock.Unlock()
+	select {
+	case <-initComplete:
+	case <-a.Client.Done():
+		return nil, fmt.Errorf("connection closed while waiting for room list: %w", a.Client.Err())
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	rooms := make([]*database.Room, 0, len(a.rooms[a.accountID]))
+	for _, room := range a.rooms[a.accountID] {
+		rooms = append(rooms, room)
+	}
+	return rooms, nil
+}
+
+// waitForSend waits for the send_complete event of the given local echo.
+// The backend only dispatches it once sending has succeeded or failed permanently.
+func (a *App) waitForSend(ctx context.Context, rowID database.EventRowID) (*database.Event, error) {
+	for {
+		a.lock.Lock()
+		evt, ok := a.sent[rowID]
+		a.lock.Unlock()
+		if ok {
+			return evt, nil
+		}
+		select {
+		case <-a.sentUpdated:
+		case <-a.Client.Done():
+			return nil, fmt.Errorf("connection closed while waiting for message to be sent: %w", a.Client.Err())
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for message to be sent: %w", ctx.Err())
+		}
+	}
+}
+
+func (a *App) handleEvent(evt *hicli.JSONCommand) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	switch evt.Command {
+	case "sync_complete":
+		var data hicli.SyncComplete
+		if json.Unmarshal(evt.Data, &data) != nil {
+			return
+		}
+		a.handleSync(evt.AccountID, &data)
+	case "init_complete":
+		ch := a.getInitComplete(evt.AccountID)
+		select {
+		case <-ch:
+		default:
+			close(ch)
+		}
+	case "send_complete":
+		if evt.AccountID != a.accountID {
+			return
+		}
+		var data hicli.SendComplete
+		if json.Unmarshal(evt.Data, &data) != nil || data.Event == nil {
+			return
+		}
+		a.sent[data.Event.RowID] = data.Event
+		select {
+		case a.sentUpdated <- struct{}{}:
+		default:
+		}
+	case "events_decrypted":
+		if evt.AccountID != a.accountID || a.tail == nil {
+			return
+		}
+		var data hicli.EventsDecrypted
+		if json.Unmarshal(evt.Data, &data) != nil || data.RoomID != a.tail.roomID {
+			return
+		}
+		for _, decrypted := range data.Events {
+			if _, waiting := a.tail.undecrypted[decrypted.RowID]; waiting {
+				delete(a.tail.undecrypted, decrypted.RowID)
+				a.tailEvent(decrypted)
+			}
+		}
+	}
+}
+
+func (a *App) handleSync(accountID string, data *hicli.SyncComplete) {
+	rooms, ok := a.rooms[accountID]
+	if !ok || data.ClearState {
+		rooms = make(map[id.RoomID]*database.Room)
+		a.rooms[accountID] = rooms
+	}
+	for _, roomID := range data.LeftRooms {
+		delete(rooms, roomID)
+	}
+	for roomID, room := range data.Rooms {
+		if room.Meta != nil {
+			rooms[roomID] = room.Meta
+		}
+		if accountID == a.accountID && a.tail != nil && roomID == a.tail.roomID {
+			a.tailSync(room)
+		}
+	}
+}
+
+func (a *App) tailSync(room *hicli.SyncRoom) {
+	eventsByRowID := make(map[database.EventRowID]*database.Event, len(room.Events))
+	for _, evt := range room.Events {
+		eventsByRowID[evt.RowID] = evt
+	}
+	for _, tuple := range room.Timeline {
+		evt, ok := eventsByRowID[tuple.Event]
+		if !ok {
+			continue
+		} else if !a.tail.ready {
+			a.tail.queued = append(a.tail.queued, evt)
+		} else {
+			a.tailEvent(evt)
+		}
+	}
+}
+
+func (a *App) tailEvent(evt *database.Event) {
+	if _, alreadyPrinted := a.tail.printed[evt.RowID]; alreadyPrinted {
+		return
+	} else if evt.Type == "m.room.encrypted" && evt.Decrypted == nil && evt.DecryptionError == "" {
+		a.tail.undecrypted[evt.RowID] = struct{}{}
+		return
+	}
+	a.tail.printed[evt.RowID] = struct{}{}
+	a.printEvent(evt)
+}
+
+// startTail starts collecting new events in the given room. Events are queued until finishTail is called,
+// so that they can be printed after the history.
+func (a *App) startTail(roomID id.RoomID) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.tail = &tailState{
+		roomID:      roomID,
+		printed:     make(map[database.EventRowID]struct{}),
+		undecrypted: make(map[database.EventRowID]struct{}),
+	}
+}
+
+func (a *App) finishTail(history []*database.Event) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for _, evt := range history {
+		a.tailEvent(evt)
+	}
+	for _, evt := range a.tail.queued {
+		a.tailEvent(evt)
+	}
+	a.tail.queued = nil
+	a.tail.ready = true
+}
+
+func (a *App) printEvent(evt *database.Event) {
+	if a.JSON {
+		a.printJSON(evt)
+	} else if line := formatEvent(evt); line != "" {
+		_, _ = fmt.Fprintln(a.Output, line)
+	}
+}
+
+func (a *App) printJSON(data any) {
+	_ = json.NewEncoder(a.Output).Encode(data)
+}
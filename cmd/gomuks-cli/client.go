@@ -0,0 +1,295 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+	"go.mau.fi/util/exerrors"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+)
+
+const pingInterval = 15 * time.Second
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrTOTPRequired       = errors.New("two-factor authentication code required")
+	ErrInvalidTOTP        = errors.New("invalid two-factor authentication code")
+)
+
+// RPCError is an error response to a command sent over the websocket.
+type RPCError struct {
+	Command string
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Command, e.Message)
+}
+
+// Client is a connection to the websocket API of a running gomuks backend.
+type Client struct {
+	conn *websocket.Conn
+	// AccountID is sent with every command. Empty means the default account.
+	AccountID string
+	// EventHandler is called from the read loop for every event received from the server.
+	// It must not block, as responses to commands are read by the same loop.
+	EventHandler func(evt *hicli.JSONCommand)
+
+	nextRequestID  atomic.Int64
+	lastReceivedID atomic.Int64
+	waiters        map[int64]chan *hicli.JSONCommand
+	waitersLock    sync.Mutex
+	done           chan struct{}
+	readErr        error
+}
+
+// authenticate logs into the gomuks web server with the given credentials and returns the auth cookie.
+// The TOTP code is only needed if the server has two-factor authentication enabled.
+// The cookie is marked as secure, so it's extracted manually rather than using a cookie jar,
+// which would refuse to send it to a plain HTTP server on localhost.
+func authenticate(ctx context.Context, baseURL *url.URL, username, password, totpCode string) (*http.Cookie, error) {
+	authURL := baseURL.JoinPath("_gomuks", "auth")
+	authURL.RawQuery = url.Values{"device_label": {"gomuks-cli"}}.Encode()
+	var body io.Reader
+	if totpCode != "" {
+		body = bytes.NewReader(exerrors.Must(json.Marshal(map[string]string{"totp_code": totpCode})))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, authURL.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare auth request: %w", err)
+	}
+	req.SetBasicAuth(username, password)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send auth request: %w", err)
+	}
+	var errResp struct {
+		ErrCode string `json:"errcode"`
+		Err     string `json:"error"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&errResp)
+	_ = resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusUnauthorized:
+		switch errResp.ErrCode {
+		case "FI.MAU.GOMUKS.TOTP_REQUIRED":
+			return nil, ErrTOTPRequired
+		case "FI.MAU.GOMUKS.INVALID_TOTP":
+			return nil, ErrInvalidTOTP
+		default:
+			return nil, ErrInvalidCredentials
+		}
+	case http.StatusTooManyRequests:
+		return nil, fmt.Errorf("rate limited by auth endpoint: %s", errResp.Err)
+	default:
+		return nil, fmt.Errorf("unexpected status code %d from auth endpoint", resp.StatusCode)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "gomuks_auth" {
+			return cookie, nil
+		}
+	}
+	// Servers with authentication disabled don't return a cookie.
+	return nil, nil
+}
+
+// Connect authenticates and opens a websocket connection to the given gomuks server.
+// The server only streams events of the given account. Empty means the default account.
+func Connect(ctx context.Context, baseURL *url.URL, username, password, totpCode, accountID string) (*Client, error) {
+	cookie, err := authenticate(ctx, baseURL, username, password, totpCode)
+	if err != nil {
+		return nil, err
+	}
+	wsURL := baseURL.JoinPath("_gomuks", "websocket")
+	switch wsURL.Scheme {
+	case "https":
+		wsURL.Scheme = "wss"
+	case "http":
+		wsURL.Scheme = "ws"
+	}
+	if accountID != "" {
+		wsURL.RawQuery = url.Values{"account_id": {accountID}}.Encode()
+	}
+	headers := make(http.Header)
+	if cookie != nil {
+		headers.Set("Cookie", cookie.String())
+	}
+	conn, resp, err := websocket.Dial(ctx, wsURL.String(), &websocket.DialOptions{HTTPHeader: headers})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("account %q not found", accountID)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to connect to websocket: %w", err)
+	}
+	// Initial sync payloads can be much larger than the default read limit.
+	conn.SetReadLimit(-1)
+	cli := &Client{
+		AccountID: accountID,
+		conn:      conn,
+		waiters:   make(map[int64]chan *hicli.JSONCommand),
+		done:      make(chan struct{}),
+	}
+	return cli, nil
+}
+
+// Start begins reading messages from the websocket. The event handler must be set before calling.
+func (c *Client) Start(ctx context.Context) {
+	go c.readLoop(ctx)
+	go c.pingLoop(ctx)
+}
+
+// Done returns a channel that is closed when the connection is closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that caused the connection to close.
+func (c *Client) Err() error {
+	return c.readErr
+}
+
+// Close logs out the web session created by Connect and closes the connection.
+func (c *Client) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// The server closes the websocket when the session is revoked, so there's no response if it succeeds.
+	_ = c.Request(ctx, "revoke_web_session", map[string]any{}, nil)
+	_ = c.conn.Close(websocket.StatusNormalClosure, "")
+}
+
+func (c *Client) readLoop(ctx context.Context) {
+	defer close(c.done)
+	for {
+		var cmd hicli.JSONCommand
+		msgType, data, err := c.conn.Read(ctx)
+		if err != nil {
+			c.readErr = err
+			return
+		} else if msgType != websocket.MessageText {
+			continue
+		} else if err = json.Unmarshal(data, &cmd); err != nil {
+			c.readErr = fmt.Errorf("failed to parse message from server: %w", err)
+			_ = c.conn.Close(websocket.StatusUnsupportedData, "Invalid JSON")
+			return
+		}
+		if cmd.RequestID < 0 {
+			c.lastReceivedID.Store(cmd.RequestID)
+		}
+		switch cmd.Command {
+		case "response", "error", "pong":
+			c.waitersLock.Lock()
+			waiter, ok := c.waiters[cmd.RequestID]
+			delete(c.waiters, cmd.RequestID)
+			c.waitersLock.Unlock()
+			if ok {
+				waiter <- &cmd
+			}
+		default:
+			if c.EventHandler != nil {
+				c.EventHandler(&cmd)
+			}
+		}
+	}
+}
+
+func (c *Client) pingLoop(ctx context.Context) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, err := c.send(ctx, "ping", map[string]int64{"last_received_id": c.lastReceivedID.Load()}, false)
+			if err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, command string, data any, withAccount bool) (<-chan *hicli.JSONCommand, error) {
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request data: %w", err)
+	}
+	req := &hicli.JSONCommand{
+		Command:   command,
+		RequestID: c.nextRequestID.Add(1),
+		Data:      rawData,
+	}
+	if withAccount {
+		req.AccountID = c.AccountID
+	}
+	waiter := make(chan *hicli.JSONCommand, 1)
+	c.waitersLock.Lock()
+	c.waiters[req.RequestID] = waiter
+	c.waitersLock.Unlock()
+	payload, err := json.Marshal(req)
+	if err == nil {
+		err = c.conn.Write(ctx, websocket.MessageText, payload)
+	}
+	if err != nil {
+		c.waitersLock.Lock()
+		delete(c.waiters, req.RequestID)
+		c.waitersLock.Unlock()
+		return nil, fmt.Errorf("failed to send %s request: %w", command, err)
+	}
+	return waiter, nil
+}
+
+// Request sends a command to the server and waits for the response. If resp is non-nil,
+// the response data is unmarshaled into it.
+func (c *Client) Request(ctx context.Context, command string, data, resp any) error {
+	waiter, err := c.send(ctx, command, data, true)
+	if err != nil {
+		return err
+	}
+	select {
+	case res := <-waiter:
+		if res.Command == "error" {
+			var msg string
+			_ = json.Unmarshal(res.Data, &msg)
+			return &RPCError{Command: command, Message: msg}
+		} else if resp != nil {
+			err = json.Unmarshal(res.Data, resp)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s response: %w", command, err)
+			}
+		}
+		return nil
+	case <-c.done:
+		return fmt.Errorf("connection closed while waiting for %s response: %w", command, c.readErr)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
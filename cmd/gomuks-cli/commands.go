@@ -0,0 +1,255 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+type Command struct {
+	Usage   string
+	MinArgs int
+	// Streaming commands run until interrupted, so the timeout isn't applied to them.
+	Streaming bool
+	Handler   func(ctx context.Context, app *App, args []string) error
+}
+
+var commands = map[string]*Command{
+	"rooms": {
+		Usage:   "rooms",
+		Handler: cmdRooms,
+	},
+	"send": {
+		Usage:   "send <room> [message...]",
+		MinArgs: 1,
+		Handler: cmdSend,
+	},
+	"tail": {
+		Usage:     "tail <room>",
+		MinArgs:   1,
+		Streaming: true,
+		Handler:   cmdTail,
+	},
+	"mark-read": {
+		Usage:   "mark-read <room> [event ID]",
+		MinArgs: 1,
+		Handler: cmdMarkRead,
+	},
+	"get-event": {
+		Usage:   "get-event <room> <event ID>",
+		MinArgs: 2,
+		Handler: cmdGetEvent,
+	},
+	"join": {
+		Usage:   "join <room ID or alias> [via...]",
+		MinArgs: 1,
+		Handler: cmdJoin,
+	},
+}
+
+// resolveRoom converts the given room ID or alias into a room ID.
+func (a *App) resolveRoom(ctx context.Context, room string) (id.RoomID, error) {
+	switch {
+	case strings.HasPrefix(room, "!"):
+		return id.RoomID(room), nil
+	case strings.HasPrefix(room, "#"):
+		var resp mautrix.RespAliasResolve
+		err := a.Client.Request(ctx, "resolve_alias", map[string]any{"alias": room}, &resp)
+		if err != nil {
+			return "", err
+		}
+		return resp.RoomID, nil
+	default:
+		return "", fmt.Errorf("invalid room %q: must be a room ID or alias", room)
+	}
+}
+
+func cmdRooms(ctx context.Context, app *App, args []string) error {
+	rooms, err := app.waitForRooms(ctx)
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(rooms, func(a, b *database.Room) int {
+		return cmp.Or(
+			b.SortingTimestamp.Compare(a.SortingTimestamp.Time),
+			strings.Compare(string(a.ID), string(b.ID)),
+		)
+	})
+	if app.JSON {
+		app.printJSON(rooms)
+		return nil
+	}
+	tw := tabwriter.NewWriter(app.Output, 0, 4, 2, ' ', 0)
+	for _, room := range rooms {
+		var name string
+		if room.Name != nil {
+			name = *room.Name
+		}
+		var unread string
+		if room.UnreadHighlights > 0 {
+			unread = fmt.Sprintf("%d unread, %d highlights", room.UnreadMessages, room.UnreadHighlights)
+		} else if room.UnreadMessages > 0 {
+			unread = fmt.Sprintf("%d unread", room.UnreadMessages)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", room.ID, name, unread)
+	}
+	return tw.Flush()
+}
+
+func cmdSend(ctx context.Context, app *App, args []string) error {
+	roomID, err := app.resolveRoom(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	text := strings.Join(args[1:], " ")
+	if text == "" || text == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read message from stdin: %w", err)
+		}
+		text = strings.TrimRight(string(data), "\n")
+	}
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("message is empty")
+	}
+	var localEcho *database.Event
+	err = app.Client.Request(ctx, "send_message", map[string]any{"room_id": roomID, "text": text}, &localEcho)
+	if err != nil {
+		return err
+	} else if localEcho == nil {
+		// Commands like /invite don't send an event
+		return nil
+	}
+	evt, err := app.waitForSend(ctx, localEcho.RowID)
+	if err != nil {
+		return err
+	} else if evt.SendError != "" {
+		return fmt.Errorf("failed to send message: %s", evt.SendError)
+	}
+	if app.JSON {
+		app.printJSON(evt)
+	} else {
+		_, _ = fmt.Fprintln(app.Output, evt.ID)
+	}
+	return nil
+}
+
+func cmdTail(ctx context.Context, app *App, args []string) error {
+	roomID, err := app.resolveRoom(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	app.startTail(roomID)
+	var history []*database.Event
+	if *tailLines > 0 {
+		var resp hicli.PaginationResponse
+		err = app.Client.Request(ctx, "paginate", map[string]any{"room_id": roomID, "limit": *tailLines}, &resp)
+		if err != nil {
+			return err
+		}
+		history = resp.Events
+		slices.Reverse(history)
+	}
+	app.finishTail(history)
+	select {
+	case <-app.Client.Done():
+		return fmt.Errorf("connection closed: %w", app.Client.Err())
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func cmdMarkRead(ctx context.Context, app *App, args []string) error {
+	roomID, err := app.resolveRoom(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	var eventID id.EventID
+	if len(args) > 1 {
+		eventID = id.EventID(args[1])
+	} else {
+		var resp hicli.PaginationResponse
+		err = app.Client.Request(ctx, "paginate", map[string]any{"room_id": roomID, "limit": 1}, &resp)
+		if err != nil {
+			return err
+		} else if len(resp.Events) == 0 {
+			return fmt.Errorf("room has no events to mark as read")
+		}
+		eventID = resp.Events[0].ID
+	}
+	err = app.Client.Request(ctx, "mark_read", map[string]any{
+		"room_id":      roomID,
+		"event_id":     eventID,
+		"receipt_type": event.ReceiptTypeRead,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if app.JSON {
+		app.printJSON(map[string]any{"room_id": roomID, "event_id": eventID})
+	} else {
+		_, _ = fmt.Fprintln(app.Output, eventID)
+	}
+	return nil
+}
+
+func cmdGetEvent(ctx context.Context, app *App, args []string) error {
+	roomID, err := app.resolveRoom(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	var evt *database.Event
+	err = app.Client.Request(ctx, "get_event", map[string]any{"room_id": roomID, "event_id": args[1]}, &evt)
+	if err != nil {
+		return err
+	} else if evt == nil {
+		return fmt.Errorf("event not found")
+	}
+	app.printEvent(evt)
+	return nil
+}
+
+func cmdJoin(ctx context.Context, app *App, args []string) error {
+	var resp mautrix.RespJoinRoom
+	err := app.Client.Request(ctx, "join_room", map[string]any{
+		"room_id_or_alias": args[0],
+		"via":              args[1:],
+	}, &resp)
+	if err != nil {
+		return err
+	}
+	if app.JSON {
+		app.printJSON(&resp)
+	} else {
+		_, _ = fmt.Fprintln(app.Output, resp.RoomID)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+type messageContent struct {
+	MsgType    event.MessageType `json:"msgtype"`
+	Body       string            `json:"body"`
+	NewContent *messageContent   `json:"m.new_content"`
+	RelatesTo  *event.RelatesTo  `json:"m.relates_to"`
+}
+
+// formatEvent renders an event as a single line (or multiple lines for multi-line messages) of text.
+// Events that aren't interesting in a plain text log, like reactions and redactions, return an empty string.
+func formatEvent(evt *database.Event) string {
+	prefix := fmt.Sprintf("[%s] ", evt.Timestamp.Local().Format(timeFormat))
+	evtType, content := evt.Type, evt.Content
+	if evt.Decrypted != nil {
+		evtType, content = evt.DecryptedType, evt.Decrypted
+	}
+	var body string
+	switch {
+	case evt.RedactedBy != "":
+		body = fmt.Sprintf("%s: (redacted)", evt.Sender)
+	case evtType == event.EventEncrypted.Type:
+		body = fmt.Sprintf("%s: (failed to decrypt: %s)", evt.Sender, evt.DecryptionError)
+	case evtType == event.EventMessage.Type || evtType == event.EventSticker.Type:
+		var msg messageContent
+		_ = json.Unmarshal(content, &msg)
+		edited := ""
+		if msg.RelatesTo != nil && msg.RelatesTo.Type == event.RelReplace && msg.NewContent != nil {
+			msg = *msg.NewContent
+			edited = " (edited)"
+		}
+		switch msg.MsgType {
+		case event.MsgEmote:
+			body = fmt.Sprintf("* %s %s%s", evt.Sender, msg.Body, edited)
+		case event.MsgText, event.MsgNotice, "":
+			body = fmt.Sprintf("%s: %s%s", evt.Sender, msg.Body, edited)
+		default:
+			body = fmt.Sprintf("%s: [%s] %s%s", evt.Sender, msg.MsgType, msg.Body, edited)
+		}
+	case evtType == event.StateMember.Type && evt.StateKey != nil:
+		var member event.MemberEventContent
+		_ = json.Unmarshal(content, &member)
+		if *evt.StateKey == evt.Sender.String() {
+			body = fmt.Sprintf("* %s membership: %s", evt.Sender, member.Membership)
+		} else {
+			body = fmt.Sprintf("* %s set membership of %s to %s", evt.Sender, *evt.StateKey, member.Membership)
+		}
+	case evt.StateKey != nil:
+		body = fmt.Sprintf("* %s changed %s", evt.Sender, evtType)
+	default:
+		return ""
+	}
+	return prefix + strings.ReplaceAll(body, "\n", "\n"+strings.Repeat(" ", len(prefix)))
+}
@@ -0,0 +1,184 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/chzyer/readline"
+	"golang.org/x/term"
+	flag "maunium.net/go/mauflag"
+
+	"go.mau.fi/gomuks/version"
+)
+
+var wantHelp, _ = flag.MakeHelpFlag()
+var wantVersion = flag.MakeFull("v", "version", "View gomuks version and quit.", "false").Bool()
+var serverURL = flag.MakeFull("s", "server", "URL of the gomuks web server. Defaults to $GOMUKS_URL or http://localhost:29325.", "").String()
+var username = flag.MakeFull("u", "username", "Username for the gomuks web server. Defaults to $GOMUKS_USERNAME.", "").String()
+var accountID = flag.MakeFull("a", "account", "ID of the account to use. Defaults to the default account.", "").String()
+var jsonOutput = flag.MakeFull("j", "json", "Output JSON instead of human-readable text.", "false").Bool()
+var tailLines = flag.MakeFull("n", "lines", "Number of past messages to print before following a room with tail.", "10").Int()
+var timeout = flag.MakeFull("t", "timeout", "Timeout in seconds for commands other than tail.", "60").Int()
+
+const commandHelp = `Commands:
+  rooms                          List joined rooms, most recently active first.
+  send <room> [message...]       Send a message. The message is read from stdin if omitted or "-".
+                                 Slash commands like /notice and /me work like in gomuks web.
+  tail <room>                    Print recent messages and follow new ones until interrupted.
+  mark-read <room> [event ID]    Send a read receipt for the given event, or the latest event.
+  get-event <room> <event ID>    Print a single event.
+  join <room ID or alias> [via...]
+                                 Join a room.
+
+Rooms can be specified by ID or alias. The password is read from $GOMUKS_PASSWORD,
+or prompted for if stdin is a terminal. If the server has two-factor authentication
+enabled, the code is read from $GOMUKS_TOTP_CODE or prompted for in the same way.`
+
+func main() {
+	flag.SetHelpTitles(
+		"gomuks cli - Send and read Matrix messages through a running gomuks web server.",
+		"gomuks-cli [-hvj] [-s url] [-u username] [-a account] <command> [args...]",
+	)
+	err := flag.Parse()
+
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		flag.PrintHelp()
+		os.Exit(1)
+	} else if *wantHelp {
+		flag.PrintHelp()
+		fmt.Println()
+		fmt.Println(commandHelp)
+		os.Exit(0)
+	} else if *wantVersion {
+		fmt.Println(version.Description)
+		os.Exit(0)
+	} else if flag.NArg() == 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "No command specified")
+		_, _ = fmt.Fprintln(os.Stderr, commandHelp)
+		os.Exit(1)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		_, _ = fmt.Fprintf(os.Stderr, "Unknown command %q\n", flag.Arg(0))
+		_, _ = fmt.Fprintln(os.Stderr, commandHelp)
+		os.Exit(1)
+	}
+	args := flag.Args()[1:]
+	if len(args) < cmd.MinArgs {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: gomuks-cli %s\n", cmd.Usage)
+		os.Exit(1)
+	}
+
+	baseURL, err := getServerURL()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	user, password, err := getCredentials()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if !cmd.Streaming {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, time.Duration(*timeout)*time.Second)
+		defer cancelTimeout()
+	}
+	cli, err := Connect(ctx, baseURL, user, password, os.Getenv("GOMUKS_TOTP_CODE"), *accountID)
+	if errors.Is(err, ErrTOTPRequired) && term.IsTerminal(int(os.Stdin.Fd())) {
+		var totpCode string
+		totpCode, err = readline.Line("Two-factor authentication code: ")
+		if err == nil {
+			cli, err = Connect(ctx, baseURL, user, password, strings.TrimSpace(totpCode), *accountID)
+		}
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to connect to gomuks:", err)
+		os.Exit(2)
+	}
+	app := NewApp(cli, *jsonOutput)
+	cli.Start(ctx)
+	err = app.selectAccount(ctx)
+	if err == nil {
+		err = cmd.Handler(ctx, app, args)
+	}
+	cli.Close()
+	if errors.Is(err, context.Canceled) && cmd.Streaming {
+		err = nil
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func getServerURL() (*url.URL, error) {
+	rawURL := *serverURL
+	if rawURL == "" {
+		rawURL = os.Getenv("GOMUKS_URL")
+	}
+	if rawURL == "" {
+		rawURL = "http://localhost:29325"
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL: %w", err)
+	} else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("invalid server URL: scheme must be http or https")
+	}
+	return parsed, nil
+}
+
+func getCredentials() (user, password string, err error) {
+	user = *username
+	if user == "" {
+		user = os.Getenv("GOMUKS_USERNAME")
+	}
+	password, hasPassword := os.LookupEnv("GOMUKS_PASSWORD")
+	if hasPassword && user != "" {
+		return
+	} else if !term.IsTerminal(int(os.Stdin.Fd())) {
+		err = fmt.Errorf("username and password must be provided with --username and $GOMUKS_PASSWORD when stdin is not a terminal")
+		return
+	}
+	if user == "" {
+		user, err = readline.Line("Username: ")
+		if err != nil {
+			return
+		}
+		user = strings.TrimSpace(user)
+	}
+	if !hasPassword {
+		var passwordBytes []byte
+		passwordBytes, err = readline.Password("Password: ")
+		password = string(passwordBytes)
+	}
+	return
+}
@@ -0,0 +1,412 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+type App struct {
+	Client *hicli.HiClient
+
+	ctx     context.Context
+	screen  tcell.Screen
+	updates chan func()
+	// stopped is closed when Run returns, after which updates are no longer applied.
+	stopped chan struct{}
+	quit    bool
+
+	rooms          []*database.Room
+	roomsByID      map[id.RoomID]*database.Room
+	roomListScroll int
+	roomListWidth  int
+
+	timelines      map[id.RoomID]*Timeline
+	timelineHeight int
+	activeRoom     id.RoomID
+	typing         map[id.RoomID][]id.UserID
+	readReceipts   map[id.RoomID]id.EventID
+
+	composer Composer
+	drafts   map[id.RoomID]string
+	pasting  bool
+
+	syncStatus    *hicli.SyncStatus
+	statusMessage string
+	statusIsError bool
+}
+
+func NewApp() *App {
+	return &App{
+		updates:      make(chan func(), 1024),
+		stopped:      make(chan struct{}),
+		roomsByID:    make(map[id.RoomID]*database.Room),
+		timelines:    make(map[id.RoomID]*Timeline),
+		typing:       make(map[id.RoomID][]id.UserID),
+		readReceipts: make(map[id.RoomID]id.EventID),
+		drafts:       make(map[id.RoomID]string),
+	}
+}
+
+// queueUpdate queues a function to be called on the UI goroutine. If the UI has already stopped,
+// the function is dropped, so that the client isn't blocked forever while it's being stopped.
+func (app *App) queueUpdate(fn func()) {
+	select {
+	case app.updates <- fn:
+	case <-app.stopped:
+	}
+}
+
+// HandleHicliEvent queues an event from the client to be applied on the UI goroutine.
+func (app *App) HandleHicliEvent(evt any) {
+	app.queueUpdate(func() {
+		app.handleHicliEvent(evt)
+	})
+}
+
+// background runs the given function in a new goroutine. The returned function is then called
+// on the UI goroutine, which means it can safely modify the UI state.
+func (app *App) background(fn func() func()) {
+	go func() {
+		app.queueUpdate(fn())
+	}()
+}
+
+func (app *App) setStatus(isError bool, format string, args ...any) {
+	app.statusMessage = fmt.Sprintf(format, args...)
+	app.statusIsError = isError
+}
+
+func (app *App) Run(ctx context.Context) error {
+	defer close(app.stopped)
+	app.ctx = ctx
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return fmt.Errorf("failed to create screen: %w", err)
+	} else if err = screen.Init(); err != nil {
+		return fmt.Errorf("failed to initialize screen: %w", err)
+	}
+	app.screen = screen
+	screen.EnableMouse()
+	screen.EnablePaste()
+	defer screen.Fini()
+	app.loadRooms()
+
+	tcellEvents := make(chan tcell.Event, 64)
+	quit := make(chan struct{})
+	defer close(quit)
+	go screen.ChannelEvents(tcellEvents, quit)
+	for !app.quit {
+		app.draw()
+		select {
+		case evt := <-tcellEvents:
+			app.handleTcellEvent(evt)
+		case fn := <-app.updates:
+			fn()
+		}
+		// Apply all queued updates before redrawing
+	DrainLoop:
+		for {
+			select {
+			case fn := <-app.updates:
+				fn()
+			default:
+				break DrainLoop
+			}
+		}
+	}
+	return nil
+}
+
+func (app *App) loadRooms() {
+	// A negative limit means no limit in SQLite
+	rooms, err := app.Client.DB.Room.GetBySortTS(app.ctx, time.Now().Add(1*time.Hour), -1)
+	if err != nil {
+		zerolog.Ctx(app.ctx).Err(err).Msg("Failed to load rooms")
+		app.setStatus(true, "Failed to load rooms: %v", err)
+		return
+	}
+	app.rooms = rooms
+	clear(app.roomsByID)
+	for _, room := range rooms {
+		app.roomsByID[room.ID] = room
+	}
+}
+
+func (app *App) sortRooms() {
+	app.rooms = app.rooms[:0]
+	for _, room := range app.roomsByID {
+		if room.SortingTimestamp.UnixMilli() > 0 {
+			app.rooms = append(app.rooms, room)
+		}
+	}
+	slices.SortFunc(app.rooms, func(a, b *database.Room) int {
+		return b.SortingTimestamp.Compare(a.SortingTimestamp.Time)
+	})
+}
+
+func roomName(room *database.Room) string {
+	if room.Name != nil && *room.Name != "" {
+		return *room.Name
+	}
+	return room.ID.String()
+}
+
+func (app *App) activeTimeline() *Timeline {
+	if app.activeRoom == "" {
+		return nil
+	}
+	return app.timelines[app.activeRoom]
+}
+
+func (app *App) switchRoom(roomID id.RoomID) {
+	if roomID == app.activeRoom {
+		return
+	}
+	if app.activeRoom != "" {
+		app.drafts[app.activeRoom] = app.composer.Text()
+	}
+	app.activeRoom = roomID
+	app.composer.SetText(app.drafts[roomID])
+	tl, ok := app.timelines[roomID]
+	if !ok {
+		tl = NewTimeline(app.Client, roomID)
+		app.timelines[roomID] = tl
+		app.loadHistory(tl)
+	}
+	tl.Scroll = 0
+	app.markRead(tl)
+}
+
+func (app *App) moveRoom(delta int) {
+	if len(app.rooms) == 0 {
+		return
+	}
+	idx := slices.IndexFunc(app.rooms, func(room *database.Room) bool {
+		return room.ID == app.activeRoom
+	})
+	if idx == -1 {
+		idx = 0
+	} else {
+		idx = (idx + delta + len(app.rooms)) % len(app.rooms)
+	}
+	app.switchRoom(app.rooms[idx].ID)
+}
+
+func (app *App) loadHistory(tl *Timeline) {
+	if tl.Loading || !tl.HasMore {
+		return
+	}
+	tl.Loading = true
+	maxTimelineID := tl.OldestTimelineRowID()
+	app.background(func() func() {
+		resp, err := app.Client.Paginate(app.ctx, tl.RoomID, maxTimelineID, paginationLimit)
+		return func() {
+			tl.Loading = false
+			if err != nil {
+				zerolog.Ctx(app.ctx).Err(err).Stringer("room_id", tl.RoomID).Msg("Failed to paginate")
+				app.setStatus(true, "Failed to load history: %v", err)
+				tl.HasMore = false
+			} else if tl.OldestTimelineRowID() == maxTimelineID {
+				tl.ApplyPagination(resp)
+				if tl.RoomID == app.activeRoom {
+					app.markRead(tl)
+				}
+			}
+		}
+	})
+}
+
+// markRead sends a read receipt for the latest event in the room if it has unread messages.
+func (app *App) markRead(tl *Timeline) {
+	room := app.roomsByID[tl.RoomID]
+	latest := tl.LatestEvent()
+	if room == nil || latest == nil || app.readReceipts[tl.RoomID] == latest.ID ||
+		(room.UnreadCounts.IsZero() && (room.MarkedUnread == nil || !*room.MarkedUnread)) {
+		return
+	}
+	app.readReceipts[tl.RoomID] = latest.ID
+	app.background(func() func() {
+		err := app.Client.MarkRead(app.ctx, tl.RoomID, latest.ID, event.ReceiptTypeRead, "")
+		return func() {
+			if err != nil {
+				zerolog.Ctx(app.ctx).Err(err).Stringer("room_id", tl.RoomID).Msg("Failed to mark room as read")
+				delete(app.readReceipts, tl.RoomID)
+			}
+		}
+	})
+}
+
+func (app *App) sendMessage() {
+	text := app.composer.Text()
+	tl := app.activeTimeline()
+	if strings.TrimSpace(text) == "" || tl == nil {
+		return
+	}
+	app.composer.SetText("")
+	app.statusMessage = ""
+	app.background(func() func() {
+		dbEvt, err := app.Client.SendMessage(app.ctx, tl.RoomID, nil, nil, text, nil, nil)
+		return func() {
+			if err != nil {
+				app.setStatus(true, "Failed to send message: %v", err)
+				if app.activeRoom == tl.RoomID && app.composer.Text() == "" {
+					app.composer.SetText(text)
+				}
+			} else if dbEvt != nil {
+				tl.AddPending(dbEvt)
+				tl.Scroll = 0
+			}
+		}
+	})
+}
+
+func (app *App) handleHicliEvent(rawEvt any) {
+	switch evt := rawEvt.(type) {
+	case *hicli.SyncComplete:
+		if evt.ClearState {
+			clear(app.timelines)
+			clear(app.roomsByID)
+			activeRoom := app.activeRoom
+			app.activeRoom = ""
+			if activeRoom != "" {
+				app.switchRoom(activeRoom)
+			}
+		}
+		for roomID, room := range evt.Rooms {
+			app.roomsByID[roomID] = room.Meta
+			if tl, ok := app.timelines[roomID]; ok {
+				tl.ApplySync(room)
+				if room.Reset {
+					app.loadHistory(tl)
+				}
+				if roomID == app.activeRoom {
+					app.markRead(tl)
+				}
+			}
+		}
+		for _, roomID := range evt.LeftRooms {
+			delete(app.roomsByID, roomID)
+			delete(app.timelines, roomID)
+			if roomID == app.activeRoom {
+				app.activeRoom = ""
+				app.composer.SetText("")
+			}
+		}
+		app.sortRooms()
+	case *hicli.EventsDecrypted:
+		if tl, ok := app.timelines[evt.RoomID]; ok {
+			for _, decrypted := range evt.Events {
+				tl.ApplyEvent(decrypted)
+			}
+		}
+	case *hicli.SendComplete:
+		if tl, ok := app.timelines[evt.Event.RoomID]; ok {
+			tl.ApplyEvent(evt.Event)
+		}
+		if evt.Error != nil {
+			app.setStatus(true, "Failed to send message: %v", evt.Error)
+		}
+	case *hicli.Typing:
+		app.typing[evt.RoomID] = evt.UserIDs
+	case *hicli.SyncStatus:
+		app.syncStatus = evt
+	case *hicli.ClientState:
+		if !evt.IsLoggedIn {
+			app.setStatus(true, "Logged out")
+			app.quit = true
+		}
+	}
+}
+
+func (app *App) scrollTimeline(delta int) {
+	if tl := app.activeTimeline(); tl != nil {
+		// The upper bound is applied when drawing, as it depends on the screen size
+		tl.Scroll = max(tl.Scroll+delta, 0)
+	}
+}
+
+func (app *App) handleTcellEvent(rawEvt tcell.Event) {
+	switch evt := rawEvt.(type) {
+	case *tcell.EventResize:
+		app.screen.Sync()
+	case *tcell.EventPaste:
+		app.pasting = evt.Start()
+	case *tcell.EventMouse:
+		app.handleMouse(evt)
+	case *tcell.EventKey:
+		app.handleKey(evt)
+	}
+}
+
+func (app *App) handleMouse(evt *tcell.EventMouse) {
+	x, y := evt.Position()
+	inRoomList := x < app.roomListWidth
+	switch {
+	case evt.Buttons()&tcell.WheelUp != 0:
+		if inRoomList {
+			app.roomListScroll = max(app.roomListScroll-3, 0)
+		} else {
+			app.scrollTimeline(3)
+		}
+	case evt.Buttons()&tcell.WheelDown != 0:
+		if inRoomList {
+			app.roomListScroll = min(app.roomListScroll+3, max(len(app.rooms)-1, 0))
+		} else {
+			app.scrollTimeline(-3)
+		}
+	case evt.Buttons()&tcell.Button1 != 0 && inRoomList:
+		if idx := y + app.roomListScroll; idx < len(app.rooms) {
+			app.switchRoom(app.rooms[idx].ID)
+		}
+	}
+}
+
+func (app *App) handleKey(evt *tcell.EventKey) {
+	switch {
+	case evt.Key() == tcell.KeyCtrlC, evt.Key() == tcell.KeyCtrlQ:
+		app.quit = true
+	case evt.Key() == tcell.KeyEnter && app.pasting:
+		app.composer.Insert("\n")
+	case evt.Key() == tcell.KeyEnter:
+		app.sendMessage()
+	case evt.Key() == tcell.KeyCtrlN, evt.Key() == tcell.KeyDown && evt.Modifiers()&tcell.ModAlt != 0:
+		app.moveRoom(1)
+	case evt.Key() == tcell.KeyCtrlP, evt.Key() == tcell.KeyUp && evt.Modifiers()&tcell.ModAlt != 0:
+		app.moveRoom(-1)
+	case evt.Key() == tcell.KeyPgUp:
+		app.scrollTimeline(max(app.timelineHeight-1, 1))
+	case evt.Key() == tcell.KeyPgDn:
+		app.scrollTimeline(-max(app.timelineHeight-1, 1))
+	case evt.Key() == tcell.KeyEscape:
+		app.statusMessage = ""
+	default:
+		app.composer.HandleKey(evt)
+	}
+}
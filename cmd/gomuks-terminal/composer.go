@@ -0,0 +1,109 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+// Composer is a single-line text input.
+type Composer struct {
+	text   []rune
+	cursor int
+}
+
+func (c *Composer) Text() string {
+	return string(c.text)
+}
+
+func (c *Composer) SetText(text string) {
+	c.text = []rune(text)
+	c.cursor = len(c.text)
+}
+
+func (c *Composer) Insert(text string) {
+	runes := []rune(text)
+	c.text = append(c.text[:c.cursor], append(runes, c.text[c.cursor:]...)...)
+	c.cursor += len(runes)
+}
+
+func (c *Composer) deleteRange(start, end int) {
+	c.text = append(c.text[:start], c.text[end:]...)
+	c.cursor = start
+}
+
+func (c *Composer) wordStart() int {
+	pos := c.cursor
+	for pos > 0 && unicode.IsSpace(c.text[pos-1]) {
+		pos--
+	}
+	for pos > 0 && !unicode.IsSpace(c.text[pos-1]) {
+		pos--
+	}
+	return pos
+}
+
+// HandleKey applies a key press to the composer. It returns false if the key isn't used by the composer.
+func (c *Composer) HandleKey(evt *tcell.EventKey) bool {
+	switch evt.Key() {
+	case tcell.KeyRune:
+		c.Insert(string(evt.Rune()))
+	case tcell.KeyLeft:
+		c.cursor = max(c.cursor-1, 0)
+	case tcell.KeyRight:
+		c.cursor = min(c.cursor+1, len(c.text))
+	case tcell.KeyHome, tcell.KeyCtrlA:
+		c.cursor = 0
+	case tcell.KeyEnd, tcell.KeyCtrlE:
+		c.cursor = len(c.text)
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if c.cursor > 0 {
+			c.deleteRange(c.cursor-1, c.cursor)
+		}
+	case tcell.KeyDelete:
+		if c.cursor < len(c.text) {
+			c.deleteRange(c.cursor, c.cursor+1)
+		}
+	case tcell.KeyCtrlW:
+		c.deleteRange(c.wordStart(), c.cursor)
+	case tcell.KeyCtrlU:
+		c.deleteRange(0, c.cursor)
+	case tcell.KeyCtrlK:
+		c.text = c.text[:c.cursor]
+	default:
+		return false
+	}
+	return true
+}
+
+// Draw draws the composer and places the terminal cursor in it. If the text doesn't fit,
+// it's scrolled horizontally so that the cursor is visible.
+func (c *Composer) Draw(screen tcell.Screen, x, y, width int) {
+	prompt := "> "
+	promptWidth := drawString(screen, x, y, width, prompt, tcell.StyleDefault.Bold(true))
+	width -= promptWidth
+	x += promptWidth
+	start := 0
+	for runewidth.StringWidth(string(c.text[start:c.cursor])) >= width && start < c.cursor {
+		start++
+	}
+	Text(string(c.text[start:]), tcell.StyleDefault).Draw(screen, x, y, width)
+	screen.ShowCursor(x+runewidth.StringWidth(string(c.text[start:c.cursor])), y)
+}
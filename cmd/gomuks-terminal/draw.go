@@ -0,0 +1,161 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+func (app *App) draw() {
+	app.screen.Clear()
+	app.screen.HideCursor()
+	width, height := app.screen.Size()
+	app.roomListWidth = min(max(width/4, 16), 32)
+	app.drawRoomList(0, 0, app.roomListWidth, height)
+	for y := 0; y < height; y++ {
+		app.screen.SetContent(app.roomListWidth, y, '│', nil, styleDim)
+	}
+	x := app.roomListWidth + 1
+	width -= x
+	if tl := app.activeTimeline(); tl != nil && height >= 4 {
+		app.drawRoomHeader(x, 0, width)
+		app.timelineHeight = height - 3
+		app.drawTimeline(tl, x, 1, width, app.timelineHeight)
+		app.drawStatus(x, height-2, width)
+		app.composer.Draw(app.screen, x, height-1, width)
+	} else {
+		app.drawStatus(x, height-2, width)
+		drawString(app.screen, x+1, 0, width-1, "Select a room with Ctrl+N/Ctrl+P or by clicking it", styleInfo)
+	}
+	app.screen.Show()
+}
+
+func roomListStyle(room *database.Room) (tcell.Style, string) {
+	switch {
+	case room.UnreadHighlights > 0:
+		return tcell.StyleDefault.Bold(true).Foreground(tcell.ColorRed), fmt.Sprintf(" (%d)", room.UnreadHighlights)
+	case room.UnreadNotifications > 0:
+		return tcell.StyleDefault.Bold(true), fmt.Sprintf(" (%d)", room.UnreadNotifications)
+	case room.UnreadMessages > 0, room.MarkedUnread != nil && *room.MarkedUnread:
+		return tcell.StyleDefault.Bold(true), ""
+	default:
+		return tcell.StyleDefault, ""
+	}
+}
+
+func (app *App) drawRoomList(x, y, width, height int) {
+	// Keep the active room visible
+	for i, room := range app.rooms {
+		if room.ID != app.activeRoom {
+			continue
+		} else if i < app.roomListScroll {
+			app.roomListScroll = i
+		} else if i >= app.roomListScroll+height {
+			app.roomListScroll = i - height + 1
+		}
+		break
+	}
+	app.roomListScroll = max(min(app.roomListScroll, len(app.rooms)-height), 0)
+	for row := 0; row < height && app.roomListScroll+row < len(app.rooms); row++ {
+		room := app.rooms[app.roomListScroll+row]
+		style, counter := roomListStyle(room)
+		if room.ID == app.activeRoom {
+			style = style.Reverse(true)
+			fill(app.screen, x, y+row, width, 1, style)
+		}
+		counterWidth := Text(counter, style).Width()
+		used := drawString(app.screen, x, y+row, width-counterWidth, roomName(room), style)
+		drawString(app.screen, x+used, y+row, width-used, counter, style)
+	}
+}
+
+func (app *App) drawRoomHeader(x, y, width int) {
+	style := tcell.StyleDefault.Reverse(true)
+	fill(app.screen, x, y, width, 1, style)
+	room := app.roomsByID[app.activeRoom]
+	if room == nil {
+		drawString(app.screen, x+1, y, width-1, app.activeRoom.String(), style.Bold(true))
+		return
+	}
+	used := drawString(app.screen, x+1, y, width-1, roomName(room), style.Bold(true)) + 1
+	if room.Topic != nil && *room.Topic != "" {
+		topic, _, _ := strings.Cut(*room.Topic, "\n")
+		drawString(app.screen, x+used, y, width-used, " — "+topic, style)
+	}
+}
+
+func (app *App) drawTimeline(tl *Timeline, x, y, width, height int) {
+	rows := tl.RenderRows(app.ctx, width)
+	maxScroll := max(len(rows)-height, 0)
+	tl.Scroll = min(tl.Scroll, maxScroll)
+	start := len(rows) - height - tl.Scroll
+	for i := 0; i < height; i++ {
+		if idx := start + i; idx >= 0 {
+			rows[idx].Draw(app.screen, x, y+i, width)
+		}
+	}
+	if tl.Scroll == maxScroll && tl.HasMore {
+		if tl.Loading {
+			fill(app.screen, x, y, width, 1, tcell.StyleDefault)
+			drawString(app.screen, x, y, width, "Loading history...", styleInfo)
+		} else {
+			app.loadHistory(tl)
+		}
+	}
+}
+
+func (app *App) typingText(tl *Timeline) string {
+	var names []string
+	for _, userID := range app.typing[tl.RoomID] {
+		if userID != app.Client.Account.UserID {
+			names = append(names, tl.MemberName(app.ctx, userID))
+		}
+	}
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return names[0] + " is typing..."
+	default:
+		return strings.Join(names, ", ") + " are typing..."
+	}
+}
+
+func (app *App) drawStatus(x, y, width int) {
+	var text string
+	style := styleInfo
+	if app.statusMessage != "" {
+		text = app.statusMessage
+		if app.statusIsError {
+			style = styleError
+		}
+	} else if app.syncStatus != nil && app.syncStatus.Type != hicli.SyncStatusOK {
+		text = fmt.Sprintf("Sync %s", app.syncStatus.Type)
+		if app.syncStatus.Error != "" {
+			text += ": " + app.syncStatus.Error
+		}
+	} else if tl := app.activeTimeline(); tl != nil {
+		text = app.typingText(tl)
+	}
+	drawString(app.screen, x, y, width, text, style)
+}
@@ -0,0 +1,288 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type linePrefix struct {
+	first     StyledText
+	rest      StyledText
+	usedFirst bool
+}
+
+type htmlRenderer struct {
+	lines    []StyledText
+	current  StyledText
+	prefixes []*linePrefix
+	// hasPrefix is true if the prefixes have already been written to the current line
+	hasPrefix bool
+	pre       bool
+}
+
+// RenderHTML converts the sanitized HTML generated by hicli into styled lines of terminal text.
+func RenderHTML(input string, baseStyle tcell.Style) []StyledText {
+	nodes, err := html.ParseFragment(strings.NewReader(input), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err != nil {
+		return []StyledText{Text(input, baseStyle)}
+	}
+	r := &htmlRenderer{}
+	for _, node := range nodes {
+		r.renderNode(node, baseStyle)
+	}
+	r.lineBreak()
+	// Trim the empty lines that block elements leave at the end
+	for len(r.lines) > 1 && len(r.lines[len(r.lines)-1]) == 0 {
+		r.lines = r.lines[:len(r.lines)-1]
+	}
+	return r.lines
+}
+
+func (r *htmlRenderer) write(text string, style tcell.Style) {
+	if text == "" {
+		return
+	}
+	if !r.hasPrefix {
+		r.hasPrefix = true
+		for _, prefix := range r.prefixes {
+			if !prefix.usedFirst {
+				prefix.usedFirst = true
+				r.current = append(r.current, prefix.first...)
+			} else {
+				r.current = append(r.current, prefix.rest...)
+			}
+		}
+	}
+	r.current = r.current.Append(text, style)
+}
+
+func (r *htmlRenderer) lineBreak() {
+	r.lines = append(r.lines, r.current)
+	r.current = nil
+	r.hasPrefix = false
+}
+
+// blockBreak ends the current line unless it's empty.
+func (r *htmlRenderer) blockBreak() {
+	if len(r.current) > 0 {
+		r.lineBreak()
+	}
+}
+
+func (r *htmlRenderer) pushPrefix(first, rest StyledText) {
+	r.prefixes = append(r.prefixes, &linePrefix{first: first, rest: rest})
+}
+
+func (r *htmlRenderer) popPrefix() {
+	r.prefixes = r.prefixes[:len(r.prefixes)-1]
+}
+
+func getAttributeOK(node *html.Node, name string) (string, bool) {
+	for _, attr := range node.Attr {
+		if attr.Key == name {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+func getAttribute(node *html.Node, name string) string {
+	val, _ := getAttributeOK(node, name)
+	return val
+}
+
+func collapseWhitespace(text string) string {
+	var buf strings.Builder
+	prevSpace := false
+	for _, r := range text {
+		if r == ' ' || r == '\n' || r == '\t' || r == '\r' {
+			if !prevSpace {
+				buf.WriteRune(' ')
+			}
+			prevSpace = true
+		} else {
+			buf.WriteRune(r)
+			prevSpace = false
+		}
+	}
+	return buf.String()
+}
+
+func (r *htmlRenderer) renderText(text string, style tcell.Style) {
+	if !r.pre {
+		text = collapseWhitespace(text)
+		if len(r.current) == 0 || r.current[len(r.current)-1].Rune == ' ' {
+			text = strings.TrimLeft(text, " ")
+		}
+		r.write(text, style)
+		return
+	}
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			r.lineBreak()
+		}
+		r.write(line, style)
+	}
+}
+
+func (r *htmlRenderer) renderChildren(node *html.Node, style tcell.Style) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		r.renderNode(child, style)
+	}
+}
+
+func styleWithColor(node *html.Node, style tcell.Style) tcell.Style {
+	color := getAttribute(node, "data-mx-color")
+	if color == "" {
+		color = getAttribute(node, "color")
+	}
+	if color != "" {
+		style = style.Foreground(tcell.GetColor(color))
+	}
+	if bgColor := getAttribute(node, "data-mx-bg-color"); bgColor != "" {
+		style = style.Background(tcell.GetColor(bgColor))
+	}
+	return style
+}
+
+func (r *htmlRenderer) renderList(node *html.Node, style tcell.Style) {
+	r.blockBreak()
+	ordered := node.DataAtom == atom.Ol
+	counter := 1
+	if start, err := strconv.Atoi(getAttribute(node, "start")); err == nil && ordered {
+		counter = start
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode || child.DataAtom != atom.Li {
+			continue
+		}
+		bullet := "• "
+		if ordered {
+			bullet = strconv.Itoa(counter) + ". "
+			counter++
+		}
+		r.pushPrefix(Text(bullet, style), Text(strings.Repeat(" ", runewidth.StringWidth(bullet)), style))
+		r.renderChildren(child, style)
+		r.blockBreak()
+		r.popPrefix()
+	}
+}
+
+func (r *htmlRenderer) renderNode(node *html.Node, style tcell.Style) {
+	switch node.Type {
+	case html.TextNode:
+		r.renderText(node.Data, style)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+	switch node.DataAtom {
+	case atom.B, atom.Strong:
+		r.renderChildren(node, style.Bold(true))
+	case atom.I, atom.Em:
+		r.renderChildren(node, style.Italic(true))
+	case atom.U, atom.Ins:
+		r.renderChildren(node, style.Underline(true))
+	case atom.Del, atom.S, atom.Strike:
+		r.renderChildren(node, style.StrikeThrough(true))
+	case atom.Code:
+		r.renderChildren(node, style.Foreground(tcell.ColorYellow))
+	case atom.Font:
+		r.renderChildren(node, styleWithColor(node, style))
+	case atom.Span:
+		if _, isSpoiler := getAttributeOK(node, "data-mx-spoiler"); isSpoiler {
+			style = style.Foreground(tcell.ColorGray).Background(tcell.ColorGray)
+		}
+		r.renderChildren(node, styleWithColor(node, style))
+	case atom.A:
+		href := getAttribute(node, "href")
+		startLine, startLen := len(r.lines), len(r.current)
+		r.renderChildren(node, style.Underline(true).Url(href))
+		// Not all terminals support hyperlinks, so include the URL in the text unless it's already visible
+		// or the link is a mention.
+		if href != "" && !strings.HasPrefix(href, "https://matrix.to/") {
+			var text string
+			if len(r.lines) == startLine {
+				text = r.current[startLen:].String()
+			}
+			if !strings.HasSuffix(text, href) {
+				r.write(" <"+href+">", style.Dim(true))
+			}
+		}
+	case atom.Br:
+		r.lineBreak()
+	case atom.Hr:
+		r.blockBreak()
+		r.write(strings.Repeat("─", 20), style.Dim(true))
+		r.lineBreak()
+	case atom.Img:
+		alt := getAttribute(node, "alt")
+		if _, isEmote := getAttributeOK(node, "data-mx-emoticon"); isEmote && alt != "" {
+			r.write(alt, style)
+		} else if alt != "" {
+			r.write("[image: "+alt+"]", style.Dim(true))
+		} else {
+			r.write("[image]", style.Dim(true))
+		}
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		r.blockBreak()
+		level := int(node.Data[1] - '0')
+		r.write(strings.Repeat("#", level)+" ", style.Bold(true))
+		r.renderChildren(node, style.Bold(true))
+		r.blockBreak()
+	case atom.Blockquote:
+		r.blockBreak()
+		quotePrefix := Text("▌ ", style.Dim(true))
+		r.pushPrefix(quotePrefix, quotePrefix)
+		r.renderChildren(node, style)
+		r.blockBreak()
+		r.popPrefix()
+	case atom.Pre:
+		r.blockBreak()
+		r.pre = true
+		r.renderChildren(node, style.Foreground(tcell.ColorYellow))
+		r.pre = false
+		r.blockBreak()
+	case atom.Ul, atom.Ol:
+		r.renderList(node, style)
+	case atom.P, atom.Div, atom.Details, atom.Summary, atom.Tr, atom.Table:
+		r.blockBreak()
+		r.renderChildren(node, style)
+		r.blockBreak()
+	case atom.Td, atom.Th:
+		r.renderChildren(node, style)
+		r.write(" ", style)
+	default:
+		if node.Data == "mx-reply" {
+			// Reply fallbacks are rendered separately
+			return
+		}
+		r.renderChildren(node, style)
+	}
+}
@@ -0,0 +1,193 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/chzyer/readline"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/exzerolog"
+	"go.mau.fi/util/ptr"
+	"go.mau.fi/zeroconfig"
+	flag "maunium.net/go/mauflag"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/gomuks"
+	"go.mau.fi/gomuks/pkg/hicli"
+	"go.mau.fi/gomuks/version"
+)
+
+var wantHelp, _ = flag.MakeHelpFlag()
+var wantVersion = flag.MakeFull("v", "version", "View gomuks version and quit.", "false").Bool()
+var debug = flag.MakeFull("d", "debug", "Enable debug logging.", "false").Bool()
+
+func main() {
+	hicli.InitialDeviceDisplayName = "gomuks terminal"
+	flag.SetHelpTitles(
+		"gomuks terminal - A terminal Matrix client written in Go.",
+		"gomuks-terminal [-hvd]",
+	)
+	err := flag.Parse()
+
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		flag.PrintHelp()
+		os.Exit(1)
+	} else if *wantHelp {
+		flag.PrintHelp()
+		os.Exit(0)
+	} else if *wantVersion {
+		fmt.Println(version.Description)
+		os.Exit(0)
+	}
+
+	// Only the directory logic is reused from gomuks web. The terminal frontend has its own
+	// session and database, as two sync loops must never share the same database. The database
+	// is kept outside the gomuks web data directory, as that is deleted when gomuks web logs out.
+	gmx := gomuks.NewGomuks()
+	gmx.InitDirectories()
+	log, err := setupLog(gmx.LogDir)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to set up logging:", err)
+		os.Exit(9)
+	}
+	dataDir := gmx.DataDir + "-terminal"
+	if err = os.MkdirAll(dataDir, 0700); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to create data directory:", err)
+		os.Exit(10)
+	}
+	rawDB, err := dbutil.NewFromConfig("gomuks", dbutil.Config{
+		PoolConfig: dbutil.PoolConfig{
+			Type:         "sqlite3-fk-wal",
+			URI:          fmt.Sprintf("file:%s/gomuks.db?_txlock=immediate", dataDir),
+			MaxOpenConns: 5,
+			MaxIdleConns: 1,
+		},
+	}, dbutil.ZeroLogger(log.With().Str("db_section", "main").Logger()))
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to open database:", err)
+		os.Exit(11)
+	}
+
+	app := NewApp()
+	ctx := log.WithContext(context.Background())
+	cli := hicli.New(rawDB, nil, log.With().Str("component", "hicli").Logger(), []byte("meow"), app.HandleHicliEvent)
+	app.Client = cli
+	userID, err := cli.DB.Account.GetFirstUserID(ctx)
+	if err == nil {
+		err = cli.Start(ctx, userID, nil)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to start client:", err)
+		os.Exit(12)
+	}
+	if !cli.IsLoggedIn() {
+		err = login(ctx, cli)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Failed to log in:", err)
+			cli.Stop()
+			os.Exit(13)
+		}
+	}
+	// Unverified sessions only sync to-device events, so the room list would stay empty forever.
+	if !cli.Verified {
+		err = verify(ctx, cli)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Failed to verify session:", err)
+			cli.Stop()
+			os.Exit(14)
+		}
+	}
+	err = app.Run(ctx)
+	cli.Stop()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func setupLog(logDir string) (*zerolog.Logger, error) {
+	minLevel := zerolog.InfoLevel
+	if *debug {
+		minLevel = zerolog.DebugLevel
+	}
+	// Logs can't be written to stdout as the terminal is used by the UI
+	log, err := (&zeroconfig.Config{
+		MinLevel: ptr.Ptr(minLevel),
+		Writers: []zeroconfig.WriterConfig{{
+			Type:   zeroconfig.WriterTypeFile,
+			Format: "json",
+			FileConfig: zeroconfig.FileConfig{
+				Filename:   filepath.Join(logDir, "gomuks-terminal.log"),
+				MaxSize:    100 * 1024 * 1024,
+				MaxBackups: 10,
+			},
+		}},
+	}).Compile()
+	if err != nil {
+		return nil, err
+	}
+	exzerolog.SetupDefaults(log)
+	return log, nil
+}
+
+func login(ctx context.Context, cli *hicli.HiClient) error {
+	fmt.Println("Please log in to your Matrix account")
+	userIDStr, err := readline.Line("User ID: ")
+	if err != nil {
+		return err
+	}
+	userID := id.UserID(userIDStr)
+	_, serverName, err := userID.Parse()
+	if err != nil {
+		return fmt.Errorf("invalid user ID: %w", err)
+	}
+	discovery, err := mautrix.DiscoverClientAPI(ctx, serverName)
+	if err != nil {
+		return fmt.Errorf("failed to discover homeserver URL: %w", err)
+	} else if discovery == nil {
+		discovery = &mautrix.ClientWellKnown{Homeserver: mautrix.HomeserverInfo{BaseURL: "https://" + serverName}}
+	}
+	password, err := readline.Password("Password: ")
+	if err != nil {
+		return err
+	}
+	return cli.LoginPassword(ctx, discovery.Homeserver.BaseURL, userID.String(), string(password))
+}
+
+func verify(ctx context.Context, cli *hicli.HiClient) error {
+	fmt.Println("Please verify this session with your recovery key or passphrase, or leave it empty to quit")
+	for {
+		recoveryKey, err := readline.Password("Recovery key: ")
+		if err != nil {
+			return err
+		} else if len(recoveryKey) == 0 {
+			return fmt.Errorf("no recovery key entered")
+		}
+		err = cli.Verify(ctx, string(recoveryKey))
+		if err == nil {
+			return nil
+		}
+		fmt.Println("Failed to verify session:", err)
+	}
+}
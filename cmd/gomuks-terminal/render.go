@@ -0,0 +1,256 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/tidwall/gjson"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+var senderColors = []tcell.Color{
+	tcell.ColorRed, tcell.ColorGreen, tcell.ColorYellow, tcell.ColorBlue,
+	tcell.ColorFuchsia, tcell.ColorAqua, tcell.ColorOrange, tcell.ColorLime,
+}
+
+var (
+	styleDim   = tcell.StyleDefault.Dim(true)
+	styleInfo  = tcell.StyleDefault.Dim(true).Italic(true)
+	styleError = tcell.StyleDefault.Foreground(tcell.ColorRed)
+)
+
+func senderStyle(userID id.UserID) tcell.Style {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(userID))
+	return tcell.StyleDefault.Bold(true).Foreground(senderColors[hash.Sum32()%uint32(len(senderColors))])
+}
+
+// groupWithPrevious returns true if the event is from the same sender as the previous
+// event and sent soon after it, which means the sender header can be omitted.
+func groupWithPrevious(prev, evt *database.Event) bool {
+	return prev != nil &&
+		prev.Sender == evt.Sender &&
+		prev.StateKey == nil && evt.StateKey == nil &&
+		evt.Timestamp.Sub(prev.Timestamp.Time) < 5*time.Minute
+}
+
+func formatTimestamp(ts time.Time) string {
+	now := time.Now()
+	if ts.Year() == now.Year() && ts.YearDay() == now.YearDay() {
+		return ts.Format("15:04")
+	}
+	return ts.Format("Jan 2 15:04")
+}
+
+// RenderRows renders the visible events of the timeline into rows that fit in the given width.
+func (tl *Timeline) RenderRows(ctx context.Context, width int) []StyledText {
+	const indent = 2
+	var rows []StyledText
+	var prev *database.Event
+	for _, evt := range tl.Visible() {
+		if !groupWithPrevious(prev, evt) {
+			header := Text(tl.MemberName(ctx, evt.Sender), senderStyle(evt.Sender)).
+				Append(" "+formatTimestamp(evt.Timestamp.Time), styleDim)
+			rows = append(rows, header)
+		}
+		prev = evt
+		lines, ok := tl.renderCache[evt.RowID]
+		if !ok {
+			lines = tl.renderEvent(ctx, evt)
+			tl.renderCache[evt.RowID] = lines
+		}
+		padding := Text(strings.Repeat(" ", indent), tcell.StyleDefault)
+		for _, line := range lines {
+			for _, row := range line.Wrap(width - indent) {
+				rows = append(rows, slices.Concat(padding, row))
+			}
+		}
+	}
+	return rows
+}
+
+// renderEvent renders the body of an event into unwrapped lines.
+func (tl *Timeline) renderEvent(ctx context.Context, evt *database.Event) []StyledText {
+	var lines []StyledText
+	if reply := tl.ReplyTarget(evt); reply != nil {
+		if reply.Sender != "" {
+			lines = append(lines, Text("↳ "+tl.MemberName(ctx, reply.Sender)+": "+firstLine(reply), styleDim))
+		} else {
+			lines = append(lines, Text("↳ in reply to an unknown message", styleDim))
+		}
+	}
+	if evt.StateKey != nil {
+		lines = append(lines, Text(tl.renderStateEvent(ctx, evt), styleInfo))
+	} else {
+		lines = append(lines, tl.renderMessage(ctx, evt)...)
+	}
+	if len(evt.Reactions) > 0 {
+		var reactions StyledText
+		for _, key := range slices.Sorted(maps.Keys(evt.Reactions)) {
+			reactions = reactions.Append(fmt.Sprintf("[%s %d] ", key, evt.Reactions[key]), styleDim)
+		}
+		lines = append(lines, reactions)
+	}
+	if evt.SendError != "" {
+		lines = append(lines, Text("✗ Failed to send: "+evt.SendError, styleError))
+	} else if strings.HasPrefix(evt.ID.String(), "~") {
+		lines = append(lines, Text("Sending...", styleDim))
+	}
+	return lines
+}
+
+func (tl *Timeline) renderMessage(ctx context.Context, evt *database.Event) []StyledText {
+	if evt.RedactedBy != "" {
+		return []StyledText{Text("(deleted message)", styleInfo)}
+	}
+	content, evtType, localContent := evt.Content, evt.Type, evt.LocalContent
+	if evt.Decrypted != nil {
+		content, evtType = evt.Decrypted, evt.DecryptedType
+	} else if evt.Type == event.EventEncrypted.Type {
+		if evt.DecryptionError != "" {
+			return []StyledText{Text("Failed to decrypt: "+evt.DecryptionError, styleError)}
+		}
+		return []StyledText{Text("Waiting for decryption key...", styleInfo)}
+	}
+	edited := false
+	if edit := tl.lastEdit(evt); edit != nil {
+		editContent := edit.Content
+		if edit.Decrypted != nil {
+			editContent = edit.Decrypted
+		}
+		if newContent := gjson.GetBytes(editContent, `m\.new_content`); newContent.IsObject() {
+			content = json.RawMessage(newContent.Raw)
+			localContent = edit.LocalContent
+			edited = true
+		}
+	}
+	parsed := gjson.ParseBytes(content)
+	body := parsed.Get("body").Str
+	style := tcell.StyleDefault
+	var lines []StyledText
+	if evtType == event.EventSticker.Type {
+		lines = []StyledText{Text("[sticker] "+body, styleInfo)}
+	} else {
+		switch event.MessageType(parsed.Get("msgtype").Str) {
+		case event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile:
+			lines = []StyledText{Text(fmt.Sprintf("[%s] %s", strings.TrimPrefix(parsed.Get("msgtype").Str, "m."), body), styleInfo)}
+		case event.MsgLocation:
+			lines = []StyledText{Text("[location] "+parsed.Get("geo_uri").Str, styleInfo)}
+		case event.MsgNotice:
+			style = styleDim
+			fallthrough
+		default:
+			if localContent != nil && localContent.SanitizedHTML != "" {
+				lines = RenderHTML(localContent.SanitizedHTML, style)
+			} else {
+				for _, line := range strings.Split(body, "\n") {
+					lines = append(lines, Text(line, style))
+				}
+			}
+		}
+	}
+	if parsed.Get("msgtype").Str == string(event.MsgEmote) && len(lines) > 0 {
+		lines[0] = slices.Concat(Text("* "+tl.MemberName(ctx, evt.Sender)+" ", style), lines[0])
+	}
+	if edited {
+		lines[len(lines)-1] = lines[len(lines)-1].Append(" (edited)", styleDim)
+	}
+	return lines
+}
+
+func (tl *Timeline) renderStateEvent(ctx context.Context, evt *database.Event) string {
+	content := gjson.ParseBytes(evt.Content)
+	sender := tl.MemberName(ctx, evt.Sender)
+	switch evt.Type {
+	case event.StateMember.Type:
+		return tl.renderMemberEvent(ctx, evt, sender, content)
+	case event.StateRoomName.Type:
+		if name := content.Get("name").Str; name != "" {
+			return fmt.Sprintf("%s changed the room name to %s", sender, name)
+		}
+		return fmt.Sprintf("%s removed the room name", sender)
+	case event.StateTopic.Type:
+		if topic := content.Get("topic").Str; topic != "" {
+			return fmt.Sprintf("%s changed the topic to %s", sender, topic)
+		}
+		return fmt.Sprintf("%s removed the topic", sender)
+	case event.StateRoomAvatar.Type:
+		return fmt.Sprintf("%s changed the room avatar", sender)
+	case event.StateEncryption.Type:
+		return fmt.Sprintf("%s enabled encryption", sender)
+	case event.StateCreate.Type:
+		return fmt.Sprintf("%s created the room", sender)
+	case event.StatePinnedEvents.Type:
+		return fmt.Sprintf("%s changed the pinned messages", sender)
+	default:
+		return fmt.Sprintf("%s sent a %s state event", sender, evt.Type)
+	}
+}
+
+func (tl *Timeline) renderMemberEvent(ctx context.Context, evt *database.Event, sender string, content gjson.Result) string {
+	target := tl.MemberName(ctx, id.UserID(*evt.StateKey))
+	if displayname := content.Get("displayname").Str; displayname != "" {
+		target = displayname
+	}
+	prevContent := gjson.GetBytes(evt.Unsigned, "prev_content")
+	prevMembership := event.Membership(prevContent.Get("membership").Str)
+	var text string
+	switch event.Membership(content.Get("membership").Str) {
+	case event.MembershipJoin:
+		if prevMembership != event.MembershipJoin {
+			text = fmt.Sprintf("%s joined the room", target)
+		} else if prevName := prevContent.Get("displayname").Str; prevName != content.Get("displayname").Str {
+			text = fmt.Sprintf("%s changed their display name to %s", prevName, target)
+		} else {
+			text = fmt.Sprintf("%s changed their avatar", target)
+		}
+	case event.MembershipInvite:
+		text = fmt.Sprintf("%s invited %s", sender, target)
+	case event.MembershipBan:
+		text = fmt.Sprintf("%s banned %s", sender, target)
+	case event.MembershipKnock:
+		text = fmt.Sprintf("%s asked to join the room", target)
+	case event.MembershipLeave:
+		if evt.Sender.String() == *evt.StateKey {
+			text = fmt.Sprintf("%s left the room", target)
+		} else if prevMembership == event.MembershipBan {
+			text = fmt.Sprintf("%s unbanned %s", sender, target)
+		} else if prevMembership == event.MembershipInvite {
+			text = fmt.Sprintf("%s revoked the invitation for %s", sender, target)
+		} else {
+			text = fmt.Sprintf("%s kicked %s", sender, target)
+		}
+	default:
+		text = fmt.Sprintf("%s changed the membership of %s", sender, target)
+	}
+	if reason := content.Get("reason").Str; reason != "" {
+		text += ": " + reason
+	}
+	return text
+}
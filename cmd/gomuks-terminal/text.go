@@ -0,0 +1,128 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+// Cell is a single character on the screen. Zero-width characters are stored in
+// the combining list of the previous cell.
+type Cell struct {
+	Rune      rune
+	Combining []rune
+	Style     tcell.Style
+}
+
+func (c Cell) width() int {
+	return runewidth.RuneWidth(c.Rune)
+}
+
+// StyledText is a line of text where every character can have a different style.
+type StyledText []Cell
+
+func (st StyledText) Append(text string, style tcell.Style) StyledText {
+	for _, r := range text {
+		if runewidth.RuneWidth(r) == 0 && len(st) > 0 && r != '\n' && r != '\t' {
+			st[len(st)-1].Combining = append(st[len(st)-1].Combining, r)
+			continue
+		} else if r == '\t' {
+			r = ' '
+		} else if r == '\n' || r < ' ' {
+			continue
+		}
+		st = append(st, Cell{Rune: r, Style: style})
+	}
+	return st
+}
+
+func Text(text string, style tcell.Style) StyledText {
+	return StyledText(nil).Append(text, style)
+}
+
+func (st StyledText) Width() int {
+	var width int
+	for _, c := range st {
+		width += c.width()
+	}
+	return width
+}
+
+func (st StyledText) String() string {
+	runes := make([]rune, 0, len(st))
+	for _, c := range st {
+		runes = append(runes, c.Rune)
+		runes = append(runes, c.Combining...)
+	}
+	return string(runes)
+}
+
+// Wrap splits the text into rows that fit in the given width. Rows are broken
+// after the last space if possible, otherwise in the middle of a word.
+func (st StyledText) Wrap(width int) []StyledText {
+	if width <= 0 {
+		return nil
+	} else if len(st) == 0 {
+		return []StyledText{nil}
+	}
+	var rows []StyledText
+	start, rowWidth, lastSpace := 0, 0, -1
+	for i, c := range st {
+		if cw := c.width(); rowWidth+cw > width && i > start {
+			end := i
+			if lastSpace >= start {
+				end = lastSpace + 1
+			}
+			rows = append(rows, st[start:end])
+			start = end
+			rowWidth = st[start:i].Width()
+			lastSpace = -1
+		}
+		if c.Rune == ' ' {
+			lastSpace = i
+		}
+		rowWidth += c.width()
+	}
+	return append(rows, st[start:])
+}
+
+// Draw draws the text at the given position, cutting it off at maxWidth. It returns the width that was used.
+func (st StyledText) Draw(screen tcell.Screen, x, y, maxWidth int) int {
+	used := 0
+	for _, c := range st {
+		cw := c.width()
+		if used+cw > maxWidth {
+			break
+		}
+		screen.SetContent(x+used, y, c.Rune, c.Combining, c.Style)
+		used += cw
+	}
+	return used
+}
+
+func drawString(screen tcell.Screen, x, y, maxWidth int, text string, style tcell.Style) int {
+	return Text(text, style).Draw(screen, x, y, maxWidth)
+}
+
+func fill(screen tcell.Screen, x, y, width, height int, style tcell.Style) {
+	for row := y; row < y+height; row++ {
+		for col := x; col < x+width; col++ {
+			screen.SetContent(col, row, ' ', nil, style)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+const paginationLimit = 50
+
+// Timeline contains the events of a room that has been opened in the UI.
+type Timeline struct {
+	RoomID id.RoomID
+	// Events contains the events in the timeline, oldest first.
+	Events []*database.Event
+	// Pending contains events that have been sent, but haven't come down sync yet.
+	Pending []*database.Event
+	HasMore bool
+	Loading bool
+	// Scroll is the number of rows the timeline is scrolled up from the bottom.
+	Scroll int
+
+	client        *hicli.HiClient
+	eventsByRowID map[database.EventRowID]*database.Event
+	eventsByID    map[id.EventID]*database.Event
+	inTimeline    map[database.EventRowID]struct{}
+	members       map[id.UserID]string
+	renderCache   map[database.EventRowID][]StyledText
+}
+
+func NewTimeline(client *hicli.HiClient, roomID id.RoomID) *Timeline {
+	tl := &Timeline{RoomID: roomID, client: client}
+	tl.clear()
+	return tl
+}
+
+func (tl *Timeline) clear() {
+	tl.Events = nil
+	tl.Pending = nil
+	tl.HasMore = true
+	tl.Scroll = 0
+	tl.eventsByRowID = make(map[database.EventRowID]*database.Event)
+	tl.eventsByID = make(map[id.EventID]*database.Event)
+	tl.inTimeline = make(map[database.EventRowID]struct{})
+	tl.members = make(map[id.UserID]string)
+	tl.renderCache = make(map[database.EventRowID][]StyledText)
+}
+
+// OldestTimelineRowID returns the timeline row ID to paginate backwards from.
+func (tl *Timeline) OldestTimelineRowID() database.TimelineRowID {
+	if len(tl.Events) == 0 {
+		return 0
+	}
+	return tl.Events[0].TimelineRowID
+}
+
+// LatestEvent returns the newest event in the timeline that has been sent successfully.
+func (tl *Timeline) LatestEvent() *database.Event {
+	if len(tl.Events) == 0 {
+		return nil
+	}
+	return tl.Events[len(tl.Events)-1]
+}
+
+// addEvent stores an event or updates an existing one. Existing events are updated in place,
+// so that the pointers in the timeline and pending lists stay valid.
+func (tl *Timeline) addEvent(evt *database.Event) *database.Event {
+	if existing, ok := tl.eventsByRowID[evt.RowID]; ok {
+		timelineRowID := existing.TimelineRowID
+		*existing = *evt
+		if existing.TimelineRowID == 0 {
+			existing.TimelineRowID = timelineRowID
+		}
+		evt = existing
+	} else {
+		tl.eventsByRowID[evt.RowID] = evt
+	}
+	tl.eventsByID[evt.ID] = evt
+	delete(tl.renderCache, evt.RowID)
+	if evt.RelatesTo != "" {
+		// Edits and reactions change how the target event is rendered
+		if target, ok := tl.eventsByID[evt.RelatesTo]; ok {
+			delete(tl.renderCache, target.RowID)
+		}
+	}
+	if evt.Type == event.StateMember.Type && evt.StateKey != nil {
+		delete(tl.members, id.UserID(*evt.StateKey))
+		clear(tl.renderCache)
+	}
+	return evt
+}
+
+func (tl *Timeline) ApplyPagination(resp *hicli.PaginationResponse) {
+	for _, evt := range resp.RelatedEvents {
+		if _, ok := tl.eventsByRowID[evt.RowID]; !ok {
+			tl.addEvent(evt)
+		}
+	}
+	// Pagination responses are newest first, the timeline is oldest first
+	newEvents := make([]*database.Event, 0, len(resp.Events))
+	for _, evt := range slices.Backward(resp.Events) {
+		evt = tl.addEvent(evt)
+		if _, ok := tl.inTimeline[evt.RowID]; !ok {
+			tl.inTimeline[evt.RowID] = struct{}{}
+			newEvents = append(newEvents, evt)
+		}
+	}
+	tl.Events = slices.Concat(newEvents, tl.Events)
+	tl.HasMore = resp.HasMore
+}
+
+func (tl *Timeline) ApplySync(room *hicli.SyncRoom) {
+	if room.Reset {
+		tl.clear()
+	}
+	for _, evt := range room.Events {
+		tl.addEvent(evt)
+	}
+	for _, tuple := range room.Timeline {
+		evt, ok := tl.eventsByRowID[tuple.Event]
+		if !ok {
+			continue
+		}
+		evt.TimelineRowID = tuple.Timeline
+		tl.Pending = slices.DeleteFunc(tl.Pending, func(pending *database.Event) bool {
+			return pending.RowID == evt.RowID
+		})
+		if _, ok = tl.inTimeline[evt.RowID]; !ok {
+			tl.inTimeline[evt.RowID] = struct{}{}
+			tl.Events = append(tl.Events, evt)
+		}
+	}
+}
+
+func (tl *Timeline) ApplyEvent(evt *database.Event) {
+	tl.addEvent(evt)
+}
+
+func (tl *Timeline) AddPending(evt *database.Event) {
+	evt = tl.addEvent(evt)
+	if _, ok := tl.inTimeline[evt.RowID]; !ok && !slices.Contains(tl.Pending, evt) {
+		tl.Pending = append(tl.Pending, evt)
+	}
+}
+
+func (tl *Timeline) MemberName(ctx context.Context, userID id.UserID) string {
+	if name, ok := tl.members[userID]; ok {
+		return name
+	}
+	name := userID.String()
+	memberEvt, err := tl.client.DB.CurrentState.Get(ctx, tl.RoomID, event.StateMember, userID.String())
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("user_id", userID).Msg("Failed to get member event")
+	} else if memberEvt != nil {
+		if displayname := gjson.GetBytes(memberEvt.Content, "displayname").Str; displayname != "" {
+			name = displayname
+		}
+	}
+	tl.members[userID] = name
+	return name
+}
+
+func isVisible(evt *database.Event) bool {
+	if evt.RelationType == event.RelReplace {
+		return false
+	} else if evt.StateKey != nil {
+		return true
+	}
+	switch evt.Type {
+	case event.EventMessage.Type, event.EventSticker.Type, event.EventEncrypted.Type:
+		return true
+	default:
+		return false
+	}
+}
+
+// Visible returns the events that should be rendered, including pending events.
+func (tl *Timeline) Visible() []*database.Event {
+	visible := make([]*database.Event, 0, len(tl.Events)+len(tl.Pending))
+	for _, evt := range tl.Events {
+		if isVisible(evt) {
+			visible = append(visible, evt)
+		}
+	}
+	for _, evt := range tl.Pending {
+		if isVisible(evt) {
+			visible = append(visible, evt)
+		}
+	}
+	return visible
+}
+
+// lastEdit returns the latest edit of the event if it's known.
+func (tl *Timeline) lastEdit(evt *database.Event) *database.Event {
+	if evt.LastEditRowID == nil || *evt.LastEditRowID == 0 {
+		return nil
+	}
+	return tl.eventsByRowID[*evt.LastEditRowID]
+}
+
+// ReplyTarget returns the event that the given event is replying to, or nil if it's not a reply.
+// If the target event isn't known, a placeholder event containing only the ID is returned.
+func (tl *Timeline) ReplyTarget(evt *database.Event) *database.Event {
+	content := evt.Content
+	if evt.Decrypted != nil {
+		content = evt.Decrypted
+	}
+	targetID := id.EventID(gjson.GetBytes(content, `m\.relates_to.m\.in_reply_to.event_id`).Str)
+	if targetID == "" {
+		return nil
+	} else if target, ok := tl.eventsByID[targetID]; ok {
+		return target
+	}
+	return &database.Event{ID: targetID}
+}
+
+// firstLine returns the first line of the plaintext body of the event for previews.
+func firstLine(evt *database.Event) string {
+	content := evt.Content
+	if evt.Decrypted != nil {
+		content = evt.Decrypted
+	}
+	line, _, _ := strings.Cut(gjson.GetBytes(content, "body").Str, "\n")
+	return line
+}
@@ -31,13 +31,15 @@ import (
 
 var wantHelp, _ = flag.MakeHelpFlag()
 var wantVersion = flag.MakeFull("v", "version", "View gomuks version and quit.", "false").Bool()
+var enrollTOTP = flag.Make().LongKey("enroll-totp").Usage("Set up two-factor authentication for the web app and quit.").Default("false").Bool()
+var disableTOTP = flag.Make().LongKey("disable-totp").Usage("Disable two-factor authentication for the web app and quit.").Default("false").Bool()
 
 func main() {
 	hicli.InitialDeviceDisplayName = "gomuks web"
 	exhttp.AutoAllowCORS = false
 	flag.SetHelpTitles(
 		"gomuks - A Matrix client written in Go.",
-		"gomuks [-hv]",
+		"gomuks [-hv] [--enroll-totp | --disable-totp]",
 	)
 	err := flag.Parse()
 
@@ -59,5 +61,23 @@ func main() {
 	gmx.LinkifiedVersion = version.LinkifiedVersion
 	gmx.BuildTime = version.ParsedBuildTime
 	gmx.FrontendFS = web.Frontend
+	if *enrollTOTP || *disableTOTP {
+		gmx.InitDirectories()
+		err = gmx.LoadConfig()
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Failed to load config:", err)
+			os.Exit(9)
+		}
+		if *enrollTOTP {
+			err = gmx.EnrollTOTP()
+		} else {
+			err = gmx.DisableTOTP()
+		}
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	gmx.Run()
 }
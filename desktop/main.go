@@ -51,49 +51,61 @@ type CommandHandler struct {
 }
 
 func (c *CommandHandler) HandleCommand(cmd *hicli.JSONCommand) *hicli.JSONCommand {
-	return c.Gomuks.Client.SubmitJSONCommand(c.Ctx, cmd)
+	return c.Gomuks.SubmitJSONCommand(c.Ctx, cmd)
 }
 
 func (c *CommandHandler) Init() {
 	c.Gomuks.Log.Info().Msg("Sending initial state to client")
-	c.App.EmitEvent("hicli_event", &hicli.JSONCommandCustom[*hicli.ClientState]{
-		Command: "client_state",
-		Data:    c.Gomuks.Client.State(),
-	})
-	c.App.EmitEvent("hicli_event", &hicli.JSONCommandCustom[*hicli.SyncStatus]{
-		Command: "sync_status",
-		Data:    c.Gomuks.Client.SyncStatus.Load(),
-	})
-	if c.Gomuks.Client.IsLoggedIn() {
-		go func() {
-			log := c.Gomuks.Log
-			ctx := log.WithContext(context.TODO())
-			var roomCount int
-			for payload := range c.Gomuks.Client.GetInitialSync(ctx, 100) {
-				roomCount += len(payload.Rooms)
-				marshaledPayload, err := json.Marshal(&payload)
-				if err != nil {
-					log.Err(err).Msg("Failed to marshal initial rooms to send to client")
-					return
-				}
-				c.App.EmitEvent("hicli_event", &hicli.JSONCommand{
-					Command:   "sync_complete",
-					RequestID: 0,
-					Data:      marshaledPayload,
-				})
-			}
-			if ctx.Err() != nil {
-				return
-			}
-			c.App.EmitEvent("hicli_event", &hicli.JSONCommand{
-				Command:   "init_complete",
-				RequestID: 0,
-			})
-			log.Info().Int("room_count", roomCount).Msg("Sent initial rooms to client")
-		}()
+	for _, account := range c.Gomuks.ListAccounts() {
+		client := c.Gomuks.GetClient(account.AccountID)
+		if client == nil {
+			continue
+		}
+		c.App.EmitEvent("hicli_event", &hicli.JSONCommandCustom[*hicli.ClientState]{
+			Command:   "client_state",
+			AccountID: account.AccountID,
+			Data:      account.ClientState,
+		})
+		c.App.EmitEvent("hicli_event", &hicli.JSONCommandCustom[*hicli.SyncStatus]{
+			Command:   "sync_status",
+			AccountID: account.AccountID,
+			Data:      client.SyncStatus.Load(),
+		})
+		if client.IsLoggedIn() {
+			go c.sendInitialData(account.AccountID, client)
+		}
 	}
 }
 
+func (c *CommandHandler) sendInitialData(accountID string, client *hicli.HiClient) {
+	log := c.Gomuks.Log.With().Str("account_id", accountID).Logger()
+	ctx := log.WithContext(context.TODO())
+	var roomCount int
+	for payload := range client.GetInitialSync(ctx, 100) {
+		roomCount += len(payload.Rooms)
+		marshaledPayload, err := json.Marshal(&payload)
+		if err != nil {
+			log.Err(err).Msg("Failed to marshal initial rooms to send to client")
+			return
+		}
+		c.App.EmitEvent("hicli_event", &hicli.JSONCommand{
+			Command:   "sync_complete",
+			RequestID: 0,
+			AccountID: accountID,
+			Data:      marshaledPayload,
+		})
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	c.App.EmitEvent("hicli_event", &hicli.JSONCommand{
+		Command:   "init_complete",
+		RequestID: 0,
+		AccountID: accountID,
+	})
+	log.Info().Int("room_count", roomCount).Msg("Sent initial rooms to client")
+}
+
 func main() {
 	gmx := gomuks.NewGomuks()
 	gmx.Version = version.Version
@@ -157,7 +169,7 @@ func main() {
 		URL:              "/",
 	})
 
-	gmx.EventBuffer.Subscribe(0, nil, func(command *hicli.JSONCommand) {
+	gmx.EventBuffer.Subscribe(0, "", nil, func(command *hicli.JSONCommand) {
 		app.EmitEvent("hicli_event", command)
 	})
 
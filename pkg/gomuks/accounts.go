@@ -0,0 +1,290 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/exerrors"
+	"go.mau.fi/util/random"
+	"golang.org/x/net/http2"
+	"maunium.net/go/mautrix"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+)
+
+// DefaultAccountID is the ID of the account whose database is stored directly in the data directory.
+// Commands without an account ID are routed to the default account.
+const DefaultAccountID = "default"
+
+var accountIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+var ErrUnknownAccount = errors.New("unknown account")
+
+type AccountInfo struct {
+	AccountID string `json:"account_id"`
+	*hicli.ClientState
+}
+
+type removeAccountParams struct {
+	AccountID string `json:"account_id"`
+}
+
+func (gmx *Gomuks) accountDataDir(accountID string) string {
+	if accountID == DefaultAccountID {
+		return gmx.DataDir
+	}
+	return filepath.Join(gmx.DataDir, "accounts", accountID)
+}
+
+// GetClient returns the client for the given account ID, or nil if there's no such account.
+// An empty account ID refers to the default account.
+func (gmx *Gomuks) GetClient(accountID string) *hicli.HiClient {
+	if accountID == "" {
+		accountID = DefaultAccountID
+	}
+	gmx.accountsLock.RLock()
+	defer gmx.accountsLock.RUnlock()
+	return gmx.accounts[accountID]
+}
+
+func (gmx *Gomuks) getClientForRequest(w http.ResponseWriter, r *http.Request) *hicli.HiClient {
+	client := gmx.GetClient(r.URL.Query().Get("account_id"))
+	if client == nil {
+		mautrix.MNotFound.WithMessage("Unknown account").Write(w)
+	}
+	return client
+}
+
+// ListAccounts returns the state of all accounts, with the default account first.
+func (gmx *Gomuks) ListAccounts() []*AccountInfo {
+	gmx.accountsLock.RLock()
+	defer gmx.accountsLock.RUnlock()
+	accounts := make([]*AccountInfo, 0, len(gmx.accounts))
+	for accountID, client := range gmx.accounts {
+		accounts = append(accounts, &AccountInfo{AccountID: accountID, ClientState: client.State()})
+	}
+	slices.SortFunc(accounts, func(a, b *AccountInfo) int {
+		if a.AccountID == DefaultAccountID {
+			return -1
+		} else if b.AccountID == DefaultAccountID {
+			return 1
+		}
+		return strings.Compare(a.AccountID, b.AccountID)
+	})
+	return accounts
+}
+
+func (gmx *Gomuks) setClient(accountID string, client *hicli.HiClient) {
+	gmx.accountsLock.Lock()
+	defer gmx.accountsLock.Unlock()
+	if client == nil {
+		delete(gmx.accounts, accountID)
+	} else {
+		gmx.accounts[accountID] = client
+	}
+	if accountID == DefaultAccountID {
+		gmx.Client = client
+	}
+}
+
+func (gmx *Gomuks) openAccount(accountID string) (*hicli.HiClient, error) {
+	dataDir := gmx.accountDataDir(accountID)
+	err := os.MkdirAll(dataDir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	log := gmx.Log.With().Str("component", "hicli").Str("account_id", accountID).Logger()
+	rawDB, err := dbutil.NewFromConfig("gomuks", dbutil.Config{
+		PoolConfig: dbutil.PoolConfig{
+			Type:         "sqlite3-fk-wal",
+			URI:          fmt.Sprintf("file:%s/gomuks.db?_txlock=immediate", dataDir),
+			MaxOpenConns: 5,
+			MaxIdleConns: 1,
+		},
+	}, dbutil.ZeroLogger(log.With().Str("db_section", "main").Logger()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	ctx := log.WithContext(context.Background())
+	client := hicli.New(
+		rawDB,
+		nil,
+		log,
+		[]byte("meow"),
+		gmx.EventBuffer.HicliEventHandler(accountID),
+	)
+	client.LogoutFunc = func(ctx context.Context) error {
+		return gmx.Logout(ctx, accountID)
+	}
+	client.OpenCachedMedia = gmx.openCachedMedia
+	client.EnablePresence = gmx.Config.Matrix.EnablePresence
+	httpClient := client.Client.Client
+	httpClient.Transport.(*http.Transport).ForceAttemptHTTP2 = false
+	if !gmx.Config.Matrix.DisableHTTP2 {
+		h2, err := http2.ConfigureTransports(httpClient.Transport.(*http.Transport))
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure HTTP/2: %w", err)
+		}
+		h2.ReadIdleTimeout = 30 * time.Second
+	}
+	userID, err := client.DB.Account.GetFirstUserID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get first user ID: %w", err)
+	}
+	err = client.Start(ctx, userID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start client: %w", err)
+	}
+	log.Info().Stringer("user_id", userID).Msg("Client started")
+	return client, nil
+}
+
+func (gmx *Gomuks) startAdditionalAccounts() error {
+	entries, err := os.ReadDir(filepath.Join(gmx.DataDir, "accounts"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read accounts directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !accountIDRegex.MatchString(entry.Name()) || entry.Name() == DefaultAccountID {
+			continue
+		}
+		client, err := gmx.openAccount(entry.Name())
+		if err != nil {
+			return fmt.Errorf("failed to start account %s: %w", entry.Name(), err)
+		}
+		gmx.setClient(entry.Name(), client)
+	}
+	return nil
+}
+
+// AddAccount creates a new logged-out account with its own database. The frontend is expected
+// to log in by sending login commands with the returned account ID.
+func (gmx *Gomuks) AddAccount(ctx context.Context) (*AccountInfo, error) {
+	accountID := strings.ToLower(random.String(12))
+	client, err := gmx.openAccount(accountID)
+	if err != nil {
+		return nil, err
+	}
+	gmx.setClient(accountID, client)
+	zerolog.Ctx(ctx).Info().Str("account_id", accountID).Msg("Added new account")
+	client.EventHandler(client.State())
+	client.EventHandler(client.SyncStatus.Load())
+	return &AccountInfo{AccountID: accountID, ClientState: client.State()}, nil
+}
+
+// RemoveAccount stops the client of a logged-out account and deletes its data directory.
+// The default account can't be removed.
+func (gmx *Gomuks) RemoveAccount(ctx context.Context, accountID string) error {
+	if accountID == DefaultAccountID || accountID == "" {
+		return fmt.Errorf("can't remove default account")
+	}
+	client := gmx.GetClient(accountID)
+	if client == nil {
+		return ErrUnknownAccount
+	} else if client.IsLoggedIn() {
+		return fmt.Errorf("account must be logged out before removing it")
+	}
+	client.Stop()
+	gmx.setClient(accountID, nil)
+	err := os.RemoveAll(gmx.accountDataDir(accountID))
+	if err != nil {
+		return fmt.Errorf("failed to remove account data: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().Str("account_id", accountID).Msg("Removed account")
+	return nil
+}
+
+func (gmx *Gomuks) stopAllClients() {
+	gmx.accountsLock.RLock()
+	defer gmx.accountsLock.RUnlock()
+	for _, client := range gmx.accounts {
+		client.Stop()
+	}
+}
+
+func (gmx *Gomuks) handleAccountCommand(ctx context.Context, req *hicli.JSONCommand) (any, error) {
+	switch req.Command {
+	case "list_accounts":
+		return gmx.ListAccounts(), nil
+	case "add_account":
+		return gmx.AddAccount(ctx)
+	case "remove_account":
+		var params removeAccountParams
+		err := json.Unmarshal(req.Data, &params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal request data: %w", err)
+		}
+		return true, gmx.RemoveAccount(ctx, params.AccountID)
+	default:
+		return nil, fmt.Errorf("unknown account command")
+	}
+}
+
+func makeAccountCommandResponse(req *hicli.JSONCommand, resp any, err error) *hicli.JSONCommand {
+	if err != nil {
+		return &hicli.JSONCommand{
+			Command:   "error",
+			RequestID: req.RequestID,
+			Data:      exerrors.Must(json.Marshal(err.Error())),
+		}
+	}
+	return &hicli.JSONCommand{
+		Command:   "response",
+		RequestID: req.RequestID,
+		Data:      exerrors.Must(json.Marshal(resp)),
+	}
+}
+
+// SubmitJSONCommand handles account management, API token and web session commands
+// and routes all other commands to the client of the account specified in the request.
+func (gmx *Gomuks) SubmitJSONCommand(ctx context.Context, req *hicli.JSONCommand) *hicli.JSONCommand {
+	var out *hicli.JSONCommand
+	switch req.Command {
+	case "list_accounts", "add_account", "remove_account":
+		resp, err := gmx.handleAccountCommand(ctx, req)
+		out = makeAccountCommandResponse(req, resp, err)
+	case "list_api_tokens", "create_api_token", "revoke_api_token":
+		resp, err := gmx.handleAPITokenCommand(ctx, req)
+		out = makeAccountCommandResponse(req, resp, err)
+	case "list_web_sessions", "revoke_web_session":
+		resp, err := gmx.handleWebSessionCommand(ctx, req)
+		out = makeAccountCommandResponse(req, resp, err)
+	default:
+		if client := gmx.GetClient(req.AccountID); client != nil {
+			out = client.SubmitJSONCommand(ctx, req)
+		} else {
+			out = makeAccountCommandResponse(req, nil, ErrUnknownAccount)
+		}
+	}
+	out.AccountID = req.AccountID
+	return out
+}
@@ -0,0 +1,281 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"go.mau.fi/util/jsontime"
+	"go.mau.fi/util/random"
+	"maunium.net/go/mautrix"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+)
+
+// APIToken is a long-lived token for the HTTP command endpoint. Only a hash of the token is stored.
+type APIToken struct {
+	Name      string    `yaml:"name"`
+	TokenHash string    `yaml:"token_hash"`
+	CreatedAt time.Time `yaml:"created_at"`
+}
+
+type APITokenInfo struct {
+	Name      string             `json:"name"`
+	CreatedAt jsontime.UnixMilli `json:"created_at"`
+	// Token is only included in the response to create_api_token.
+	Token string `json:"token,omitempty"`
+}
+
+type apiTokenParams struct {
+	Name string `json:"name"`
+}
+
+const apiTokenPrefix = "gmx_"
+
+var (
+	ErrInvalidAPIToken     = mautrix.RespError{ErrCode: "FI.MAU.GOMUKS.INVALID_API_TOKEN", Err: "Invalid API token", StatusCode: http.StatusUnauthorized}
+	ErrCommandNotAllowed   = mautrix.RespError{ErrCode: "FI.MAU.GOMUKS.COMMAND_NOT_ALLOWED", Err: "This command can't be used with an API token", StatusCode: http.StatusForbidden}
+	ErrCommandFailed       = mautrix.RespError{ErrCode: "FI.MAU.GOMUKS.COMMAND_FAILED", StatusCode: http.StatusBadRequest}
+	ErrUntrustedOrigin     = mautrix.RespError{ErrCode: "FI.MAU.GOMUKS.UNTRUSTED_ORIGIN", Err: "Request origin is not allowed", StatusCode: http.StatusForbidden}
+	ErrAPITokenNotFound    = errors.New("API token not found")
+	ErrAPITokenNameInUse   = errors.New("an API token with that name already exists")
+	ErrInvalidAPITokenName = errors.New("API token name must be 1-64 characters long")
+)
+
+func hashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return base64.RawStdEncoding.EncodeToString(hash[:])
+}
+
+// ListAPITokens returns the names and creation dates of all API tokens.
+func (gmx *Gomuks) ListAPITokens() []*APITokenInfo {
+	gmx.configLock.RLock()
+	defer gmx.configLock.RUnlock()
+	tokens := make([]*APITokenInfo, len(gmx.Config.Web.APITokens))
+	for i, token := range gmx.Config.Web.APITokens {
+		tokens[i] = &APITokenInfo{Name: token.Name, CreatedAt: jsontime.UM(token.CreatedAt)}
+	}
+	return tokens
+}
+
+// CreateAPIToken generates a new API token and saves its hash in the config.
+// The returned info is the only place where the token itself is available.
+func (gmx *Gomuks) CreateAPIToken(ctx context.Context, name string) (*APITokenInfo, error) {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 || len(name) > 64 {
+		return nil, ErrInvalidAPITokenName
+	}
+	gmx.configLock.Lock()
+	defer gmx.configLock.Unlock()
+	if slices.ContainsFunc(gmx.Config.Web.APITokens, func(token *APIToken) bool {
+		return token.Name == name
+	}) {
+		return nil, ErrAPITokenNameInUse
+	}
+	tokenString := apiTokenPrefix + random.String(48)
+	token := &APIToken{
+		Name:      name,
+		TokenHash: hashAPIToken(tokenString),
+		CreatedAt: time.Now().Truncate(time.Second),
+	}
+	gmx.Config.Web.APITokens = append(gmx.Config.Web.APITokens, token)
+	err := gmx.SaveConfig()
+	if err != nil {
+		gmx.Config.Web.APITokens = gmx.Config.Web.APITokens[:len(gmx.Config.Web.APITokens)-1]
+		return nil, fmt.Errorf("failed to save config: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().Str("token_name", name).Msg("Created API token")
+	return &APITokenInfo{Name: name, CreatedAt: jsontime.UM(token.CreatedAt), Token: tokenString}, nil
+}
+
+// RevokeAPIToken deletes the API token with the given name. Requests using the token are rejected immediately.
+func (gmx *Gomuks) RevokeAPIToken(ctx context.Context, name string) error {
+	gmx.configLock.Lock()
+	defer gmx.configLock.Unlock()
+	idx := slices.IndexFunc(gmx.Config.Web.APITokens, func(token *APIToken) bool {
+		return token.Name == name
+	})
+	if idx < 0 {
+		return ErrAPITokenNotFound
+	}
+	oldTokens := gmx.Config.Web.APITokens
+	gmx.Config.Web.APITokens = slices.Delete(slices.Clone(oldTokens), idx, idx+1)
+	err := gmx.SaveConfig()
+	if err != nil {
+		gmx.Config.Web.APITokens = oldTokens
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().Str("token_name", name).Msg("Revoked API token")
+	return nil
+}
+
+func (gmx *Gomuks) validateAPIToken(token string) *APIToken {
+	if !strings.HasPrefix(token, apiTokenPrefix) || len(token) > 500 {
+		return nil
+	}
+	hash := []byte(hashAPIToken(token))
+	gmx.configLock.RLock()
+	defer gmx.configLock.RUnlock()
+	for _, apiToken := range gmx.Config.Web.APITokens {
+		if hmac.Equal(hash, []byte(apiToken.TokenHash)) {
+			return apiToken
+		}
+	}
+	return nil
+}
+
+func (gmx *Gomuks) handleAPITokenCommand(ctx context.Context, req *hicli.JSONCommand) (any, error) {
+	if req.Command == "list_api_tokens" {
+		return gmx.ListAPITokens(), nil
+	}
+	var params apiTokenParams
+	err := json.Unmarshal(req.Data, &params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal request data: %w", err)
+	}
+	switch req.Command {
+	case "create_api_token":
+		return gmx.CreateAPIToken(ctx, params.Name)
+	case "revoke_api_token":
+		return true, gmx.RevokeAPIToken(ctx, params.Name)
+	default:
+		return nil, fmt.Errorf("unknown API token command")
+	}
+}
+
+// httpCommandCounter is used to generate request IDs for commands submitted over HTTP.
+// The IDs are negative so they don't collide with the IDs chosen by websocket clients.
+var httpCommandCounter atomic.Int64
+
+// HandleCommand runs a single JSON command synchronously. The request body is used as the command data,
+// and the response data is returned as-is on success. The endpoint accepts either the normal auth cookie
+// or an API token in the Authorization header. Cookie-authenticated requests must be JSON and come from
+// an allowed origin, as browsers would otherwise happily submit cross-site forms to this endpoint.
+func (gmx *Gomuks) HandleCommand(w http.ResponseWriter, r *http.Request) {
+	command := r.PathValue("name")
+	if r.Context().Value(contextKeyAPIToken) != nil {
+		if !isAPITokenAllowedCommand(command) {
+			ErrCommandNotAllowed.Write(w)
+			return
+		}
+	} else if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		mautrix.MNotJSON.WithMessage("Content-Type must be application/json").Write(w)
+		return
+	} else if !gmx.isAllowedOrigin(r) {
+		ErrUntrustedOrigin.Write(w)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 128*1024))
+	if err != nil {
+		mautrix.MTooLarge.WithMessage("Failed to read request body: %v", err).Write(w)
+		return
+	} else if len(data) == 0 {
+		data = emptyObject
+	} else if !json.Valid(data) {
+		mautrix.MNotJSON.WithMessage("Request body is not valid JSON").Write(w)
+		return
+	}
+	resp := gmx.SubmitJSONCommand(r.Context(), &hicli.JSONCommand{
+		Command:   command,
+		RequestID: -httpCommandCounter.Add(1),
+		AccountID: r.URL.Query().Get("account_id"),
+		Data:      data,
+	})
+	if resp.Command == "error" {
+		var errMsg string
+		_ = json.Unmarshal(resp.Data, &errMsg)
+		ErrCommandFailed.WithMessage(errMsg).Write(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(resp.Data)
+}
+
+// isAPITokenAllowedCommand returns true for commands that API tokens are allowed to use.
+// Tokens are meant for scripts that read rooms and send messages, so everything else is denied by default.
+// In particular, a leaked token can't be used to manage access to gomuks, change account or room settings,
+// or touch encryption keys and devices, including via commands added in the future.
+func isAPITokenAllowedCommand(command string) bool {
+	switch command {
+	case "get_state", "cancel", "list_accounts", "list_commands", "resolve_alias",
+		"get_event", "get_room_state", "get_specific_room_state", "get_receipts", "get_room_summary",
+		"paginate", "paginate_server", "get_threads", "paginate_thread", "search_messages", "get_space_hierarchy",
+		"get_profile", "get_presence", "get_mutual_rooms", "get_outbox", "get_scheduled_messages", "export_room",
+		"send_message", "send_event", "resend_event", "redact_event", "schedule_message", "cancel_scheduled_message",
+		"mark_read", "set_typing":
+		return true
+	default:
+		return false
+	}
+}
+
+// isAllowedOrigin checks the Origin header of a request against the configured origin patterns,
+// using the same rules as the websocket endpoint. Requests without an Origin header are allowed,
+// as browsers always include it in cross-origin POST requests.
+func (gmx *Gomuks) isAllowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsedOrigin, err := url.Parse(origin)
+	if err != nil || parsedOrigin.Host == "" {
+		return false
+	} else if strings.EqualFold(parsedOrigin.Host, r.Host) {
+		return true
+	}
+	originHost := strings.ToLower(parsedOrigin.Host)
+	for _, pattern := range gmx.Config.Web.OriginPatterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), originHost); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateAPIToken checks the bearer token in the Authorization header. It returns nil if the token is invalid.
+func (gmx *Gomuks) authenticateAPIToken(r *http.Request) *http.Request {
+	tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil
+	}
+	token := gmx.validateAPIToken(tokenString)
+	if token == nil {
+		return nil
+	}
+	hlog.FromRequest(r).UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Str("api_token", token.Name)
+	})
+	return r.WithContext(context.WithValue(r.Context(), contextKeyAPIToken, token.Name))
+}
@@ -38,23 +38,33 @@ type EventBuffer struct {
 	MaxSize int
 
 	websocketClosers map[uint64]WebsocketCloseFunc
-	lastAckedID      map[uint64]int64
-	eventListeners   map[uint64]func(*hicli.JSONCommand)
-	nextListenerID   uint64
+	// websocketSessions maps listener IDs to the web session that opened the websocket.
+	websocketSessions map[uint64]string
+	lastAckedID       map[uint64]int64
+	eventListeners    map[uint64]func(*hicli.JSONCommand)
+	nextListenerID    uint64
 }
 
 func NewEventBuffer(maxSize int) *EventBuffer {
 	return &EventBuffer{
-		websocketClosers: make(map[uint64]WebsocketCloseFunc),
-		lastAckedID:      make(map[uint64]int64),
-		eventListeners:   make(map[uint64]func(*hicli.JSONCommand)),
-		buf:              make([]*hicli.JSONCommand, 0, 32),
-		MaxSize:          maxSize,
-		minID:            -1,
+		websocketClosers:  make(map[uint64]WebsocketCloseFunc),
+		websocketSessions: make(map[uint64]string),
+		lastAckedID:       make(map[uint64]int64),
+		eventListeners:    make(map[uint64]func(*hicli.JSONCommand)),
+		buf:               make([]*hicli.JSONCommand, 0, 32),
+		MaxSize:           maxSize,
+		minID:             -1,
 	}
 }
 
-func (eb *EventBuffer) HicliEventHandler(evt any) {
+// HicliEventHandler returns an event handler for a HiClient which tags all events with the given account ID.
+func (eb *EventBuffer) HicliEventHandler(accountID string) func(evt any) {
+	return func(evt any) {
+		eb.handleHicliEvent(accountID, evt)
+	}
+}
+
+func (eb *EventBuffer) handleHicliEvent(accountID string, evt any) {
 	data, err := json.Marshal(evt)
 	if err != nil {
 		panic(fmt.Errorf("failed to marshal event %T: %w", evt, err))
@@ -70,8 +80,9 @@ func (eb *EventBuffer) HicliEventHandler(evt any) {
 	eb.lock.Lock()
 	defer eb.lock.Unlock()
 	jc := &hicli.JSONCommand{
-		Command: hicli.EventTypeName(evt),
-		Data:    data,
+		Command:   hicli.EventTypeName(evt),
+		AccountID: accountID,
+		Data:      data,
 	}
 	if allowCache {
 		eb.addToBuffer(jc)
@@ -81,10 +92,21 @@ func (eb *EventBuffer) HicliEventHandler(evt any) {
 	}
 }
 
-func (eb *EventBuffer) GetClosers() []WebsocketCloseFunc {
+// GetClosers returns the close functions of websockets opened by the given web session.
+// If the session ID is empty, the close functions of all websockets are returned.
+func (eb *EventBuffer) GetClosers(sessionID string) []WebsocketCloseFunc {
 	eb.lock.Lock()
 	defer eb.lock.Unlock()
-	return slices.Collect(maps.Values(eb.websocketClosers))
+	if sessionID == "" {
+		return slices.Collect(maps.Values(eb.websocketClosers))
+	}
+	var closers []WebsocketCloseFunc
+	for listenerID, closer := range eb.websocketClosers {
+		if eb.websocketSessions[listenerID] == sessionID {
+			closers = append(closers, closer)
+		}
+	}
+	return closers
 }
 
 func (eb *EventBuffer) Unsubscribe(listenerID uint64) {
@@ -92,6 +114,7 @@ func (eb *EventBuffer) Unsubscribe(listenerID uint64) {
 	defer eb.lock.Unlock()
 	delete(eb.eventListeners, listenerID)
 	delete(eb.websocketClosers, listenerID)
+	delete(eb.websocketSessions, listenerID)
 }
 
 func (eb *EventBuffer) addToBuffer(evt *hicli.JSONCommand) {
@@ -137,7 +160,12 @@ func (eb *EventBuffer) gc() {
 	}
 }
 
-func (eb *EventBuffer) Subscribe(resumeFrom int64, closeForRestart WebsocketCloseFunc, cb func(*hicli.JSONCommand)) (uint64, []*hicli.JSONCommand) {
+func (eb *EventBuffer) Subscribe(
+	resumeFrom int64,
+	sessionID string,
+	closeForRestart WebsocketCloseFunc,
+	cb func(*hicli.JSONCommand),
+) (uint64, []*hicli.JSONCommand) {
 	eb.lock.Lock()
 	defer eb.lock.Unlock()
 	eb.nextListenerID++
@@ -146,6 +174,9 @@ func (eb *EventBuffer) Subscribe(resumeFrom int64, closeForRestart WebsocketClos
 	if closeForRestart != nil {
 		eb.websocketClosers[id] = closeForRestart
 	}
+	if sessionID != "" {
+		eb.websocketSessions[id] = sessionID
+	}
 	var resumeData []*hicli.JSONCommand
 	if resumeFrom < eb.minID {
 		resumeData = eb.buf[eb.minID-resumeFrom+1:]
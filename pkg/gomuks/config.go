@@ -19,8 +19,11 @@ package gomuks
 import (
 	"errors"
 	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/chzyer/readline"
 	"github.com/rs/zerolog"
@@ -38,7 +41,8 @@ type Config struct {
 }
 
 type MatrixConfig struct {
-	DisableHTTP2 bool `yaml:"disable_http2"`
+	DisableHTTP2   bool `yaml:"disable_http2"`
+	EnablePresence bool `yaml:"enable_presence"`
 }
 
 type WebConfig struct {
@@ -49,6 +53,21 @@ type WebConfig struct {
 	DebugEndpoints  bool     `yaml:"debug_endpoints"`
 	EventBufferSize int      `yaml:"event_buffer_size"`
 	OriginPatterns  []string `yaml:"origin_patterns"`
+	// TrustedProxies are the IP addresses or CIDR ranges of reverse proxies whose X-Forwarded-For header is
+	// used to find the real address of clients. The header is ignored if the request comes from anywhere else.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// SessionIdleTimeout is how long a web session stays valid without being used.
+	SessionIdleTimeout time.Duration `yaml:"session_idle_timeout"`
+	// SessionMaxLifetime is how long a web session stays valid regardless of activity. Zero means no limit.
+	SessionMaxLifetime time.Duration `yaml:"session_max_lifetime"`
+	// APITokens are long-lived tokens for the HTTP command endpoint. They're managed with the
+	// create_api_token and revoke_api_token commands.
+	APITokens []*APIToken `yaml:"api_tokens"`
+	// TOTPSecret is the base32 secret for two-factor authentication. It's set with --enroll-totp.
+	// If empty, only the password is required.
+	TOTPSecret string `yaml:"totp_secret"`
+	// TOTPRecoveryCodes are SHA-256 hashes of the unused single-use recovery codes.
+	TOTPRecoveryCodes []string `yaml:"totp_recovery_codes"`
 }
 
 var defaultFileWriter = zeroconfig.WriterConfig{
@@ -67,7 +86,8 @@ func makeDefaultConfig() Config {
 			ListenAddress: "localhost:29325",
 		},
 		Matrix: MatrixConfig{
-			DisableHTTP2: false,
+			DisableHTTP2:   false,
+			EnablePresence: false,
 		},
 		Logging: zeroconfig.Config{
 			MinLevel: ptr.Ptr(zerolog.DebugLevel),
@@ -125,6 +145,18 @@ func (gmx *Gomuks) LoadConfig() error {
 		gmx.Config.Web.OriginPatterns = []string{"localhost:*", "*.localhost:*"}
 		changed = true
 	}
+	gmx.trustedProxies = make([]netip.Prefix, 0, len(gmx.Config.Web.TrustedProxies))
+	for _, proxy := range gmx.Config.Web.TrustedProxies {
+		prefix, err := parseIPOrPrefix(proxy)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		gmx.trustedProxies = append(gmx.trustedProxies, prefix)
+	}
+	if gmx.Config.Web.SessionIdleTimeout <= 0 {
+		gmx.Config.Web.SessionIdleTimeout = 7 * 24 * time.Hour
+		changed = true
+	}
 	if changed {
 		err = gmx.SaveConfig()
 		if err != nil {
@@ -142,3 +174,16 @@ func (gmx *Gomuks) SaveConfig() error {
 	}
 	return yaml.NewEncoder(file).Encode(&gmx.Config)
 }
+
+// parseIPOrPrefix parses a CIDR range or a single IP address, which is treated as a range containing only itself.
+func parseIPOrPrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
@@ -0,0 +1,91 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+func (gmx *Gomuks) openCachedMedia(_ context.Context, entry *database.Media) (io.ReadCloser, error) {
+	if entry.Hash == nil {
+		return nil, hicli.ErrMediaNotCached
+	}
+	file, err := os.Open(gmx.cacheEntryToPath(entry.Hash[:]))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, hicli.ErrMediaNotCached
+	}
+	return file, err
+}
+
+func (gmx *Gomuks) ExportRoom(w http.ResponseWriter, r *http.Request) {
+	client := gmx.getClientForRequest(w, r)
+	if client == nil {
+		return
+	}
+	query := r.URL.Query()
+	params := &hicli.ExportRoomParams{
+		RoomID: id.RoomID(r.PathValue("room_id")),
+		Format: hicli.ExportFormat(query.Get("format")),
+	}
+	params.FetchHistory, _ = strconv.ParseBool(query.Get("fetch_history"))
+	params.IncludeMedia, _ = strconv.ParseBool(query.Get("include_media"))
+	switch params.Format {
+	case hicli.ExportFormatHTML, hicli.ExportFormatJSONL, hicli.ExportFormatText:
+	case "":
+		params.Format = hicli.ExportFormatHTML
+	default:
+		mautrix.MInvalidParam.WithMessage(fmt.Sprintf("Unsupported export format %q", params.Format)).Write(w)
+		return
+	}
+	log := zerolog.Ctx(r.Context()).With().
+		Stringer("room_id", params.RoomID).
+		Str("format", string(params.Format)).
+		Logger()
+	ctx := log.WithContext(r.Context())
+	room, err := client.DB.Room.Get(ctx, params.RoomID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get room for export")
+		mautrix.MUnknown.WithMessage(fmt.Sprintf("Failed to get room: %v", err)).Write(w)
+		return
+	} else if room == nil {
+		mautrix.MNotFound.WithMessage("Room not found").Write(w)
+		return
+	}
+	fileName := client.ExportFileName(ctx, room.ID, params.Format)
+	w.Header().Set("Content-Type", params.Format.MimeType())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	w.WriteHeader(http.StatusOK)
+	err = client.ExportRoom(ctx, params, w)
+	if err != nil {
+		// The response has already started, so the error can't be returned to the client properly
+		log.Err(err).Msg("Failed to export room")
+	}
+}
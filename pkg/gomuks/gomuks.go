@@ -17,10 +17,10 @@
 package gomuks
 
 import (
-	"context"
 	"embed"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -31,19 +31,22 @@ import (
 
 	"github.com/coder/websocket"
 	"github.com/rs/zerolog"
-	"go.mau.fi/util/dbutil"
 	"go.mau.fi/util/exerrors"
 	"go.mau.fi/util/exzerolog"
-	"golang.org/x/net/http2"
 
+	"go.mau.fi/gomuks/pkg/gomuks/webdb"
 	"go.mau.fi/gomuks/pkg/hicli"
 )
 
 type Gomuks struct {
 	Log    *zerolog.Logger
 	Server *http.Server
+	// Client is the client of the default account.
 	Client *hicli.HiClient
 
+	accounts     map[string]*hicli.HiClient
+	accountsLock sync.RWMutex
+
 	Version          string
 	Commit           string
 	LinkifiedVersion string
@@ -61,6 +64,20 @@ type Gomuks struct {
 
 	Config      Config
 	DisableAuth bool
+	// trustedProxies is the parsed form of Config.Web.TrustedProxies.
+	trustedProxies []netip.Prefix
+	// configLock protects the parts of Config that can be changed at runtime
+	// (API tokens and TOTP recovery codes).
+	configLock sync.RWMutex
+	// totpLock protects the replay and rate limit state of TOTP verification.
+	totpLock     sync.Mutex
+	totpLastStep uint64
+	totpFailures []time.Time
+
+	// WebDB stores the sessions of the web server. It's only opened when running the web server.
+	WebDB           *webdb.Database
+	webSessions     map[string]*webdb.WebSession
+	webSessionsLock sync.RWMutex
 
 	stopOnce sync.Once
 	stopChan chan struct{}
@@ -71,6 +88,7 @@ type Gomuks struct {
 func NewGomuks() *Gomuks {
 	return &Gomuks{
 		stopChan: make(chan struct{}),
+		accounts: make(map[string]*hicli.HiClient),
 	}
 }
 
@@ -153,48 +171,17 @@ func (gmx *Gomuks) SetupLog() {
 
 func (gmx *Gomuks) StartClient() {
 	hicli.HTMLSanitizerImgSrcTemplate = "_gomuks/media/%s/%s?encrypted=false"
-	rawDB, err := dbutil.NewFromConfig("gomuks", dbutil.Config{
-		PoolConfig: dbutil.PoolConfig{
-			Type:         "sqlite3-fk-wal",
-			URI:          fmt.Sprintf("file:%s/gomuks.db?_txlock=immediate", gmx.DataDir),
-			MaxOpenConns: 5,
-			MaxIdleConns: 1,
-		},
-	}, dbutil.ZeroLogger(gmx.Log.With().Str("component", "hicli").Str("db_section", "main").Logger()))
+	client, err := gmx.openAccount(DefaultAccountID)
 	if err != nil {
-		gmx.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to open database")
-		os.Exit(10)
-	}
-	ctx := gmx.Log.WithContext(context.Background())
-	gmx.Client = hicli.New(
-		rawDB,
-		nil,
-		gmx.Log.With().Str("component", "hicli").Logger(),
-		[]byte("meow"),
-		gmx.EventBuffer.HicliEventHandler,
-	)
-	gmx.Client.LogoutFunc = gmx.Logout
-	httpClient := gmx.Client.Client.Client
-	httpClient.Transport.(*http.Transport).ForceAttemptHTTP2 = false
-	if !gmx.Config.Matrix.DisableHTTP2 {
-		h2, err := http2.ConfigureTransports(httpClient.Transport.(*http.Transport))
-		if err != nil {
-			gmx.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to configure HTTP/2")
-			os.Exit(13)
-		}
-		h2.ReadIdleTimeout = 30 * time.Second
-	}
-	userID, err := gmx.Client.DB.Account.GetFirstUserID(ctx)
-	if err != nil {
-		gmx.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to get first user ID")
-		os.Exit(11)
+		gmx.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to start client")
+		os.Exit(12)
 	}
-	err = gmx.Client.Start(ctx, userID, nil)
+	gmx.setClient(DefaultAccountID, client)
+	err = gmx.startAdditionalAccounts()
 	if err != nil {
-		gmx.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to start client")
+		gmx.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to start additional accounts")
 		os.Exit(12)
 	}
-	gmx.Log.Info().Stringer("user_id", userID).Msg("Client started")
 }
 
 func (gmx *Gomuks) Stop() {
@@ -213,10 +200,10 @@ func (gmx *Gomuks) WaitForInterrupt() {
 }
 
 func (gmx *Gomuks) DirectStop() {
-	for _, closer := range gmx.EventBuffer.GetClosers() {
+	for _, closer := range gmx.EventBuffer.GetClosers("") {
 		closer(websocket.StatusServiceRestart, "Server shutting down")
 	}
-	gmx.Client.Stop()
+	gmx.stopAllClients()
 	if gmx.Server != nil {
 		err := gmx.Server.Close()
 		if err != nil {
@@ -238,6 +225,11 @@ func (gmx *Gomuks) Run() {
 		Str("go_version", runtime.Version()).
 		Time("built_at", gmx.BuildTime).
 		Msg("Initializing gomuks")
+	err = gmx.openWebDatabase()
+	if err != nil {
+		gmx.Log.WithLevel(zerolog.FatalLevel).Err(err).Msg("Failed to open web database")
+		os.Exit(11)
+	}
 	gmx.StartServer()
 	gmx.StartClient()
 	gmx.Log.Info().Msg("Initialization complete")
@@ -0,0 +1,83 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/rs/zerolog/hlog"
+	"go.mau.fi/util/exhttp"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli"
+)
+
+const maxKeyImportSize = 64 * 1024 * 1024
+
+// ExportRoomKeys returns a key export file. The passphrase and optional room ID are read from
+// the form body rather than the query to avoid the passphrase ending up in access logs.
+func (gmx *Gomuks) ExportRoomKeys(w http.ResponseWriter, r *http.Request) {
+	client := gmx.getClientForRequest(w, r)
+	if client == nil {
+		return
+	}
+	passphrase := r.PostFormValue("passphrase")
+	if passphrase == "" {
+		mautrix.MInvalidParam.WithMessage("Passphrase is required").Write(w)
+		return
+	}
+	data, err := client.ExportRoomKeys(r.Context(), passphrase, id.RoomID(r.PostFormValue("room_id")))
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to export room keys")
+		mautrix.MUnknown.WithMessage(fmt.Sprintf("Failed to export room keys: %v", err)).Write(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": hicli.KeyExportFileName}))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// ImportRoomKeys reads a key export file from the request body. The passphrase is read from the
+// X-Passphrase header.
+func (gmx *Gomuks) ImportRoomKeys(w http.ResponseWriter, r *http.Request) {
+	client := gmx.getClientForRequest(w, r)
+	if client == nil {
+		return
+	}
+	passphrase := r.Header.Get("X-Passphrase")
+	if passphrase == "" {
+		mautrix.MInvalidParam.WithMessage("Passphrase is required").Write(w)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxKeyImportSize))
+	if err != nil {
+		mautrix.MInvalidParam.WithMessage(fmt.Sprintf("Failed to read request body: %v", err)).Write(w)
+		return
+	}
+	resp, err := client.ImportRoomKeys(r.Context(), passphrase, data)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to import room keys")
+		mautrix.MUnknown.WithMessage(err.Error()).Write(w)
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, resp)
+}
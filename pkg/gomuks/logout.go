@@ -26,39 +26,99 @@ import (
 	"maunium.net/go/mautrix"
 )
 
-func (gmx *Gomuks) Logout(ctx context.Context) error {
+func (gmx *Gomuks) removeDatabase(log *zerolog.Logger, dir string) {
+	err := os.Remove(filepath.Join(dir, "gomuks.db"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Err(err).Str("data_dir", dir).Msg("Failed to remove database")
+	}
+	_ = os.Remove(filepath.Join(dir, "gomuks.db-shm"))
+	_ = os.Remove(filepath.Join(dir, "gomuks.db-wal"))
+}
+
+// removeUnusedCachedMedia removes the given files from the media cache unless an account other than the given one
+// still uses them. This ensures decrypted media of a logged out account doesn't stay on disk.
+func (gmx *Gomuks) removeUnusedCachedMedia(ctx context.Context, accountID string, hashes [][]byte) {
 	log := zerolog.Ctx(ctx)
+	var removed int
+	for _, hash := range hashes {
+		inUse := false
+		for _, account := range gmx.ListAccounts() {
+			otherClient := gmx.GetClient(account.AccountID)
+			if account.AccountID == accountID || otherClient == nil {
+				continue
+			}
+			var err error
+			inUse, err = otherClient.DB.Media.HasCachedHash(ctx, hash)
+			if err != nil {
+				log.Err(err).Str("other_account_id", account.AccountID).Msg("Failed to check if cached media is in use")
+				inUse = true
+			}
+			if inUse {
+				break
+			}
+		}
+		if inUse {
+			continue
+		}
+		err := os.Remove(gmx.cacheEntryToPath(hash))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Err(err).Hex("hash", hash).Msg("Failed to remove cached media")
+		} else if err == nil {
+			removed++
+		}
+	}
+	log.Info().Int("removed_count", removed).Msg("Removed cached media of logged out account")
+}
+
+func (gmx *Gomuks) Logout(ctx context.Context, accountID string) error {
+	log := zerolog.Ctx(ctx).With().Str("account_id", accountID).Logger()
+	client := gmx.GetClient(accountID)
+	if client == nil {
+		return ErrUnknownAccount
+	}
+	// The media cache is shared by all accounts, so find the files this account has cached before the database
+	// is closed, so they can be removed if no other account uses them.
+	cachedMedia, err := client.DB.Media.GetCachedHashes(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to get cached media of account")
+	}
 	log.Info().Msg("Stopping client and logging out")
-	gmx.Client.Stop()
-	_, err := gmx.Client.Client.Logout(ctx)
+	client.Stop()
+	_, err = client.Client.Logout(ctx)
 	if err != nil && !errors.Is(err, mautrix.MUnknownToken) {
 		log.Warn().Err(err).Msg("Failed to log out")
 		return err
 	}
 	log.Info().Msg("Logout complete, removing data")
-	err = os.RemoveAll(gmx.CacheDir)
-	if err != nil {
-		log.Err(err).Str("cache_dir", gmx.CacheDir).Msg("Failed to remove cache dir")
-	}
-	if gmx.DataDir == gmx.ConfigDir {
-		err = os.Remove(filepath.Join(gmx.DataDir, "gomuks.db"))
-		if err != nil && !errors.Is(err, os.ErrNotExist) {
-			log.Err(err).Str("data_dir", gmx.DataDir).Msg("Failed to remove database")
-		}
-		_ = os.Remove(filepath.Join(gmx.DataDir, "gomuks.db-shm"))
-		_ = os.Remove(filepath.Join(gmx.DataDir, "gomuks.db-wal"))
+	if accountID != DefaultAccountID || len(gmx.ListAccounts()) > 1 {
+		// Other accounts share the cache and data directories, so only remove this account's database
+		gmx.removeDatabase(&log, gmx.accountDataDir(accountID))
+		gmx.removeUnusedCachedMedia(ctx, accountID, cachedMedia)
 	} else {
-		err = os.RemoveAll(gmx.DataDir)
+		err = os.RemoveAll(gmx.CacheDir)
 		if err != nil {
-			log.Err(err).Str("data_dir", gmx.DataDir).Msg("Failed to remove data dir")
+			log.Err(err).Str("cache_dir", gmx.CacheDir).Msg("Failed to remove cache dir")
+		}
+		if gmx.DataDir == gmx.ConfigDir {
+			gmx.removeDatabase(&log, gmx.DataDir)
+		} else {
+			err = os.RemoveAll(gmx.DataDir)
+			if err != nil {
+				log.Err(err).Str("data_dir", gmx.DataDir).Msg("Failed to remove data dir")
+			}
 		}
+		log.Info().Msg("Re-initializing directories")
+		gmx.InitDirectories()
 	}
-	log.Info().Msg("Re-initializing directories")
-	gmx.InitDirectories()
 	log.Info().Msg("Restarting client")
-	gmx.StartClient()
-	gmx.Client.EventHandler(gmx.Client.State())
-	gmx.Client.EventHandler(gmx.Client.SyncStatus.Load())
+	client, err = gmx.openAccount(accountID)
+	if err != nil {
+		log.Err(err).Msg("Failed to restart client")
+		return err
+	}
+	gmx.setClient(accountID, client)
+	client.EventHandler(client.State())
+	client.EventHandler(client.SyncStatus.Load())
 	log.Info().Msg("Client restarted")
 	return nil
 }
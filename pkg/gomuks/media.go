@@ -51,6 +51,7 @@ import (
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
+	"go.mau.fi/gomuks/pkg/hicli"
 	"go.mau.fi/gomuks/pkg/hicli/database"
 )
 
@@ -169,6 +170,10 @@ func (w *avatarResponseWriter) Write(p []byte) (n int, err error) {
 }
 
 func (gmx *Gomuks) DownloadMedia(w http.ResponseWriter, r *http.Request) {
+	client := gmx.getClientForRequest(w, r)
+	if client == nil {
+		return
+	}
 	mxc := id.ContentURI{
 		Homeserver: r.PathValue("server"),
 		FileID:     r.PathValue("media_id"),
@@ -198,7 +203,7 @@ func (gmx *Gomuks) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 		Logger()
 	log := &logVal
 	ctx := log.WithContext(r.Context())
-	cacheEntry, err := gmx.Client.DB.Media.Get(ctx, mxc)
+	cacheEntry, err := client.DB.Media.Get(ctx, mxc)
 	if err != nil {
 		log.Err(err).Msg("Failed to get cached media entry")
 		mautrix.MUnknown.WithMessage(fmt.Sprintf("Failed to get cached media entry: %v", err)).Write(w)
@@ -226,7 +231,7 @@ func (gmx *Gomuks) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 		_ = os.Remove(tempFile.Name())
 	}()
 
-	resp, err := gmx.Client.Client.Download(ctx, mxc)
+	resp, err := client.Client.Download(ctx, mxc)
 	if err != nil {
 		if ctx.Err() != nil {
 			w.WriteHeader(499)
@@ -263,7 +268,7 @@ func (gmx *Gomuks) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 			cacheEntry.Error.Matrix = ptr.Ptr(ErrBadGateway.WithMessage(err.Error()))
 			cacheEntry.Error.StatusCode = http.StatusBadGateway
 		}
-		err = gmx.Client.DB.Media.Put(ctx, cacheEntry)
+		err = client.DB.Media.Put(ctx, cacheEntry)
 		if err != nil {
 			log.Err(err).Msg("Failed to save errored cache entry")
 		}
@@ -322,7 +327,7 @@ func (gmx *Gomuks) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 	_ = tempFile.Close()
 	cacheEntry.Hash = (*[32]byte)(fileHasher.Sum(nil))
 	cacheEntry.Error = nil
-	err = gmx.Client.DB.Media.Put(ctx, cacheEntry)
+	err = client.DB.Media.Put(ctx, cacheEntry)
 	if err != nil {
 		log.Err(err).Msg("Failed to save cache entry")
 		mautrix.MUnknown.WithMessage(fmt.Sprintf("Failed to save cache entry: %v", err)).Write(w)
@@ -347,6 +352,10 @@ func (gmx *Gomuks) DownloadMedia(w http.ResponseWriter, r *http.Request) {
 }
 
 func (gmx *Gomuks) UploadMedia(w http.ResponseWriter, r *http.Request) {
+	client := gmx.getClientForRequest(w, r)
+	if client == nil {
+		return
+	}
 	log := hlog.FromRequest(r)
 	tempFile, err := os.CreateTemp(gmx.TempDir, "upload-*")
 	if err != nil {
@@ -401,7 +410,7 @@ func (gmx *Gomuks) UploadMedia(w http.ResponseWriter, r *http.Request) {
 	}
 	encrypt, _ := strconv.ParseBool(r.URL.Query().Get("encrypt"))
 	if msgType == event.MsgVideo {
-		err = gmx.generateVideoThumbnail(r.Context(), cacheFile.Name(), encrypt, info)
+		err = gmx.generateVideoThumbnail(r.Context(), client, cacheFile.Name(), encrypt, info)
 		if err != nil {
 			log.Warn().Err(err).Msg("Failed to generate video thumbnail")
 		}
@@ -416,7 +425,7 @@ func (gmx *Gomuks) UploadMedia(w http.ResponseWriter, r *http.Request) {
 		Info:     info,
 		FileName: fileName,
 	}
-	content.File, content.URL, err = gmx.uploadFile(r.Context(), checksum, cacheFile, encrypt, int64(info.Size), info.MimeType, fileName)
+	content.File, content.URL, err = gmx.uploadFile(r.Context(), client, checksum, cacheFile, encrypt, int64(info.Size), info.MimeType, fileName)
 	if err != nil {
 		log.Err(err).Msg("Failed to upload media")
 		writeMaybeRespError(err, w)
@@ -425,7 +434,7 @@ func (gmx *Gomuks) UploadMedia(w http.ResponseWriter, r *http.Request) {
 	exhttp.WriteJSONResponse(w, http.StatusOK, content)
 }
 
-func (gmx *Gomuks) uploadFile(ctx context.Context, checksum []byte, cacheFile *os.File, encrypt bool, fileSize int64, mimeType, fileName string) (*event.EncryptedFileInfo, id.ContentURIString, error) {
+func (gmx *Gomuks) uploadFile(ctx context.Context, client *hicli.HiClient, checksum []byte, cacheFile *os.File, encrypt bool, fileSize int64, mimeType, fileName string) (*event.EncryptedFileInfo, id.ContentURIString, error) {
 	cm := &database.Media{
 		FileName: fileName,
 		MimeType: mimeType,
@@ -439,7 +448,7 @@ func (gmx *Gomuks) uploadFile(ctx context.Context, checksum []byte, cacheFile *o
 		mimeType = "application/octet-stream"
 		fileName = ""
 	}
-	resp, err := gmx.Client.Client.UploadMedia(ctx, mautrix.ReqUploadMedia{
+	resp, err := client.Client.UploadMedia(ctx, mautrix.ReqUploadMedia{
 		Content:       cacheReader,
 		ContentLength: fileSize,
 		ContentType:   mimeType,
@@ -452,7 +461,7 @@ func (gmx *Gomuks) uploadFile(ctx context.Context, checksum []byte, cacheFile *o
 		return nil, "", fmt.Errorf("failed to close cache reader: %w", err)
 	}
 	cm.MXC = resp.ContentURI
-	err = gmx.Client.DB.Media.Put(ctx, cm)
+	err = client.DB.Media.Put(ctx, cm)
 	if err != nil {
 		zerolog.Ctx(ctx).Err(err).
 			Stringer("mxc", cm.MXC).
@@ -537,7 +546,7 @@ func (gmx *Gomuks) generateFileInfo(ctx context.Context, file *os.File) (event.M
 	return msgType, info, defaultFileName, nil
 }
 
-func (gmx *Gomuks) generateVideoThumbnail(ctx context.Context, filePath string, encrypt bool, saveInto *event.FileInfo) error {
+func (gmx *Gomuks) generateVideoThumbnail(ctx context.Context, client *hicli.HiClient, filePath string, encrypt bool, saveInto *event.FileInfo) error {
 	tempPath := filepath.Join(gmx.TempDir, "thumbnail-"+random.String(12)+".jpeg")
 	defer os.Remove(tempPath)
 	err := ffmpeg.ConvertPathWithDestination(
@@ -602,7 +611,7 @@ func (gmx *Gomuks) generateVideoThumbnail(ctx context.Context, filePath string,
 	if err != nil {
 		return fmt.Errorf("failed to open renamed file: %w", err)
 	}
-	saveInto.ThumbnailFile, saveInto.ThumbnailURL, err = gmx.uploadFile(ctx, checksum, tempFile, encrypt, fileInfo.Size(), "image/jpeg", "thumbnail.jpeg")
+	saveInto.ThumbnailFile, saveInto.ThumbnailURL, err = gmx.uploadFile(ctx, client, checksum, tempFile, encrypt, fileInfo.Size(), "image/jpeg", "thumbnail.jpeg")
 	if err != nil {
 		return fmt.Errorf("failed to upload: %w", err)
 	}
@@ -18,6 +18,7 @@ package gomuks
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -42,6 +43,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"maunium.net/go/mautrix"
 
+	"go.mau.fi/gomuks/pkg/gomuks/webdb"
 	"go.mau.fi/gomuks/pkg/hicli"
 )
 
@@ -49,11 +51,15 @@ func (gmx *Gomuks) CreateAPIRouter() http.Handler {
 	api := http.NewServeMux()
 	api.HandleFunc("GET /websocket", gmx.HandleWebsocket)
 	api.HandleFunc("POST /auth", gmx.Authenticate)
+	api.HandleFunc("POST /command/{name}", gmx.HandleCommand)
 	api.HandleFunc("POST /upload", gmx.UploadMedia)
 	api.HandleFunc("GET /sso", gmx.HandleSSOComplete)
 	api.HandleFunc("POST /sso", gmx.PrepareSSO)
 	api.HandleFunc("GET /media/{server}/{media_id}", gmx.DownloadMedia)
 	api.HandleFunc("GET /codeblock/{style}", gmx.GetCodeblockCSS)
+	api.HandleFunc("GET /export/{room_id}", gmx.ExportRoom)
+	api.HandleFunc("POST /keys/export", gmx.ExportRoomKeys)
+	api.HandleFunc("POST /keys/import", gmx.ImportRoomKeys)
 	return exhttp.ApplyMiddleware(
 		api,
 		hlog.NewHandler(*gmx.Log),
@@ -133,6 +139,13 @@ func (gmx *Gomuks) FrontendCacheMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+type contextKey int
+
+const (
+	contextKeyAPIToken contextKey = iota
+	contextKeyWebSession
+)
+
 var (
 	ErrInvalidHeader = mautrix.RespError{ErrCode: "FI.MAU.GOMUKS.INVALID_HEADER", StatusCode: http.StatusForbidden}
 	ErrMissingCookie = mautrix.RespError{ErrCode: "FI.MAU.GOMUKS.MISSING_COOKIE", Err: "Missing gomuks_auth cookie", StatusCode: http.StatusUnauthorized}
@@ -143,6 +156,9 @@ type tokenData struct {
 	Username  string        `json:"username"`
 	Expiry    jsontime.Unix `json:"expiry"`
 	ImageOnly bool          `json:"image_only,omitempty"`
+	SessionID string        `json:"session_id,omitempty"`
+	// TOTPPending tokens only prove that the password was entered and can't be used for anything else.
+	TOTPPending bool `json:"totp_pending,omitempty"`
 }
 
 func (gmx *Gomuks) validateToken(token string, output any) bool {
@@ -171,30 +187,37 @@ func (gmx *Gomuks) validateToken(token string, output any) bool {
 	return err == nil
 }
 
-func (gmx *Gomuks) validateAuth(token string, imageOnly bool) bool {
+// validateAuth checks a signed auth token and returns the web session it belongs to.
+// If the token is invalid or the session has been revoked or has expired, nil is returned.
+func (gmx *Gomuks) validateAuth(token string, imageOnly bool) *webdb.WebSession {
 	if len(token) > 500 {
-		return false
+		return nil
 	}
 	var td tokenData
-	return gmx.validateToken(token, &td) &&
-		td.Username == gmx.Config.Web.Username &&
-		td.Expiry.After(time.Now()) &&
-		td.ImageOnly == imageOnly
+	if !gmx.validateToken(token, &td) ||
+		td.Username != gmx.Config.Web.Username ||
+		!td.Expiry.After(time.Now()) ||
+		td.ImageOnly != imageOnly ||
+		td.TOTPPending {
+		return nil
+	}
+	return gmx.getWebSession(td.SessionID)
 }
 
-func (gmx *Gomuks) generateToken() (string, time.Time) {
-	expiry := time.Now().Add(7 * 24 * time.Hour)
+func (gmx *Gomuks) generateToken(session *webdb.WebSession) string {
 	return gmx.signToken(tokenData{
-		Username: gmx.Config.Web.Username,
-		Expiry:   jsontime.U(expiry),
-	}), expiry
+		Username:  gmx.Config.Web.Username,
+		Expiry:    jsontime.U(session.ExpiresAt.Time),
+		SessionID: session.ID,
+	})
 }
 
-func (gmx *Gomuks) generateImageToken() string {
+func (gmx *Gomuks) generateImageToken(sessionID string) string {
 	return gmx.signToken(tokenData{
 		Username:  gmx.Config.Web.Username,
 		Expiry:    jsontime.U(time.Now().Add(1 * time.Hour)),
 		ImageOnly: true,
+		SessionID: sessionID,
 	})
 }
 
@@ -206,47 +229,134 @@ func (gmx *Gomuks) signToken(td any) string {
 	return base64.RawURLEncoding.EncodeToString(data) + "." + base64.RawURLEncoding.EncodeToString(checksum)
 }
 
-func (gmx *Gomuks) writeTokenCookie(w http.ResponseWriter) {
-	token, expiry := gmx.generateToken()
+func (gmx *Gomuks) writeTokenCookie(w http.ResponseWriter, session *webdb.WebSession) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "gomuks_auth",
-		Value:    token,
-		Expires:  expiry,
+		Value:    gmx.generateToken(session),
+		Expires:  session.ExpiresAt.Time,
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteLaxMode,
 	})
 }
 
+type authRequest struct {
+	// TOTPCode is a code from an authenticator app or a recovery code. It's only used if TOTP is enabled.
+	TOTPCode string `json:"totp_code"`
+}
+
+func (gmx *Gomuks) checkPassword(username, password string) bool {
+	usernameHash := sha256.Sum256([]byte(username))
+	expectedUsernameHash := sha256.Sum256([]byte(gmx.Config.Web.Username))
+	usernameCorrect := hmac.Equal(usernameHash[:], expectedUsernameHash[:])
+	passwordCorrect := bcrypt.CompareHashAndPassword([]byte(gmx.Config.Web.PasswordHash), []byte(password)) == nil
+	return usernameCorrect && passwordCorrect
+}
+
+// hasPendingTOTPCookie checks whether the request has a cookie proving that the password was entered
+// recently, which allows completing authentication with only the TOTP code.
+func (gmx *Gomuks) hasPendingTOTPCookie(r *http.Request) bool {
+	cookie, err := r.Cookie("gomuks_auth_pending")
+	if err != nil {
+		return false
+	}
+	var td tokenData
+	return gmx.validateToken(cookie.Value, &td) &&
+		td.TOTPPending &&
+		td.Username == gmx.Config.Web.Username &&
+		td.Expiry.After(time.Now())
+}
+
+func (gmx *Gomuks) writePendingTOTPCookie(w http.ResponseWriter) {
+	expiry := time.Now().Add(totpPendingLifetime)
+	http.SetCookie(w, &http.Cookie{
+		Name: "gomuks_auth_pending",
+		Value: gmx.signToken(tokenData{
+			Username:    gmx.Config.Web.Username,
+			Expiry:      jsontime.U(expiry),
+			TOTPPending: true,
+		}),
+		Expires:  expiry,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func requestCredentials(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="gomuks web" charset="UTF-8"`)
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
 func (gmx *Gomuks) Authenticate(w http.ResponseWriter, r *http.Request) {
 	if gmx.DisableAuth {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
-	authCookie, err := r.Cookie("gomuks_auth")
-	if err == nil && gmx.validateAuth(authCookie.Value, false) {
-		hlog.FromRequest(r).Debug().Msg("Authentication successful with existing cookie")
-		gmx.writeTokenCookie(w)
+	log := hlog.FromRequest(r)
+	var session *webdb.WebSession
+	if authCookie, err := r.Cookie("gomuks_auth"); err == nil {
+		session = gmx.validateAuth(authCookie.Value, false)
+	}
+	if session != nil {
+		log.Debug().Str("session_id", session.ID).Msg("Authentication successful with existing cookie")
+		session, _ = gmx.touchWebSession(r, session)
+		gmx.writeTokenCookie(w, session)
 		w.WriteHeader(http.StatusOK)
-	} else if username, password, ok := r.BasicAuth(); !ok {
-		hlog.FromRequest(r).Debug().Msg("Requesting credentials for auth request")
-		w.Header().Set("WWW-Authenticate", `Basic realm="gomuks web" charset="UTF-8"`)
-		w.WriteHeader(http.StatusUnauthorized)
-	} else {
-		usernameHash := sha256.Sum256([]byte(username))
-		expectedUsernameHash := sha256.Sum256([]byte(gmx.Config.Web.Username))
-		usernameCorrect := hmac.Equal(usernameHash[:], expectedUsernameHash[:])
-		passwordCorrect := bcrypt.CompareHashAndPassword([]byte(gmx.Config.Web.PasswordHash), []byte(password)) == nil
-		if usernameCorrect && passwordCorrect {
-			hlog.FromRequest(r).Debug().Msg("Authentication successful with username and password")
-			gmx.writeTokenCookie(w)
-			w.WriteHeader(http.StatusCreated)
-		} else {
-			hlog.FromRequest(r).Debug().Msg("Authentication failed with username and password, re-requesting credentials")
-			w.Header().Set("WWW-Authenticate", `Basic realm="gomuks web" charset="UTF-8"`)
-			w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var req authRequest
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			mautrix.MNotJSON.WithMessage("Invalid request body").Write(w)
+			return
+		}
+	}
+	username, password, hasBasicAuth := r.BasicAuth()
+	if hasBasicAuth {
+		if !gmx.checkPassword(username, password) {
+			log.Debug().Msg("Authentication failed with username and password, re-requesting credentials")
+			requestCredentials(w)
+			return
+		}
+	} else if !gmx.totpEnabled() || !gmx.hasPendingTOTPCookie(r) {
+		log.Debug().Msg("Requesting credentials for auth request")
+		requestCredentials(w)
+		return
+	}
+	if gmx.totpEnabled() {
+		if hasBasicAuth {
+			gmx.writePendingTOTPCookie(w)
+		}
+		if req.TOTPCode == "" {
+			log.Debug().Msg("Password correct, requesting TOTP code")
+			ErrTOTPRequired.Write(w)
+			return
 		}
+		err := gmx.verifySecondFactor(r.Context(), req.TOTPCode)
+		var respErr mautrix.RespError
+		if errors.As(err, &respErr) {
+			log.Debug().Err(err).Msg("Authentication failed with TOTP code")
+			respErr.Write(w)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:   "gomuks_auth_pending",
+			MaxAge: -1,
+		})
+		log.Debug().Msg("Authentication successful with password and TOTP code")
+	} else {
+		log.Debug().Msg("Authentication successful with username and password")
 	}
+	session, err := gmx.createWebSession(r)
+	if err != nil {
+		log.Err(err).Msg("Failed to create web session")
+		mautrix.MUnknown.WithMessage("Failed to create session").Write(w)
+		return
+	}
+	gmx.writeTokenCookie(w, session)
+	w.WriteHeader(http.StatusCreated)
 }
 
 func isImageFetch(header http.Header) bool {
@@ -259,17 +369,26 @@ func (gmx *Gomuks) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasPrefix(r.URL.Path, "/media") &&
 			isImageFetch(r.Header) &&
-			gmx.validateAuth(r.URL.Query().Get("image_auth"), true) &&
+			gmx.validateAuth(r.URL.Query().Get("image_auth"), true) != nil &&
 			r.URL.Query().Get("encrypted") == "false" {
 			next.ServeHTTP(w, r)
 			return
 		}
-		if r.URL.Path != "/auth" {
+		if strings.HasPrefix(r.URL.Path, "/command/") && r.Header.Get("Authorization") != "" {
+			authedRequest := gmx.authenticateAPIToken(r)
+			if authedRequest == nil {
+				ErrInvalidAPIToken.Write(w)
+				return
+			}
+			r = authedRequest
+		} else if r.URL.Path != "/auth" {
 			authCookie, err := r.Cookie("gomuks_auth")
 			if err != nil {
 				ErrMissingCookie.Write(w)
 				return
-			} else if !gmx.validateAuth(authCookie.Value, false) {
+			}
+			session := gmx.validateAuth(authCookie.Value, false)
+			if session == nil {
 				http.SetCookie(w, &http.Cookie{
 					Name:   "gomuks_auth",
 					MaxAge: -1,
@@ -277,6 +396,12 @@ func (gmx *Gomuks) AuthMiddleware(next http.Handler) http.Handler {
 				ErrInvalidCookie.Write(w)
 				return
 			}
+			if updatedSession, changed := gmx.touchWebSession(r, session); changed {
+				// Touching extends the expiry of the session, so the cookie needs to be extended too
+				gmx.writeTokenCookie(w, updatedSession)
+				session = updatedSession
+			}
+			r = r.WithContext(context.WithValue(r.Context(), contextKeyWebSession, session))
 		}
 		next.ServeHTTP(w, r)
 	})
@@ -26,6 +26,8 @@ import (
 
 	"go.mau.fi/util/random"
 	"maunium.net/go/mautrix"
+
+	"go.mau.fi/gomuks/pkg/hicli"
 )
 
 const ssoErrorPage = `<!DOCTYPE html>
@@ -51,36 +53,40 @@ const ssoErrorPage = `<!DOCTYPE html>
 </body>
 </html>`
 
-func (gmx *Gomuks) parseSSOServerURL(r *http.Request) error {
+func (gmx *Gomuks) parseSSOServerURL(r *http.Request) (*hicli.HiClient, error) {
 	cookie, _ := r.Cookie("gomuks_sso_session")
 	if cookie == nil {
-		return fmt.Errorf("no SSO session cookie")
+		return nil, fmt.Errorf("no SSO session cookie")
 	}
 	var cookieData SSOCookieData
 	if !gmx.validateToken(cookie.Value, &cookieData) {
-		return fmt.Errorf("invalid SSO session cookie")
+		return nil, fmt.Errorf("invalid SSO session cookie")
 	} else if cookieData.SessionID != r.URL.Query().Get("gomuksSession") {
-		return fmt.Errorf("session ID mismatch in query param and cookie")
+		return nil, fmt.Errorf("session ID mismatch in query param and cookie")
 	} else if time.Until(cookieData.Expiry) < 0 {
-		return fmt.Errorf("SSO session cookie expired")
+		return nil, fmt.Errorf("SSO session cookie expired")
+	}
+	client := gmx.GetClient(cookieData.AccountID)
+	if client == nil {
+		return nil, ErrUnknownAccount
 	}
 	var err error
-	gmx.Client.Client.HomeserverURL, err = url.Parse(cookieData.HomeserverURL)
+	client.Client.HomeserverURL, err = url.Parse(cookieData.HomeserverURL)
 	if err != nil {
-		return fmt.Errorf("failed to parse server URL: %w", err)
+		return nil, fmt.Errorf("failed to parse server URL: %w", err)
 	}
-	return nil
+	return client, nil
 }
 
 func (gmx *Gomuks) HandleSSOComplete(w http.ResponseWriter, r *http.Request) {
-	err := gmx.parseSSOServerURL(r)
+	client, err := gmx.parseSSOServerURL(r)
 	if err != nil {
 		w.Header().Set("Content-Type", "text/html")
 		w.WriteHeader(http.StatusBadRequest)
 		_, _ = fmt.Fprintf(w, ssoErrorPage, html.EscapeString(err.Error()))
 		return
 	}
-	err = gmx.Client.Login(r.Context(), &mautrix.ReqLogin{
+	err = client.Login(r.Context(), &mautrix.ReqLogin{
 		Type:  mautrix.AuthTypeToken,
 		Token: r.URL.Query().Get("loginToken"),
 	})
@@ -97,6 +103,7 @@ func (gmx *Gomuks) HandleSSOComplete(w http.ResponseWriter, r *http.Request) {
 type SSOCookieData struct {
 	SessionID     string    `json:"session_id"`
 	HomeserverURL string    `json:"homeserver_url"`
+	AccountID     string    `json:"account_id,omitempty"`
 	Expiry        time.Time `json:"expiry"`
 }
 
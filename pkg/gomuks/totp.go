@@ -0,0 +1,203 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
+	"github.com/pquerna/otp/totp"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/random"
+	"maunium.net/go/mautrix"
+)
+
+const (
+	totpPeriod            = 30
+	totpRecoveryCodeCount = 10
+	totpMaxFailures       = 5
+	totpFailureWindow     = 5 * time.Minute
+	// totpPendingLifetime is how long the user has to enter the TOTP code after entering the password.
+	totpPendingLifetime = 5 * time.Minute
+)
+
+var (
+	ErrTOTPRequired = mautrix.RespError{ErrCode: "FI.MAU.GOMUKS.TOTP_REQUIRED", Err: "Two-factor authentication code required", StatusCode: http.StatusUnauthorized}
+	ErrInvalidTOTP  = mautrix.RespError{ErrCode: "FI.MAU.GOMUKS.INVALID_TOTP", Err: "Invalid two-factor authentication code", StatusCode: http.StatusUnauthorized}
+	ErrTOTPLimited  = mautrix.RespError{ErrCode: mautrix.MLimitExceeded.ErrCode, Err: "Too many invalid two-factor authentication codes", StatusCode: http.StatusTooManyRequests}
+)
+
+var totpValidateOpts = hotp.ValidateOpts{
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+func (gmx *Gomuks) totpEnabled() bool {
+	return gmx.Config.Web.TOTPSecret != ""
+}
+
+// normalizeRecoveryCode removes formatting from a recovery code, so that it can be entered
+// with or without dashes and in any case.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func hashRecoveryCode(code string) string {
+	hash := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func generateRecoveryCodes() (codes, hashes []string) {
+	codes = make([]string, totpRecoveryCodeCount)
+	hashes = make([]string, totpRecoveryCodeCount)
+	for i := range codes {
+		code := strings.ToLower(random.String(12))
+		codes[i] = fmt.Sprintf("%s-%s-%s", code[:4], code[4:8], code[8:])
+		hashes[i] = hashRecoveryCode(code)
+	}
+	return
+}
+
+// EnrollTOTP sets up two-factor authentication for the web server interactively. The otpauth URI is
+// printed for adding to an authenticator app, and the secret is only saved after the user confirms
+// that the app generates valid codes. Enrolling again replaces the previous secret and recovery codes.
+func (gmx *Gomuks) EnrollTOTP() error {
+	if gmx.Config.Web.Username == "" {
+		return fmt.Errorf("web username is not set")
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      "gomuks",
+		AccountName: gmx.Config.Web.Username,
+		Period:      totpPeriod,
+		Digits:      totpValidateOpts.Digits,
+		Algorithm:   totpValidateOpts.Algorithm,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	fmt.Println("Add the following URI to your authenticator app:")
+	fmt.Println()
+	fmt.Println(key.URL())
+	fmt.Println()
+	code, err := readline.Line("Enter the code shown in the app to confirm: ")
+	if err != nil {
+		return fmt.Errorf("failed to read code: %w", err)
+	} else if !totp.Validate(strings.TrimSpace(code), key.Secret()) {
+		return fmt.Errorf("invalid code, two-factor authentication was not enabled")
+	}
+	codes, hashes := generateRecoveryCodes()
+	gmx.configLock.Lock()
+	defer gmx.configLock.Unlock()
+	gmx.Config.Web.TOTPSecret = key.Secret()
+	gmx.Config.Web.TOTPRecoveryCodes = hashes
+	err = gmx.SaveConfig()
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	fmt.Println()
+	fmt.Println("Two-factor authentication enabled. Store these recovery codes somewhere safe,")
+	fmt.Println("each of them can be used once instead of a code from the app:")
+	fmt.Println()
+	for _, code := range codes {
+		fmt.Println("  " + code)
+	}
+	fmt.Println()
+	fmt.Println("If gomuks is already running, restart it to apply the change.")
+	return nil
+}
+
+// DisableTOTP removes the TOTP secret and recovery codes, so that only the password is required to log in.
+func (gmx *Gomuks) DisableTOTP() error {
+	gmx.configLock.Lock()
+	defer gmx.configLock.Unlock()
+	if !gmx.totpEnabled() {
+		fmt.Println("Two-factor authentication is not enabled")
+		return nil
+	}
+	gmx.Config.Web.TOTPSecret = ""
+	gmx.Config.Web.TOTPRecoveryCodes = nil
+	err := gmx.SaveConfig()
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	fmt.Println("Two-factor authentication disabled. If gomuks is already running, restart it to apply the change.")
+	return nil
+}
+
+// verifySecondFactor checks a TOTP code or a recovery code. TOTP codes are accepted for one period
+// before and after the current one to allow for clock drift, but each period can only be used once.
+// Recovery codes are removed from the config after use.
+func (gmx *Gomuks) verifySecondFactor(ctx context.Context, code string) error {
+	gmx.totpLock.Lock()
+	defer gmx.totpLock.Unlock()
+	now := time.Now()
+	gmx.totpFailures = slices.DeleteFunc(gmx.totpFailures, func(ts time.Time) bool {
+		return now.Sub(ts) > totpFailureWindow
+	})
+	if len(gmx.totpFailures) >= totpMaxFailures {
+		zerolog.Ctx(ctx).Warn().Msg("Rejecting TOTP code due to too many recent failures")
+		return ErrTOTPLimited
+	}
+	code = strings.TrimSpace(code)
+	if len(code) == totpValidateOpts.Digits.Length() {
+		currentStep := uint64(now.Unix()) / totpPeriod
+		for step := currentStep - 1; step <= currentStep+1; step++ {
+			if step <= gmx.totpLastStep {
+				continue
+			}
+			valid, _ := hotp.ValidateCustom(code, step, gmx.Config.Web.TOTPSecret, totpValidateOpts)
+			if valid {
+				gmx.totpLastStep = step
+				return nil
+			}
+		}
+	} else if gmx.useRecoveryCode(ctx, code) {
+		return nil
+	}
+	gmx.totpFailures = append(gmx.totpFailures, now)
+	return ErrInvalidTOTP
+}
+
+func (gmx *Gomuks) useRecoveryCode(ctx context.Context, code string) bool {
+	gmx.configLock.Lock()
+	defer gmx.configLock.Unlock()
+	hash := hashRecoveryCode(code)
+	idx := slices.IndexFunc(gmx.Config.Web.TOTPRecoveryCodes, func(storedHash string) bool {
+		return hmac.Equal([]byte(storedHash), []byte(hash))
+	})
+	if idx < 0 {
+		return false
+	}
+	gmx.Config.Web.TOTPRecoveryCodes = slices.Delete(slices.Clone(gmx.Config.Web.TOTPRecoveryCodes), idx, idx+1)
+	err := gmx.SaveConfig()
+	if err != nil {
+		// The code was already removed from memory, so it can't be reused until the next restart
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to save config after using recovery code")
+	}
+	zerolog.Ctx(ctx).Info().Int("remaining_codes", len(gmx.Config.Web.TOTPRecoveryCodes)).Msg("TOTP recovery code used")
+	return true
+}
@@ -0,0 +1,117 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/hotp"
+)
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want string
+	}{
+		{"generated format", "abcd-efgh-ijkl", "abcdefghijkl"},
+		{"without dashes", "abcdefghijkl", "abcdefghijkl"},
+		{"uppercase", "ABCD-EFGH-IJKL", "abcdefghijkl"},
+		{"spaces instead of dashes", "abcd efgh ijkl", "abcdefghijkl"},
+		{"mixed separators", " Abcd - efgh-IJKL ", "abcdefghijkl"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := normalizeRecoveryCode(test.code); got != test.want {
+				t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", test.code, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGomuks_VerifySecondFactor(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXP"
+	codeForStep := func(step uint64) string {
+		code, err := hotp.GenerateCodeCustom(secret, step, totpValidateOpts)
+		if err != nil {
+			t.Fatalf("failed to generate code: %v", err)
+		}
+		return code
+	}
+	currentStep := uint64(time.Now().Unix()) / totpPeriod
+	validCodes := []string{codeForStep(currentStep - 1), codeForStep(currentStep), codeForStep(currentStep + 1)}
+	var wrongCode string
+	for i := 0; wrongCode == ""; i++ {
+		if candidate := fmt.Sprintf("%06d", i); !slices.Contains(validCodes, candidate) {
+			wrongCode = candidate
+		}
+	}
+	recoveryCodes, recoveryHashes := generateRecoveryCodes()
+
+	tests := []struct {
+		name          string
+		code          string
+		lastStep      uint64
+		failures      int
+		wantErr       error
+		wantRemaining int
+	}{
+		{name: "current code", code: codeForStep(currentStep)},
+		{name: "code with whitespace", code: " " + codeForStep(currentStep) + "\n"},
+		{name: "previous code", code: codeForStep(currentStep - 1)},
+		{name: "next code", code: codeForStep(currentStep + 1)},
+		{name: "expired code", code: codeForStep(currentStep - 2), wantErr: ErrInvalidTOTP},
+		{name: "wrong code", code: wrongCode, wantErr: ErrInvalidTOTP},
+		{name: "reused code", code: codeForStep(currentStep), lastStep: currentStep, wantErr: ErrInvalidTOTP},
+		{name: "code older than last used", code: codeForStep(currentStep - 1), lastStep: currentStep, wantErr: ErrInvalidTOTP},
+		{name: "rate limited", code: codeForStep(currentStep), failures: totpMaxFailures, wantErr: ErrTOTPLimited},
+		{name: "below rate limit", code: codeForStep(currentStep), failures: totpMaxFailures - 1},
+		{name: "recovery code", code: recoveryCodes[0], wantRemaining: -1},
+		{name: "unformatted recovery code", code: normalizeRecoveryCode(recoveryCodes[1]), wantRemaining: -1},
+		{name: "unknown recovery code", code: "aaaa-bbbb-cccc", wantErr: ErrInvalidTOTP},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gmx := &Gomuks{ConfigDir: t.TempDir(), totpLastStep: test.lastStep}
+			gmx.Config.Web.TOTPSecret = secret
+			gmx.Config.Web.TOTPRecoveryCodes = slices.Clone(recoveryHashes)
+			for range test.failures {
+				gmx.totpFailures = append(gmx.totpFailures, time.Now())
+			}
+			err := gmx.verifySecondFactor(context.Background(), test.code)
+			if test.wantErr == nil && err != nil {
+				t.Errorf("verifySecondFactor() error = %v, want nil", err)
+			} else if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("verifySecondFactor() error = %v, want %v", err, test.wantErr)
+			}
+			wantRemaining := len(recoveryHashes) + test.wantRemaining
+			if remaining := len(gmx.Config.Web.TOTPRecoveryCodes); remaining != wantRemaining {
+				t.Errorf("verifySecondFactor() left %d recovery codes, want %d", remaining, wantRemaining)
+			}
+			if test.wantErr == nil {
+				if err = gmx.verifySecondFactor(context.Background(), test.code); !errors.Is(err, ErrInvalidTOTP) {
+					t.Errorf("verifySecondFactor() with the same code again error = %v, want %v", err, ErrInvalidTOTP)
+				}
+			}
+		})
+	}
+}
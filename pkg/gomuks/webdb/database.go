@@ -0,0 +1,45 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package webdb contains the database of the gomuks web server itself,
+// as opposed to the per-account hicli databases.
+package webdb
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+	"go.mau.fi/util/dbutil"
+
+	"go.mau.fi/gomuks/pkg/gomuks/webdb/upgrades"
+)
+
+type Database struct {
+	*dbutil.Database
+
+	WebSession WebSessionQuery
+}
+
+func New(rawDB *dbutil.Database) *Database {
+	rawDB.UpgradeTable = upgrades.Table
+	return &Database{
+		Database: rawDB,
+
+		WebSession: WebSessionQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newWebSession)},
+	}
+}
+
+func newWebSession(_ *dbutil.QueryHelper[*WebSession]) *WebSession {
+	return &WebSession{}
+}
@@ -0,0 +1,10 @@
+-- v0 -> v1: Latest revision
+CREATE TABLE web_session (
+	id         TEXT    NOT NULL PRIMARY KEY,
+	label      TEXT    NOT NULL,
+	ip         TEXT    NOT NULL,
+	user_agent TEXT    NOT NULL,
+	created_at INTEGER NOT NULL,
+	last_seen  INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+) STRICT;
@@ -0,0 +1,95 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package webdb
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+)
+
+const (
+	getAllWebSessionsQuery = `
+		SELECT id, label, ip, user_agent, created_at, last_seen, expires_at FROM web_session ORDER BY last_seen DESC
+	`
+	upsertWebSessionQuery = `
+		INSERT INTO web_session (id, label, ip, user_agent, created_at, last_seen, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE
+			SET label = excluded.label,
+			    ip = excluded.ip,
+			    user_agent = excluded.user_agent,
+			    last_seen = excluded.last_seen,
+			    expires_at = excluded.expires_at
+	`
+	deleteWebSessionQuery         = `DELETE FROM web_session WHERE id = $1`
+	deleteExpiredWebSessionsQuery = `DELETE FROM web_session WHERE expires_at <= $1`
+)
+
+type WebSessionQuery struct {
+	*dbutil.QueryHelper[*WebSession]
+}
+
+func (wsq *WebSessionQuery) GetAll(ctx context.Context) ([]*WebSession, error) {
+	return wsq.QueryMany(ctx, getAllWebSessionsQuery)
+}
+
+func (wsq *WebSessionQuery) Put(ctx context.Context, session *WebSession) error {
+	return wsq.Exec(ctx, upsertWebSessionQuery, session.sqlVariables()...)
+}
+
+func (wsq *WebSessionQuery) Delete(ctx context.Context, id string) error {
+	return wsq.Exec(ctx, deleteWebSessionQuery, id)
+}
+
+func (wsq *WebSessionQuery) DeleteExpired(ctx context.Context) error {
+	return wsq.Exec(ctx, deleteExpiredWebSessionsQuery, time.Now().UnixMilli())
+}
+
+// WebSession is a login to the gomuks web server, i.e. a browser that has a gomuks_auth cookie.
+type WebSession struct {
+	ID        string             `json:"id"`
+	Label     string             `json:"label"`
+	IP        string             `json:"ip"`
+	UserAgent string             `json:"user_agent"`
+	CreatedAt jsontime.UnixMilli `json:"created_at"`
+	LastSeen  jsontime.UnixMilli `json:"last_seen"`
+	ExpiresAt jsontime.UnixMilli `json:"expires_at"`
+	// Current is set when listing sessions and is not stored in the database.
+	Current bool `json:"current,omitempty"`
+}
+
+func (ws *WebSession) sqlVariables() []any {
+	return []any{
+		ws.ID, ws.Label, ws.IP, ws.UserAgent,
+		ws.CreatedAt.UnixMilli(), ws.LastSeen.UnixMilli(), ws.ExpiresAt.UnixMilli(),
+	}
+}
+
+func (ws *WebSession) Scan(row dbutil.Scannable) (*WebSession, error) {
+	var createdAt, lastSeen, expiresAt int64
+	err := row.Scan(&ws.ID, &ws.Label, &ws.IP, &ws.UserAgent, &createdAt, &lastSeen, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	ws.CreatedAt = jsontime.UMInt(createdAt)
+	ws.LastSeen = jsontime.UMInt(lastSeen)
+	ws.ExpiresAt = jsontime.UMInt(expiresAt)
+	return ws, nil
+}
@@ -0,0 +1,311 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gomuks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"go.mau.fi/util/random"
+
+	"go.mau.fi/gomuks/pkg/gomuks/webdb"
+	"go.mau.fi/gomuks/pkg/hicli"
+)
+
+// webSessionTouchInterval is how often the last seen timestamp of a session is written to the database.
+const webSessionTouchInterval = 1 * time.Minute
+
+var ErrWebSessionNotFound = errors.New("web session not found")
+
+type revokeWebSessionParams struct {
+	// SessionID is the session to revoke. If empty, the session making the request is revoked.
+	SessionID string `json:"session_id"`
+}
+
+// openWebDatabase opens the web session database. It's stored in the config directory rather than the data
+// directory, as the data directory is deleted when logging out while the web sessions must stay valid.
+func (gmx *Gomuks) openWebDatabase() error {
+	rawDB, err := dbutil.NewFromConfig("gomuks", dbutil.Config{
+		PoolConfig: dbutil.PoolConfig{
+			Type:         "sqlite3-fk-wal",
+			URI:          fmt.Sprintf("file:%s/web.db?_txlock=immediate", gmx.ConfigDir),
+			MaxOpenConns: 5,
+			MaxIdleConns: 1,
+		},
+	}, dbutil.ZeroLogger(gmx.Log.With().Str("db_section", "web").Logger()))
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	gmx.WebDB = webdb.New(rawDB)
+	ctx := gmx.Log.WithContext(context.Background())
+	err = gmx.WebDB.Upgrade(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to upgrade database: %w", err)
+	}
+	err = gmx.WebDB.WebSession.DeleteExpired(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired web sessions: %w", err)
+	}
+	sessions, err := gmx.WebDB.WebSession.GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load web sessions: %w", err)
+	}
+	gmx.webSessionsLock.Lock()
+	defer gmx.webSessionsLock.Unlock()
+	gmx.webSessions = make(map[string]*webdb.WebSession, len(sessions))
+	for _, session := range sessions {
+		gmx.webSessions[session.ID] = session
+	}
+	return nil
+}
+
+func (gmx *Gomuks) webSessionExpiry(createdAt, now time.Time) time.Time {
+	expiry := now.Add(gmx.Config.Web.SessionIdleTimeout)
+	if gmx.Config.Web.SessionMaxLifetime > 0 {
+		maxExpiry := createdAt.Add(gmx.Config.Web.SessionMaxLifetime)
+		if maxExpiry.Before(expiry) {
+			expiry = maxExpiry
+		}
+	}
+	return expiry
+}
+
+// isTrustedProxy checks whether the given address is one of the configured trusted proxies.
+func (gmx *Gomuks) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range gmx.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// getClientIP returns the IP address of the client. The X-Forwarded-For header is only used if the request
+// comes from a trusted proxy, in which case the last address in the header that isn't a trusted proxy is used,
+// as anything before it may have been sent by the client itself.
+func (gmx *Gomuks) getClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remoteAddr, err := netip.ParseAddr(host)
+	if err != nil || !gmx.isTrustedProxy(remoteAddr) {
+		return host
+	}
+	forwardedFor := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwardedFor[i])
+		if ip == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !gmx.isTrustedProxy(addr) {
+			return ip
+		}
+		host = ip
+	}
+	return host
+}
+
+// describeUserAgent makes a short human-readable label like "Firefox on Linux" from a user agent string.
+func describeUserAgent(userAgent string) string {
+	var browser string
+	switch {
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	case userAgent == "":
+		return "Unknown client"
+	default:
+		browser, _, _ = strings.Cut(userAgent, "/")
+		return browser
+	}
+	var os string
+	switch {
+	case strings.Contains(userAgent, "Android"):
+		os = "Android"
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		os = "iOS"
+	case strings.Contains(userAgent, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(userAgent, "Windows"):
+		os = "Windows"
+	case strings.Contains(userAgent, "Linux"):
+		os = "Linux"
+	default:
+		return browser
+	}
+	return browser + " on " + os
+}
+
+// createWebSession stores a new web session for the client making the given request.
+// The label can be set with the device_label query parameter and defaults to a description of the user agent.
+func (gmx *Gomuks) createWebSession(r *http.Request) (*webdb.WebSession, error) {
+	label := strings.TrimSpace(r.URL.Query().Get("device_label"))
+	if label == "" {
+		label = describeUserAgent(r.UserAgent())
+	} else if len(label) > 128 {
+		label = label[:128]
+	}
+	now := time.Now()
+	session := &webdb.WebSession{
+		ID:        random.String(32),
+		Label:     label,
+		IP:        gmx.getClientIP(r),
+		UserAgent: r.UserAgent(),
+		CreatedAt: jsontime.UM(now),
+		LastSeen:  jsontime.UM(now),
+		ExpiresAt: jsontime.UM(gmx.webSessionExpiry(now, now)),
+	}
+	err := gmx.WebDB.WebSession.Put(r.Context(), session)
+	if err != nil {
+		return nil, err
+	}
+	gmx.webSessionsLock.Lock()
+	gmx.webSessions[session.ID] = session
+	gmx.webSessionsLock.Unlock()
+	zerolog.Ctx(r.Context()).Info().
+		Str("session_id", session.ID).
+		Str("label", session.Label).
+		Msg("Created web session")
+	return session, nil
+}
+
+// getWebSession returns the session with the given ID if it exists and hasn't expired.
+func (gmx *Gomuks) getWebSession(sessionID string) *webdb.WebSession {
+	gmx.webSessionsLock.RLock()
+	session, ok := gmx.webSessions[sessionID]
+	gmx.webSessionsLock.RUnlock()
+	if !ok || session.ExpiresAt.Before(time.Now()) {
+		return nil
+	}
+	return session
+}
+
+// touchWebSession updates the last seen timestamp, IP address and user agent of a session and extends
+// its expiry by the idle timeout. To avoid writing to the database on every request, the session is only
+// updated if it hasn't been updated in the last minute or if the IP address or user agent changed.
+func (gmx *Gomuks) touchWebSession(r *http.Request, session *webdb.WebSession) (*webdb.WebSession, bool) {
+	now := time.Now()
+	ip := gmx.getClientIP(r)
+	if now.Sub(session.LastSeen.Time) < webSessionTouchInterval && session.IP == ip && session.UserAgent == r.UserAgent() {
+		return session, false
+	}
+	// The cached session may be in use by other requests, so modify a copy
+	updated := *session
+	updated.IP = ip
+	updated.UserAgent = r.UserAgent()
+	updated.LastSeen = jsontime.UM(now)
+	updated.ExpiresAt = jsontime.UM(gmx.webSessionExpiry(session.CreatedAt.Time, now))
+	gmx.webSessionsLock.Lock()
+	if _, stillExists := gmx.webSessions[session.ID]; !stillExists {
+		gmx.webSessionsLock.Unlock()
+		return session, false
+	}
+	gmx.webSessions[session.ID] = &updated
+	gmx.webSessionsLock.Unlock()
+	err := gmx.WebDB.WebSession.Put(r.Context(), &updated)
+	if err != nil {
+		zerolog.Ctx(r.Context()).Err(err).Str("session_id", session.ID).Msg("Failed to update web session")
+	}
+	return &updated, true
+}
+
+// ListWebSessions returns all unexpired web sessions, most recently used first.
+func (gmx *Gomuks) ListWebSessions(currentSessionID string) []*webdb.WebSession {
+	gmx.webSessionsLock.RLock()
+	sessions := make([]*webdb.WebSession, 0, len(gmx.webSessions))
+	now := time.Now()
+	for _, session := range gmx.webSessions {
+		if session.ExpiresAt.After(now) {
+			sessionCopy := *session
+			sessionCopy.Current = session.ID == currentSessionID
+			sessions = append(sessions, &sessionCopy)
+		}
+	}
+	gmx.webSessionsLock.RUnlock()
+	slices.SortFunc(sessions, func(a, b *webdb.WebSession) int {
+		return b.LastSeen.Compare(a.LastSeen.Time)
+	})
+	return sessions
+}
+
+// RevokeWebSession deletes a web session and closes all websockets opened with it.
+func (gmx *Gomuks) RevokeWebSession(ctx context.Context, sessionID string) error {
+	gmx.webSessionsLock.Lock()
+	_, ok := gmx.webSessions[sessionID]
+	delete(gmx.webSessions, sessionID)
+	gmx.webSessionsLock.Unlock()
+	if !ok {
+		return ErrWebSessionNotFound
+	}
+	err := gmx.WebDB.WebSession.Delete(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to delete session from database: %w", err)
+	}
+	for _, closer := range gmx.EventBuffer.GetClosers(sessionID) {
+		closer(StatusSessionRevoked, "Session revoked")
+	}
+	zerolog.Ctx(ctx).Info().Str("session_id", sessionID).Msg("Revoked web session")
+	return nil
+}
+
+func (gmx *Gomuks) handleWebSessionCommand(ctx context.Context, req *hicli.JSONCommand) (any, error) {
+	if gmx.WebDB == nil {
+		return nil, fmt.Errorf("web sessions are not available")
+	}
+	var currentSessionID string
+	if session, ok := ctx.Value(contextKeyWebSession).(*webdb.WebSession); ok {
+		currentSessionID = session.ID
+	}
+	switch req.Command {
+	case "list_web_sessions":
+		return gmx.ListWebSessions(currentSessionID), nil
+	case "revoke_web_session":
+		var params revokeWebSessionParams
+		err := json.Unmarshal(req.Data, &params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal request data: %w", err)
+		}
+		if params.SessionID == "" {
+			if currentSessionID == "" {
+				return nil, fmt.Errorf("request wasn't made with a web session")
+			}
+			params.SessionID = currentSessionID
+		}
+		return true, gmx.RevokeWebSession(ctx, params.SessionID)
+	default:
+		return nil, fmt.Errorf("unknown web session command")
+	}
+}
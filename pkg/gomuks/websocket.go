@@ -31,6 +31,7 @@ import (
 	"github.com/rs/zerolog"
 	"go.mau.fi/util/exerrors"
 
+	"go.mau.fi/gomuks/pkg/gomuks/webdb"
 	"go.mau.fi/gomuks/pkg/hicli"
 )
 
@@ -47,8 +48,9 @@ func writeCmd[T any](ctx context.Context, conn *websocket.Conn, cmd *hicli.JSONC
 }
 
 const (
-	StatusEventsStuck = 4001
-	StatusPingTimeout = 4002
+	StatusEventsStuck    = 4001
+	StatusPingTimeout    = 4002
+	StatusSessionRevoked = 4003
 )
 
 var emptyObject = json.RawMessage("{}")
@@ -85,6 +87,17 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 	}
 	defer recoverPanic("read loop")
 
+	// Each websocket only streams the events of one account, as the initial data of every account would be
+	// wasted on clients that only display one of them.
+	client := gmx.getClientForRequest(w, r)
+	if client == nil {
+		return
+	}
+	accountID := r.URL.Query().Get("account_id")
+	if accountID == "" {
+		accountID = DefaultAccountID
+	}
+
 	conn, acceptErr := websocket.Accept(w, r, &websocket.AcceptOptions{
 		OriginPatterns: gmx.Config.Web.OriginPatterns,
 	})
@@ -95,6 +108,7 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 	resumeFrom, _ := strconv.ParseInt(r.URL.Query().Get("last_received_event"), 10, 64)
 	resumeRunID, _ := strconv.ParseInt(r.URL.Query().Get("run_id"), 10, 64)
 	log.Info().
+		Str("account_id", accountID).
 		Int64("resume_from", resumeFrom).
 		Int64("resume_run_id", resumeRunID).
 		Int64("current_run_id", runID).
@@ -102,6 +116,11 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 	conn.SetReadLimit(128 * 1024)
 	ctx, cancel := context.WithCancel(context.Background())
 	ctx = log.WithContext(ctx)
+	var sessionID string
+	if session, ok := r.Context().Value(contextKeyWebSession).(*webdb.WebSession); ok {
+		sessionID = session.ID
+		ctx = context.WithValue(ctx, contextKeyWebSession, session)
+	}
 	var listenerID uint64
 	evts := make(chan *hicli.JSONCommand, 32)
 	forceClose := func() {
@@ -123,8 +142,8 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 		resumeFrom = 0
 	}
 	var resumeData []*hicli.JSONCommand
-	listenerID, resumeData = gmx.EventBuffer.Subscribe(resumeFrom, closeManually, func(evt *hicli.JSONCommand) {
-		if ctx.Err() != nil {
+	listenerID, resumeData = gmx.EventBuffer.Subscribe(resumeFrom, sessionID, closeManually, func(evt *hicli.JSONCommand) {
+		if ctx.Err() != nil || evt.AccountID != accountID {
 			return
 		}
 		select {
@@ -148,7 +167,7 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 	sendImageAuthToken := func() {
 		err := writeCmd(ctx, conn, &hicli.JSONCommand{
 			Command: "image_auth_token",
-			Data:    exerrors.Must(json.Marshal(gmx.generateImageToken())),
+			Data:    exerrors.Must(json.Marshal(gmx.generateImageToken(sessionID))),
 		})
 		if err != nil {
 			log.Err(err).Msg("Failed to write image auth token message")
@@ -159,6 +178,9 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 		defer recoverPanic("event loop")
 		defer closeOnce.Do(forceClose)
 		for _, cmd := range resumeData {
+			if cmd.AccountID != accountID {
+				continue
+			}
 			err := writeCmd(ctx, conn, cmd)
 			if err != nil {
 				log.Err(err).Int64("req_id", cmd.RequestID).Msg("Failed to write outgoing event from resume data")
@@ -225,7 +247,7 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 				gmx.EventBuffer.SetLastAckedID(listenerID, pingData.LastReceivedID)
 			}
 		} else {
-			resp = gmx.Client.SubmitJSONCommand(ctx, cmd)
+			resp = gmx.SubmitJSONCommand(ctx, cmd)
 		}
 		if ctx.Err() != nil {
 			return
@@ -250,25 +272,27 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	initErr = writeCmd(ctx, conn, &hicli.JSONCommandCustom[*hicli.ClientState]{
-		Command: "client_state",
-		Data:    gmx.Client.State(),
+		Command:   "client_state",
+		AccountID: accountID,
+		Data:      client.State(),
 	})
 	if initErr != nil {
 		log.Err(initErr).Msg("Failed to write init client state message")
 		return
 	}
 	initErr = writeCmd(ctx, conn, &hicli.JSONCommandCustom[*hicli.SyncStatus]{
-		Command: "sync_status",
-		Data:    gmx.Client.SyncStatus.Load(),
+		Command:   "sync_status",
+		AccountID: accountID,
+		Data:      client.SyncStatus.Load(),
 	})
 	if initErr != nil {
 		log.Err(initErr).Msg("Failed to write init sync status message")
 		return
 	}
-	go sendImageAuthToken()
-	if gmx.Client.IsLoggedIn() && !didResume {
-		go gmx.sendInitialData(ctx, conn)
+	if client.IsLoggedIn() && !didResume {
+		go gmx.sendInitialData(ctx, conn, accountID, client)
 	}
+	go sendImageAuthToken()
 	log.Debug().Bool("did_resume", didResume).Msg("Connection initialization complete")
 	var closeErr websocket.CloseError
 	for {
@@ -303,10 +327,10 @@ func (gmx *Gomuks) HandleWebsocket(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (gmx *Gomuks) sendInitialData(ctx context.Context, conn *websocket.Conn) {
-	log := zerolog.Ctx(ctx)
+func (gmx *Gomuks) sendInitialData(ctx context.Context, conn *websocket.Conn, accountID string, client *hicli.HiClient) {
+	log := zerolog.Ctx(ctx).With().Str("account_id", accountID).Logger()
 	var roomCount int
-	for payload := range gmx.Client.GetInitialSync(ctx, 100) {
+	for payload := range client.GetInitialSync(ctx, 100) {
 		roomCount += len(payload.Rooms)
 		marshaledPayload, err := json.Marshal(&payload)
 		if err != nil {
@@ -316,6 +340,7 @@ func (gmx *Gomuks) sendInitialData(ctx context.Context, conn *websocket.Conn) {
 		err = writeCmd(ctx, conn, &hicli.JSONCommand{
 			Command:   "sync_complete",
 			RequestID: 0,
+			AccountID: accountID,
 			Data:      marshaledPayload,
 		})
 		if err != nil {
@@ -329,6 +354,7 @@ func (gmx *Gomuks) sendInitialData(ctx context.Context, conn *websocket.Conn) {
 	err := writeCmd(ctx, conn, &hicli.JSONCommand{
 		Command:   "init_complete",
 		RequestID: 0,
+		AccountID: accountID,
 	})
 	if err != nil {
 		log.Err(err).Msg("Failed to send initial rooms done event to client")
@@ -0,0 +1,104 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/id"
+)
+
+// RestoreKeyBackup downloads all megolm sessions in the current key backup version and imports them.
+// The backup is downloaded and imported one room at a time, starting with the most recently active
+// encrypted rooms, and progress is dispatched to the event handler after each room. Only rooms that
+// are in the local database are restored, as there wouldn't be any events to decrypt in other rooms.
+// Events that failed to decrypt are retried as sessions are imported (through the session received
+// callback). Sessions that fail to decrypt or import are counted in SessionsFailed.
+func (h *HiClient) RestoreKeyBackup(ctx context.Context) (*KeyBackupRestoreProgress, error) {
+	version := h.KeyBackupVersion
+	key := h.KeyBackupKey
+	if version == "" || key == nil {
+		return nil, fmt.Errorf("key backup is not set up")
+	}
+	log := zerolog.Ctx(ctx).With().
+		Str("action", "restore key backup").
+		Stringer("key_backup_version", version).
+		Logger()
+	ctx = log.WithContext(ctx)
+	roomIDs, err := h.DB.Room.GetEncryptedIDs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encrypted rooms: %w", err)
+	}
+	log.Info().Int("room_count", len(roomIDs)).Msg("Restoring key backup")
+	progress := &KeyBackupRestoreProgress{RoomsTotal: len(roomIDs)}
+	dispatchProgress := func() {
+		// The event handler may keep the event around (e.g. in a send queue), so send a copy every time
+		progressCopy := *progress
+		h.EventHandler(&progressCopy)
+	}
+	dispatchProgress()
+	for _, roomID := range roomIDs {
+		resp, err := h.Client.GetKeyBackupForRoom(ctx, version, roomID)
+		if errors.Is(err, mautrix.MNotFound) {
+			resp = &mautrix.RespRoomKeyBackup[backup.EncryptedSessionData[backup.MegolmSessionData]]{}
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get key backup for %s: %w", roomID, err)
+		}
+		err = h.restoreKeyBackupForRoom(ctx, roomID, resp.Sessions, progress)
+		if err != nil {
+			return nil, err
+		}
+		progress.RoomsDone++
+		dispatchProgress()
+	}
+	progress.Done = true
+	dispatchProgress()
+	log.Info().
+		Int("imported", progress.SessionsImported).
+		Int("skipped", progress.SessionsSkipped).
+		Int("failed", progress.SessionsFailed).
+		Msg("Finished restoring key backup")
+	return progress, nil
+}
+
+func (h *HiClient) restoreKeyBackupForRoom(
+	ctx context.Context,
+	roomID id.RoomID,
+	sessions map[id.SessionID]mautrix.RespKeyBackupData[backup.EncryptedSessionData[backup.MegolmSessionData]],
+	progress *KeyBackupRestoreProgress,
+) error {
+	log := zerolog.Ctx(ctx).With().Stringer("room_id", roomID).Logger()
+	for sessionID, data := range sessions {
+		existing, _ := h.CryptoStore.GetGroupSession(ctx, roomID, sessionID)
+		if existing != nil && existing.Internal.FirstKnownIndex() <= uint32(data.FirstMessageIndex) {
+			progress.SessionsSkipped++
+			continue
+		}
+		decrypted, err := data.SessionData.Decrypt(h.KeyBackupKey)
+		if err != nil {
+			log.Warn().Err(err).Stringer("session_id", sessionID).Msg("Failed to decrypt session from key backup")
+			progress.SessionsFailed++
+			continue
+		}
+		_, err = h.Crypto.ImportRoomKeyFromBackup(ctx, h.KeyBackupVersion, roomID, sessionID, decrypted)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warn().Err(err).Stringer("session_id", sessionID).Msg("Failed to import session from key backup")
+			progress.SessionsFailed++
+			continue
+		}
+		progress.SessionsImported++
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/rainbow"
+)
+
+type CommandArgumentType string
+
+const (
+	// CommandArgumentText is free-form text that consumes the rest of the message.
+	CommandArgumentText      CommandArgumentType = "text"
+	CommandArgumentString    CommandArgumentType = "string"
+	CommandArgumentUserID    CommandArgumentType = "user_id"
+	CommandArgumentEventType CommandArgumentType = "event_type"
+	CommandArgumentJSON      CommandArgumentType = "json"
+)
+
+type CommandArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Type is used by frontends to decide what to autocomplete.
+	Type     CommandArgumentType `json:"type"`
+	Optional bool                `json:"optional,omitempty"`
+}
+
+// CommandMessage is the message being built from the text entered by the user.
+// Command handlers modify it to change what gets sent.
+type CommandMessage struct {
+	// Text is the remaining text after the command. Handlers may change it before it's rendered.
+	Text    string
+	MsgType event.MessageType
+	// Render converts the text into message content. If nil, the text is rendered as markdown without HTML.
+	Render            func(text string) event.MessageEventContent
+	DisableEncryption bool
+	// If RawContent is set, it's sent as-is using RawType instead of building a message event.
+	RawType    event.Type
+	RawContent json.RawMessage
+	// NoEvent is set by commands that perform an action instead of sending a message.
+	NoEvent bool
+}
+
+type CommandInput struct {
+	Client  *HiClient
+	RoomID  id.RoomID
+	Command *Command
+	// Args is the text after the command name, without the leading space.
+	Args    string
+	Message *CommandMessage
+}
+
+// SplitArgs splits the arguments by spaces into at most n parts.
+func (ci *CommandInput) SplitArgs(n int) []string {
+	args := strings.TrimSpace(ci.Args)
+	if args == "" {
+		return nil
+	}
+	return strings.SplitN(args, " ", n)
+}
+
+type CommandHandler func(ctx context.Context, input *CommandInput) error
+
+type Command struct {
+	Name        string             `json:"name"`
+	Aliases     []string           `json:"aliases,omitempty"`
+	Description string             `json:"description"`
+	Arguments   []*CommandArgument `json:"arguments,omitempty"`
+	// Modifier commands change how the rest of the text is sent and can be followed by other commands.
+	Modifier bool `json:"modifier,omitempty"`
+	// Action commands do something other than sending a message, so they can't be scheduled.
+	Action  bool           `json:"action,omitempty"`
+	Handler CommandHandler `json:"-"`
+}
+
+// Usage returns a short usage string for the command, like `/kick <user> [reason]`.
+func (cmd *Command) Usage() string {
+	var buf strings.Builder
+	buf.WriteRune('/')
+	buf.WriteString(cmd.Name)
+	for _, arg := range cmd.Arguments {
+		if arg.Optional {
+			_, _ = fmt.Fprintf(&buf, " [%s]", arg.Name)
+		} else {
+			_, _ = fmt.Fprintf(&buf, " <%s>", arg.Name)
+		}
+	}
+	return buf.String()
+}
+
+func (cmd *Command) hasRequiredArguments() bool {
+	return slices.ContainsFunc(cmd.Arguments, func(arg *CommandArgument) bool {
+		return !arg.Optional
+	})
+}
+
+type CommandRegistry struct {
+	lock     sync.RWMutex
+	commands map[string]*Command
+}
+
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{commands: make(map[string]*Command)}
+}
+
+// Register adds commands to the registry. Existing commands with the same name or alias are replaced.
+func (cr *CommandRegistry) Register(cmds ...*Command) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	for _, cmd := range cmds {
+		cr.commands[cmd.Name] = cmd
+		for _, alias := range cmd.Aliases {
+			cr.commands[alias] = cmd
+		}
+	}
+}
+
+func (cr *CommandRegistry) Get(name string) *Command {
+	cr.lock.RLock()
+	defer cr.lock.RUnlock()
+	return cr.commands[name]
+}
+
+// List returns all registered commands sorted by name.
+func (cr *CommandRegistry) List() []*Command {
+	cr.lock.RLock()
+	uniqueCommands := make(map[*Command]struct{}, len(cr.commands))
+	for _, cmd := range cr.commands {
+		uniqueCommands[cmd] = struct{}{}
+	}
+	cr.lock.RUnlock()
+	return slices.SortedFunc(maps.Keys(uniqueCommands), func(a, b *Command) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+}
+
+// parse finds the command at the start of the given text. The command name must be followed by a space
+// or the end of the text. If the text doesn't start with a registered command, nil is returned.
+func (cr *CommandRegistry) parse(text string) (*Command, string) {
+	if !strings.HasPrefix(text, "/") {
+		return nil, ""
+	}
+	name, args, _ := strings.Cut(text[1:], " ")
+	return cr.Get(name), args
+}
+
+// runCommands executes the commands at the start of the message text. Modifier commands are followed
+// until a non-modifier command or plain text is reached. If allowActions is false, commands that
+// do something other than sending a message are rejected.
+func (h *HiClient) runCommands(ctx context.Context, roomID id.RoomID, text string, allowActions bool) (*CommandMessage, error) {
+	msg := &CommandMessage{Text: text, MsgType: event.MsgText}
+	for {
+		cmd, args := h.Commands.parse(msg.Text)
+		if cmd == nil {
+			break
+		} else if cmd.Action && !allowActions {
+			return nil, fmt.Errorf("/%s can't be used here", cmd.Name)
+		} else if strings.TrimSpace(args) == "" && cmd.hasRequiredArguments() {
+			return nil, fmt.Errorf("usage: %s", cmd.Usage())
+		}
+		msg.Text = args
+		err := cmd.Handler(ctx, &CommandInput{
+			Client:  h,
+			RoomID:  roomID,
+			Command: cmd,
+			Args:    args,
+			Message: msg,
+		})
+		if err != nil {
+			return nil, err
+		} else if !cmd.Modifier || msg.NoEvent || msg.RawContent != nil {
+			break
+		}
+	}
+	return msg, nil
+}
+
+func renderRainbow(text string) event.MessageEventContent {
+	content := format.RenderMarkdownCustom(text, rainbowWithHTML)
+	content.FormattedBody = rainbow.ApplyColor(content.FormattedBody)
+	return content
+}
+
+func renderHTML(text string) event.MessageEventContent {
+	return format.HTMLToContent(strings.Replace(text, "\n", "<br>", -1))
+}
+
+func setMsgType(msgType event.MessageType) CommandHandler {
+	return func(ctx context.Context, input *CommandInput) error {
+		input.Message.MsgType = msgType
+		return nil
+	}
+}
+
+func setRenderer(render func(text string) event.MessageEventContent) CommandHandler {
+	return func(ctx context.Context, input *CommandInput) error {
+		input.Message.Render = render
+		return nil
+	}
+}
+
+func membershipCommand(action MembershipAction, description string) *Command {
+	return &Command{
+		Name:        string(action),
+		Description: description,
+		Arguments: []*CommandArgument{
+			{Name: "user", Type: CommandArgumentUserID},
+			{Name: "reason", Type: CommandArgumentText, Optional: true},
+		},
+		Action: true,
+		Handler: func(ctx context.Context, input *CommandInput) error {
+			input.Message.NoEvent = true
+			args := input.SplitArgs(2)
+			userID := id.UserID(args[0])
+			var reason string
+			if len(args) == 2 {
+				reason = strings.TrimSpace(args[1])
+			}
+			results, err := input.Client.UpdateMembership(ctx, input.RoomID, action, []id.UserID{userID}, reason)
+			if err != nil {
+				return err
+			} else if results[0].Error != "" {
+				return fmt.Errorf("failed to %s %s: %s", action, userID, results[0].Error)
+			}
+			return nil
+		},
+	}
+}
+
+var textArgument = []*CommandArgument{{Name: "text", Type: CommandArgumentText}}
+
+func defaultCommands() []*Command {
+	return []*Command{{
+		Name:        "unencrypted",
+		Description: "Send the message without encryption",
+		Arguments:   textArgument,
+		Modifier:    true,
+		Handler: func(ctx context.Context, input *CommandInput) error {
+			input.Message.DisableEncryption = true
+			return nil
+		},
+	}, {
+		Name:        "raw",
+		Description: "Send an event with custom JSON content",
+		Arguments: []*CommandArgument{
+			{Name: "event type", Type: CommandArgumentEventType},
+			{Name: "content", Type: CommandArgumentJSON, Optional: true},
+		},
+		Handler: func(ctx context.Context, input *CommandInput) error {
+			args := input.SplitArgs(2)
+			content := json.RawMessage("{}")
+			if len(args) == 2 {
+				content = json.RawMessage(args[1])
+			}
+			if !json.Valid(content) {
+				return fmt.Errorf("invalid JSON in /raw command")
+			}
+			input.Message.RawType = event.Type{Type: args[0]}
+			input.Message.RawContent = content
+			return nil
+		},
+	}, {
+		Name:        "me",
+		Description: "Send an emote message",
+		Arguments:   textArgument,
+		Modifier:    true,
+		Handler:     setMsgType(event.MsgEmote),
+	}, {
+		Name:        "notice",
+		Description: "Send a notice message",
+		Arguments:   textArgument,
+		Modifier:    true,
+		Handler:     setMsgType(event.MsgNotice),
+	}, {
+		Name:        "rainbow",
+		Description: "Send a rainbow-colored message",
+		Arguments:   textArgument,
+		Handler:     setRenderer(renderRainbow),
+	}, {
+		Name:        "plain",
+		Description: "Send a message without markdown formatting",
+		Arguments:   textArgument,
+		Handler:     setRenderer(format.TextToContent),
+	}, {
+		Name:        "html",
+		Description: "Send a message with raw HTML formatting",
+		Arguments:   textArgument,
+		Handler:     setRenderer(renderHTML),
+	},
+		membershipCommand(MembershipActionInvite, "Invite a user to the current room"),
+		membershipCommand(MembershipActionKick, "Kick a user from the current room"),
+		membershipCommand(MembershipActionBan, "Ban a user from the current room"),
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"testing"
+
+	"maunium.net/go/mautrix/event"
+)
+
+func TestCommandRegistry_Parse(t *testing.T) {
+	cr := NewCommandRegistry()
+	cr.Register(defaultCommands()...)
+	tests := []struct {
+		name     string
+		text     string
+		wantCmd  string
+		wantArgs string
+	}{
+		{"plain text", "hello", "", ""},
+		{"command with args", "/me waves", "me", "waves"},
+		{"command without args", "/me", "me", ""},
+		{"extra spaces are kept", "/me  waves", "me", " waves"},
+		{"no space after name", "/mewaves", "", ""},
+		{"unknown command", "/foo bar", "", ""},
+		{"slash in the middle", "hello /me", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd, args := cr.parse(test.text)
+			var cmdName string
+			if cmd != nil {
+				cmdName = cmd.Name
+			}
+			if cmdName != test.wantCmd {
+				t.Errorf("parse() command = %q, want %q", cmdName, test.wantCmd)
+			}
+			if cmd != nil && args != test.wantArgs {
+				t.Errorf("parse() args = %q, want %q", args, test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestHiClient_RunCommands(t *testing.T) {
+	h := &HiClient{Commands: NewCommandRegistry()}
+	h.Commands.Register(defaultCommands()...)
+	tests := []struct {
+		name         string
+		text         string
+		allowActions bool
+		wantErr      bool
+		wantText     string
+		wantMsgType  event.MessageType
+		wantNoEncr   bool
+		wantRawType  string
+		wantRendered bool
+	}{
+		{name: "plain text", text: "hello", wantText: "hello", wantMsgType: event.MsgText},
+		{name: "unknown command", text: "/foo bar", wantText: "/foo bar", wantMsgType: event.MsgText},
+		{name: "emote", text: "/me waves", wantText: "waves", wantMsgType: event.MsgEmote},
+		{name: "chained modifiers", text: "/unencrypted /notice hi", wantText: "hi", wantMsgType: event.MsgNotice, wantNoEncr: true},
+		{name: "modifier before renderer", text: "/me /rainbow hi", wantText: "hi", wantMsgType: event.MsgEmote, wantRendered: true},
+		{name: "renderer stops parsing", text: "/plain /me hi", wantText: "/me hi", wantMsgType: event.MsgText, wantRendered: true},
+		{name: "raw event", text: `/raw com.example.test {"foo":1}`, wantText: `com.example.test {"foo":1}`, wantMsgType: event.MsgText, wantRawType: "com.example.test"},
+		{name: "raw event with invalid JSON", text: "/raw com.example.test {", wantErr: true},
+		{name: "missing required argument", text: "/me", wantErr: true},
+		{name: "missing required argument of action", text: "/kick ", allowActions: true, wantErr: true},
+		{name: "action not allowed", text: "/kick @user:example.com", wantErr: true},
+		{name: "action not allowed after modifier", text: "/unencrypted /ban @user:example.com", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := h.runCommands(context.Background(), "!room:example.com", test.text, test.allowActions)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("runCommands() error = nil, want error")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("runCommands() error = %v", err)
+			}
+			if msg.Text != test.wantText {
+				t.Errorf("runCommands() text = %q, want %q", msg.Text, test.wantText)
+			}
+			if msg.MsgType != test.wantMsgType {
+				t.Errorf("runCommands() msgtype = %q, want %q", msg.MsgType, test.wantMsgType)
+			}
+			if msg.DisableEncryption != test.wantNoEncr {
+				t.Errorf("runCommands() disable encryption = %v, want %v", msg.DisableEncryption, test.wantNoEncr)
+			}
+			if msg.RawType.Type != test.wantRawType {
+				t.Errorf("runCommands() raw type = %q, want %q", msg.RawType.Type, test.wantRawType)
+			}
+			if (msg.Render != nil) != test.wantRendered {
+				t.Errorf("runCommands() has renderer = %v, want %v", msg.Render != nil, test.wantRendered)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/ptr"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type RoomPreset string
+
+const (
+	RoomPresetPrivateChat        RoomPreset = "private_chat"
+	RoomPresetTrustedPrivateChat RoomPreset = "trusted_private_chat"
+	RoomPresetPublicChat         RoomPreset = "public_chat"
+)
+
+type CreateRoomParams struct {
+	Name      string      `json:"name,omitempty"`
+	Topic     string      `json:"topic,omitempty"`
+	AliasName string      `json:"room_alias_name,omitempty"`
+	Preset    RoomPreset  `json:"preset,omitempty"`
+	Invite    []id.UserID `json:"invite,omitempty"`
+	// IsDirect marks the room as a direct chat and adds it to the m.direct account data for every invitee.
+	IsDirect  bool `json:"is_direct,omitempty"`
+	Encrypted bool `json:"encrypted,omitempty"`
+	// ParentID is a space to add the new room to. The room gets a canonical m.space.parent event
+	// and a m.space.child event is sent to the space.
+	ParentID    id.RoomID `json:"parent_id,omitempty"`
+	RoomVersion string    `json:"room_version,omitempty"`
+	// Visibility specifies whether the room is published in the server's room directory.
+	Visibility string `json:"visibility,omitempty"`
+}
+
+func (h *HiClient) CreateRoom(ctx context.Context, params *CreateRoomParams) (*mautrix.RespCreateRoom, error) {
+	preset := params.Preset
+	switch preset {
+	case "":
+		if params.IsDirect {
+			preset = RoomPresetTrustedPrivateChat
+		} else {
+			preset = RoomPresetPrivateChat
+		}
+	case RoomPresetPrivateChat, RoomPresetTrustedPrivateChat, RoomPresetPublicChat:
+	default:
+		return nil, fmt.Errorf("unsupported room preset %q", preset)
+	}
+	if params.IsDirect && len(params.Invite) == 0 {
+		return nil, fmt.Errorf("direct chats must have at least one invitee")
+	}
+	req := &mautrix.ReqCreateRoom{
+		Visibility:    params.Visibility,
+		RoomAliasName: params.AliasName,
+		Name:          params.Name,
+		Topic:         params.Topic,
+		Invite:        params.Invite,
+		Preset:        string(preset),
+		IsDirect:      params.IsDirect,
+		RoomVersion:   params.RoomVersion,
+	}
+	if params.Encrypted {
+		req.InitialState = append(req.InitialState, &event.Event{
+			Type:     event.StateEncryption,
+			StateKey: ptr.Ptr(""),
+			Content: event.Content{Parsed: &event.EncryptionEventContent{
+				Algorithm: id.AlgorithmMegolmV1,
+			}},
+		})
+	}
+	var via []string
+	if params.ParentID != "" {
+		via = []string{h.Account.UserID.Homeserver()}
+		req.InitialState = append(req.InitialState, &event.Event{
+			Type:     event.StateSpaceParent,
+			StateKey: ptr.Ptr(params.ParentID.String()),
+			Content: event.Content{Parsed: &event.SpaceParentEventContent{
+				Via:       via,
+				Canonical: true,
+			}},
+		})
+	}
+	resp, err := h.Client.CreateRoom(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	log := zerolog.Ctx(ctx).With().Stringer("room_id", resp.RoomID).Logger()
+	log.Info().Msg("Created room")
+	if params.IsDirect {
+		err = h.addDirectChat(ctx, resp.RoomID, params.Invite)
+		if err != nil {
+			return resp, fmt.Errorf("created room %s, but failed to update direct chats: %w", resp.RoomID, err)
+		}
+	}
+	if params.ParentID != "" {
+		_, err = h.Client.SendStateEvent(ctx, params.ParentID, event.StateSpaceChild, resp.RoomID.String(), &event.SpaceChildEventContent{
+			Via: via,
+		})
+		if err != nil {
+			return resp, fmt.Errorf("created room %s, but failed to add it to space: %w", resp.RoomID, err)
+		}
+	}
+	return resp, nil
+}
+
+func (h *HiClient) addDirectChat(ctx context.Context, roomID id.RoomID, userIDs []id.UserID) error {
+	var content event.DirectChatsEventContent
+	err := h.Client.GetAccountData(ctx, event.AccountDataDirectChats.Type, &content)
+	if err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return fmt.Errorf("failed to get current direct chats: %w", err)
+	} else if content == nil {
+		content = make(event.DirectChatsEventContent)
+	}
+	for _, userID := range userIDs {
+		if !slices.Contains(content[userID], roomID) {
+			content[userID] = append(content[userID], roomID)
+		}
+	}
+	return h.Client.SetAccountData(ctx, event.AccountDataDirectChats.Type, &content)
+}
@@ -12,13 +12,15 @@ import (
 	"errors"
 
 	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
 
 const (
-	getAccountQuery    = `SELECT user_id, device_id, access_token, homeserver_url, next_batch FROM account WHERE user_id = $1`
-	putNextBatchQuery  = `UPDATE account SET next_batch = $1 WHERE user_id = $2`
-	upsertAccountQuery = `
+	getAccountQuery      = `SELECT user_id, device_id, access_token, homeserver_url, next_batch, sync_presence FROM account WHERE user_id = $1`
+	putNextBatchQuery    = `UPDATE account SET next_batch = $1 WHERE user_id = $2`
+	putSyncPresenceQuery = `UPDATE account SET sync_presence = $1 WHERE user_id = $2`
+	upsertAccountQuery   = `
 		INSERT INTO account (user_id, device_id, access_token, homeserver_url, next_batch)
 		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (user_id)
 			DO UPDATE SET device_id = excluded.device_id,
@@ -52,6 +54,10 @@ func (aq *AccountQuery) PutNextBatch(ctx context.Context, userID id.UserID, next
 	return aq.Exec(ctx, putNextBatchQuery, nextBatch, userID)
 }
 
+func (aq *AccountQuery) PutSyncPresence(ctx context.Context, userID id.UserID, presence event.Presence) error {
+	return aq.Exec(ctx, putSyncPresenceQuery, presence, userID)
+}
+
 func (aq *AccountQuery) Put(ctx context.Context, account *Account) error {
 	return aq.Exec(ctx, upsertAccountQuery, account.sqlVariables()...)
 }
@@ -62,10 +68,12 @@ type Account struct {
 	AccessToken   string
 	HomeserverURL string
 	NextBatch     string
+	// SyncPresence is the presence sent in sync requests, or empty to use the server default.
+	SyncPresence event.Presence
 }
 
 func (a *Account) Scan(row dbutil.Scannable) (*Account, error) {
-	return dbutil.ValueOrErr(a, row.Scan(&a.UserID, &a.DeviceID, &a.AccessToken, &a.HomeserverURL, &a.NextBatch))
+	return dbutil.ValueOrErr(a, row.Scan(&a.UserID, &a.DeviceID, &a.AccessToken, &a.HomeserverURL, &a.NextBatch, &a.SyncPresence))
 }
 
 func (a *Account) sqlVariables() []any {
@@ -27,6 +27,11 @@ type Database struct {
 	SessionRequest SessionRequestQuery
 	Receipt        ReceiptQuery
 	Media          MediaQuery
+	SpaceEdge      SpaceEdgeQuery
+	Presence       PresenceQuery
+	ScheduledEvent ScheduledEventQuery
+	Outbox         OutboxQuery
+	Draft          DraftQuery
 }
 
 func New(rawDB *dbutil.Database) *Database {
@@ -45,6 +50,11 @@ func New(rawDB *dbutil.Database) *Database {
 		SessionRequest: SessionRequestQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newSessionRequest)},
 		Receipt:        ReceiptQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newReceipt)},
 		Media:          MediaQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newMedia)},
+		SpaceEdge:      SpaceEdgeQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newSpaceEdge)},
+		Presence:       PresenceQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newPresence)},
+		ScheduledEvent: ScheduledEventQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newScheduledEvent)},
+		Outbox:         OutboxQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newOutboxEntry)},
+		Draft:          DraftQuery{QueryHelper: dbutil.MakeQueryHelper(rawDB, newDraft)},
 	}
 }
 
@@ -72,6 +82,26 @@ func newMedia(_ *dbutil.QueryHelper[*Media]) *Media {
 	return &Media{}
 }
 
+func newSpaceEdge(_ *dbutil.QueryHelper[*SpaceEdge]) *SpaceEdge {
+	return &SpaceEdge{}
+}
+
+func newPresence(_ *dbutil.QueryHelper[*Presence]) *Presence {
+	return &Presence{}
+}
+
+func newScheduledEvent(_ *dbutil.QueryHelper[*ScheduledEvent]) *ScheduledEvent {
+	return &ScheduledEvent{}
+}
+
+func newOutboxEntry(_ *dbutil.QueryHelper[*OutboxEntry]) *OutboxEntry {
+	return &OutboxEntry{}
+}
+
+func newDraft(_ *dbutil.QueryHelper[*Draft]) *Draft {
+	return &Draft{}
+}
+
 func newAccountData(_ *dbutil.QueryHelper[*AccountData]) *AccountData {
 	return &AccountData{}
 }
@@ -0,0 +1,70 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getAllDraftsQuery = `
+		SELECT room_id, thread_id, content, updated_at FROM draft ORDER BY updated_at DESC
+	`
+	upsertDraftQuery = `
+		INSERT INTO draft (room_id, thread_id, content, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (room_id, thread_id) DO UPDATE
+			SET content = excluded.content, updated_at = excluded.updated_at
+	`
+	deleteDraftQuery = `DELETE FROM draft WHERE room_id = $1 AND thread_id = $2`
+)
+
+type DraftQuery struct {
+	*dbutil.QueryHelper[*Draft]
+}
+
+func (dq *DraftQuery) GetAll(ctx context.Context) ([]*Draft, error) {
+	return dq.QueryMany(ctx, getAllDraftsQuery)
+}
+
+func (dq *DraftQuery) Put(ctx context.Context, draft *Draft) error {
+	return dq.Exec(ctx, upsertDraftQuery, draft.sqlVariables()...)
+}
+
+func (dq *DraftQuery) Delete(ctx context.Context, roomID id.RoomID, threadID id.EventID) error {
+	return dq.Exec(ctx, deleteDraftQuery, roomID, threadID)
+}
+
+// Draft is an unsent message in a room or thread. The content is opaque to the backend:
+// it's whatever the frontend needs to restore the composer.
+type Draft struct {
+	RoomID    id.RoomID          `json:"room_id"`
+	ThreadID  id.EventID         `json:"thread_id,omitempty"`
+	Content   json.RawMessage    `json:"content"`
+	UpdatedAt jsontime.UnixMilli `json:"updated_at"`
+}
+
+func (d *Draft) sqlVariables() []any {
+	return []any{d.RoomID, d.ThreadID, unsafeJSONString(d.Content), d.UpdatedAt.UnixMilli()}
+}
+
+func (d *Draft) Scan(row dbutil.Scannable) (*Draft, error) {
+	var content []byte
+	var updatedAt int64
+	err := row.Scan(&d.RoomID, &d.ThreadID, &content, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	d.Content = content
+	d.UpdatedAt = jsontime.UMInt(updatedAt)
+	return d, nil
+}
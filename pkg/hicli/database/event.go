@@ -28,27 +28,29 @@ const (
 		SELECT rowid, -1,
 		       room_id, event_id, sender, type, state_key, timestamp, content, decrypted, decrypted_type,
 		       unsigned, local_content, transaction_id, redacted_by, relates_to, relation_type,
-		       megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type
+		       megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type, trust_state
 		FROM event
 	`
 	getEventByRowID                  = getEventBaseQuery + `WHERE rowid = $1`
 	getManyEventsByRowID             = getEventBaseQuery + `WHERE rowid IN (%s)`
 	getEventByID                     = getEventBaseQuery + `WHERE event_id = $1`
+	getManyEventsByID                = getEventBaseQuery + `WHERE event_id IN (%s)`
 	getEventByTransactionID          = getEventBaseQuery + `WHERE transaction_id = $1`
 	getFailedEventsByMegolmSessionID = getEventBaseQuery + `WHERE room_id = $1 AND megolm_session_id = $2 AND decryption_error IS NOT NULL`
 	insertEventBaseQuery             = `
 		INSERT INTO event (
 			room_id, event_id, sender, type, state_key, timestamp, content, decrypted, decrypted_type,
 			unsigned, local_content, transaction_id, redacted_by, relates_to, relation_type,
-			megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type
+			megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type, trust_state
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
 	`
 	insertEventQuery = insertEventBaseQuery + `RETURNING rowid`
 	upsertEventQuery = insertEventBaseQuery + `
 		ON CONFLICT (event_id) DO UPDATE
 			SET decrypted=COALESCE(event.decrypted, excluded.decrypted),
 			    decrypted_type=COALESCE(event.decrypted_type, excluded.decrypted_type),
+			    trust_state=CASE WHEN event.decrypted IS NULL THEN excluded.trust_state ELSE event.trust_state END,
 			    redacted_by=COALESCE(event.redacted_by, excluded.redacted_by),
 			    decryption_error=CASE WHEN COALESCE(event.decrypted, excluded.decrypted) IS NULL THEN COALESCE(excluded.decryption_error, event.decryption_error) END,
 			    send_error=excluded.send_error,
@@ -63,7 +65,7 @@ const (
 	`
 	updateEventSendErrorQuery        = `UPDATE event SET send_error = $2 WHERE rowid = $1`
 	updateEventIDQuery               = `UPDATE event SET event_id = $2, send_error = NULL WHERE rowid=$1`
-	updateEventDecryptedQuery        = `UPDATE event SET decrypted = $2, decrypted_type = $3, decryption_error = NULL, unread_type = $4, local_content = $5 WHERE rowid = $1`
+	updateEventDecryptedQuery        = `UPDATE event SET decrypted = $2, decrypted_type = $3, decryption_error = NULL, unread_type = $4, local_content = $5, trust_state = $6 WHERE rowid = $1`
 	updateEventLocalContentQuery     = `UPDATE event SET local_content = $2 WHERE rowid = $1`
 	updateEventEncryptedContentQuery = `UPDATE event SET content = $2, megolm_session_id = $3 WHERE rowid = $1`
 	getEventReactionsQuery           = getEventBaseQuery + `
@@ -90,6 +92,18 @@ const (
 		UPDATE event SET last_edit_rowid = $2 WHERE event_id = $1
 	`
 	updateReactionCountsQuery = `UPDATE event SET reactions = $2 WHERE event_id = $1`
+	getTrustStateSendersQuery = `
+		SELECT DISTINCT sender FROM event WHERE trust_state IS NOT NULL
+	`
+	getTrustStateSessionsQuery = `
+		SELECT DISTINCT room_id, megolm_session_id FROM event
+		WHERE sender = $1 AND trust_state IS NOT NULL AND megolm_session_id IS NOT NULL
+	`
+	updateTrustStateQuery = `
+		UPDATE event SET trust_state = $4
+		WHERE room_id = $1 AND megolm_session_id = $2 AND sender = $3 AND trust_state IS NOT NULL AND trust_state <> $4
+		RETURNING rowid
+	`
 )
 
 type EventQuery struct {
@@ -104,6 +118,14 @@ func (eq *EventQuery) GetByID(ctx context.Context, eventID id.EventID) (*Event,
 	return eq.QueryOne(ctx, getEventByID, eventID)
 }
 
+func (eq *EventQuery) GetByIDs(ctx context.Context, eventIDs ...id.EventID) ([]*Event, error) {
+	if len(eventIDs) == 0 {
+		return nil, nil
+	}
+	query, params := buildMultiEventGetFunction(nil, eventIDs, getManyEventsByID)
+	return eq.QueryMany(ctx, query, params...)
+}
+
 func (eq *EventQuery) GetByTransactionID(ctx context.Context, txnID string) (*Event, error) {
 	return eq.QueryOne(ctx, getEventByTransactionID, txnID)
 }
@@ -134,8 +156,8 @@ func (eq *EventQuery) Insert(ctx context.Context, evt *Event) (rowID EventRowID,
 }
 
 var stateEventMassInserter = dbutil.NewMassInsertBuilder[*Event, [1]any](
-	strings.ReplaceAll(upsertEventQuery, "($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)", "($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"),
-	"($1, $%d, $%d, $%d, $%d, $%d, $%d, NULL, NULL, $%d, NULL, $%d, $%d, NULL, NULL, NULL, NULL, NULL, '{}', 0, 0)",
+	strings.ReplaceAll(upsertEventQuery, "($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)", "($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"),
+	"($1, $%d, $%d, $%d, $%d, $%d, $%d, NULL, NULL, $%d, NULL, $%d, $%d, NULL, NULL, NULL, NULL, NULL, '{}', 0, 0, NULL)",
 )
 
 var massInsertConverter = dbutil.ConvertRowFn[EventRowID](dbutil.ScanSingleColumn[EventRowID])
@@ -174,6 +196,33 @@ func (eq *EventQuery) UpdateSendError(ctx context.Context, rowID EventRowID, sen
 	return eq.Exec(ctx, updateEventSendErrorQuery, rowID, sendError)
 }
 
+type RoomSession struct {
+	RoomID    id.RoomID
+	SessionID id.SessionID
+}
+
+// GetTrustStateSenders returns the senders of all decrypted events that have a trust state.
+func (eq *EventQuery) GetTrustStateSenders(ctx context.Context) ([]id.UserID, error) {
+	rows, err := eq.GetDB().Query(ctx, getTrustStateSendersQuery)
+	return dbutil.NewRowIterWithError(rows, dbutil.ScanSingleColumn[id.UserID], err).AsList()
+}
+
+// GetTrustStateSessions returns the megolm sessions of all decrypted events sent by the given user.
+func (eq *EventQuery) GetTrustStateSessions(ctx context.Context, sender id.UserID) ([]RoomSession, error) {
+	rows, err := eq.GetDB().Query(ctx, getTrustStateSessionsQuery, sender)
+	return dbutil.NewRowIterWithError(rows, func(row dbutil.Scannable) (rs RoomSession, err error) {
+		err = row.Scan(&rs.RoomID, &rs.SessionID)
+		return
+	}, err).AsList()
+}
+
+// UpdateTrustState changes the stored trust state of decrypted events from the given sender and megolm session.
+// It returns the row IDs of the events whose trust state changed.
+func (eq *EventQuery) UpdateTrustState(ctx context.Context, roomID id.RoomID, sessionID id.SessionID, sender id.UserID, trust id.TrustState) ([]EventRowID, error) {
+	rows, err := eq.GetDB().Query(ctx, updateTrustStateQuery, roomID, sessionID, sender, trust)
+	return dbutil.NewRowIterWithError(rows, dbutil.ScanSingleColumn[EventRowID], err).AsList()
+}
+
 func (eq *EventQuery) UpdateDecrypted(ctx context.Context, evt *Event) error {
 	return eq.Exec(
 		ctx,
@@ -183,6 +232,7 @@ func (eq *EventQuery) UpdateDecrypted(ctx context.Context, evt *Event) error {
 		evt.DecryptedType,
 		evt.UnreadType,
 		dbutil.JSONPtr(evt.LocalContent),
+		evt.TrustState,
 	)
 }
 
@@ -370,6 +420,9 @@ type Event struct {
 	Reactions     map[string]int `json:"reactions,omitempty"`
 	LastEditRowID *EventRowID    `json:"last_edit_rowid,omitempty"`
 	UnreadType    UnreadType     `json:"unread_type,omitempty"`
+	// TrustState is the trust state of the device that sent the event. It's only set for decrypted events.
+	// It is re-evaluated when the sender is verified or their keys change (see HiClient.RefreshTrustStates).
+	TrustState *id.TrustState `json:"trust_state,omitempty"`
 }
 
 func MautrixToEvent(evt *event.Event) *Event {
@@ -448,6 +501,7 @@ func (e *Event) Scan(row dbutil.Scannable) (*Event, error) {
 		dbutil.JSON{Data: &e.Reactions},
 		&e.LastEditRowID,
 		&e.UnreadType,
+		&e.TrustState,
 	)
 	if err != nil {
 		return nil, err
@@ -533,6 +587,7 @@ func (e *Event) sqlVariables() []any {
 		dbutil.JSON{Data: reactions},
 		e.LastEditRowID,
 		e.UnreadType,
+		e.TrustState,
 	}
 }
 
@@ -0,0 +1,13 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build sqlite_fts5 || fts5
+
+package database
+
+// FTS5Enabled is true when go-sqlite3 was built with the FTS5 extension,
+// which the message search index requires.
+const FTS5Enabled = true
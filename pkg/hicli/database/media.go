@@ -44,6 +44,12 @@ const (
 		FROM media
 		WHERE mxc = $1
 	`
+	getCachedMediaHashesQuery = `
+		SELECT DISTINCT hash FROM media WHERE hash IS NOT NULL
+	`
+	hasCachedMediaHashQuery = `
+		SELECT EXISTS(SELECT 1 FROM media WHERE hash = $1)
+	`
 	addMediaReferenceQuery = `
 		INSERT INTO media_reference (event_rowid, media_mxc)
 		VALUES ($1, $2)
@@ -67,6 +73,18 @@ func (mq *MediaQuery) Add(ctx context.Context, cm *Media) error {
 	return mq.Exec(ctx, insertMediaQuery, cm.sqlVariables()...)
 }
 
+// GetCachedHashes returns the hashes of all media files in the cache directory that are used by this database.
+func (mq *MediaQuery) GetCachedHashes(ctx context.Context) ([][]byte, error) {
+	rows, err := mq.GetDB().Query(ctx, getCachedMediaHashesQuery)
+	return dbutil.NewRowIterWithError(rows, dbutil.ScanSingleColumn[[]byte], err).AsList()
+}
+
+// HasCachedHash checks if any media in this database uses the cached file with the given hash.
+func (mq *MediaQuery) HasCachedHash(ctx context.Context, hash []byte) (exists bool, err error) {
+	err = mq.GetDB().QueryRow(ctx, hasCachedMediaHashQuery, hash).Scan(&exists)
+	return
+}
+
 func (mq *MediaQuery) AddReference(ctx context.Context, evtRowID EventRowID, mxc id.ContentURI) error {
 	return mq.Exec(ctx, addMediaReferenceQuery, evtRowID, &mxc)
 }
@@ -0,0 +1,95 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getOutboxBaseQuery = `
+		SELECT event_rowid, room_id, attempts, next_attempt_at, last_error
+		FROM outbox
+	`
+	// Only the oldest entry of each room is returned to ensure events are sent in order
+	getOutboxHeadsQuery = getOutboxBaseQuery + `
+		WHERE event_rowid IN (SELECT MIN(event_rowid) FROM outbox GROUP BY room_id)
+		ORDER BY next_attempt_at
+	`
+	getOutboxByRoomQuery = getOutboxBaseQuery + `WHERE room_id = $1 ORDER BY event_rowid`
+	addOutboxEntryQuery  = `
+		INSERT INTO outbox (event_rowid, room_id, attempts, next_attempt_at, last_error)
+		VALUES ($1, $2, 0, $3, NULL)
+		ON CONFLICT (event_rowid) DO UPDATE
+			SET attempts = 0, next_attempt_at = excluded.next_attempt_at, last_error = NULL
+	`
+	updateOutboxEntryQuery = `
+		UPDATE outbox SET attempts = $2, next_attempt_at = $3, last_error = $4 WHERE event_rowid = $1
+	`
+	removeOutboxEntryQuery  = `DELETE FROM outbox WHERE event_rowid = $1`
+	resetOutboxBackoffQuery = `UPDATE outbox SET next_attempt_at = $1 WHERE next_attempt_at > $1`
+)
+
+type OutboxQuery struct {
+	*dbutil.QueryHelper[*OutboxEntry]
+}
+
+func (oq *OutboxQuery) Add(ctx context.Context, rowID EventRowID, roomID id.RoomID) error {
+	return oq.Exec(ctx, addOutboxEntryQuery, rowID, roomID, time.Now().UnixMilli())
+}
+
+// GetHeads returns the first pending entry in each room.
+func (oq *OutboxQuery) GetHeads(ctx context.Context) ([]*OutboxEntry, error) {
+	return oq.QueryMany(ctx, getOutboxHeadsQuery)
+}
+
+func (oq *OutboxQuery) GetByRoom(ctx context.Context, roomID id.RoomID) ([]*OutboxEntry, error) {
+	return oq.QueryMany(ctx, getOutboxByRoomQuery, roomID)
+}
+
+func (oq *OutboxQuery) Update(ctx context.Context, entry *OutboxEntry) error {
+	return oq.Exec(ctx, updateOutboxEntryQuery, entry.sqlVariables()...)
+}
+
+func (oq *OutboxQuery) Remove(ctx context.Context, rowID EventRowID) error {
+	return oq.Exec(ctx, removeOutboxEntryQuery, rowID)
+}
+
+// ResetBackoff makes all pending entries eligible for sending immediately.
+func (oq *OutboxQuery) ResetBackoff(ctx context.Context) error {
+	return oq.Exec(ctx, resetOutboxBackoffQuery, time.Now().UnixMilli())
+}
+
+type OutboxEntry struct {
+	EventRowID  EventRowID         `json:"event_rowid"`
+	RoomID      id.RoomID          `json:"room_id"`
+	Attempts    int                `json:"attempts"`
+	NextAttempt jsontime.UnixMilli `json:"next_attempt_ts"`
+	LastError   string             `json:"last_error,omitempty"`
+}
+
+func (oe *OutboxEntry) sqlVariables() []any {
+	return []any{oe.EventRowID, oe.Attempts, oe.NextAttempt.UnixMilli(), dbutil.StrPtr(oe.LastError)}
+}
+
+func (oe *OutboxEntry) Scan(row dbutil.Scannable) (*OutboxEntry, error) {
+	var nextAttempt int64
+	var lastError sql.NullString
+	err := row.Scan(&oe.EventRowID, &oe.RoomID, &oe.Attempts, &nextAttempt, &lastError)
+	if err != nil {
+		return nil, err
+	}
+	oe.NextAttempt = jsontime.UMInt(nextAttempt)
+	oe.LastError = lastError.String
+	return oe, nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getPresenceQuery = `
+		SELECT user_id, presence, status_msg, last_active_ts, currently_active, updated_at
+		FROM presence
+		WHERE user_id = $1
+	`
+	upsertPresenceQuery = `
+		INSERT INTO presence (user_id, presence, status_msg, last_active_ts, currently_active, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE
+			SET presence = excluded.presence,
+			    status_msg = excluded.status_msg,
+			    last_active_ts = excluded.last_active_ts,
+			    currently_active = excluded.currently_active,
+			    updated_at = excluded.updated_at
+	`
+)
+
+type PresenceQuery struct {
+	*dbutil.QueryHelper[*Presence]
+}
+
+func (pq *PresenceQuery) Get(ctx context.Context, userID id.UserID) (*Presence, error) {
+	return pq.QueryOne(ctx, getPresenceQuery, userID)
+}
+
+func (pq *PresenceQuery) Put(ctx context.Context, presence *Presence) error {
+	return pq.Exec(ctx, upsertPresenceQuery, presence.sqlVariables()...)
+}
+
+type Presence struct {
+	UserID          id.UserID          `json:"user_id"`
+	Presence        event.Presence     `json:"presence"`
+	StatusMsg       string             `json:"status_msg,omitempty"`
+	LastActiveTS    jsontime.UnixMilli `json:"last_active_ts,omitempty"`
+	CurrentlyActive bool               `json:"currently_active"`
+	UpdatedAt       jsontime.UnixMilli `json:"updated_at"`
+}
+
+// PresenceFromContent converts a presence event into a database row.
+// The relative last active time is converted into an absolute timestamp.
+func PresenceFromContent(userID id.UserID, content *event.PresenceEventContent) *Presence {
+	now := time.Now()
+	p := &Presence{
+		UserID:          userID,
+		Presence:        content.Presence,
+		StatusMsg:       content.StatusMessage,
+		CurrentlyActive: content.CurrentlyActive,
+		UpdatedAt:       jsontime.UM(now),
+	}
+	if content.LastActiveAgo > 0 {
+		p.LastActiveTS = jsontime.UM(now.Add(-time.Duration(content.LastActiveAgo) * time.Millisecond))
+	}
+	return p
+}
+
+func (p *Presence) sqlVariables() []any {
+	var lastActiveTS *int64
+	if !p.LastActiveTS.IsZero() {
+		lastActiveTS = dbutil.UnixMilliPtr(p.LastActiveTS.Time)
+	}
+	return []any{
+		p.UserID,
+		p.Presence,
+		dbutil.StrPtr(p.StatusMsg),
+		lastActiveTS,
+		p.CurrentlyActive,
+		p.UpdatedAt.UnixMilli(),
+	}
+}
+
+func (p *Presence) Scan(row dbutil.Scannable) (*Presence, error) {
+	var statusMsg sql.NullString
+	var lastActiveTS sql.NullInt64
+	var updatedAt int64
+	err := row.Scan(&p.UserID, &p.Presence, &statusMsg, &lastActiveTS, &p.CurrentlyActive, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.StatusMsg = statusMsg.String
+	if lastActiveTS.Valid {
+		p.LastActiveTS = jsontime.UMInt(lastActiveTS.Int64)
+	}
+	p.UpdatedAt = jsontime.UMInt(updatedAt)
+	return p, nil
+}
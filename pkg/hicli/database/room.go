@@ -23,7 +23,7 @@ const (
 	getRoomBaseQuery = `
 		SELECT room_id, creation_content, tombstone_content, name, name_quality, avatar, explicit_avatar, topic, canonical_alias,
 		       lazy_load_summary, encryption_event, has_member_list, preview_event_rowid, sorting_timestamp,
-		       unread_highlights, unread_notifications, unread_messages, marked_unread, prev_batch
+		       unread_highlights, unread_notifications, unread_messages, marked_unread, notification_mode, prev_batch
 		FROM room
 	`
 	getRoomsBySortingTimestampQuery = getRoomBaseQuery + `WHERE sorting_timestamp < $1 AND sorting_timestamp > 0 ORDER BY sorting_timestamp DESC LIMIT $2`
@@ -57,6 +57,15 @@ const (
 	setRoomPrevBatchQuery = `
 		UPDATE room SET prev_batch = $2 WHERE room_id = $1
 	`
+	setRoomNotificationModeQuery = `
+		UPDATE room SET notification_mode = $2 WHERE room_id = $1
+	`
+	getEncryptedRoomIDsQuery = `
+		SELECT room_id FROM room WHERE encryption_event IS NOT NULL ORDER BY sorting_timestamp DESC
+	`
+	getRoomsWithNotificationModeQuery = `
+		SELECT room_id, notification_mode FROM room WHERE notification_mode <> 'all'
+	`
 	deleteRoomQuery = `
 		DELETE FROM room WHERE room_id = $1
 	`
@@ -99,6 +108,32 @@ func (rq *RoomQuery) Upsert(ctx context.Context, room *Room) error {
 	return rq.Exec(ctx, upsertRoomFromSyncQuery, room.sqlVariables()...)
 }
 
+func (rq *RoomQuery) SetNotificationMode(ctx context.Context, roomID id.RoomID, mode RoomNotificationMode) error {
+	return rq.Exec(ctx, setRoomNotificationModeQuery, roomID, mode)
+}
+
+// GetEncryptedIDs returns the IDs of all encrypted rooms, most recently active first.
+func (rq *RoomQuery) GetEncryptedIDs(ctx context.Context) ([]id.RoomID, error) {
+	rows, err := rq.GetDB().Query(ctx, getEncryptedRoomIDsQuery)
+	return dbutil.NewRowIterWithError(rows, dbutil.ScanSingleColumn[id.RoomID], err).AsList()
+}
+
+// GetNotificationModes returns the notification modes of all rooms that don't use the default mode.
+func (rq *RoomQuery) GetNotificationModes(ctx context.Context) (map[id.RoomID]RoomNotificationMode, error) {
+	rows, err := rq.GetDB().Query(ctx, getRoomsWithNotificationModeQuery)
+	return dbutil.RowIterAsMap(dbutil.NewRowIterWithError(rows, func(row dbutil.Scannable) (rnm roomNotificationMode, err error) {
+		err = row.Scan(&rnm.RoomID, &rnm.Mode)
+		return
+	}, err), func(rnm roomNotificationMode) (id.RoomID, RoomNotificationMode) {
+		return rnm.RoomID, rnm.Mode
+	})
+}
+
+type roomNotificationMode struct {
+	RoomID id.RoomID
+	Mode   RoomNotificationMode
+}
+
 func (rq *RoomQuery) Delete(ctx context.Context, roomID id.RoomID) error {
 	return rq.Exec(ctx, deleteRoomQuery, roomID)
 }
@@ -136,6 +171,14 @@ const (
 	NameQualityExplicit
 )
 
+type RoomNotificationMode string
+
+const (
+	RoomNotificationModeAll          RoomNotificationMode = "all"
+	RoomNotificationModeMentionsOnly RoomNotificationMode = "mentions_only"
+	RoomNotificationModeMute         RoomNotificationMode = "mute"
+)
+
 const PrevBatchPaginationComplete = "fi.mau.gomuks.pagination_complete"
 
 type Room struct {
@@ -159,6 +202,8 @@ type Room struct {
 	SortingTimestamp  jsontime.UnixMilli `json:"sorting_timestamp"`
 	UnreadCounts
 	MarkedUnread *bool `json:"marked_unread,omitempty"`
+	// NotificationMode is derived from push rules and is not updated by Upsert.
+	NotificationMode RoomNotificationMode `json:"notification_mode"`
 
 	PrevBatch string `json:"prev_batch"`
 }
@@ -255,6 +300,7 @@ func (r *Room) Scan(row dbutil.Scannable) (*Room, error) {
 		&r.UnreadNotifications,
 		&r.UnreadMessages,
 		&r.MarkedUnread,
+		&r.NotificationMode,
 		&prevBatch,
 	)
 	if err != nil {
@@ -0,0 +1,102 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getScheduledEventBaseQuery = `
+		SELECT rowid, room_id, type, content, disable_encryption, send_at, delay_id, created_at
+		FROM scheduled_event
+	`
+	getScheduledEventByRowIDQuery = getScheduledEventBaseQuery + `WHERE rowid = $1`
+	getScheduledEventsByRoomQuery = getScheduledEventBaseQuery + `WHERE room_id = $1 ORDER BY send_at`
+	getAllScheduledEventsQuery    = getScheduledEventBaseQuery + `ORDER BY send_at`
+	getNextScheduledEventQuery    = getScheduledEventBaseQuery + `ORDER BY send_at LIMIT 1`
+	deleteScheduledEventQuery     = `DELETE FROM scheduled_event WHERE rowid = $1`
+	insertScheduledEventQuery     = `
+		INSERT INTO scheduled_event (room_id, type, content, disable_encryption, send_at, delay_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING rowid
+	`
+)
+
+type ScheduledEventQuery struct {
+	*dbutil.QueryHelper[*ScheduledEvent]
+}
+
+func (seq *ScheduledEventQuery) Insert(ctx context.Context, evt *ScheduledEvent) error {
+	return seq.GetDB().QueryRow(ctx, insertScheduledEventQuery, evt.sqlVariables()...).Scan(&evt.RowID)
+}
+
+func (seq *ScheduledEventQuery) Get(ctx context.Context, rowID int64) (*ScheduledEvent, error) {
+	return seq.QueryOne(ctx, getScheduledEventByRowIDQuery, rowID)
+}
+
+// GetAll returns scheduled events in the given room, or in all rooms if roomID is empty.
+func (seq *ScheduledEventQuery) GetAll(ctx context.Context, roomID id.RoomID) ([]*ScheduledEvent, error) {
+	if roomID == "" {
+		return seq.QueryMany(ctx, getAllScheduledEventsQuery)
+	}
+	return seq.QueryMany(ctx, getScheduledEventsByRoomQuery, roomID)
+}
+
+// GetNext returns the scheduled event with the earliest send time.
+func (seq *ScheduledEventQuery) GetNext(ctx context.Context) (*ScheduledEvent, error) {
+	return seq.QueryOne(ctx, getNextScheduledEventQuery)
+}
+
+func (seq *ScheduledEventQuery) Delete(ctx context.Context, rowID int64) error {
+	return seq.Exec(ctx, deleteScheduledEventQuery, rowID)
+}
+
+type ScheduledEvent struct {
+	RowID             int64              `json:"rowid"`
+	RoomID            id.RoomID          `json:"room_id"`
+	Type              string             `json:"type"`
+	Content           json.RawMessage    `json:"content"`
+	DisableEncryption bool               `json:"disable_encryption,omitempty"`
+	SendAt            jsontime.UnixMilli `json:"send_at"`
+	// DelayID is set if the event was scheduled on the server using MSC4140 delayed events.
+	DelayID   string             `json:"delay_id,omitempty"`
+	CreatedAt jsontime.UnixMilli `json:"created_at"`
+}
+
+func (se *ScheduledEvent) sqlVariables() []any {
+	return []any{
+		se.RoomID,
+		se.Type,
+		unsafeJSONString(se.Content),
+		se.DisableEncryption,
+		se.SendAt.UnixMilli(),
+		dbutil.StrPtr(se.DelayID),
+		se.CreatedAt.UnixMilli(),
+	}
+}
+
+func (se *ScheduledEvent) Scan(row dbutil.Scannable) (*ScheduledEvent, error) {
+	var content []byte
+	var delayID sql.NullString
+	var sendAt, createdAt int64
+	err := row.Scan(&se.RowID, &se.RoomID, &se.Type, &content, &se.DisableEncryption, &sendAt, &delayID, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	se.Content = content
+	se.DelayID = delayID.String
+	se.SendAt = jsontime.UMInt(sendAt)
+	se.CreatedAt = jsontime.UMInt(createdAt)
+	return se, nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"strings"
+	"unicode/utf16"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	searchEventsQuery = `
+		SELECT event.rowid, -1,
+		       event.room_id, event.event_id, event.sender, event.type, event.state_key, event.timestamp,
+		       event.content, event.decrypted, event.decrypted_type, event.unsigned, event.local_content,
+		       event.transaction_id, event.redacted_by, event.relates_to, event.relation_type,
+		       event.megolm_session_id, event.decryption_error, event.send_error, event.reactions,
+		       event.last_edit_rowid, event.unread_type, event.trust_state,
+		       highlight(event_fts, 0, char(1), char(2))
+		FROM event_fts
+		INNER JOIN event ON event.rowid = event_fts.rowid
+		WHERE event_fts MATCH $1
+		  AND ($2 = '' OR event.room_id = $2)
+		  AND ($3 = '' OR event.sender = $3)
+		  AND ($4 = 0 OR event.timestamp >= $4)
+		  AND ($5 = 0 OR event.timestamp < $5)
+		ORDER BY event.timestamp DESC, event.rowid DESC
+		LIMIT $6 OFFSET $7
+	`
+)
+
+const (
+	highlightStart = '\x01'
+	highlightEnd   = '\x02'
+)
+
+type SearchParams struct {
+	Query  string             `json:"query"`
+	RoomID id.RoomID          `json:"room_id,omitempty"`
+	Sender id.UserID          `json:"sender,omitempty"`
+	After  jsontime.UnixMilli `json:"after,omitempty"`
+	Before jsontime.UnixMilli `json:"before,omitempty"`
+	Limit  int                `json:"limit,omitempty"`
+	Offset int                `json:"offset,omitempty"`
+}
+
+type SearchResult struct {
+	Event *Event `json:"event"`
+	// Highlights contains the matched ranges in the event body as [start, end) pairs.
+	// The offsets are in UTF-16 code units so that they can be used directly with JavaScript strings.
+	Highlights [][2]int `json:"highlights"`
+}
+
+type scannableWithExtra struct {
+	dbutil.Scannable
+	extra []any
+}
+
+func (swe scannableWithExtra) Scan(dest ...any) error {
+	return swe.Scannable.Scan(append(dest, swe.extra...)...)
+}
+
+func (eq *EventQuery) Search(ctx context.Context, params *SearchParams) ([]*SearchResult, error) {
+	ftsQuery := makeFTSQuery(params.Query)
+	if ftsQuery == "" {
+		return []*SearchResult{}, nil
+	}
+	var after, before int64
+	if !params.After.IsZero() {
+		after = params.After.UnixMilli()
+	}
+	if !params.Before.IsZero() {
+		before = params.Before.UnixMilli()
+	}
+	limit := params.Limit
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	rows, err := eq.GetDB().Query(
+		ctx, searchEventsQuery,
+		ftsQuery, params.RoomID, params.Sender, after, before, limit, max(params.Offset, 0),
+	)
+	return dbutil.NewRowIterWithError(rows, func(row dbutil.Scannable) (*SearchResult, error) {
+		var highlighted string
+		evt, err := (&Event{}).Scan(scannableWithExtra{Scannable: row, extra: []any{&highlighted}})
+		if err != nil {
+			return nil, err
+		}
+		return &SearchResult{Event: evt, Highlights: parseHighlights(highlighted)}, nil
+	}, err).AsList()
+}
+
+// makeFTSQuery converts a user-provided search string into an FTS5 query where every word is a quoted string,
+// which means FTS5 syntax characters in the input are treated as plain text. The last word is a prefix match
+// to make search-as-you-type work.
+func makeFTSQuery(query string) string {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return ""
+	}
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	words[len(words)-1] += "*"
+	return strings.Join(words, " ")
+}
+
+func parseHighlights(highlighted string) [][2]int {
+	highlights := make([][2]int, 0)
+	var offset, start int
+	for _, char := range highlighted {
+		switch char {
+		case highlightStart:
+			start = offset
+		case highlightEnd:
+			highlights = append(highlights, [2]int{start, offset})
+		default:
+			offset += utf16.RuneLen(char)
+		}
+	}
+	return highlights
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	getSpaceEdgeBaseQuery = `
+		SELECT space_id, child_id, child_event_rowid, "order", suggested, parent_event_rowid, canonical
+		FROM space_edge
+	`
+	// Children are sorted according to https://spec.matrix.org/v1.12/client-server-api/#ordering-of-children-within-a-space
+	getSpaceChildrenQuery = `
+		SELECT space_id, child_id, child_event_rowid, "order", suggested, parent_event_rowid, canonical
+		FROM space_edge
+		LEFT JOIN event ON event.rowid = space_edge.child_event_rowid
+		WHERE space_id = $1 AND child_event_rowid IS NOT NULL
+		ORDER BY "order" = '', "order", event.timestamp, child_id
+	`
+	getSpaceParentsQuery = getSpaceEdgeBaseQuery + `WHERE child_id = $1`
+	setSpaceChildQuery   = `
+		INSERT INTO space_edge (space_id, child_id, child_event_rowid, "order", suggested)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (space_id, child_id) DO UPDATE
+			SET child_event_rowid = excluded.child_event_rowid,
+			    "order" = excluded."order",
+			    suggested = excluded.suggested
+	`
+	setSpaceParentQuery = `
+		INSERT INTO space_edge (space_id, child_id, parent_event_rowid, canonical)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (space_id, child_id) DO UPDATE
+			SET parent_event_rowid = excluded.parent_event_rowid,
+			    canonical = excluded.canonical
+	`
+	clearSpaceChildQuery = `
+		UPDATE space_edge SET child_event_rowid = NULL, "order" = '', suggested = false
+		WHERE space_id = $1 AND child_id = $2
+	`
+	clearSpaceParentQuery = `
+		UPDATE space_edge SET parent_event_rowid = NULL, canonical = false
+		WHERE space_id = $1 AND child_id = $2
+	`
+	deleteEmptySpaceEdgeQuery = `
+		DELETE FROM space_edge WHERE space_id = $1 AND child_id = $2 AND child_event_rowid IS NULL AND parent_event_rowid IS NULL
+	`
+)
+
+type SpaceEdgeQuery struct {
+	*dbutil.QueryHelper[*SpaceEdge]
+}
+
+// GetChildren returns the children of the given space as defined by m.space.child events in the space.
+func (seq *SpaceEdgeQuery) GetChildren(ctx context.Context, spaceID id.RoomID) ([]*SpaceEdge, error) {
+	return seq.QueryMany(ctx, getSpaceChildrenQuery, spaceID)
+}
+
+// GetParents returns all spaces that the given room is in, either based on an m.space.child event
+// in the space or an m.space.parent event in the room itself.
+func (seq *SpaceEdgeQuery) GetParents(ctx context.Context, childID id.RoomID) ([]*SpaceEdge, error) {
+	return seq.QueryMany(ctx, getSpaceParentsQuery, childID)
+}
+
+func (seq *SpaceEdgeQuery) SetChild(ctx context.Context, spaceID, childID id.RoomID, rowID EventRowID, order string, suggested bool) error {
+	return seq.Exec(ctx, setSpaceChildQuery, spaceID, childID, rowID, order, suggested)
+}
+
+func (seq *SpaceEdgeQuery) SetParent(ctx context.Context, spaceID, childID id.RoomID, rowID EventRowID, canonical bool) error {
+	return seq.Exec(ctx, setSpaceParentQuery, spaceID, childID, rowID, canonical)
+}
+
+func (seq *SpaceEdgeQuery) ClearChild(ctx context.Context, spaceID, childID id.RoomID) error {
+	err := seq.Exec(ctx, clearSpaceChildQuery, spaceID, childID)
+	if err != nil {
+		return err
+	}
+	return seq.Exec(ctx, deleteEmptySpaceEdgeQuery, spaceID, childID)
+}
+
+func (seq *SpaceEdgeQuery) ClearParent(ctx context.Context, spaceID, childID id.RoomID) error {
+	err := seq.Exec(ctx, clearSpaceParentQuery, spaceID, childID)
+	if err != nil {
+		return err
+	}
+	return seq.Exec(ctx, deleteEmptySpaceEdgeQuery, spaceID, childID)
+}
+
+type SpaceEdge struct {
+	SpaceID id.RoomID `json:"space_id"`
+	ChildID id.RoomID `json:"child_id"`
+
+	ChildEventRowID EventRowID `json:"child_event_rowid,omitempty"`
+	Order           string     `json:"order,omitempty"`
+	Suggested       bool       `json:"suggested,omitempty"`
+
+	ParentEventRowID EventRowID `json:"parent_event_rowid,omitempty"`
+	Canonical        bool       `json:"canonical,omitempty"`
+}
+
+func (se *SpaceEdge) Scan(row dbutil.Scannable) (*SpaceEdge, error) {
+	var childRowID, parentRowID sql.NullInt64
+	err := row.Scan(&se.SpaceID, &se.ChildID, &childRowID, &se.Order, &se.Suggested, &parentRowID, &se.Canonical)
+	if err != nil {
+		return nil, err
+	}
+	se.ChildEventRowID = EventRowID(childRowID.Int64)
+	se.ParentEventRowID = EventRowID(parentRowID.Int64)
+	return se, nil
+}
@@ -33,7 +33,7 @@ const (
 		SELECT event.rowid, -1,
 		       event.room_id, event.event_id, sender, event.type, event.state_key, timestamp, content, decrypted, decrypted_type,
 		       unsigned, local_content, transaction_id, redacted_by, relates_to, relation_type,
-		       megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type
+		       megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type, trust_state
 		FROM current_state cs
 		JOIN event ON cs.event_rowid = event.rowid
 	`
@@ -41,6 +41,9 @@ const (
 	getCurrentRoomStateWithoutMembersQuery = getCurrentRoomStateBaseQuery + `WHERE cs.room_id = $1 AND type<>'m.room.member'`
 	getManyCurrentRoomStateQuery           = getCurrentRoomStateBaseQuery + `WHERE (cs.room_id, cs.event_type, cs.state_key) IN (%s)`
 	getCurrentStateEventQuery              = getCurrentRoomStateBaseQuery + `WHERE cs.room_id = $1 AND cs.event_type = $2 AND cs.state_key = $3`
+	getJoinedMemberCountQuery              = `
+		SELECT COUNT(*) FROM current_state WHERE room_id = $1 AND event_type = 'm.room.member' AND membership = 'join'
+	`
 )
 
 var massInsertCurrentStateBuilder = dbutil.NewMassInsertBuilder[*CurrentStateEntry, [1]any](addCurrentStateQuery, "($1, $%d, $%d, $%d, $%d)")
@@ -111,6 +114,13 @@ func (csq *CurrentStateQuery) Get(ctx context.Context, roomID id.RoomID, eventTy
 	return csq.QueryOne(ctx, getCurrentStateEventQuery, roomID, eventType.Type, stateKey)
 }
 
+// GetJoinedMemberCount counts the joined members in the room based on locally stored state.
+// The count is only accurate if the room's full member list has been fetched.
+func (csq *CurrentStateQuery) GetJoinedMemberCount(ctx context.Context, roomID id.RoomID) (count int, err error) {
+	err = csq.GetDB().QueryRow(ctx, getJoinedMemberCountQuery, roomID).Scan(&count)
+	return
+}
+
 func (csq *CurrentStateQuery) GetAll(ctx context.Context, roomID id.RoomID) ([]*Event, error) {
 	return csq.QueryMany(ctx, getCurrentRoomStateQuery, roomID)
 }
@@ -0,0 +1,62 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package database
+
+import (
+	"context"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// The bare rowid column is taken from the row with the maximum timestamp
+	// (see https://www.sqlite.org/lang_select.html#bareagg)
+	getThreadsQuery = `
+		SELECT thread_root, COUNT(*), rowid, MAX(timestamp)
+		FROM event
+		WHERE room_id = $1 AND thread_root IS NOT NULL AND redacted_by IS NULL
+		GROUP BY thread_root
+		HAVING $2 = 0 OR MAX(timestamp) < $2
+		ORDER BY MAX(timestamp) DESC
+		LIMIT $3
+	`
+	getThreadEventsQuery = getEventBaseQuery + `
+		WHERE room_id = $1 AND thread_root = $2
+		  AND ($3 = 0 OR (timestamp, rowid) < (SELECT timestamp, rowid FROM event WHERE rowid = $3))
+		ORDER BY timestamp DESC, rowid DESC
+		LIMIT $4
+	`
+)
+
+type ThreadSummary struct {
+	RootID           id.EventID         `json:"root_id"`
+	ReplyCount       int                `json:"reply_count"`
+	LatestEventRowID EventRowID         `json:"latest_event_rowid"`
+	LatestTimestamp  jsontime.UnixMilli `json:"latest_timestamp"`
+	UnreadCounts
+}
+
+func (ts *ThreadSummary) Scan(row dbutil.Scannable) (*ThreadSummary, error) {
+	return dbutil.ValueOrErr(ts, row.Scan(&ts.RootID, &ts.ReplyCount, &ts.LatestEventRowID, &ts.LatestTimestamp))
+}
+
+// GetThreads returns the threads in the given room ordered by latest activity.
+// If before is non-zero, only threads whose latest reply is older than that are returned.
+func (eq *EventQuery) GetThreads(ctx context.Context, roomID id.RoomID, before int64, limit int) ([]*ThreadSummary, error) {
+	rows, err := eq.GetDB().Query(ctx, getThreadsQuery, roomID, before, limit)
+	return dbutil.NewRowIterWithError(rows, func(row dbutil.Scannable) (*ThreadSummary, error) {
+		return (&ThreadSummary{}).Scan(row)
+	}, err).AsList()
+}
+
+// GetThreadEvents returns the locally known events in the given thread in reverse chronological order.
+// If before is non-zero, only events older than that event are returned.
+func (eq *EventQuery) GetThreadEvents(ctx context.Context, roomID id.RoomID, threadRoot id.EventID, before EventRowID, limit int) ([]*Event, error) {
+	return eq.QueryMany(ctx, getThreadEventsQuery, roomID, threadRoot, before, limit)
+}
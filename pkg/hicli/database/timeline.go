@@ -31,18 +31,25 @@ const (
 	checkTimelineContainsQuery = `
 		SELECT EXISTS(SELECT 1 FROM timeline WHERE room_id = $1 AND event_rowid = $2)
 	`
-	findMinRowIDQuery = `SELECT MIN(rowid) FROM timeline`
-	getTimelineQuery  = `
+	findMinRowIDQuery    = `SELECT MIN(rowid) FROM timeline`
+	getTimelineBaseQuery = `
 		SELECT event.rowid, timeline.rowid,
 		       event.room_id, event_id, sender, type, state_key, timestamp, content, decrypted, decrypted_type,
 		       unsigned, local_content, transaction_id, redacted_by, relates_to, relation_type,
-		       megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type
+		       megolm_session_id, decryption_error, send_error, reactions, last_edit_rowid, unread_type, trust_state
 		FROM timeline
 		JOIN event ON event.rowid = timeline.event_rowid
+	`
+	getTimelineQuery = getTimelineBaseQuery + `
 		WHERE timeline.room_id = $1 AND ($2 = 0 OR timeline.rowid < $2)
 		ORDER BY timeline.rowid DESC
 		LIMIT $3
 	`
+	getTimelineAscendingQuery = getTimelineBaseQuery + `
+		WHERE timeline.room_id = $1 AND timeline.rowid > $2
+		ORDER BY timeline.rowid ASC
+		LIMIT $3
+	`
 )
 
 type TimelineRowID int64
@@ -129,6 +136,12 @@ func (tq *TimelineQuery) Get(ctx context.Context, roomID id.RoomID, limit int, b
 	return tq.QueryMany(ctx, getTimelineQuery, roomID, before, limit)
 }
 
+// GetAfter returns timeline events in chronological order, starting after the given timeline row ID.
+// Timeline row IDs can be negative, so pass math.MinInt64 to start from the beginning of the timeline.
+func (tq *TimelineQuery) GetAfter(ctx context.Context, roomID id.RoomID, limit int, after TimelineRowID) ([]*Event, error) {
+	return tq.QueryMany(ctx, getTimelineAscendingQuery, roomID, after, limit)
+}
+
 func (tq *TimelineQuery) Has(ctx context.Context, roomID id.RoomID, eventRowID EventRowID) (exists bool, err error) {
 	err = tq.GetDB().QueryRow(ctx, checkTimelineContainsQuery, roomID, eventRowID).Scan(&exists)
 	return
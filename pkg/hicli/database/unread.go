@@ -9,38 +9,66 @@ package database
 import (
 	"context"
 
+	"go.mau.fi/util/dbutil"
 	"maunium.net/go/mautrix/id"
 )
 
 const (
 	// TODO find out if this needs to be wrapped in another query that limits the number of events it evaluates
 	//      (or maybe the timeline store just shouldn't be allowed to grow that big?)
+	//
+	// Events in threads are counted as read if either the unthreaded receipt or the receipt of that thread
+	// is after them, while events in the main timeline only care about the unthreaded receipt.
 	calculateUnreadsQuery = `
+		WITH own_receipt AS (
+			SELECT receipt.thread_id, MAX(timeline.rowid) AS timeline_rowid
+			FROM receipt
+			JOIN event ON receipt.event_id=event.event_id
+			JOIN timeline ON timeline.event_rowid=event.rowid
+			WHERE receipt.room_id = $1 AND receipt.user_id = $2
+			GROUP BY receipt.thread_id
+		)
 		SELECT
+			COALESCE(event.thread_root, '') AS thread,
 			COALESCE(SUM(CASE WHEN unread_type & 0100 THEN 1 ELSE 0 END), 0) AS highlights,
 			COALESCE(SUM(CASE WHEN unread_type & 0010 THEN 1 ELSE 0 END), 0) AS notifications,
 			COALESCE(SUM(CASE WHEN unread_type & 0001 THEN 1 ELSE 0 END), 0) AS messages
 		FROM timeline
 		JOIN event ON event.rowid = timeline.event_rowid
-		WHERE timeline.room_id = $1 AND timeline.rowid > (
-			SELECT MAX(rowid)
-			FROM timeline
-			WHERE room_id = $1 AND event_rowid IN (
-				SELECT event.rowid
-				FROM receipt
-				JOIN event ON receipt.event_id=event.event_id
-				WHERE receipt.room_id = $1 AND receipt.user_id = $2
-			)
+		LEFT JOIN own_receipt main_receipt ON main_receipt.thread_id = ''
+		LEFT JOIN own_receipt thread_receipt ON thread_receipt.thread_id = event.thread_root
+		WHERE timeline.room_id = $1 AND timeline.rowid > MAX(
+			COALESCE(main_receipt.timeline_rowid, thread_receipt.timeline_rowid),
+			COALESCE(thread_receipt.timeline_rowid, main_receipt.timeline_rowid)
 		) AND unread_type > 0 AND redacted_by IS NULL
+		GROUP BY thread
 	`
 )
 
-func (rq *RoomQuery) CalculateUnreads(ctx context.Context, roomID id.RoomID, userID id.UserID) (uc UnreadCounts, err error) {
-	err = rq.GetDB().QueryRow(ctx, calculateUnreadsQuery, roomID, userID).
-		Scan(&uc.UnreadHighlights, &uc.UnreadNotifications, &uc.UnreadMessages)
+// CalculateUnreads calculates the unread counts of a room based on the given user's read receipts.
+// The total counts include threads, while the map contains the counts of each individual thread.
+func (rq *RoomQuery) CalculateUnreads(ctx context.Context, roomID id.RoomID, userID id.UserID) (uc UnreadCounts, threads map[id.EventID]UnreadCounts, err error) {
+	rows, err := rq.GetDB().Query(ctx, calculateUnreadsQuery, roomID, userID)
+	threads = make(map[id.EventID]UnreadCounts)
+	err = dbutil.NewRowIterWithError(rows, func(row dbutil.Scannable) (threadUnreads, error) {
+		var tu threadUnreads
+		err := row.Scan(&tu.ThreadRoot, &tu.UnreadHighlights, &tu.UnreadNotifications, &tu.UnreadMessages)
+		return tu, err
+	}, err).Iter(func(tu threadUnreads) (bool, error) {
+		uc.Add(tu.UnreadCounts)
+		if tu.ThreadRoot != "" {
+			threads[tu.ThreadRoot] = tu.UnreadCounts
+		}
+		return true, nil
+	})
 	return
 }
 
+type threadUnreads struct {
+	ThreadRoot id.EventID
+	UnreadCounts
+}
+
 type UnreadType int
 
 func (ut UnreadType) Is(flag UnreadType) bool {
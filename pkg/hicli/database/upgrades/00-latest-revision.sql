@@ -1,11 +1,12 @@
--- v0 -> v9 (compatible with v5+): Latest revision
+-- v0 -> v19 (compatible with v10+): Latest revision
 CREATE TABLE account (
 	user_id        TEXT NOT NULL PRIMARY KEY,
 	device_id      TEXT NOT NULL,
 	access_token   TEXT NOT NULL,
 	homeserver_url TEXT NOT NULL,
 
-	next_batch     TEXT NOT NULL
+	next_batch     TEXT NOT NULL,
+	sync_presence  TEXT NOT NULL DEFAULT ''
 ) STRICT;
 
 CREATE TABLE room (
@@ -30,6 +31,7 @@ CREATE TABLE room (
 	unread_notifications INTEGER NOT NULL DEFAULT 0,
 	unread_messages      INTEGER NOT NULL DEFAULT 0,
 	marked_unread        INTEGER NOT NULL DEFAULT false,
+	notification_mode    TEXT    NOT NULL DEFAULT 'all',
 
 	prev_batch           TEXT,
 
@@ -102,6 +104,9 @@ CREATE TABLE event (
 	reactions         TEXT,
 	last_edit_rowid   INTEGER,
 	unread_type       INTEGER NOT NULL DEFAULT 0,
+	trust_state       INTEGER,
+
+	thread_root       TEXT GENERATED ALWAYS AS (CASE WHEN relation_type = 'm.thread' THEN relates_to END) VIRTUAL,
 
 	CONSTRAINT event_id_unique_key UNIQUE (event_id),
 	CONSTRAINT transaction_id_unique_key UNIQUE (transaction_id),
@@ -111,6 +116,8 @@ CREATE INDEX event_room_id_idx ON event (room_id);
 CREATE INDEX event_redacted_by_idx ON event (room_id, redacted_by);
 CREATE INDEX event_relates_to_idx ON event (room_id, relates_to);
 CREATE INDEX event_megolm_session_id_idx ON event (room_id, megolm_session_id);
+CREATE INDEX event_thread_root_idx ON event (room_id, thread_root) WHERE thread_root IS NOT NULL;
+CREATE INDEX event_sender_session_idx ON event (sender, megolm_session_id) WHERE trust_state IS NOT NULL;
 
 CREATE TRIGGER event_update_redacted_by
 	AFTER INSERT
@@ -209,6 +216,103 @@ BEGIN
 	  AND reactions IS NOT NULL;
 END;
 
+CREATE VIRTUAL TABLE event_fts USING fts5 (
+	body,
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+-- The searchable body of a message is the body of its latest edit, or its own body if it hasn't been edited
+CREATE VIEW event_fts_source AS
+SELECT main.rowid, COALESCE(
+	(SELECT COALESCE(edit.decrypted, edit.content) ->> '$."m.new_content".body'
+	 FROM event edit
+	 WHERE edit.room_id = main.room_id
+	   AND edit.relates_to = main.event_id
+	   AND edit.relation_type = 'm.replace'
+	   AND COALESCE(edit.decrypted_type, edit.type) = 'm.room.message'
+	   AND edit.sender = main.sender
+	   AND edit.redacted_by IS NULL
+	   AND edit.state_key IS NULL
+	   AND typeof(COALESCE(edit.decrypted, edit.content) ->> '$."m.new_content".body') = 'text'
+	 ORDER BY edit.timestamp DESC
+	 LIMIT 1),
+	COALESCE(main.decrypted, main.content) ->> 'body'
+) AS body
+FROM event main
+WHERE COALESCE(main.decrypted_type, main.type) = 'm.room.message'
+  AND main.state_key IS NULL
+  AND main.redacted_by IS NULL
+  AND (main.relation_type IS NULL OR main.relation_type <> 'm.replace');
+
+CREATE TRIGGER event_insert_fts
+	AFTER INSERT
+	ON event
+	WHEN COALESCE(NEW.decrypted_type, NEW.type) = 'm.room.message'
+		AND NEW.state_key IS NULL
+		AND NEW.redacted_by IS NULL
+		AND (NEW.relation_type IS NULL OR NEW.relation_type <> 'm.replace')
+BEGIN
+	INSERT INTO event_fts (rowid, body)
+	SELECT rowid, body FROM event_fts_source WHERE rowid = NEW.rowid AND typeof(body) = 'text';
+END;
+
+CREATE TRIGGER event_decrypt_fts
+	AFTER UPDATE
+	ON event
+	WHEN OLD.decrypted IS NULL
+		AND NEW.decrypted IS NOT NULL
+		AND NEW.decrypted_type = 'm.room.message'
+		AND NEW.state_key IS NULL
+		AND NEW.redacted_by IS NULL
+		AND (NEW.relation_type IS NULL OR NEW.relation_type <> 'm.replace')
+BEGIN
+	INSERT INTO event_fts (rowid, body)
+	SELECT rowid, body FROM event_fts_source WHERE rowid = NEW.rowid AND typeof(body) = 'text';
+END;
+
+CREATE TRIGGER event_redact_fts
+	AFTER UPDATE
+	ON event
+	WHEN OLD.redacted_by IS NULL
+		AND NEW.redacted_by IS NOT NULL
+BEGIN
+	DELETE FROM event_fts WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER event_delete_fts
+	AFTER DELETE
+	ON event
+BEGIN
+	DELETE FROM event_fts WHERE rowid = OLD.rowid;
+END;
+
+CREATE TRIGGER event_insert_edit_fts
+	AFTER INSERT
+	ON event
+	WHEN COALESCE(NEW.decrypted_type, NEW.type) = 'm.room.message'
+		AND NEW.relation_type = 'm.replace'
+		AND NEW.state_key IS NULL
+BEGIN
+	DELETE FROM event_fts WHERE rowid = (SELECT rowid FROM event WHERE event_id = NEW.relates_to);
+	INSERT INTO event_fts (rowid, body)
+	SELECT rowid, body FROM event_fts_source
+	WHERE rowid = (SELECT rowid FROM event WHERE event_id = NEW.relates_to) AND typeof(body) = 'text';
+END;
+
+CREATE TRIGGER event_update_edit_fts
+	AFTER UPDATE
+	ON event
+	WHEN ((OLD.decrypted IS NULL AND NEW.decrypted IS NOT NULL AND NEW.decrypted_type = 'm.room.message')
+		OR (OLD.redacted_by IS NULL AND NEW.redacted_by IS NOT NULL))
+		AND NEW.relation_type = 'm.replace'
+		AND NEW.state_key IS NULL
+BEGIN
+	DELETE FROM event_fts WHERE rowid = (SELECT rowid FROM event WHERE event_id = NEW.relates_to);
+	INSERT INTO event_fts (rowid, body)
+	SELECT rowid, body FROM event_fts_source
+	WHERE rowid = (SELECT rowid FROM event WHERE event_id = NEW.relates_to) AND typeof(body) = 'text';
+END;
+
 CREATE TABLE media (
 	mxc       TEXT NOT NULL PRIMARY KEY,
 	enc_file  TEXT,
@@ -278,3 +382,78 @@ CREATE TABLE receipt (
 	CONSTRAINT receipt_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
 	-- note: there's no foreign key on event ID because receipts could point at events that are too far in history.
 ) STRICT;
+
+CREATE TABLE space_edge (
+	space_id           TEXT    NOT NULL,
+	child_id           TEXT    NOT NULL,
+
+	child_event_rowid  INTEGER,
+	"order"            TEXT    NOT NULL DEFAULT '',
+	suggested          INTEGER NOT NULL DEFAULT false,
+
+	parent_event_rowid INTEGER,
+	canonical          INTEGER NOT NULL DEFAULT false,
+
+	PRIMARY KEY (space_id, child_id),
+	CONSTRAINT space_edge_child_event_fkey FOREIGN KEY (child_event_rowid) REFERENCES event (rowid) ON DELETE SET NULL,
+	CONSTRAINT space_edge_parent_event_fkey FOREIGN KEY (parent_event_rowid) REFERENCES event (rowid) ON DELETE SET NULL
+) STRICT;
+CREATE INDEX space_edge_child_idx ON space_edge (child_id);
+CREATE INDEX space_edge_child_event_idx ON space_edge (child_event_rowid);
+CREATE INDEX space_edge_parent_event_idx ON space_edge (parent_event_rowid);
+
+CREATE TRIGGER space_edge_delete_on_room_delete
+	AFTER DELETE
+	ON room
+BEGIN
+	UPDATE space_edge SET child_event_rowid = NULL, "order" = '', suggested = false WHERE space_id = OLD.room_id;
+	UPDATE space_edge SET parent_event_rowid = NULL, canonical = false WHERE child_id = OLD.room_id;
+	DELETE FROM space_edge WHERE child_event_rowid IS NULL AND parent_event_rowid IS NULL;
+END;
+
+CREATE TABLE presence (
+	user_id          TEXT    NOT NULL PRIMARY KEY,
+	presence         TEXT    NOT NULL,
+	status_msg       TEXT,
+	last_active_ts   INTEGER,
+	currently_active INTEGER NOT NULL DEFAULT false,
+	updated_at       INTEGER NOT NULL
+) STRICT;
+
+CREATE TABLE scheduled_event (
+	rowid              INTEGER PRIMARY KEY,
+	room_id            TEXT    NOT NULL,
+	type               TEXT    NOT NULL,
+	content            TEXT    NOT NULL,
+	disable_encryption INTEGER NOT NULL DEFAULT false,
+	send_at            INTEGER NOT NULL,
+	delay_id           TEXT,
+	created_at         INTEGER NOT NULL,
+
+	CONSTRAINT scheduled_event_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX scheduled_event_send_at_idx ON scheduled_event (send_at);
+CREATE INDEX scheduled_event_room_idx ON scheduled_event (room_id);
+
+CREATE TABLE outbox (
+	event_rowid     INTEGER NOT NULL PRIMARY KEY,
+	room_id         TEXT    NOT NULL,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at INTEGER NOT NULL,
+	last_error      TEXT,
+
+	CONSTRAINT outbox_event_fkey FOREIGN KEY (event_rowid) REFERENCES event (rowid) ON DELETE CASCADE,
+	CONSTRAINT outbox_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX outbox_room_idx ON outbox (room_id, event_rowid);
+
+CREATE TABLE draft (
+	room_id    TEXT    NOT NULL,
+	-- Empty string for the main timeline
+	thread_id  TEXT    NOT NULL DEFAULT '',
+	content    TEXT    NOT NULL,
+	updated_at INTEGER NOT NULL,
+
+	PRIMARY KEY (room_id, thread_id),
+	CONSTRAINT draft_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
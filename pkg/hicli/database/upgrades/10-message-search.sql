@@ -0,0 +1,56 @@
+-- v10: Add full-text search index for messages
+CREATE VIRTUAL TABLE event_fts USING fts5 (
+	body,
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+INSERT INTO event_fts (rowid, body)
+SELECT rowid, COALESCE(decrypted, content) ->> 'body'
+FROM event
+WHERE COALESCE(decrypted_type, type) = 'm.room.message'
+  AND state_key IS NULL
+  AND redacted_by IS NULL
+  AND (relation_type IS NULL OR relation_type <> 'm.replace')
+  AND typeof(COALESCE(decrypted, content) ->> 'body') = 'text';
+
+CREATE TRIGGER event_insert_fts
+	AFTER INSERT
+	ON event
+	WHEN COALESCE(NEW.decrypted_type, NEW.type) = 'm.room.message'
+		AND NEW.state_key IS NULL
+		AND NEW.redacted_by IS NULL
+		AND (NEW.relation_type IS NULL OR NEW.relation_type <> 'm.replace')
+		AND typeof(COALESCE(NEW.decrypted, NEW.content) ->> 'body') = 'text'
+BEGIN
+	INSERT INTO event_fts (rowid, body) VALUES (NEW.rowid, COALESCE(NEW.decrypted, NEW.content) ->> 'body');
+END;
+
+CREATE TRIGGER event_decrypt_fts
+	AFTER UPDATE
+	ON event
+	WHEN OLD.decrypted IS NULL
+		AND NEW.decrypted IS NOT NULL
+		AND NEW.decrypted_type = 'm.room.message'
+		AND NEW.state_key IS NULL
+		AND NEW.redacted_by IS NULL
+		AND (NEW.relation_type IS NULL OR NEW.relation_type <> 'm.replace')
+		AND typeof(NEW.decrypted ->> 'body') = 'text'
+BEGIN
+	INSERT INTO event_fts (rowid, body) VALUES (NEW.rowid, NEW.decrypted ->> 'body');
+END;
+
+CREATE TRIGGER event_redact_fts
+	AFTER UPDATE
+	ON event
+	WHEN OLD.redacted_by IS NULL
+		AND NEW.redacted_by IS NOT NULL
+BEGIN
+	DELETE FROM event_fts WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER event_delete_fts
+	AFTER DELETE
+	ON event
+BEGIN
+	DELETE FROM event_fts WHERE rowid = OLD.rowid;
+END;
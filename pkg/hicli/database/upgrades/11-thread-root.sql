@@ -0,0 +1,3 @@
+-- v11 (compatible with v10+): Add thread root column for events
+ALTER TABLE event ADD COLUMN thread_root TEXT GENERATED ALWAYS AS (CASE WHEN relation_type = 'm.thread' THEN relates_to END) VIRTUAL;
+CREATE INDEX event_thread_root_idx ON event (room_id, thread_root) WHERE thread_root IS NOT NULL;
@@ -0,0 +1,45 @@
+-- v12 (compatible with v10+): Add table for space hierarchy
+CREATE TABLE space_edge (
+	space_id           TEXT    NOT NULL,
+	child_id           TEXT    NOT NULL,
+
+	child_event_rowid  INTEGER,
+	"order"            TEXT    NOT NULL DEFAULT '',
+	suggested          INTEGER NOT NULL DEFAULT false,
+
+	parent_event_rowid INTEGER,
+	canonical          INTEGER NOT NULL DEFAULT false,
+
+	PRIMARY KEY (space_id, child_id),
+	CONSTRAINT space_edge_child_event_fkey FOREIGN KEY (child_event_rowid) REFERENCES event (rowid) ON DELETE SET NULL,
+	CONSTRAINT space_edge_parent_event_fkey FOREIGN KEY (parent_event_rowid) REFERENCES event (rowid) ON DELETE SET NULL
+) STRICT;
+CREATE INDEX space_edge_child_idx ON space_edge (child_id);
+CREATE INDEX space_edge_child_event_idx ON space_edge (child_event_rowid);
+CREATE INDEX space_edge_parent_event_idx ON space_edge (parent_event_rowid);
+
+CREATE TRIGGER space_edge_delete_on_room_delete
+	AFTER DELETE
+	ON room
+BEGIN
+	UPDATE space_edge SET child_event_rowid = NULL, "order" = '', suggested = false WHERE space_id = OLD.room_id;
+	UPDATE space_edge SET parent_event_rowid = NULL, canonical = false WHERE child_id = OLD.room_id;
+	DELETE FROM space_edge WHERE child_event_rowid IS NULL AND parent_event_rowid IS NULL;
+END;
+
+INSERT INTO space_edge (space_id, child_id, child_event_rowid, "order", suggested)
+SELECT cs.room_id, cs.state_key, cs.event_rowid,
+       CASE WHEN typeof(event.content ->> 'order') = 'text' THEN event.content ->> 'order' ELSE '' END,
+       COALESCE(event.content ->> 'suggested', false) = true
+FROM current_state cs
+INNER JOIN event ON cs.event_rowid = event.rowid
+WHERE cs.event_type = 'm.space.child' AND json_array_length(event.content, '$.via') > 0;
+
+INSERT INTO space_edge (space_id, child_id, parent_event_rowid, canonical)
+SELECT cs.state_key, cs.room_id, cs.event_rowid, COALESCE(event.content ->> 'canonical', false) = true
+FROM current_state cs
+INNER JOIN event ON cs.event_rowid = event.rowid
+WHERE cs.event_type = 'm.space.parent' AND json_array_length(event.content, '$.via') > 0
+ON CONFLICT (space_id, child_id) DO UPDATE
+	SET parent_event_rowid = excluded.parent_event_rowid,
+	    canonical = excluded.canonical;
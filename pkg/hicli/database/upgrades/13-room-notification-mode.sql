@@ -0,0 +1,2 @@
+-- v13 (compatible with v10+): Add notification mode for rooms
+ALTER TABLE room ADD COLUMN notification_mode TEXT NOT NULL DEFAULT 'all';
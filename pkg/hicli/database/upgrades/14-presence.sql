@@ -0,0 +1,11 @@
+-- v14 (compatible with v10+): Add table for presence and store presence to send in sync requests
+CREATE TABLE presence (
+	user_id          TEXT    NOT NULL PRIMARY KEY,
+	presence         TEXT    NOT NULL,
+	status_msg       TEXT,
+	last_active_ts   INTEGER,
+	currently_active INTEGER NOT NULL DEFAULT false,
+	updated_at       INTEGER NOT NULL
+) STRICT;
+
+ALTER TABLE account ADD COLUMN sync_presence TEXT NOT NULL DEFAULT '';
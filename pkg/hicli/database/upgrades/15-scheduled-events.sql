@@ -0,0 +1,15 @@
+-- v15 (compatible with v10+): Add table for scheduled events
+CREATE TABLE scheduled_event (
+	rowid              INTEGER PRIMARY KEY,
+	room_id            TEXT    NOT NULL,
+	type               TEXT    NOT NULL,
+	content            TEXT    NOT NULL,
+	disable_encryption INTEGER NOT NULL DEFAULT false,
+	send_at            INTEGER NOT NULL,
+	delay_id           TEXT,
+	created_at         INTEGER NOT NULL,
+
+	CONSTRAINT scheduled_event_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX scheduled_event_send_at_idx ON scheduled_event (send_at);
+CREATE INDEX scheduled_event_room_idx ON scheduled_event (room_id);
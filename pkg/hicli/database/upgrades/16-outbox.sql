@@ -0,0 +1,12 @@
+-- v16 (compatible with v10+): Add outbox for retrying failed sends
+CREATE TABLE outbox (
+	event_rowid     INTEGER NOT NULL PRIMARY KEY,
+	room_id         TEXT    NOT NULL,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at INTEGER NOT NULL,
+	last_error      TEXT,
+
+	CONSTRAINT outbox_event_fkey FOREIGN KEY (event_rowid) REFERENCES event (rowid) ON DELETE CASCADE,
+	CONSTRAINT outbox_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
+CREATE INDEX outbox_room_idx ON outbox (room_id, event_rowid);
@@ -0,0 +1,3 @@
+-- v17 (compatible with v10+): Add sender trust state to events
+ALTER TABLE event ADD COLUMN trust_state INTEGER;
+CREATE INDEX event_sender_session_idx ON event (sender, megolm_session_id) WHERE trust_state IS NOT NULL;
@@ -0,0 +1,11 @@
+-- v18 (compatible with v10+): Add table for message drafts
+CREATE TABLE draft (
+	room_id    TEXT    NOT NULL,
+	-- Empty string for the main timeline
+	thread_id  TEXT    NOT NULL DEFAULT '',
+	content    TEXT    NOT NULL,
+	updated_at INTEGER NOT NULL,
+
+	PRIMARY KEY (room_id, thread_id),
+	CONSTRAINT draft_room_fkey FOREIGN KEY (room_id) REFERENCES room (room_id) ON DELETE CASCADE
+) STRICT;
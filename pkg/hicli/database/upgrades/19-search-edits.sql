@@ -0,0 +1,83 @@
+-- v19 (compatible with v10+): Index the latest edit of messages for search
+-- The searchable body of a message is the body of its latest edit, or its own body if it hasn't been edited
+CREATE VIEW event_fts_source AS
+SELECT main.rowid, COALESCE(
+	(SELECT COALESCE(edit.decrypted, edit.content) ->> '$."m.new_content".body'
+	 FROM event edit
+	 WHERE edit.room_id = main.room_id
+	   AND edit.relates_to = main.event_id
+	   AND edit.relation_type = 'm.replace'
+	   AND COALESCE(edit.decrypted_type, edit.type) = 'm.room.message'
+	   AND edit.sender = main.sender
+	   AND edit.redacted_by IS NULL
+	   AND edit.state_key IS NULL
+	   AND typeof(COALESCE(edit.decrypted, edit.content) ->> '$."m.new_content".body') = 'text'
+	 ORDER BY edit.timestamp DESC
+	 LIMIT 1),
+	COALESCE(main.decrypted, main.content) ->> 'body'
+) AS body
+FROM event main
+WHERE COALESCE(main.decrypted_type, main.type) = 'm.room.message'
+  AND main.state_key IS NULL
+  AND main.redacted_by IS NULL
+  AND (main.relation_type IS NULL OR main.relation_type <> 'm.replace');
+
+DROP TRIGGER event_insert_fts;
+DROP TRIGGER event_decrypt_fts;
+
+CREATE TRIGGER event_insert_fts
+	AFTER INSERT
+	ON event
+	WHEN COALESCE(NEW.decrypted_type, NEW.type) = 'm.room.message'
+		AND NEW.state_key IS NULL
+		AND NEW.redacted_by IS NULL
+		AND (NEW.relation_type IS NULL OR NEW.relation_type <> 'm.replace')
+BEGIN
+	INSERT INTO event_fts (rowid, body)
+	SELECT rowid, body FROM event_fts_source WHERE rowid = NEW.rowid AND typeof(body) = 'text';
+END;
+
+CREATE TRIGGER event_decrypt_fts
+	AFTER UPDATE
+	ON event
+	WHEN OLD.decrypted IS NULL
+		AND NEW.decrypted IS NOT NULL
+		AND NEW.decrypted_type = 'm.room.message'
+		AND NEW.state_key IS NULL
+		AND NEW.redacted_by IS NULL
+		AND (NEW.relation_type IS NULL OR NEW.relation_type <> 'm.replace')
+BEGIN
+	INSERT INTO event_fts (rowid, body)
+	SELECT rowid, body FROM event_fts_source WHERE rowid = NEW.rowid AND typeof(body) = 'text';
+END;
+
+CREATE TRIGGER event_insert_edit_fts
+	AFTER INSERT
+	ON event
+	WHEN COALESCE(NEW.decrypted_type, NEW.type) = 'm.room.message'
+		AND NEW.relation_type = 'm.replace'
+		AND NEW.state_key IS NULL
+BEGIN
+	DELETE FROM event_fts WHERE rowid = (SELECT rowid FROM event WHERE event_id = NEW.relates_to);
+	INSERT INTO event_fts (rowid, body)
+	SELECT rowid, body FROM event_fts_source
+	WHERE rowid = (SELECT rowid FROM event WHERE event_id = NEW.relates_to) AND typeof(body) = 'text';
+END;
+
+CREATE TRIGGER event_update_edit_fts
+	AFTER UPDATE
+	ON event
+	WHEN ((OLD.decrypted IS NULL AND NEW.decrypted IS NOT NULL AND NEW.decrypted_type = 'm.room.message')
+		OR (OLD.redacted_by IS NULL AND NEW.redacted_by IS NOT NULL))
+		AND NEW.relation_type = 'm.replace'
+		AND NEW.state_key IS NULL
+BEGIN
+	DELETE FROM event_fts WHERE rowid = (SELECT rowid FROM event WHERE event_id = NEW.relates_to);
+	INSERT INTO event_fts (rowid, body)
+	SELECT rowid, body FROM event_fts_source
+	WHERE rowid = (SELECT rowid FROM event WHERE event_id = NEW.relates_to) AND typeof(body) = 'text';
+END;
+
+DELETE FROM event_fts;
+INSERT INTO event_fts (rowid, body)
+SELECT rowid, body FROM event_fts_source WHERE typeof(body) = 'text';
@@ -210,6 +210,7 @@ func (h *HiClient) FetchKeysForOutdatedUsers(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	h.RefreshTrustStates(ctx, outdatedUsers...)
 	// TODO backoff for users that fail to be fetched?
 	return nil
 }
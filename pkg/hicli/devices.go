@@ -0,0 +1,110 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/id"
+)
+
+type OwnDevice struct {
+	DeviceID    id.DeviceID        `json:"device_id"`
+	DisplayName string             `json:"display_name"`
+	LastSeenIP  string             `json:"last_seen_ip,omitempty"`
+	LastSeenTS  jsontime.UnixMilli `json:"last_seen_ts"`
+	Current     bool               `json:"current"`
+	// The fields below are only set if the device has uploaded encryption keys.
+	IdentityKey id.Curve25519 `json:"identity_key,omitempty"`
+	SigningKey  id.Ed25519    `json:"signing_key,omitempty"`
+	Fingerprint string        `json:"fingerprint,omitempty"`
+	Trust       id.TrustState `json:"trust_state"`
+}
+
+// GetOwnDevices returns all devices logged into the current account, combined with
+// the encryption keys and cross-signing trust state of each device.
+func (h *HiClient) GetOwnDevices(ctx context.Context) ([]*OwnDevice, error) {
+	resp, err := h.Client.GetDevicesInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices: %w", err)
+	}
+	var cryptoDevices map[id.DeviceID]*id.Device
+	cachedDevices, err := h.Crypto.GetCachedDevices(ctx, h.Account.UserID)
+	if err != nil && !errors.Is(err, crypto.ErrUserNotTracked) {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get cached own devices")
+	} else if err == nil {
+		cryptoDevices = make(map[id.DeviceID]*id.Device, len(cachedDevices.Devices))
+		for _, dev := range cachedDevices.Devices {
+			cryptoDevices[dev.DeviceID] = dev
+		}
+	}
+	devices := make([]*OwnDevice, len(resp.Devices))
+	for i, dev := range resp.Devices {
+		devices[i] = &OwnDevice{
+			DeviceID:    dev.DeviceID,
+			DisplayName: dev.DisplayName,
+			LastSeenIP:  dev.LastSeenIP,
+			LastSeenTS:  jsontime.UMInt(dev.LastSeenTS),
+			Current:     dev.DeviceID == h.Account.DeviceID,
+		}
+		if cryptoDev, ok := cryptoDevices[dev.DeviceID]; ok {
+			devices[i].IdentityKey = cryptoDev.IdentityKey
+			devices[i].SigningKey = cryptoDev.SigningKey
+			devices[i].Fingerprint = cryptoDev.Fingerprint()
+			devices[i].Trust = cryptoDev.Trust
+		}
+	}
+	slices.SortFunc(devices, func(a, b *OwnDevice) int {
+		return b.LastSeenTS.Compare(a.LastSeenTS.Time)
+	})
+	return devices, nil
+}
+
+func (h *HiClient) RenameDevice(ctx context.Context, deviceID id.DeviceID, name string) error {
+	return h.Client.SetDeviceInfo(ctx, deviceID, &mautrix.ReqDeviceInfo{DisplayName: name})
+}
+
+// DeleteDevices logs out the given devices. Deleting devices requires user-interactive auth,
+// which is done using the given password. If the password is empty and the server requires
+// auth, ErrPasswordRequired is returned.
+func (h *HiClient) DeleteDevices(ctx context.Context, deviceIDs []id.DeviceID, password string) error {
+	if slices.Contains(deviceIDs, h.Account.DeviceID) {
+		return fmt.Errorf("can't delete current device, log out instead")
+	}
+	req := &mautrix.ReqDeleteDevices{Devices: deviceIDs}
+	err := h.Client.DeleteDevices(ctx, req)
+	var httpErr mautrix.HTTPError
+	if !errors.As(err, &httpErr) || !httpErr.IsStatus(http.StatusUnauthorized) || httpErr.RespError != nil {
+		return err
+	}
+	var uiaResp mautrix.RespUserInteractive
+	if jsonErr := json.Unmarshal([]byte(httpErr.ResponseBody), &uiaResp); jsonErr != nil {
+		return fmt.Errorf("failed to parse user-interactive auth response: %w", jsonErr)
+	} else if !uiaResp.HasSingleStageFlow(mautrix.AuthTypePassword) {
+		return fmt.Errorf("server doesn't support password auth for deleting devices")
+	} else if password == "" {
+		return ErrPasswordRequired
+	}
+	req.Auth = &mautrix.ReqUIAuthLogin{
+		BaseAuthData: mautrix.BaseAuthData{
+			Type:    mautrix.AuthTypePassword,
+			Session: uiaResp.Session,
+		},
+		User:     h.Account.UserID.String(),
+		Password: password,
+	}
+	return h.Client.DeleteDevices(ctx, req)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+func (h *HiClient) GetDrafts(ctx context.Context) ([]*database.Draft, error) {
+	return h.DB.Draft.GetAll(ctx)
+}
+
+// SetDraft stores the draft for the given room and thread, or deletes it if the content is empty or null.
+// The change is dispatched as a [DraftUpdated] event so that all connected frontends stay in sync.
+func (h *HiClient) SetDraft(ctx context.Context, roomID id.RoomID, threadID id.EventID, content json.RawMessage) (*database.Draft, error) {
+	var draft *database.Draft
+	if len(content) == 0 || bytes.Equal(content, []byte("null")) {
+		err := h.DB.Draft.Delete(ctx, roomID, threadID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete draft: %w", err)
+		}
+	} else {
+		if !json.Valid(content) {
+			return nil, fmt.Errorf("draft content must be valid JSON")
+		}
+		room, err := h.DB.Room.Get(ctx, roomID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get room: %w", err)
+		} else if room == nil {
+			return nil, fmt.Errorf("unknown room")
+		}
+		draft = &database.Draft{
+			RoomID:    roomID,
+			ThreadID:  threadID,
+			Content:   content,
+			UpdatedAt: jsontime.UM(time.Now()),
+		}
+		err = h.DB.Draft.Put(ctx, draft)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save draft: %w", err)
+		}
+	}
+	h.EventHandler(&DraftUpdated{RoomID: roomID, ThreadID: threadID, Draft: draft})
+	return draft, nil
+}
@@ -23,6 +23,10 @@ type SyncRoom struct {
 	Reset         bool                                          `json:"reset"`
 	Notifications []SyncNotification                            `json:"notifications"`
 	Receipts      map[id.EventID][]*database.Receipt            `json:"receipts"`
+
+	// SpaceChildren and SpaceParents are only included on initial sync and when they change.
+	SpaceChildren []*database.SpaceEdge `json:"space_children,omitempty"`
+	SpaceParents  []*database.SpaceEdge `json:"space_parents,omitempty"`
 }
 
 type SyncNotification struct {
@@ -70,11 +74,36 @@ type Typing struct {
 	event.TypingEventContent
 }
 
+type Presence struct {
+	Users []*database.Presence `json:"users"`
+}
+
 type SendComplete struct {
 	Event *database.Event `json:"event"`
 	Error error           `json:"error"`
 }
 
+type OutboxStatus struct {
+	RoomID  id.RoomID               `json:"room_id"`
+	Entries []*database.OutboxEntry `json:"entries"`
+}
+
+type DraftUpdated struct {
+	RoomID   id.RoomID  `json:"room_id"`
+	ThreadID id.EventID `json:"thread_id,omitempty"`
+	// Draft is nil if the draft was cleared.
+	Draft *database.Draft `json:"draft"`
+}
+
+type KeyBackupRestoreProgress struct {
+	RoomsTotal       int  `json:"rooms_total"`
+	RoomsDone        int  `json:"rooms_done"`
+	SessionsImported int  `json:"sessions_imported"`
+	SessionsSkipped  int  `json:"sessions_skipped"`
+	SessionsFailed   int  `json:"sessions_failed"`
+	Done             bool `json:"done"`
+}
+
 type ClientState struct {
 	IsLoggedIn    bool        `json:"is_logged_in"`
 	IsVerified    bool        `json:"is_verified"`
@@ -82,3 +111,30 @@ type ClientState struct {
 	DeviceID      id.DeviceID `json:"device_id,omitempty"`
 	HomeserverURL string      `json:"homeserver_url,omitempty"`
 }
+
+type VerificationRequested struct {
+	TransactionID id.VerificationTransactionID `json:"transaction_id"`
+	FromUser      id.UserID                    `json:"from_user"`
+	FromDevice    id.DeviceID                  `json:"from_device"`
+}
+
+type VerificationEmoji struct {
+	Emoji       string `json:"emoji"`
+	Description string `json:"description"`
+}
+
+type VerificationSAS struct {
+	TransactionID id.VerificationTransactionID `json:"transaction_id"`
+	Emojis        []VerificationEmoji          `json:"emojis,omitempty"`
+	Decimals      []int                        `json:"decimals"`
+}
+
+type VerificationCancelled struct {
+	TransactionID id.VerificationTransactionID `json:"transaction_id"`
+	Code          event.VerificationCancelCode `json:"code"`
+	Reason        string                       `json:"reason"`
+}
+
+type VerificationDone struct {
+	TransactionID id.VerificationTransactionID `json:"transaction_id"`
+}
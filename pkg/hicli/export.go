@@ -0,0 +1,591 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+type ExportFormat string
+
+const (
+	ExportFormatHTML  ExportFormat = "html"
+	ExportFormatJSONL ExportFormat = "jsonl"
+	ExportFormatText  ExportFormat = "text"
+)
+
+func (ef ExportFormat) Extension() string {
+	switch ef {
+	case ExportFormatHTML:
+		return "html"
+	case ExportFormatJSONL:
+		return "jsonl"
+	default:
+		return "txt"
+	}
+}
+
+func (ef ExportFormat) MimeType() string {
+	switch ef {
+	case ExportFormatHTML:
+		return "text/html; charset=utf-8"
+	case ExportFormatJSONL:
+		return "application/jsonl; charset=utf-8"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+type ExportRoomParams struct {
+	RoomID id.RoomID    `json:"room_id"`
+	Format ExportFormat `json:"format"`
+	// FetchHistory makes the export paginate the entire room history from the server first.
+	// Otherwise, only events that are already in the local database are exported.
+	FetchHistory bool `json:"fetch_history"`
+	// IncludeMedia embeds cached media files in HTML exports.
+	IncludeMedia bool `json:"include_media"`
+}
+
+type ExportRoomResponse struct {
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type"`
+	Data     string `json:"data"`
+}
+
+const (
+	exportBatchSize         = 1000
+	exportMaxEmbedMediaSize = 50 * 1024 * 1024
+)
+
+type roomExporter struct {
+	h      *HiClient
+	w      *bufio.Writer
+	room   *database.Room
+	params *ExportRoomParams
+	names  map[id.UserID]string
+}
+
+type exportedEvent struct {
+	*database.Event
+	Type    string
+	Content json.RawMessage
+	Edited  bool
+	Local   *database.LocalContent
+}
+
+// ExportFileName returns a file name suitable for an export of the given room.
+func (h *HiClient) ExportFileName(ctx context.Context, roomID id.RoomID, format ExportFormat) string {
+	name := roomID.String()
+	room, err := h.DB.Room.Get(ctx, roomID)
+	if err == nil && room != nil && room.Name != nil && *room.Name != "" {
+		name = *room.Name
+	}
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, name)
+	return fmt.Sprintf("%s-%s.%s", name, time.Now().Format("2006-01-02"), format.Extension())
+}
+
+// ExportRoom writes the timeline of the given room into the writer in chronological order.
+//
+// Encrypted events are exported using their decrypted content, edits are applied to the original
+// messages and redacted events are replaced with a placeholder.
+func (h *HiClient) ExportRoom(ctx context.Context, params *ExportRoomParams, w io.Writer) error {
+	switch params.Format {
+	case ExportFormatHTML, ExportFormatJSONL, ExportFormatText:
+	case "":
+		params.Format = ExportFormatHTML
+	default:
+		return fmt.Errorf("unsupported export format %q", params.Format)
+	}
+	room, err := h.DB.Room.Get(ctx, params.RoomID)
+	if err != nil {
+		return fmt.Errorf("failed to get room metadata: %w", err)
+	} else if room == nil {
+		return fmt.Errorf("unknown room")
+	}
+	if params.FetchHistory {
+		for {
+			resp, err := h.PaginateServer(ctx, room.ID, 500)
+			if err != nil {
+				return fmt.Errorf("failed to fetch room history: %w", err)
+			} else if !resp.HasMore {
+				break
+			}
+		}
+	}
+	exp := &roomExporter{
+		h:      h,
+		w:      bufio.NewWriter(w),
+		room:   room,
+		params: params,
+		names:  make(map[id.UserID]string),
+	}
+	err = exp.writeHeader()
+	if err != nil {
+		return err
+	}
+	after := database.TimelineRowID(math.MinInt64)
+	for {
+		evts, err := h.DB.Timeline.GetAfter(ctx, room.ID, exportBatchSize, after)
+		if err != nil {
+			return fmt.Errorf("failed to get timeline: %w", err)
+		} else if len(evts) == 0 {
+			break
+		}
+		after = evts[len(evts)-1].TimelineRowID
+		for _, evt := range evts {
+			if evt.RelationType == event.RelReplace || evt.Type == event.EventRedaction.Type || evt.Type == event.EventReaction.Type {
+				continue
+			}
+			err = exp.writeEvent(ctx, exp.prepareEvent(ctx, evt))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = exp.writeFooter()
+	if err != nil {
+		return err
+	}
+	return exp.w.Flush()
+}
+
+func (exp *roomExporter) prepareEvent(ctx context.Context, evt *database.Event) *exportedEvent {
+	out := &exportedEvent{
+		Event:   evt,
+		Type:    evt.Type,
+		Content: evt.Content,
+		Local:   evt.LocalContent,
+	}
+	if evt.Decrypted != nil {
+		out.Type = evt.DecryptedType
+		out.Content = evt.Decrypted
+	}
+	if evt.LastEditRowID != nil && *evt.LastEditRowID != 0 && evt.RedactedBy == "" {
+		edit, err := exp.h.DB.Event.GetByRowID(ctx, *evt.LastEditRowID)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Stringer("event_id", evt.ID).Msg("Failed to get last edit of event for export")
+		} else if edit != nil {
+			editContent := edit.Content
+			if edit.Decrypted != nil {
+				editContent = edit.Decrypted
+			}
+			newContent := gjson.GetBytes(editContent, `m\.new_content`)
+			if newContent.IsObject() {
+				out.Content = json.RawMessage(newContent.Raw)
+				out.Local = edit.LocalContent
+				out.Edited = true
+			}
+		}
+	}
+	return out
+}
+
+func (exp *roomExporter) getName(ctx context.Context, userID id.UserID) string {
+	name, ok := exp.names[userID]
+	if ok {
+		return name
+	}
+	name = userID.String()
+	memberEvt, err := exp.h.DB.CurrentState.Get(ctx, exp.room.ID, event.StateMember, userID.String())
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Stringer("user_id", userID).Msg("Failed to get member event for export")
+	} else if memberEvt != nil {
+		if displayname := gjson.GetBytes(memberEvt.Content, "displayname").Str; displayname != "" {
+			name = displayname
+		}
+	}
+	exp.names[userID] = name
+	return name
+}
+
+func (exp *roomExporter) roomName() string {
+	if exp.room.Name != nil && *exp.room.Name != "" {
+		return *exp.room.Name
+	}
+	return exp.room.ID.String()
+}
+
+const exportHTMLHeader = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: sans-serif; max-width: 60rem; margin: 0 auto; padding: 1rem; }
+.event { display: grid; grid-template-columns: 10rem 12rem 1fr; gap: .5rem; padding: .25rem 0; border-bottom: 1px solid #eee; }
+.timestamp { color: #888; font-size: .85em; }
+.sender { font-weight: bold; overflow: hidden; text-overflow: ellipsis; }
+.state, .redacted, .undecryptable { color: #666; font-style: italic; }
+.edited { color: #888; font-size: .8em; }
+img, video { max-width: 100%%; max-height: 30rem; }
+blockquote { border-left: 3px solid #ccc; margin: 0; padding-left: .5rem; }
+</style>
+</head>
+<body>
+<h1>%s</h1>
+<p>Exported from gomuks on %s</p>
+`
+
+func (exp *roomExporter) writeHeader() error {
+	var err error
+	switch exp.params.Format {
+	case ExportFormatHTML:
+		name := html.EscapeString(exp.roomName())
+		_, err = fmt.Fprintf(exp.w, exportHTMLHeader, name, name, time.Now().Format(time.RFC1123))
+	case ExportFormatText:
+		_, err = fmt.Fprintf(exp.w, "Export of %s (%s) on %s\n\n", exp.roomName(), exp.room.ID, time.Now().Format(time.RFC1123))
+	}
+	return err
+}
+
+func (exp *roomExporter) writeFooter() error {
+	if exp.params.Format == ExportFormatHTML {
+		_, err := exp.w.WriteString("</body>\n</html>\n")
+		return err
+	}
+	return nil
+}
+
+func (exp *roomExporter) writeEvent(ctx context.Context, evt *exportedEvent) error {
+	switch exp.params.Format {
+	case ExportFormatJSONL:
+		return exp.writeJSONEvent(evt)
+	case ExportFormatHTML:
+		return exp.writeHTMLEvent(ctx, evt)
+	default:
+		return exp.writeTextEvent(ctx, evt)
+	}
+}
+
+type exportedJSONEvent struct {
+	EventID         id.EventID      `json:"event_id"`
+	Sender          id.UserID       `json:"sender"`
+	Type            string          `json:"type"`
+	StateKey        *string         `json:"state_key,omitempty"`
+	Timestamp       int64           `json:"origin_server_ts"`
+	Content         json.RawMessage `json:"content"`
+	Encrypted       bool            `json:"encrypted,omitempty"`
+	DecryptionError string          `json:"decryption_error,omitempty"`
+	Edited          bool            `json:"edited,omitempty"`
+	RedactedBy      id.EventID      `json:"redacted_by,omitempty"`
+}
+
+func (exp *roomExporter) writeJSONEvent(evt *exportedEvent) error {
+	data, err := json.Marshal(&exportedJSONEvent{
+		EventID:         evt.ID,
+		Sender:          evt.Sender,
+		Type:            evt.Type,
+		StateKey:        evt.StateKey,
+		Timestamp:       evt.Timestamp.UnixMilli(),
+		Content:         evt.Content,
+		Encrypted:       evt.Event.Type == event.EventEncrypted.Type,
+		DecryptionError: evt.DecryptionError,
+		Edited:          evt.Edited,
+		RedactedBy:      evt.RedactedBy,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event %s: %w", evt.ID, err)
+	}
+	_, err = exp.w.Write(data)
+	if err != nil {
+		return err
+	}
+	return exp.w.WriteByte('\n')
+}
+
+// describeEvent returns a plaintext description of the event. The second return value is false
+// if the event is not a message (i.e. state events, redacted and undecryptable events).
+func (exp *roomExporter) describeEvent(ctx context.Context, evt *exportedEvent) (string, bool) {
+	if evt.RedactedBy != "" {
+		return "[message deleted]", false
+	} else if evt.Type == event.EventEncrypted.Type {
+		return "[unable to decrypt message]", false
+	}
+	content := gjson.ParseBytes(evt.Content)
+	switch evt.Type {
+	case event.EventMessage.Type, event.EventSticker.Type:
+		return content.Get("body").Str, true
+	case event.StateMember.Type:
+		if evt.StateKey == nil {
+			break
+		}
+		target := exp.getName(ctx, id.UserID(*evt.StateKey))
+		switch event.Membership(content.Get("membership").Str) {
+		case event.MembershipJoin:
+			return fmt.Sprintf("%s joined the room", target), false
+		case event.MembershipLeave:
+			if *evt.StateKey == evt.Sender.String() {
+				return fmt.Sprintf("%s left the room", target), false
+			}
+			return fmt.Sprintf("%s was removed from the room", target), false
+		case event.MembershipInvite:
+			return fmt.Sprintf("%s was invited to the room", target), false
+		case event.MembershipBan:
+			return fmt.Sprintf("%s was banned from the room", target), false
+		case event.MembershipKnock:
+			return fmt.Sprintf("%s asked to join the room", target), false
+		}
+	case event.StateRoomName.Type:
+		return fmt.Sprintf("%s changed the room name to %s", exp.getName(ctx, evt.Sender), content.Get("name").Str), false
+	case event.StateTopic.Type:
+		return fmt.Sprintf("%s changed the room topic to %s", exp.getName(ctx, evt.Sender), content.Get("topic").Str), false
+	}
+	if evt.StateKey != nil {
+		return fmt.Sprintf("%s changed %s", exp.getName(ctx, evt.Sender), evt.Type), false
+	}
+	return "", false
+}
+
+func (exp *roomExporter) writeTextEvent(ctx context.Context, evt *exportedEvent) error {
+	body, isMessage := exp.describeEvent(ctx, evt)
+	if body == "" {
+		return nil
+	}
+	ts := evt.Timestamp.Format("2006-01-02 15:04:05")
+	sender := exp.getName(ctx, evt.Sender)
+	var err error
+	if isMessage {
+		msgType := event.MessageType(gjson.GetBytes(evt.Content, "msgtype").Str)
+		switch msgType {
+		case event.MsgEmote:
+			_, err = fmt.Fprintf(exp.w, "[%s] * %s %s", ts, sender, body)
+		case event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile:
+			_, err = fmt.Fprintf(exp.w, "[%s] %s sent a file: %s", ts, sender, body)
+		default:
+			_, err = fmt.Fprintf(exp.w, "[%s] %s: %s", ts, sender, body)
+		}
+		if err == nil && evt.Edited {
+			_, err = exp.w.WriteString(" (edited)")
+		}
+	} else {
+		_, err = fmt.Fprintf(exp.w, "[%s] -- %s", ts, body)
+	}
+	if err != nil {
+		return err
+	}
+	return exp.w.WriteByte('\n')
+}
+
+func (exp *roomExporter) writeHTMLEvent(ctx context.Context, evt *exportedEvent) error {
+	body, isMessage := exp.describeEvent(ctx, evt)
+	if body == "" {
+		return nil
+	}
+	_, err := fmt.Fprintf(
+		exp.w, `<div class="event" id="%s"><span class="timestamp">%s</span><span class="sender" title="%s">%s</span><div class="content">`,
+		html.EscapeString(evt.ID.String()),
+		evt.Timestamp.Format("2006-01-02 15:04:05"),
+		html.EscapeString(evt.Sender.String()),
+		html.EscapeString(exp.getName(ctx, evt.Sender)),
+	)
+	if err != nil {
+		return err
+	}
+	if !isMessage {
+		class := "state"
+		if evt.RedactedBy != "" {
+			class = "redacted"
+		} else if evt.Type == event.EventEncrypted.Type {
+			class = "undecryptable"
+		}
+		_, err = fmt.Fprintf(exp.w, `<span class="%s">%s</span>`, class, html.EscapeString(body))
+	} else {
+		err = exp.writeHTMLMessage(ctx, evt, body)
+		if err == nil && evt.Edited {
+			_, err = exp.w.WriteString(` <span class="edited">(edited)</span>`)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	_, err = exp.w.WriteString("</div></div>\n")
+	return err
+}
+
+func (exp *roomExporter) writeHTMLMessage(ctx context.Context, evt *exportedEvent, body string) error {
+	var content event.MessageEventContent
+	err := json.Unmarshal(evt.Content, &content)
+	if err != nil {
+		_, err = exp.w.WriteString(html.EscapeString(body))
+		return err
+	}
+	if evt.Type == event.EventSticker.Type {
+		content.MsgType = event.MsgImage
+	}
+	switch content.MsgType {
+	case event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile:
+		mxc := content.URL
+		if content.File != nil {
+			mxc = content.File.URL
+		}
+		fileName := content.GetFileName()
+		if exp.params.IncludeMedia {
+			var written bool
+			written, err = exp.writeEmbeddedMedia(ctx, content.MsgType, mxc, fileName)
+			if err != nil || written {
+				return err
+			}
+		}
+		_, err = fmt.Fprintf(exp.w, `<span class="file">%s (%s)</span>`, html.EscapeString(fileName), html.EscapeString(string(mxc)))
+		return err
+	case event.MsgEmote:
+		_, err = fmt.Fprintf(exp.w, "* %s ", html.EscapeString(exp.getName(ctx, evt.Sender)))
+		if err != nil {
+			return err
+		}
+	}
+	if evt.Local != nil && evt.Local.SanitizedHTML != "" {
+		err = exp.writeSanitizedHTML(ctx, evt.Local.SanitizedHTML)
+	} else {
+		_, err = exp.w.WriteString(strings.ReplaceAll(html.EscapeString(body), "\n", "<br>"))
+	}
+	return err
+}
+
+// sanitizedMediaURLRegex matches the media URLs that the HTML sanitizer generates for inline images
+// and mxc links (see HTMLSanitizerImgSrcTemplate), which only work inside gomuks itself.
+var sanitizedMediaURLRegex = sync.OnceValue(func() *regexp.Regexp {
+	return regexp.MustCompile(strings.ReplaceAll(regexp.QuoteMeta(HTMLSanitizerImgSrcTemplate), "%s", `([^/"?&]+)`))
+})
+
+// writeSanitizedHTML writes the sanitized HTML of a message with media URLs replaced by
+// embedded data URIs if media is included and cached, or plain mxc URIs otherwise.
+func (exp *roomExporter) writeSanitizedHTML(ctx context.Context, sanitizedHTML string) error {
+	var prevEnd int
+	for _, match := range sanitizedMediaURLRegex().FindAllStringSubmatchIndex(sanitizedHTML, -1) {
+		_, err := exp.w.WriteString(sanitizedHTML[prevEnd:match[0]])
+		if err != nil {
+			return err
+		}
+		prevEnd = match[1]
+		mxc := id.ContentURI{
+			Homeserver: sanitizedHTML[match[2]:match[3]],
+			FileID:     sanitizedHTML[match[4]:match[5]],
+		}
+		if exp.params.IncludeMedia {
+			file, entry, err := exp.openEmbeddableMedia(ctx, mxc)
+			if err != nil {
+				return err
+			} else if file != nil {
+				_, err = fmt.Fprintf(exp.w, "data:%s;base64,", html.EscapeString(entry.MimeType))
+				if err == nil {
+					err = exp.writeBase64(file)
+				}
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		_, err = exp.w.WriteString(mxc.String())
+		if err != nil {
+			return err
+		}
+	}
+	_, err := exp.w.WriteString(sanitizedHTML[prevEnd:])
+	return err
+}
+
+// openEmbeddableMedia opens the given media from the cache. The returned file is nil if the media
+// isn't cached or is too large to embed.
+func (exp *roomExporter) openEmbeddableMedia(ctx context.Context, mxc id.ContentURI) (io.ReadCloser, *database.Media, error) {
+	if exp.h.OpenCachedMedia == nil {
+		return nil, nil, nil
+	}
+	entry, err := exp.h.DB.Media.Get(ctx, mxc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get media cache entry: %w", err)
+	} else if entry == nil || entry.Hash == nil || entry.Size > exportMaxEmbedMediaSize {
+		return nil, nil, nil
+	}
+	file, err := exp.h.OpenCachedMedia(ctx, entry)
+	if errors.Is(err, ErrMediaNotCached) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to open cached media: %w", err)
+	}
+	return file, entry, nil
+}
+
+// writeBase64 copies the given file into the export as base64 and closes it.
+func (exp *roomExporter) writeBase64(file io.ReadCloser) error {
+	defer func() {
+		_ = file.Close()
+	}()
+	encoder := base64.NewEncoder(base64.StdEncoding, exp.w)
+	_, err := io.Copy(encoder, file)
+	if err != nil {
+		return fmt.Errorf("failed to copy media into export: %w", err)
+	}
+	return encoder.Close()
+}
+
+func (exp *roomExporter) writeEmbeddedMedia(ctx context.Context, msgType event.MessageType, uri id.ContentURIString, fileName string) (bool, error) {
+	mxc, err := uri.Parse()
+	if err != nil {
+		return false, nil
+	}
+	file, entry, err := exp.openEmbeddableMedia(ctx, mxc)
+	if err != nil || file == nil {
+		return false, err
+	}
+	mimeType := html.EscapeString(entry.MimeType)
+	escapedName := html.EscapeString(fileName)
+	switch msgType {
+	case event.MsgImage:
+		_, err = fmt.Fprintf(exp.w, `<img alt="%s" src="data:%s;base64,`, escapedName, mimeType)
+	case event.MsgVideo:
+		_, err = fmt.Fprintf(exp.w, `<video controls title="%s" src="data:%s;base64,`, escapedName, mimeType)
+	case event.MsgAudio:
+		_, err = fmt.Fprintf(exp.w, `<audio controls title="%s" src="data:%s;base64,`, escapedName, mimeType)
+	default:
+		_, err = fmt.Fprintf(exp.w, `<a download="%s" href="data:%s;base64,`, escapedName, mimeType)
+	}
+	if err != nil {
+		_ = file.Close()
+		return true, err
+	}
+	err = exp.writeBase64(file)
+	if err != nil {
+		return true, err
+	}
+	switch msgType {
+	case event.MsgImage:
+		_, err = exp.w.WriteString(`">`)
+	case event.MsgVideo:
+		_, err = exp.w.WriteString(`"></video>`)
+	case event.MsgAudio:
+		_, err = exp.w.WriteString(`"></audio>`)
+	default:
+		_, err = fmt.Fprintf(exp.w, `">%s</a>`, escapedName)
+	}
+	return true, err
+}
@@ -11,6 +11,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -24,6 +25,8 @@ import (
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto"
 	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/crypto/verificationhelper"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 	"maunium.net/go/mautrix/pushrules"
 
@@ -40,7 +43,13 @@ type HiClient struct {
 	ClientStore *database.ClientStateStore
 	Log         zerolog.Logger
 
+	Verification *verificationhelper.VerificationHelper
+	// Commands contains the slash commands that can be used in SendMessage.
+	Commands *CommandRegistry
+
 	Verified bool
+	// EnablePresence controls whether presence updates are requested in sync.
+	EnablePresence bool
 
 	KeyBackupVersion id.KeyBackupVersion
 	KeyBackupKey     *backup.MegolmBackupKey
@@ -52,6 +61,9 @@ type HiClient struct {
 
 	EventHandler func(evt any)
 	LogoutFunc   func(context.Context) error
+	// OpenCachedMedia is used to read cached media files when exporting rooms.
+	// It should return ErrMediaNotCached if the file doesn't exist.
+	OpenCachedMedia func(context.Context, *database.Media) (io.ReadCloser, error)
 
 	firstSyncReceived bool
 	syncingID         int
@@ -59,16 +71,31 @@ type HiClient struct {
 	stopSync          atomic.Pointer[context.CancelFunc]
 	encryptLock       sync.Mutex
 
-	requestQueueWakeup chan struct{}
+	requestQueueWakeup   chan struct{}
+	scheduledEventWakeup chan struct{}
+	outboxWakeup         chan struct{}
+
+	outboxLock     sync.Mutex
+	outboxInFlight map[id.RoomID]struct{}
 
 	jsonRequestsLock sync.Mutex
 	jsonRequests     map[int64]context.CancelCauseFunc
 
 	paginationInterrupterLock sync.Mutex
 	paginationInterrupter     map[id.RoomID]context.CancelCauseFunc
+
+	eventTypeHandlersLock sync.RWMutex
+	eventTypeHandlers     map[event.Type][]mautrix.EventHandler
+
+	verificationPartnersLock sync.Mutex
+	verificationPartners     map[id.VerificationTransactionID]id.UserID
 }
 
 var ErrTimelineReset = errors.New("got limited timeline sync response")
+var ErrMediaNotCached = errors.New("media not found in cache")
+var ErrPasswordRequired = errors.New("account password is required for user-interactive auth")
+var ErrFTS5NotEnabled = errors.New("gomuks must be built with the sqlite_fts5 tag, as the message search index requires SQLite's FTS5 extension")
+var ErrSecurityAlreadySetUp = errors.New("account already has cross-signing keys or secret storage, pass reset to replace them")
 
 func New(rawDB, cryptoDB *dbutil.Database, log zerolog.Logger, pickleKey []byte, evtHandler func(any)) *HiClient {
 	if cryptoDB == nil {
@@ -87,11 +114,18 @@ func New(rawDB, cryptoDB *dbutil.Database, log zerolog.Logger, pickleKey []byte,
 		Log: log,
 
 		requestQueueWakeup:    make(chan struct{}, 1),
+		scheduledEventWakeup:  make(chan struct{}, 1),
+		outboxWakeup:          make(chan struct{}, 1),
+		outboxInFlight:        make(map[id.RoomID]struct{}),
 		jsonRequests:          make(map[int64]context.CancelCauseFunc),
 		paginationInterrupter: make(map[id.RoomID]context.CancelCauseFunc),
+		eventTypeHandlers:     make(map[event.Type][]mautrix.EventHandler),
+		verificationPartners:  make(map[id.VerificationTransactionID]id.UserID),
 
 		EventHandler: evtHandler,
+		Commands:     NewCommandRegistry(),
 	}
+	c.Commands.Register(defaultCommands()...)
 	if cryptoDB != rawDB {
 		c.CryptoDB = cryptoDB
 	}
@@ -127,6 +161,8 @@ func New(rawDB, cryptoDB *dbutil.Database, log zerolog.Logger, pickleKey []byte,
 	c.Crypto.DisableRatchetTracking = true
 	c.Crypto.DisableDecryptKeyFetching = true
 	c.Client.Crypto = (*hiCryptoHelper)(c)
+	c.Verification = verificationhelper.NewVerificationHelper(c.Client, c.Crypto, nil, (*hiVerificationCallbacks)(c), false)
+	exerrors.PanicIfNotNil(c.Verification.Init(log.With().Str("component", "verification").Logger().WithContext(context.Background())))
 	return c
 }
 
@@ -151,6 +187,9 @@ func (h *HiClient) Start(ctx context.Context, userID id.UserID, expectedAccount
 	if expectedAccount != nil && userID != expectedAccount.UserID {
 		panic(fmt.Errorf("invalid parameters: different user ID in expected account and user ID"))
 	}
+	if !database.FTS5Enabled {
+		return ErrFTS5NotEnabled
+	}
 	err := h.DB.Upgrade(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to upgrade hicli db: %w", err)
@@ -179,6 +218,7 @@ func (h *HiClient) Start(ctx context.Context, userID id.UserID, expectedAccount
 		h.Client.UserID = account.UserID
 		h.Client.DeviceID = account.DeviceID
 		h.Client.AccessToken = account.AccessToken
+		h.Client.SyncPresence = account.SyncPresence
 		h.Client.HomeserverURL, err = url.Parse(account.HomeserverURL)
 		if err != nil {
 			return err
@@ -202,8 +242,9 @@ func (h *HiClient) Start(ctx context.Context, userID id.UserID, expectedAccount
 			if err != nil {
 				return err
 			}
-			go h.Sync()
 		}
+		// Unverified clients still sync to-device events to allow interactive verification
+		go h.Sync()
 	}
 	return nil
 }
@@ -246,8 +287,12 @@ func (h *HiClient) Sync() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	h.stopSync.Store(&cancel)
-	go h.RunRequestQueue(h.Log.WithContext(ctx))
-	go h.LoadPushRules(h.Log.WithContext(ctx))
+	if h.Verified {
+		go h.RunOutbox(h.Log.WithContext(ctx))
+		go h.RunRequestQueue(h.Log.WithContext(ctx))
+		go h.RunScheduledEventQueue(h.Log.WithContext(ctx))
+		go h.LoadPushRules(h.Log.WithContext(ctx))
+	}
 	ctx = log.WithContext(ctx)
 	log.Info().Msg("Starting syncing")
 	err := h.Client.SyncWithContext(ctx)
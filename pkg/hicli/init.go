@@ -64,6 +64,13 @@ func (h *HiClient) getInitialSyncRoom(ctx context.Context, room *database.Room)
 			syncRoom.Events = append(syncRoom.Events, previewEvent)
 		}
 	}
+	err = h.fillSpaceEdges(ctx, syncRoom)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("room_id", room.ID).Msg("Failed to get space edges for room")
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
 	return syncRoom
 }
 
@@ -12,6 +12,7 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 	"time"
 
 	"maunium.net/go/mautrix"
@@ -44,6 +45,44 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *sendMessageParams) (*database.Event, error) {
 			return h.SendMessage(ctx, params.RoomID, params.BaseContent, params.Extra, params.Text, params.RelatesTo, params.Mentions)
 		})
+	case "schedule_message":
+		return unmarshalAndCall(req.Data, func(params *scheduleMessageParams) (*database.ScheduledEvent, error) {
+			return h.ScheduleMessage(ctx, params.RoomID, time.UnixMilli(params.SendAt), params.BaseContent, params.Extra, params.Text, params.RelatesTo, params.Mentions)
+		})
+	case "get_scheduled_messages":
+		return unmarshalAndCall(req.Data, func(params *getScheduledMessagesParams) ([]*database.ScheduledEvent, error) {
+			return h.GetScheduledMessages(ctx, params.RoomID)
+		})
+	case "cancel_scheduled_message":
+		return unmarshalAndCall(req.Data, func(params *cancelScheduledMessageParams) (bool, error) {
+			return true, h.CancelScheduledMessage(ctx, params.RowID)
+		})
+	case "get_drafts":
+		return h.GetDrafts(ctx)
+	case "set_draft":
+		return unmarshalAndCall(req.Data, func(params *setDraftParams) (*database.Draft, error) {
+			return h.SetDraft(ctx, params.RoomID, params.ThreadID, params.Content)
+		})
+	case "get_outbox":
+		return unmarshalAndCall(req.Data, func(params *getOutboxParams) ([]*database.OutboxEntry, error) {
+			return h.GetOutbox(ctx, params.RoomID)
+		})
+	case "export_room":
+		return unmarshalAndCall(req.Data, func(params *ExportRoomParams) (*ExportRoomResponse, error) {
+			if params.IncludeMedia {
+				return nil, fmt.Errorf("exports with media must be downloaded via GET /_gomuks/export/{room_id}")
+			}
+			var buf strings.Builder
+			err := h.ExportRoom(ctx, params, &buf)
+			if err != nil {
+				return nil, err
+			}
+			return &ExportRoomResponse{
+				FileName: h.ExportFileName(ctx, params.RoomID, params.Format),
+				MimeType: params.Format.MimeType(),
+				Data:     buf.String(),
+			}, nil
+		})
 	case "send_event":
 		return unmarshalAndCall(req.Data, func(params *sendEventParams) (*database.Event, error) {
 			return h.Send(ctx, params.RoomID, params.EventType, params.Content)
@@ -76,7 +115,19 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		})
 	case "mark_read":
 		return unmarshalAndCall(req.Data, func(params *markReadParams) (bool, error) {
-			return true, h.MarkRead(ctx, params.RoomID, params.EventID, params.ReceiptType)
+			return true, h.MarkRead(ctx, params.RoomID, params.EventID, params.ReceiptType, params.ThreadID)
+		})
+	case "set_room_notification_mode":
+		return unmarshalAndCall(req.Data, func(params *setRoomNotificationModeParams) (bool, error) {
+			return true, h.SetRoomNotificationMode(ctx, params.RoomID, params.Mode)
+		})
+	case "get_presence":
+		return unmarshalAndCall(req.Data, func(params *getPresenceParams) (*database.Presence, error) {
+			return h.GetPresence(ctx, params.UserID)
+		})
+	case "set_presence":
+		return unmarshalAndCall(req.Data, func(params *setPresenceParams) (bool, error) {
+			return true, h.SetPresence(ctx, params.Presence, params.StatusMsg)
 		})
 	case "set_typing":
 		return unmarshalAndCall(req.Data, func(params *setTypingParams) (bool, error) {
@@ -98,6 +149,16 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 			}
 			return h.GetProfileEncryptionInfo(ctx, params.UserID)
 		})
+	case "get_own_devices":
+		return h.GetOwnDevices(ctx)
+	case "rename_device":
+		return unmarshalAndCall(req.Data, func(params *renameDeviceParams) (bool, error) {
+			return true, h.RenameDevice(ctx, params.DeviceID, params.Name)
+		})
+	case "delete_devices":
+		return unmarshalAndCall(req.Data, func(params *deleteDevicesParams) (bool, error) {
+			return true, h.DeleteDevices(ctx, params.DeviceIDs, params.Password)
+		})
 	case "get_profile_encryption_info":
 		return unmarshalAndCall(req.Data, func(params *getProfileParams) (*ProfileEncryptionInfo, error) {
 			return h.GetProfileEncryptionInfo(ctx, params.UserID)
@@ -122,6 +183,10 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *getReceiptsParams) (map[id.EventID][]*database.Receipt, error) {
 			return h.GetReceipts(ctx, params.RoomID, params.EventIDs)
 		})
+	case "search_messages":
+		return unmarshalAndCall(req.Data, func(params *database.SearchParams) ([]*database.SearchResult, error) {
+			return h.DB.Event.Search(ctx, params)
+		})
 	case "paginate":
 		return unmarshalAndCall(req.Data, func(params *paginateParams) (*PaginationResponse, error) {
 			return h.Paginate(ctx, params.RoomID, params.MaxTimelineID, params.Limit)
@@ -130,6 +195,23 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *paginateParams) (*PaginationResponse, error) {
 			return h.PaginateServer(ctx, params.RoomID, params.Limit)
 		})
+	case "get_space_hierarchy":
+		return unmarshalAndCall(req.Data, func(params *getSpaceHierarchyParams) (*SpaceHierarchyResponse, error) {
+			return h.GetSpaceHierarchy(ctx, params.SpaceID, &mautrix.ReqHierarchy{
+				From:          params.From,
+				Limit:         params.Limit,
+				MaxDepth:      params.MaxDepth,
+				SuggestedOnly: params.SuggestedOnly,
+			})
+		})
+	case "get_threads":
+		return unmarshalAndCall(req.Data, func(params *getThreadsParams) (*ThreadListResponse, error) {
+			return h.GetThreads(ctx, params.RoomID, params.Before, params.Limit)
+		})
+	case "paginate_thread":
+		return unmarshalAndCall(req.Data, func(params *paginateThreadParams) (*ThreadPaginationResponse, error) {
+			return h.PaginateThread(ctx, params.RoomID, params.ThreadRoot, params.MaxRowID, params.From, params.Limit)
+		})
 	case "get_room_summary":
 		return unmarshalAndCall(req.Data, func(params *joinRoomParams) (*mautrix.RespRoomSummary, error) {
 			return h.Client.GetRoomSummary(ctx, params.RoomIDOrAlias, params.Via...)
@@ -145,6 +227,51 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *leaveRoomParams) (*mautrix.RespLeaveRoom, error) {
 			return h.Client.LeaveRoom(ctx, params.RoomID, &mautrix.ReqLeave{Reason: params.Reason})
 		})
+	case "create_room":
+		return unmarshalAndCall(req.Data, func(params *CreateRoomParams) (*mautrix.RespCreateRoom, error) {
+			return h.CreateRoom(ctx, params)
+		})
+	case "set_room_name":
+		return unmarshalAndCall(req.Data, func(params *setRoomNameParams) (id.EventID, error) {
+			return h.SetRoomName(ctx, params.RoomID, params.Name)
+		})
+	case "set_room_topic":
+		return unmarshalAndCall(req.Data, func(params *setRoomTopicParams) (id.EventID, error) {
+			return h.SetRoomTopic(ctx, params.RoomID, params.Topic)
+		})
+	case "set_room_avatar":
+		return unmarshalAndCall(req.Data, func(params *setRoomAvatarParams) (id.EventID, error) {
+			return h.SetRoomAvatar(ctx, params.RoomID, params.URL)
+		})
+	case "set_room_join_rules":
+		return unmarshalAndCall(req.Data, func(params *setRoomJoinRulesParams) (id.EventID, error) {
+			return h.SetRoomJoinRules(ctx, params.RoomID, &event.JoinRulesEventContent{
+				JoinRule: params.JoinRule,
+				Allow:    params.Allow,
+			})
+		})
+	case "set_room_history_visibility":
+		return unmarshalAndCall(req.Data, func(params *setRoomHistoryVisibilityParams) (id.EventID, error) {
+			return h.SetRoomHistoryVisibility(ctx, params.RoomID, params.HistoryVisibility)
+		})
+	case "set_room_power_levels":
+		return unmarshalAndCall(req.Data, func(params *setRoomPowerLevelsParams) (id.EventID, error) {
+			return h.SetRoomPowerLevels(ctx, params.RoomID, params.PowerLevels)
+		})
+	case "update_membership":
+		return unmarshalAndCall(req.Data, func(params *updateMembershipParams) ([]*MembershipResult, error) {
+			return h.UpdateMembership(ctx, params.RoomID, params.Action, params.UserIDs, params.Reason)
+		})
+	case "accept_invite":
+		return unmarshalAndCall(req.Data, func(params *leaveRoomParams) (*mautrix.RespJoinRoom, error) {
+			return h.AcceptInvite(ctx, params.RoomID)
+		})
+	case "reject_invite":
+		return unmarshalAndCall(req.Data, func(params *leaveRoomParams) (bool, error) {
+			return true, h.RejectInvite(ctx, params.RoomID, params.Reason)
+		})
+	case "list_commands":
+		return h.Commands.List(), nil
 	case "ensure_group_session_shared":
 		return unmarshalAndCall(req.Data, func(params *ensureGroupSessionSharedParams) (bool, error) {
 			return true, h.EnsureGroupSessionShared(ctx, params.RoomID)
@@ -175,6 +302,49 @@ func (h *HiClient) handleJSONCommand(ctx context.Context, req *JSONCommand) (any
 		return unmarshalAndCall(req.Data, func(params *verifyParams) (bool, error) {
 			return true, h.Verify(ctx, params.RecoveryKey)
 		})
+	case "bootstrap_security":
+		return unmarshalAndCall(req.Data, func(params *bootstrapSecurityParams) (*bootstrapSecurityResponse, error) {
+			recoveryKey, err := h.BootstrapSecurity(ctx, params.Password, params.Passphrase, params.Reset)
+			if err != nil {
+				return nil, err
+			}
+			return &bootstrapSecurityResponse{RecoveryKey: recoveryKey}, nil
+		})
+	case "restore_key_backup":
+		return h.RestoreKeyBackup(ctx)
+	case "export_room_keys":
+		return unmarshalAndCall(req.Data, func(params *exportRoomKeysParams) (string, error) {
+			data, err := h.ExportRoomKeys(ctx, params.Passphrase, params.RoomID)
+			return string(data), err
+		})
+	case "import_room_keys":
+		return unmarshalAndCall(req.Data, func(params *importRoomKeysParams) (*ImportRoomKeysResponse, error) {
+			return h.ImportRoomKeys(ctx, params.Passphrase, []byte(params.Data))
+		})
+	case "start_verification":
+		return unmarshalAndCall(req.Data, func(params *startVerificationParams) (id.VerificationTransactionID, error) {
+			return h.StartVerification(ctx, params.UserID)
+		})
+	case "verify_user":
+		return unmarshalAndCall(req.Data, func(params *verifyUserParams) (bool, error) {
+			return true, h.VerifyUser(ctx, params.UserID, params.MasterKey)
+		})
+	case "accept_verification":
+		return unmarshalAndCall(req.Data, func(params *verificationParams) (bool, error) {
+			return true, h.Verification.AcceptVerification(ctx, params.TransactionID)
+		})
+	case "start_sas_verification":
+		return unmarshalAndCall(req.Data, func(params *verificationParams) (bool, error) {
+			return true, h.Verification.StartSAS(ctx, params.TransactionID)
+		})
+	case "confirm_sas_verification":
+		return unmarshalAndCall(req.Data, func(params *verificationParams) (bool, error) {
+			return true, h.Verification.ConfirmSAS(ctx, params.TransactionID)
+		})
+	case "cancel_verification":
+		return unmarshalAndCall(req.Data, func(params *verificationParams) (bool, error) {
+			return true, h.CancelVerification(ctx, params.TransactionID, params.Reason)
+		})
 	case "discover_homeserver":
 		return unmarshalAndCall(req.Data, func(params *discoverHomeserverParams) (*mautrix.ClientWellKnown, error) {
 			_, homeserver, err := params.UserID.Parse()
@@ -223,6 +393,29 @@ type sendMessageParams struct {
 	Mentions    *event.Mentions            `json:"mentions"`
 }
 
+type scheduleMessageParams struct {
+	sendMessageParams
+	SendAt int64 `json:"send_at"`
+}
+
+type getScheduledMessagesParams struct {
+	RoomID id.RoomID `json:"room_id"`
+}
+
+type cancelScheduledMessageParams struct {
+	RowID int64 `json:"rowid"`
+}
+
+type setDraftParams struct {
+	RoomID   id.RoomID       `json:"room_id"`
+	ThreadID id.EventID      `json:"thread_id"`
+	Content  json.RawMessage `json:"content"`
+}
+
+type getOutboxParams struct {
+	RoomID id.RoomID `json:"room_id"`
+}
+
 type sendEventParams struct {
 	RoomID    id.RoomID       `json:"room_id"`
 	EventType event.Type      `json:"type"`
@@ -262,6 +455,12 @@ type markReadParams struct {
 	RoomID      id.RoomID         `json:"room_id"`
 	EventID     id.EventID        `json:"event_id"`
 	ReceiptType event.ReceiptType `json:"receipt_type"`
+	ThreadID    event.ThreadID    `json:"thread_id,omitempty"`
+}
+
+type setRoomNotificationModeParams struct {
+	RoomID id.RoomID                     `json:"room_id"`
+	Mode   database.RoomNotificationMode `json:"mode"`
 }
 
 type setTypingParams struct {
@@ -316,6 +515,51 @@ type verifyParams struct {
 	RecoveryKey string `json:"recovery_key"`
 }
 
+type renameDeviceParams struct {
+	DeviceID id.DeviceID `json:"device_id"`
+	Name     string      `json:"name"`
+}
+
+type deleteDevicesParams struct {
+	DeviceIDs []id.DeviceID `json:"device_ids"`
+	Password  string        `json:"password,omitempty"`
+}
+
+type bootstrapSecurityParams struct {
+	Password   string `json:"password,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+	// Reset must be set to replace existing cross-signing and SSSS keys.
+	Reset bool `json:"reset,omitempty"`
+}
+
+type bootstrapSecurityResponse struct {
+	RecoveryKey string `json:"recovery_key"`
+}
+
+type exportRoomKeysParams struct {
+	Passphrase string    `json:"passphrase"`
+	RoomID     id.RoomID `json:"room_id,omitempty"`
+}
+
+type importRoomKeysParams struct {
+	Passphrase string `json:"passphrase"`
+	Data       string `json:"data"`
+}
+
+type startVerificationParams struct {
+	UserID id.UserID `json:"user_id,omitempty"`
+}
+
+type verifyUserParams struct {
+	UserID    id.UserID `json:"user_id"`
+	MasterKey string    `json:"master_key"`
+}
+
+type verificationParams struct {
+	TransactionID id.VerificationTransactionID `json:"transaction_id"`
+	Reason        string                       `json:"reason,omitempty"`
+}
+
 type discoverHomeserverParams struct {
 	UserID id.UserID `json:"user_id"`
 }
@@ -330,6 +574,28 @@ type paginateParams struct {
 	Limit         int                    `json:"limit"`
 }
 
+type getSpaceHierarchyParams struct {
+	SpaceID       id.RoomID `json:"space_id"`
+	From          string    `json:"from,omitempty"`
+	Limit         int       `json:"limit,omitempty"`
+	MaxDepth      *int      `json:"max_depth,omitempty"`
+	SuggestedOnly bool      `json:"suggested_only,omitempty"`
+}
+
+type getThreadsParams struct {
+	RoomID id.RoomID `json:"room_id"`
+	Before int64     `json:"before"`
+	Limit  int       `json:"limit"`
+}
+
+type paginateThreadParams struct {
+	RoomID     id.RoomID           `json:"room_id"`
+	ThreadRoot id.EventID          `json:"thread_root"`
+	MaxRowID   database.EventRowID `json:"max_rowid"`
+	From       string              `json:"from"`
+	Limit      int                 `json:"limit"`
+}
+
 type joinRoomParams struct {
 	RoomIDOrAlias string   `json:"room_id_or_alias"`
 	Via           []string `json:"via"`
@@ -341,7 +607,54 @@ type leaveRoomParams struct {
 	Reason string    `json:"reason"`
 }
 
+type updateMembershipParams struct {
+	RoomID  id.RoomID        `json:"room_id"`
+	Action  MembershipAction `json:"action"`
+	UserIDs []id.UserID      `json:"user_ids"`
+	Reason  string           `json:"reason"`
+}
+
+type setRoomNameParams struct {
+	RoomID id.RoomID `json:"room_id"`
+	Name   string    `json:"name"`
+}
+
+type setRoomTopicParams struct {
+	RoomID id.RoomID `json:"room_id"`
+	Topic  string    `json:"topic"`
+}
+
+type setRoomAvatarParams struct {
+	RoomID id.RoomID           `json:"room_id"`
+	URL    id.ContentURIString `json:"url"`
+}
+
+type setRoomJoinRulesParams struct {
+	RoomID   id.RoomID             `json:"room_id"`
+	JoinRule event.JoinRule        `json:"join_rule"`
+	Allow    []event.JoinRuleAllow `json:"allow,omitempty"`
+}
+
+type setRoomHistoryVisibilityParams struct {
+	RoomID            id.RoomID               `json:"room_id"`
+	HistoryVisibility event.HistoryVisibility `json:"history_visibility"`
+}
+
+type setRoomPowerLevelsParams struct {
+	RoomID      id.RoomID                      `json:"room_id"`
+	PowerLevels *event.PowerLevelsEventContent `json:"power_levels"`
+}
+
 type getReceiptsParams struct {
 	RoomID   id.RoomID    `json:"room_id"`
 	EventIDs []id.EventID `json:"event_ids"`
 }
+
+type getPresenceParams struct {
+	UserID id.UserID `json:"user_id"`
+}
+
+type setPresenceParams struct {
+	Presence  event.Presence `json:"presence"`
+	StatusMsg string         `json:"status_msg"`
+}
@@ -19,6 +19,7 @@ import (
 type JSONCommandCustom[T any] struct {
 	Command   string `json:"command"`
 	RequestID int64  `json:"request_id"`
+	AccountID string `json:"account_id,omitempty"`
 	Data      T      `json:"data"`
 }
 
@@ -38,10 +39,26 @@ func EventTypeName(evt any) string {
 		return "events_decrypted"
 	case *Typing:
 		return "typing"
+	case *Presence:
+		return "presence"
 	case *SendComplete:
 		return "send_complete"
+	case *OutboxStatus:
+		return "outbox_status"
+	case *DraftUpdated:
+		return "draft_updated"
+	case *KeyBackupRestoreProgress:
+		return "key_backup_restore_progress"
 	case *ClientState:
 		return "client_state"
+	case *VerificationRequested:
+		return "verification_requested"
+	case *VerificationSAS:
+		return "verification_sas"
+	case *VerificationCancelled:
+		return "verification_cancelled"
+	case *VerificationDone:
+		return "verification_done"
 	default:
 		panic(fmt.Errorf("unknown event type %T", evt))
 	}
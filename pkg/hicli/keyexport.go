@@ -0,0 +1,68 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/id"
+)
+
+const KeyExportFileName = "element-keys.txt"
+
+type ImportRoomKeysResponse struct {
+	Imported int `json:"imported"`
+	Total    int `json:"total"`
+}
+
+// ExportRoomKeys exports megolm sessions from the crypto store using the passphrase-encrypted
+// key export format from the Matrix spec. If roomID is empty, sessions from all rooms are exported.
+func (h *HiClient) ExportRoomKeys(ctx context.Context, passphrase string, roomID id.RoomID) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase is required")
+	}
+	var sessions []*crypto.InboundGroupSession
+	var err error
+	if roomID == "" {
+		sessions, err = h.CryptoStore.GetAllGroupSessions(ctx).AsList()
+	} else {
+		sessions, err = h.CryptoStore.GetGroupSessionsForRoom(ctx, roomID).AsList()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get megolm sessions: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().
+		Stringer("room_id", roomID).
+		Int("session_count", len(sessions)).
+		Msg("Exporting room keys")
+	data, err := crypto.ExportKeys(passphrase, sessions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export megolm sessions: %w", err)
+	}
+	return data, nil
+}
+
+// ImportRoomKeys imports megolm sessions from a passphrase-encrypted key export file.
+// Events that failed to decrypt are retried through the normal session received callback.
+func (h *HiClient) ImportRoomKeys(ctx context.Context, passphrase string, data []byte) (*ImportRoomKeysResponse, error) {
+	imported, total, err := h.Crypto.ImportKeys(ctx, passphrase, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import room keys: %w", err)
+	}
+	zerolog.Ctx(ctx).Info().
+		Int("imported", imported).
+		Int("total", total).
+		Msg("Imported room keys")
+	if imported > 0 {
+		// Wake up the request queue to upload the new sessions to key backup
+		h.WakeupRequestQueue()
+	}
+	return &ImportRoomKeysResponse{Imported: imported, Total: total}, nil
+}
@@ -72,6 +72,7 @@ func (h *HiClient) Login(ctx context.Context, req *mautrix.ReqLogin) error {
 	if err != nil {
 		return fmt.Errorf("failed to fetch own devices: %w", err)
 	}
+	go h.Sync()
 	return nil
 }
 
@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type MembershipAction string
+
+const (
+	MembershipActionInvite MembershipAction = "invite"
+	MembershipActionKick   MembershipAction = "kick"
+	MembershipActionBan    MembershipAction = "ban"
+	MembershipActionUnban  MembershipAction = "unban"
+)
+
+type MembershipResult struct {
+	UserID id.UserID `json:"user_id"`
+	Error  string    `json:"error,omitempty"`
+}
+
+func (action MembershipAction) requiredLevel(pl *event.PowerLevelsEventContent) int {
+	switch action {
+	case MembershipActionInvite:
+		return pl.Invite()
+	case MembershipActionKick:
+		return pl.Kick()
+	default:
+		return pl.Ban()
+	}
+}
+
+// checkMembershipPowerLevel ensures the current user is allowed to perform the given action on the target user
+// according to the power levels in the local database. If the room has no power levels stored, the check is
+// left to the server.
+func (h *HiClient) checkMembershipPowerLevel(pl *event.PowerLevelsEventContent, action MembershipAction, target id.UserID) error {
+	if pl == nil {
+		return nil
+	}
+	ownLevel := pl.GetUserLevel(h.Account.UserID)
+	if requiredLevel := action.requiredLevel(pl); ownLevel < requiredLevel {
+		return fmt.Errorf("%w to %s users (have %d, need %d)", ErrInsufficientPowerLevel, action, ownLevel, requiredLevel)
+	}
+	if target != "" && action != MembershipActionInvite {
+		if targetLevel := pl.GetUserLevel(target); targetLevel >= ownLevel {
+			return fmt.Errorf("%w to %s %s (have %d, they have %d)", ErrInsufficientPowerLevel, action, target, ownLevel, targetLevel)
+		}
+	}
+	return nil
+}
+
+// UpdateMembership invites, kicks, bans or unbans the given users. The power levels are checked for each
+// user before sending any requests. Errors for individual users are returned in the results rather than
+// aborting the entire operation, but lacking the power level for the action itself is returned as an error.
+func (h *HiClient) UpdateMembership(
+	ctx context.Context,
+	roomID id.RoomID,
+	action MembershipAction,
+	userIDs []id.UserID,
+	reason string,
+) ([]*MembershipResult, error) {
+	switch action {
+	case MembershipActionInvite, MembershipActionKick, MembershipActionBan, MembershipActionUnban:
+	default:
+		return nil, fmt.Errorf("unsupported membership action %q", action)
+	}
+	if len(userIDs) == 0 {
+		return nil, fmt.Errorf("no users specified")
+	}
+	pl, err := h.ClientStore.GetPowerLevels(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get power levels: %w", err)
+	} else if err = h.checkMembershipPowerLevel(pl, action, ""); err != nil {
+		return nil, err
+	}
+	log := zerolog.Ctx(ctx).With().
+		Stringer("room_id", roomID).
+		Str("action", string(action)).
+		Logger()
+	results := make([]*MembershipResult, len(userIDs))
+	for i, userID := range userIDs {
+		results[i] = &MembershipResult{UserID: userID}
+		if _, _, err = userID.Parse(); err != nil {
+			results[i].Error = fmt.Sprintf("invalid user ID: %v", err)
+			continue
+		} else if err = h.checkMembershipPowerLevel(pl, action, userID); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		switch action {
+		case MembershipActionInvite:
+			_, err = h.Client.InviteUser(ctx, roomID, &mautrix.ReqInviteUser{UserID: userID, Reason: reason})
+		case MembershipActionKick:
+			_, err = h.Client.KickUser(ctx, roomID, &mautrix.ReqKickUser{UserID: userID, Reason: reason})
+		case MembershipActionBan:
+			_, err = h.Client.BanUser(ctx, roomID, &mautrix.ReqBanUser{UserID: userID, Reason: reason})
+		case MembershipActionUnban:
+			_, err = h.Client.UnbanUser(ctx, roomID, &mautrix.ReqUnbanUser{UserID: userID, Reason: reason})
+		}
+		if err != nil {
+			log.Warn().Err(err).Stringer("user_id", userID).Msg("Failed to update membership")
+			results[i].Error = err.Error()
+		}
+	}
+	return results, nil
+}
+
+func (h *HiClient) AcceptInvite(ctx context.Context, roomID id.RoomID) (*mautrix.RespJoinRoom, error) {
+	return h.Client.JoinRoomByID(ctx, roomID)
+}
+
+// RejectInvite leaves a room the user has been invited to. The invite is removed from the
+// database when the leave comes down sync.
+func (h *HiClient) RejectInvite(ctx context.Context, roomID id.RoomID, reason string) error {
+	_, err := h.Client.LeaveRoom(ctx, roomID, &mautrix.ReqLeave{Reason: reason})
+	return err
+}
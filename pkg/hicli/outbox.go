@@ -0,0 +1,194 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+const (
+	outboxMinBackoff = 2 * time.Second
+	outboxMaxBackoff = 5 * time.Minute
+)
+
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxMinBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, outboxMaxBackoff)
+}
+
+// isRetriableSendError returns true if the error is likely temporary, i.e. a network error,
+// a rate limit or a server error. All other errors fail the send permanently.
+func isRetriableSendError(err error) bool {
+	var httpErr mautrix.HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	} else if httpErr.Response == nil {
+		// Requests that were never sent (e.g. failed to marshal) don't have a request either
+		return httpErr.Request != nil
+	}
+	return httpErr.Response.StatusCode == http.StatusTooManyRequests || httpErr.Response.StatusCode >= 500
+}
+
+func (h *HiClient) WakeupOutbox() {
+	select {
+	case h.outboxWakeup <- struct{}{}:
+	default:
+	}
+}
+
+// GetOutbox returns the events waiting to be sent in the given room.
+func (h *HiClient) GetOutbox(ctx context.Context, roomID id.RoomID) ([]*database.OutboxEntry, error) {
+	return h.DB.Outbox.GetByRoom(ctx, roomID)
+}
+
+func (h *HiClient) retryOutbox(ctx context.Context) {
+	err := h.DB.Outbox.ResetBackoff(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to reset outbox backoff")
+	}
+	h.WakeupOutbox()
+}
+
+// RunOutbox sends events in the outbox. Events in the same room are sent one at a time in order,
+// while different rooms are sent in parallel. Temporary failures are retried with exponential backoff,
+// other failures are marked as send errors and removed from the outbox.
+func (h *HiClient) RunOutbox(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("action", "outbox").Logger()
+	ctx = log.WithContext(ctx)
+	log.Info().Msg("Starting outbox")
+	defer func() {
+		log.Info().Msg("Stopping outbox")
+	}()
+	for {
+		var timer <-chan time.Time
+		heads, err := h.DB.Outbox.GetHeads(ctx)
+		if err != nil {
+			log.Err(err).Msg("Failed to get outbox entries")
+			timer = time.After(1 * time.Minute)
+		} else {
+			var nextAttempt time.Time
+			for _, entry := range heads {
+				if time.Until(entry.NextAttempt.Time) > 0 {
+					if nextAttempt.IsZero() || entry.NextAttempt.Before(nextAttempt) {
+						nextAttempt = entry.NextAttempt.Time
+					}
+				} else if h.markOutboxRoomInFlight(entry.RoomID) {
+					go func() {
+						shouldWakeup := h.sendOutboxEntry(ctx, entry)
+						h.outboxLock.Lock()
+						delete(h.outboxInFlight, entry.RoomID)
+						h.outboxLock.Unlock()
+						if shouldWakeup {
+							h.WakeupOutbox()
+						}
+					}()
+				}
+			}
+			if !nextAttempt.IsZero() {
+				timer = time.After(time.Until(nextAttempt))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.outboxWakeup:
+		case <-timer:
+		}
+	}
+}
+
+func (h *HiClient) markOutboxRoomInFlight(roomID id.RoomID) bool {
+	h.outboxLock.Lock()
+	defer h.outboxLock.Unlock()
+	if _, inFlight := h.outboxInFlight[roomID]; inFlight {
+		return false
+	}
+	h.outboxInFlight[roomID] = struct{}{}
+	return true
+}
+
+// sendOutboxEntry tries to send the given outbox entry and returns true if the outbox should be checked again.
+func (h *HiClient) sendOutboxEntry(ctx context.Context, entry *database.OutboxEntry) bool {
+	log := zerolog.Ctx(ctx).With().
+		Stringer("room_id", entry.RoomID).
+		Int64("event_rowid", int64(entry.EventRowID)).
+		Logger()
+	dbEvt, err := h.DB.Event.GetByRowID(ctx, entry.EventRowID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get outbox event from database")
+		return false
+	}
+	room, err := h.DB.Room.Get(ctx, entry.RoomID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get room metadata for outbox event")
+		return false
+	}
+	if room == nil || dbEvt == nil || !strings.HasPrefix(dbEvt.ID.String(), "~") {
+		log.Debug().Msg("Dropping outbox entry for already sent or deleted event")
+		return h.removeOutboxEntry(ctx, entry)
+	}
+	evtType := event.Type{Type: dbEvt.Type, Class: event.MessageEventType}
+	if dbEvt.Decrypted != nil && len(dbEvt.Content) <= 2 {
+		evtType.Type = dbEvt.DecryptedType
+	}
+	dbEvt.SendError = ""
+	sent, err := h.actuallySend(ctx, room, dbEvt, evtType)
+	if sent || err == nil || !isRetriableSendError(err) {
+		// Events that reached the server must never be sent again, even if updating the database failed.
+		return h.removeOutboxEntry(ctx, entry)
+	}
+	entry.Attempts++
+	entry.NextAttempt = jsontime.UM(time.Now().Add(outboxBackoff(entry.Attempts)))
+	entry.LastError = err.Error()
+	log.Debug().Err(err).
+		Int("attempts", entry.Attempts).
+		Time("next_attempt", entry.NextAttempt.Time).
+		Msg("Failed to send outbox event, will retry")
+	err = h.DB.Outbox.Update(ctx, entry)
+	if err != nil {
+		log.Err(err).Msg("Failed to update outbox entry")
+		return false
+	}
+	h.dispatchOutboxStatus(ctx, entry.RoomID)
+	return true
+}
+
+func (h *HiClient) removeOutboxEntry(ctx context.Context, entry *database.OutboxEntry) bool {
+	err := h.DB.Outbox.Remove(ctx, entry.EventRowID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).
+			Int64("event_rowid", int64(entry.EventRowID)).
+			Msg("Failed to remove outbox entry")
+		return false
+	}
+	h.dispatchOutboxStatus(ctx, entry.RoomID)
+	return true
+}
+
+func (h *HiClient) dispatchOutboxStatus(ctx context.Context, roomID id.RoomID) {
+	entries, err := h.DB.Outbox.GetByRoom(ctx, roomID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("room_id", roomID).Msg("Failed to get outbox entries")
+		return
+	}
+	h.EventHandler(&OutboxStatus{RoomID: roomID, Entries: entries})
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestIsRetriableSendError(t *testing.T) {
+	req := &http.Request{}
+	withStatus := func(status int) error {
+		return mautrix.HTTPError{Request: req, Response: &http.Response{StatusCode: status}}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"plain error", errors.New("failed to encrypt"), false},
+		{"not sent", mautrix.HTTPError{WrappedError: errors.New("failed to marshal")}, false},
+		{"network error", mautrix.HTTPError{Request: req, WrappedError: errors.New("connection reset")}, true},
+		{"rate limited", withStatus(http.StatusTooManyRequests), true},
+		{"internal server error", withStatus(http.StatusInternalServerError), true},
+		{"bad gateway", withStatus(http.StatusBadGateway), true},
+		{"wrapped server error", fmt.Errorf("failed to send event: %w", withStatus(http.StatusServiceUnavailable)), true},
+		{"bad request", withStatus(http.StatusBadRequest), false},
+		{"forbidden", withStatus(http.StatusForbidden), false},
+		{"not found", withStatus(http.StatusNotFound), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isRetriableSendError(test.err); got != test.want {
+				t.Errorf("isRetriableSendError() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
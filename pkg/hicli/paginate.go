@@ -108,6 +108,7 @@ func (h *HiClient) processGetRoomState(ctx context.Context, roomID id.RoomID, fe
 			}
 		}
 	}
+	spaceEdgesChanged := make(map[id.RoomID]struct{})
 	return h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
 		room, err := h.DB.Room.Get(ctx, roomID)
 		if err != nil {
@@ -129,6 +130,24 @@ func (h *HiClient) processGetRoomState(ctx context.Context, roomID id.RoomID, fe
 			if evts[i].Type != event.StateMember {
 				processImportantEvent(ctx, evts[i], room, updatedRoom)
 			}
+			if evts[i].Type == event.StateSpaceChild || evts[i].Type == event.StateSpaceParent {
+				err = h.processSpaceEdge(ctx, roomID, evts[i], dbEvts[i].RowID)
+				if err != nil {
+					return fmt.Errorf("failed to save space edge from %s: %w", evts[i].ID, err)
+				}
+				spaceEdgesChanged[roomID] = struct{}{}
+				spaceEdgesChanged[id.RoomID(*evts[i].StateKey)] = struct{}{}
+			}
+		}
+		if refetch {
+			staleEdgeRooms, err := h.clearStaleSpaceEdges(ctx, roomID, evts)
+			if err != nil {
+				return err
+			}
+			for _, otherRoomID := range staleEdgeRooms {
+				spaceEdgesChanged[roomID] = struct{}{}
+				spaceEdgesChanged[otherRoomID] = struct{}{}
+			}
 		}
 		err = h.DB.Media.AddMany(ctx, mediaCacheEntries)
 		if err != nil {
@@ -151,25 +170,22 @@ func (h *HiClient) processGetRoomState(ctx context.Context, roomID id.RoomID, fe
 			if err != nil {
 				return fmt.Errorf("failed to save room data: %w", err)
 			}
-			if dispatchEvt {
-				h.EventHandler(&SyncComplete{
-					Rooms: map[id.RoomID]*SyncRoom{
-						roomID: {
-							Meta:          room,
-							Timeline:      make([]database.TimelineRowTuple, 0),
-							State:         make(map[event.Type]map[string]database.EventRowID),
-							AccountData:   make(map[event.Type]*database.AccountData),
-							Events:        make([]*database.Event, 0),
-							Reset:         false,
-							Notifications: make([]SyncNotification, 0),
-							Receipts:      make(map[id.EventID][]*database.Receipt),
-						},
-					},
-					InvitedRooms: make([]*database.InvitedRoom, 0),
-					AccountData:  make(map[event.Type]*database.AccountData),
-					LeftRooms:    make([]id.RoomID, 0),
-				})
+		}
+		if dispatchEvt && (roomChanged || len(spaceEdgesChanged) > 0) {
+			rooms := make(map[id.RoomID]*SyncRoom, len(spaceEdgesChanged)+1)
+			if roomChanged {
+				rooms[roomID] = emptySyncRoom(room)
 			}
+			err = h.fillChangedSpaceEdges(ctx, spaceEdgesChanged, rooms)
+			if err != nil {
+				return err
+			}
+			h.EventHandler(&SyncComplete{
+				Rooms:        rooms,
+				InvitedRooms: make([]*database.InvitedRoom, 0),
+				AccountData:  make(map[event.Type]*database.AccountData),
+				LeftRooms:    make([]id.RoomID, 0),
+			})
 		}
 		return nil
 	})
@@ -221,6 +237,14 @@ func (h *HiClient) Paginate(ctx context.Context, roomID id.RoomID, maxTimelineID
 			return nil, err
 		}
 	}
+	err = h.fillPaginationRelatedData(ctx, roomID, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (h *HiClient) fillPaginationRelatedData(ctx context.Context, roomID id.RoomID, resp *PaginationResponse) (err error) {
 	resp.RelatedEvents = make([]*database.Event, 0)
 	eventIDs := make([]id.EventID, len(resp.Events))
 	eventMap := make(map[id.EventID]struct{})
@@ -234,7 +258,7 @@ func (h *HiClient) Paginate(ctx context.Context, roomID id.RoomID, maxTimelineID
 			if !replyToAdded {
 				dbEvt, err := h.DB.Event.GetByID(ctx, replyTo)
 				if err != nil {
-					return nil, fmt.Errorf("failed to get reply-to event: %w", err)
+					return fmt.Errorf("failed to get reply-to event: %w", err)
 				} else if dbEvt != nil {
 					resp.RelatedEvents = append(resp.RelatedEvents, dbEvt)
 					eventMap[replyTo] = struct{}{}
@@ -244,9 +268,9 @@ func (h *HiClient) Paginate(ctx context.Context, roomID id.RoomID, maxTimelineID
 	}
 	resp.Receipts, err = h.GetReceipts(ctx, roomID, eventIDs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get receipts: %w", err)
+		return fmt.Errorf("failed to get receipts: %w", err)
 	}
-	return resp, nil
+	return nil
 }
 
 func (h *HiClient) GetReceipts(ctx context.Context, roomID id.RoomID, eventIDs []id.EventID) (map[id.EventID][]*database.Receipt, error) {
@@ -283,6 +307,7 @@ func (h *HiClient) GetReceipts(ctx context.Context, roomID id.RoomID, eventIDs [
 
 func (h *HiClient) PaginateServer(ctx context.Context, roomID id.RoomID, limit int) (*PaginationResponse, error) {
 	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 	h.paginationInterrupterLock.Lock()
 	if _, alreadyPaginating := h.paginationInterrupter[roomID]; alreadyPaginating {
 		h.paginationInterrupterLock.Unlock()
@@ -0,0 +1,83 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+type reqSetPresence struct {
+	Presence  event.Presence `json:"presence"`
+	StatusMsg string         `json:"status_msg,omitempty"`
+}
+
+func (h *HiClient) processPresence(ctx context.Context, evt *event.Event) error {
+	var content event.PresenceEventContent
+	err := json.Unmarshal(evt.Content.VeryRaw, &content)
+	if err != nil {
+		return fmt.Errorf("failed to parse content: %w", err)
+	}
+	presence := database.PresenceFromContent(evt.Sender, &content)
+	err = h.DB.Presence.Put(ctx, presence)
+	if err != nil {
+		return fmt.Errorf("failed to save presence: %w", err)
+	}
+	syncCtx := ctx.Value(syncContextKey).(*syncContext)
+	syncCtx.presence = append(syncCtx.presence, presence)
+	return nil
+}
+
+// GetPresence returns the presence of the given user. If presence is enabled, presence received via sync
+// is returned from the database. Other users are always fetched from the server.
+func (h *HiClient) GetPresence(ctx context.Context, userID id.UserID) (*database.Presence, error) {
+	if h.EnablePresence {
+		presence, err := h.DB.Presence.Get(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get presence from database: %w", err)
+		} else if presence != nil {
+			return presence, nil
+		}
+	}
+	resp, err := h.Client.GetPresence(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get presence from server: %w", err)
+	}
+	return database.PresenceFromContent(userID, &event.PresenceEventContent{
+		Presence:        resp.Presence,
+		LastActiveAgo:   int64(resp.LastActiveAgo),
+		CurrentlyActive: resp.CurrentlyActive,
+		StatusMessage:   resp.StatusMsg,
+	}), nil
+}
+
+// SetPresence sets the presence and status message of the current user.
+// The presence is also sent in all future sync requests, as the server would otherwise reset it to online.
+func (h *HiClient) SetPresence(ctx context.Context, presence event.Presence, statusMsg string) error {
+	urlPath := h.Client.BuildClientURL("v3", "presence", h.Account.UserID, "status")
+	_, err := h.Client.MakeRequest(ctx, http.MethodPut, urlPath, &reqSetPresence{
+		Presence:  presence,
+		StatusMsg: statusMsg,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to set presence: %w", err)
+	}
+	err = h.DB.Account.PutSyncPresence(ctx, h.Account.UserID, presence)
+	if err != nil {
+		return fmt.Errorf("failed to save sync presence: %w", err)
+	}
+	h.Account.SyncPresence = presence
+	h.Client.SyncPresence = presence
+	return nil
+}
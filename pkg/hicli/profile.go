@@ -94,5 +94,9 @@ func (h *HiClient) GetProfileEncryptionInfo(ctx context.Context, userID id.UserI
 
 func (h *HiClient) TrackUserDevices(ctx context.Context, userID id.UserID) error {
 	_, err := h.Crypto.FetchKeys(ctx, []id.UserID{userID}, true)
-	return err
+	if err != nil {
+		return err
+	}
+	go h.RefreshTrustStates(context.WithoutCancel(ctx), userID)
+	return nil
 }
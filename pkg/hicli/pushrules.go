@@ -9,8 +9,11 @@ package hicli
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 
 	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
@@ -25,11 +28,26 @@ type pushRoom struct {
 	h      *HiClient
 	ll     *mautrix.LazyLoadSummary
 	pl     *event.PowerLevelsEventContent
+
+	ownDisplayname    *string
+	joinedMemberCount *int
 }
 
 func (p *pushRoom) GetOwnDisplayname() string {
-	// TODO implement
-	return ""
+	if p.ownDisplayname != nil {
+		return *p.ownDisplayname
+	}
+	var displayname string
+	memberEvt, err := p.h.DB.CurrentState.Get(p.ctx, p.roomID, event.StateMember, p.h.Account.UserID.String())
+	if err != nil {
+		zerolog.Ctx(p.ctx).Err(err).
+			Stringer("room_id", p.roomID).
+			Msg("Failed to get own member event in push rule evaluator")
+	} else if memberEvt != nil {
+		displayname = gjson.GetBytes(memberEvt.Content, "displayname").Str
+	}
+	p.ownDisplayname = &displayname
+	return displayname
 }
 
 func (p *pushRoom) GetMemberCount() int {
@@ -46,8 +64,16 @@ func (p *pushRoom) GetMemberCount() int {
 	if p.ll != nil && p.ll.JoinedMemberCount != nil {
 		return *p.ll.JoinedMemberCount
 	}
-	// TODO query db?
-	return 0
+	if p.joinedMemberCount == nil {
+		count, err := p.h.DB.CurrentState.GetJoinedMemberCount(p.ctx, p.roomID)
+		if err != nil {
+			zerolog.Ctx(p.ctx).Err(err).
+				Stringer("room_id", p.roomID).
+				Msg("Failed to count joined members in push rule evaluator")
+		}
+		p.joinedMemberCount = &count
+	}
+	return *p.joinedMemberCount
 }
 
 func (p *pushRoom) GetEvent(id id.EventID) *event.Event {
@@ -126,5 +152,155 @@ func (h *HiClient) LoadPushRules(ctx context.Context) {
 
 func (h *HiClient) receiveNewPushRules(ctx context.Context, rules *pushrules.PushRuleset) {
 	h.PushRules.Store(rules)
-	// TODO set mute flag in rooms
+	err := h.updateRoomNotificationModes(ctx, getRoomNotificationModes(rules))
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to update room notification modes")
+	}
+}
+
+func isRoomMuteRule(rule *pushrules.PushRule) bool {
+	return rule.Enabled &&
+		!rule.Actions.Should().Notify &&
+		len(rule.Conditions) == 1 &&
+		rule.Conditions[0].Kind == pushrules.KindEventMatch &&
+		rule.Conditions[0].Key == "room_id" &&
+		rule.Conditions[0].Pattern == rule.RuleID
+}
+
+// getRoomNotificationModes finds rooms with non-default notification modes in the given push rules.
+// Rooms are muted using override rules that match the room ID, and set to mentions only using
+// room rules that don't notify, which is the same convention that other clients use.
+func getRoomNotificationModes(rules *pushrules.PushRuleset) map[id.RoomID]database.RoomNotificationMode {
+	modes := make(map[id.RoomID]database.RoomNotificationMode)
+	if rules == nil {
+		return modes
+	}
+	for _, rule := range rules.Room.Map {
+		if rule.Enabled && !rule.Actions.Should().Notify {
+			modes[id.RoomID(rule.RuleID)] = database.RoomNotificationModeMentionsOnly
+		}
+	}
+	for _, rule := range rules.Override {
+		if isRoomMuteRule(rule) {
+			modes[id.RoomID(rule.RuleID)] = database.RoomNotificationModeMute
+		}
+	}
+	return modes
+}
+
+// getRoomNotificationMode derives the notification mode of a single room from the current push rules.
+func (h *HiClient) getRoomNotificationMode(roomID id.RoomID) database.RoomNotificationMode {
+	mode, ok := getRoomNotificationModes(h.PushRules.Load())[roomID]
+	if !ok {
+		return database.RoomNotificationModeAll
+	}
+	return mode
+}
+
+func (h *HiClient) updateRoomNotificationModes(ctx context.Context, newModes map[id.RoomID]database.RoomNotificationMode) error {
+	oldModes, err := h.DB.Room.GetNotificationModes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get current notification modes: %w", err)
+	}
+	for roomID := range oldModes {
+		if _, stillSet := newModes[roomID]; !stillSet {
+			newModes[roomID] = database.RoomNotificationModeAll
+		}
+	}
+	var changedRooms []*database.Room
+	for roomID, mode := range newModes {
+		if oldModes[roomID] == mode || (mode == database.RoomNotificationModeAll && oldModes[roomID] == "") {
+			continue
+		}
+		err = h.DB.Room.SetNotificationMode(ctx, roomID, mode)
+		if err != nil {
+			return fmt.Errorf("failed to set notification mode of %s: %w", roomID, err)
+		}
+		room, err := h.DB.Room.Get(ctx, roomID)
+		if err != nil {
+			return fmt.Errorf("failed to get room %s after updating notification mode: %w", roomID, err)
+		} else if room != nil {
+			changedRooms = append(changedRooms, room)
+		}
+	}
+	if len(changedRooms) == 0 {
+		return nil
+	}
+	syncCtx, isSync := ctx.Value(syncContextKey).(*syncContext)
+	evt := &SyncComplete{
+		Rooms:        make(map[id.RoomID]*SyncRoom, len(changedRooms)),
+		InvitedRooms: make([]*database.InvitedRoom, 0),
+		AccountData:  make(map[event.Type]*database.AccountData),
+		LeftRooms:    make([]id.RoomID, 0),
+	}
+	if isSync {
+		evt = syncCtx.evt
+	}
+	for _, room := range changedRooms {
+		evt.Rooms[room.ID] = &SyncRoom{
+			Meta:          room,
+			Timeline:      make([]database.TimelineRowTuple, 0),
+			State:         make(map[event.Type]map[string]database.EventRowID),
+			AccountData:   make(map[event.Type]*database.AccountData),
+			Events:        make([]*database.Event, 0),
+			Notifications: make([]SyncNotification, 0),
+			Receipts:      make(map[id.EventID][]*database.Receipt),
+		}
+	}
+	if !isSync {
+		h.EventHandler(evt)
+	}
+	return nil
+}
+
+func (h *HiClient) deletePushRuleIfExists(ctx context.Context, kind pushrules.PushRuleType, ruleID string) error {
+	err := h.Client.DeletePushRule(ctx, "global", kind, ruleID)
+	if errors.Is(err, mautrix.MNotFound) {
+		return nil
+	}
+	return err
+}
+
+// SetRoomNotificationMode writes the push rules matching the given notification mode to the server.
+// The local notification mode is updated when the new push rules come down sync.
+func (h *HiClient) SetRoomNotificationMode(ctx context.Context, roomID id.RoomID, mode database.RoomNotificationMode) error {
+	var err error
+	switch mode {
+	case database.RoomNotificationModeAll:
+		err = h.deletePushRuleIfExists(ctx, pushrules.OverrideRule, roomID.String())
+		if err != nil {
+			return fmt.Errorf("failed to delete override push rule: %w", err)
+		}
+		err = h.deletePushRuleIfExists(ctx, pushrules.RoomRule, roomID.String())
+		if err != nil {
+			return fmt.Errorf("failed to delete room push rule: %w", err)
+		}
+	case database.RoomNotificationModeMentionsOnly:
+		err = h.deletePushRuleIfExists(ctx, pushrules.OverrideRule, roomID.String())
+		if err != nil {
+			return fmt.Errorf("failed to delete override push rule: %w", err)
+		}
+		err = h.Client.PutPushRule(ctx, "global", pushrules.RoomRule, roomID.String(), &mautrix.ReqPutPushRule{
+			Actions:    []pushrules.PushActionType{},
+			Conditions: []pushrules.PushCondition{},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create room push rule: %w", err)
+		}
+	case database.RoomNotificationModeMute:
+		err = h.Client.PutPushRule(ctx, "global", pushrules.OverrideRule, roomID.String(), &mautrix.ReqPutPushRule{
+			Actions: []pushrules.PushActionType{},
+			Conditions: []pushrules.PushCondition{{
+				Kind:    pushrules.KindEventMatch,
+				Key:     "room_id",
+				Pattern: roomID.String(),
+			}},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create override push rule: %w", err)
+		}
+	default:
+		return fmt.Errorf("invalid notification mode %q", mode)
+	}
+	return nil
 }
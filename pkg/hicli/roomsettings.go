@@ -0,0 +1,152 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var ErrInsufficientPowerLevel = errors.New("insufficient power level")
+
+// checkStatePowerLevel ensures the current user is allowed to send the given state event type according to
+// the power levels in the local database. If the room has no power levels stored, the check is left to the server.
+func (h *HiClient) checkStatePowerLevel(ctx context.Context, roomID id.RoomID, evtType event.Type) (*event.PowerLevelsEventContent, error) {
+	pl, err := h.ClientStore.GetPowerLevels(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get power levels: %w", err)
+	} else if pl == nil {
+		return nil, nil
+	}
+	ownLevel := pl.GetUserLevel(h.Account.UserID)
+	if requiredLevel := pl.GetEventLevel(evtType); ownLevel < requiredLevel {
+		return nil, fmt.Errorf("%w to send %s (have %d, need %d)", ErrInsufficientPowerLevel, evtType.Type, ownLevel, requiredLevel)
+	}
+	return pl, nil
+}
+
+func (h *HiClient) setRoomState(ctx context.Context, roomID id.RoomID, evtType event.Type, content any) (id.EventID, error) {
+	_, err := h.checkStatePowerLevel(ctx, roomID, evtType)
+	if err != nil {
+		return "", err
+	}
+	return h.SetState(ctx, roomID, evtType, "", content)
+}
+
+func (h *HiClient) SetRoomName(ctx context.Context, roomID id.RoomID, name string) (id.EventID, error) {
+	return h.setRoomState(ctx, roomID, event.StateRoomName, &event.RoomNameEventContent{Name: name})
+}
+
+func (h *HiClient) SetRoomTopic(ctx context.Context, roomID id.RoomID, topic string) (id.EventID, error) {
+	return h.setRoomState(ctx, roomID, event.StateTopic, &event.TopicEventContent{Topic: topic})
+}
+
+// SetRoomAvatar changes the avatar of a room. An empty URL removes the avatar.
+func (h *HiClient) SetRoomAvatar(ctx context.Context, roomID id.RoomID, url id.ContentURIString) (id.EventID, error) {
+	if url != "" {
+		if _, err := url.Parse(); err != nil {
+			return "", fmt.Errorf("invalid avatar URL: %w", err)
+		}
+	}
+	return h.setRoomState(ctx, roomID, event.StateRoomAvatar, &event.RoomAvatarEventContent{URL: url})
+}
+
+func (h *HiClient) SetRoomJoinRules(ctx context.Context, roomID id.RoomID, content *event.JoinRulesEventContent) (id.EventID, error) {
+	switch content.JoinRule {
+	case event.JoinRulePublic, event.JoinRuleInvite, event.JoinRuleKnock:
+	case event.JoinRuleRestricted, event.JoinRuleKnockRestricted:
+		if len(content.Allow) == 0 {
+			return "", fmt.Errorf("join rule %s requires at least one allowed room", content.JoinRule)
+		}
+	default:
+		return "", fmt.Errorf("unsupported join rule %q", content.JoinRule)
+	}
+	return h.setRoomState(ctx, roomID, event.StateJoinRules, content)
+}
+
+func (h *HiClient) SetRoomHistoryVisibility(ctx context.Context, roomID id.RoomID, visibility event.HistoryVisibility) (id.EventID, error) {
+	switch visibility {
+	case event.HistoryVisibilityInvited, event.HistoryVisibilityJoined, event.HistoryVisibilityShared, event.HistoryVisibilityWorldReadable:
+	default:
+		return "", fmt.Errorf("unsupported history visibility %q", visibility)
+	}
+	return h.setRoomState(ctx, roomID, event.StateHistoryVisibility, &event.HistoryVisibilityEventContent{
+		HistoryVisibility: visibility,
+	})
+}
+
+// SetRoomPowerLevels replaces the power levels of a room. In addition to the permission to send power level
+// events, the change is validated using the same rules as the server's auth rules: levels above the current
+// user's own level can't be granted, and existing levels above (or for other users, equal to) the current
+// user's level can't be changed.
+func (h *HiClient) SetRoomPowerLevels(ctx context.Context, roomID id.RoomID, content *event.PowerLevelsEventContent) (id.EventID, error) {
+	if content == nil {
+		return "", fmt.Errorf("power levels are required")
+	}
+	existing, err := h.checkStatePowerLevel(ctx, roomID, event.StatePowerLevels)
+	if err != nil {
+		return "", err
+	} else if existing != nil {
+		err = checkPowerLevelChange(h.Account.UserID, existing, content)
+		if err != nil {
+			return "", err
+		}
+	}
+	return h.SetState(ctx, roomID, event.StatePowerLevels, "", content)
+}
+
+func checkPowerLevelChange(ownUserID id.UserID, oldPL, newPL *event.PowerLevelsEventContent) error {
+	ownLevel := oldPL.GetUserLevel(ownUserID)
+	checkLevel := func(name string, oldLevel, newLevel int) error {
+		if oldLevel == newLevel {
+			return nil
+		} else if oldLevel > ownLevel || newLevel > ownLevel {
+			return fmt.Errorf("%w to change %s from %d to %d (have %d)", ErrInsufficientPowerLevel, name, oldLevel, newLevel, ownLevel)
+		}
+		return nil
+	}
+	errs := []error{
+		checkLevel("users_default", oldPL.UsersDefault, newPL.UsersDefault),
+		checkLevel("events_default", oldPL.EventsDefault, newPL.EventsDefault),
+		checkLevel("state_default", oldPL.StateDefault(), newPL.StateDefault()),
+		checkLevel("invite", oldPL.Invite(), newPL.Invite()),
+		checkLevel("kick", oldPL.Kick(), newPL.Kick()),
+		checkLevel("ban", oldPL.Ban(), newPL.Ban()),
+		checkLevel("redact", oldPL.Redact(), newPL.Redact()),
+		checkLevel("notifications.room", oldPL.Notifications.Room(), newPL.Notifications.Room()),
+	}
+	eventTypes := make(map[string]int, len(oldPL.Events)+len(newPL.Events))
+	maps.Copy(eventTypes, oldPL.Events)
+	maps.Copy(eventTypes, newPL.Events)
+	for evtType := range eventTypes {
+		oldLevel, oldOK := oldPL.Events[evtType]
+		newLevel, newOK := newPL.Events[evtType]
+		if oldOK != newOK || oldLevel != newLevel {
+			errs = append(errs, checkLevel(fmt.Sprintf("events[%s]", evtType), oldLevel, newLevel))
+		}
+	}
+	userIDs := make(map[id.UserID]int, len(oldPL.Users)+len(newPL.Users))
+	maps.Copy(userIDs, oldPL.Users)
+	maps.Copy(userIDs, newPL.Users)
+	for userID := range userIDs {
+		oldLevel := oldPL.GetUserLevel(userID)
+		newLevel := newPL.GetUserLevel(userID)
+		if oldLevel == newLevel {
+			continue
+		} else if userID != ownUserID && oldLevel >= ownLevel {
+			errs = append(errs, fmt.Errorf("%w to change the level of %s (have %d, they have %d)", ErrInsufficientPowerLevel, userID, ownLevel, oldLevel))
+		} else {
+			errs = append(errs, checkLevel(fmt.Sprintf("users[%s]", userID), oldLevel, newLevel))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"errors"
+	"testing"
+
+	"go.mau.fi/util/ptr"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestCheckPowerLevelChange(t *testing.T) {
+	const (
+		admin  id.UserID = "@admin:example.com"
+		admin2 id.UserID = "@admin2:example.com"
+		mod    id.UserID = "@mod:example.com"
+		mod2   id.UserID = "@mod2:example.com"
+		user   id.UserID = "@user:example.com"
+	)
+	basePL := &event.PowerLevelsEventContent{
+		Users: map[id.UserID]int{admin: 100, admin2: 100, mod: 50, mod2: 50},
+		Events: map[string]int{
+			event.StateRoomName.Type:    50,
+			event.StatePowerLevels.Type: 100,
+		},
+		KickPtr: ptr.Ptr(50),
+	}
+	tests := []struct {
+		name    string
+		ownUser id.UserID
+		modify  func(pl *event.PowerLevelsEventContent)
+		wantErr bool
+	}{
+		{"no changes", mod, func(pl *event.PowerLevelsEventContent) {}, false},
+		{"lower kick level", mod, func(pl *event.PowerLevelsEventContent) { pl.KickPtr = ptr.Ptr(0) }, false},
+		{"raise kick level to own level", mod, func(pl *event.PowerLevelsEventContent) { pl.KickPtr = ptr.Ptr(50) }, false},
+		{"raise kick level above own level", mod, func(pl *event.PowerLevelsEventContent) { pl.KickPtr = ptr.Ptr(51) }, true},
+		{"raise events_default to own level", mod, func(pl *event.PowerLevelsEventContent) { pl.EventsDefault = 50 }, false},
+		{"raise users_default above own level", mod, func(pl *event.PowerLevelsEventContent) { pl.UsersDefault = 75 }, true},
+		{"raise room notification level", mod, func(pl *event.PowerLevelsEventContent) {
+			pl.Notifications = &event.NotificationPowerLevels{RoomPtr: ptr.Ptr(100)}
+		}, true},
+		{"lower event level", mod, func(pl *event.PowerLevelsEventContent) { pl.Events[event.StateRoomName.Type] = 0 }, false},
+		{"lower event level above own level", mod, func(pl *event.PowerLevelsEventContent) {
+			pl.Events[event.StatePowerLevels.Type] = 50
+		}, true},
+		{"remove event level above own level", mod, func(pl *event.PowerLevelsEventContent) {
+			delete(pl.Events, event.StatePowerLevels.Type)
+		}, true},
+		{"add event level at own level", mod, func(pl *event.PowerLevelsEventContent) { pl.Events["m.room.topic"] = 50 }, false},
+		{"add event level above own level", mod, func(pl *event.PowerLevelsEventContent) { pl.Events["m.room.topic"] = 60 }, true},
+		{"promote user to own level", mod, func(pl *event.PowerLevelsEventContent) { pl.Users[user] = 50 }, false},
+		{"promote user above own level", mod, func(pl *event.PowerLevelsEventContent) { pl.Users[user] = 51 }, true},
+		{"demote user with same level", mod, func(pl *event.PowerLevelsEventContent) { pl.Users[mod2] = 0 }, true},
+		{"demote user with higher level", mod, func(pl *event.PowerLevelsEventContent) { delete(pl.Users, admin) }, true},
+		{"demote self", mod, func(pl *event.PowerLevelsEventContent) { pl.Users[mod] = 0 }, false},
+		{"promote self", mod, func(pl *event.PowerLevelsEventContent) { pl.Users[mod] = 100 }, true},
+		{"promote moderator to admin", admin, func(pl *event.PowerLevelsEventContent) { pl.Users[mod] = 100 }, false},
+		{"demote other admin", admin, func(pl *event.PowerLevelsEventContent) { pl.Users[admin2] = 50 }, true},
+		{"change power level event level as admin", admin, func(pl *event.PowerLevelsEventContent) {
+			pl.Events[event.StatePowerLevels.Type] = 50
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newPL := basePL.Clone()
+			test.modify(newPL)
+			err := checkPowerLevelChange(test.ownUser, basePL, newPL)
+			if test.wantErr && !errors.Is(err, ErrInsufficientPowerLevel) {
+				t.Errorf("checkPowerLevelChange() error = %v, want %v", err, ErrInsufficientPowerLevel)
+			} else if !test.wantErr && err != nil {
+				t.Errorf("checkPowerLevelChange() error = %v, want nil", err)
+			}
+		})
+	}
+}
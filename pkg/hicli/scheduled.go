@@ -0,0 +1,207 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+var FeatureDelayedEvents = mautrix.UnstableFeature{UnstableFlag: "org.matrix.msc4140"}
+
+type respDelayedEvent struct {
+	DelayID string `json:"delay_id"`
+}
+
+type reqUpdateDelayedEvent struct {
+	Action string `json:"action"`
+}
+
+// ScheduleMessage stores a message to be sent at the given time. If the homeserver supports delayed events
+// (MSC4140), the event is sent to the server immediately and the server will publish it at the given time.
+// Otherwise, the event is kept in the local database and sent by RunScheduledEventQueue.
+//
+// Messages in encrypted rooms always use the local queue, as delayed events would have to be encrypted
+// when scheduling, so members who join before the send time wouldn't be able to decrypt them.
+func (h *HiClient) ScheduleMessage(
+	ctx context.Context,
+	roomID id.RoomID,
+	sendAt time.Time,
+	base *event.MessageEventContent,
+	extra map[string]any,
+	text string,
+	relatesTo *event.RelatesTo,
+	mentions *event.Mentions,
+) (*database.ScheduledEvent, error) {
+	if time.Until(sendAt) <= 0 {
+		return nil, fmt.Errorf("scheduled time must be in the future")
+	}
+	room, err := h.DB.Room.Get(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room metadata: %w", err)
+	} else if room == nil {
+		return nil, fmt.Errorf("unknown room")
+	}
+	msg, err := h.prepareMessage(ctx, room.ID, false, base, extra, text, relatesTo, mentions)
+	if err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(msg.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event content: %w", err)
+	}
+	scheduled := &database.ScheduledEvent{
+		RoomID:            room.ID,
+		Type:              msg.Type.Type,
+		Content:           content,
+		DisableEncryption: msg.DisableEncryption,
+		SendAt:            jsontime.UM(sendAt),
+		CreatedAt:         jsontime.UnixMilliNow(),
+	}
+	willEncrypt := room.EncryptionEvent != nil && msg.Type != event.EventReaction && !msg.DisableEncryption
+	if !willEncrypt && h.Client.SpecVersions.Supports(FeatureDelayedEvents) {
+		scheduled.DelayID, err = h.sendDelayedEvent(ctx, room.ID, msg.Type, content, time.Until(sendAt))
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).
+				Stringer("room_id", room.ID).
+				Msg("Failed to send delayed event to server, falling back to local queue")
+		}
+	}
+	err = h.DB.ScheduledEvent.Insert(ctx, scheduled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save scheduled event: %w", err)
+	}
+	h.wakeupScheduledEventQueue()
+	return scheduled, nil
+}
+
+func (h *HiClient) sendDelayedEvent(
+	ctx context.Context,
+	roomID id.RoomID,
+	evtType event.Type,
+	content json.RawMessage,
+	delay time.Duration,
+) (string, error) {
+	urlPath := h.Client.BuildURLWithQuery(
+		mautrix.ClientURLPath{"v3", "rooms", roomID, "send", evtType.Type, "hicli-" + h.Client.TxnID()},
+		map[string]string{"org.matrix.msc4140.delay": strconv.FormatInt(delay.Milliseconds(), 10)},
+	)
+	var resp respDelayedEvent
+	_, err := h.Client.MakeRequest(ctx, http.MethodPut, urlPath, content, &resp)
+	if err != nil {
+		return "", err
+	} else if resp.DelayID == "" {
+		return "", fmt.Errorf("server didn't return a delay ID")
+	}
+	return resp.DelayID, nil
+}
+
+func (h *HiClient) GetScheduledMessages(ctx context.Context, roomID id.RoomID) ([]*database.ScheduledEvent, error) {
+	return h.DB.ScheduledEvent.GetAll(ctx, roomID)
+}
+
+// CancelScheduledMessage removes a scheduled message from the local queue
+// and cancels the delayed event on the server if it was sent there.
+func (h *HiClient) CancelScheduledMessage(ctx context.Context, rowID int64) error {
+	scheduled, err := h.DB.ScheduledEvent.Get(ctx, rowID)
+	if err != nil {
+		return fmt.Errorf("failed to get scheduled event: %w", err)
+	} else if scheduled == nil {
+		return fmt.Errorf("unknown scheduled event")
+	}
+	if scheduled.DelayID != "" {
+		urlPath := h.Client.BuildClientURL("unstable", "org.matrix.msc4140", "delayed_events", scheduled.DelayID)
+		_, err = h.Client.MakeRequest(ctx, http.MethodPost, urlPath, &reqUpdateDelayedEvent{Action: "cancel"}, nil)
+		if err != nil && !errors.Is(err, mautrix.MNotFound) {
+			return fmt.Errorf("failed to cancel delayed event on server: %w", err)
+		}
+	}
+	err = h.DB.ScheduledEvent.Delete(ctx, rowID)
+	if err != nil {
+		return fmt.Errorf("failed to delete scheduled event: %w", err)
+	}
+	h.wakeupScheduledEventQueue()
+	return nil
+}
+
+func (h *HiClient) wakeupScheduledEventQueue() {
+	select {
+	case h.scheduledEventWakeup <- struct{}{}:
+	default:
+	}
+}
+
+// RunScheduledEventQueue sends locally scheduled events when their time comes.
+// Events that were delayed on the server are removed from the database once they've been sent.
+func (h *HiClient) RunScheduledEventQueue(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("action", "scheduled event queue").Logger()
+	ctx = log.WithContext(ctx)
+	log.Info().Msg("Starting scheduled event queue")
+	defer func() {
+		log.Info().Msg("Stopping scheduled event queue")
+	}()
+	for {
+		var timer <-chan time.Time
+		next, err := h.DB.ScheduledEvent.GetNext(ctx)
+		if err != nil {
+			log.Err(err).Msg("Failed to get next scheduled event")
+			timer = time.After(1 * time.Minute)
+		} else if next != nil {
+			wait := time.Until(next.SendAt.Time)
+			if wait > 0 {
+				timer = time.After(wait)
+			} else if err = h.sendScheduledEvent(ctx, next); err != nil {
+				log.Err(err).Int64("scheduled_event_rowid", next.RowID).Msg("Failed to delete scheduled event before sending")
+				timer = time.After(1 * time.Minute)
+			} else {
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.scheduledEventWakeup:
+		case <-timer:
+		}
+	}
+}
+
+func (h *HiClient) sendScheduledEvent(ctx context.Context, scheduled *database.ScheduledEvent) error {
+	// Delete the entry first to ensure the event isn't sent twice if something goes wrong
+	err := h.DB.ScheduledEvent.Delete(ctx, scheduled.RowID)
+	if err != nil {
+		return err
+	} else if scheduled.DelayID != "" {
+		// The server has already sent the event
+		return nil
+	}
+	log := zerolog.Ctx(ctx).With().
+		Int64("scheduled_event_rowid", scheduled.RowID).
+		Stringer("room_id", scheduled.RoomID).
+		Logger()
+	evtType := event.Type{Type: scheduled.Type, Class: event.MessageEventType}
+	dbEvt, err := h.send(ctx, scheduled.RoomID, evtType, scheduled.Content, "", scheduled.DisableEncryption)
+	if err != nil {
+		log.Err(err).Msg("Failed to send scheduled event")
+	} else {
+		log.Debug().Str("txn_id", dbEvt.TransactionID).Msg("Sent scheduled event")
+	}
+	return nil
+}
@@ -71,52 +71,53 @@ func (h *HiClient) SendMessage(
 	relatesTo *event.RelatesTo,
 	mentions *event.Mentions,
 ) (*database.Event, error) {
-	var unencrypted bool
-	if strings.HasPrefix(text, "/unencrypted ") {
-		text = strings.TrimPrefix(text, "/unencrypted ")
-		unencrypted = true
-	}
-	if strings.HasPrefix(text, "/raw ") {
-		parts := strings.SplitN(text, " ", 3)
-		if len(parts) < 2 || len(parts[1]) == 0 {
-			return nil, fmt.Errorf("invalid /raw command")
-		}
-		var content json.RawMessage
-		if len(parts) == 3 {
-			content = json.RawMessage(parts[2])
-		} else {
-			content = json.RawMessage("{}")
-		}
-		if !json.Valid(content) {
-			return nil, fmt.Errorf("invalid JSON in /raw command")
-		}
-		return h.send(ctx, roomID, event.Type{Type: parts[1]}, content, "", unencrypted)
+	// Base content is used for edits and media, which must not run actions like /kick instead of being sent
+	msg, err := h.prepareMessage(ctx, roomID, base == nil, base, extra, text, relatesTo, mentions)
+	if err != nil {
+		return nil, err
+	} else if msg == nil {
+		// Action commands like /kick don't send a message, so there's no event to return
+		return nil, nil
 	}
-	var content event.MessageEventContent
-	msgType := event.MsgText
+	return h.send(ctx, roomID, msg.Type, msg.Content, msg.EditSource, msg.DisableEncryption)
+}
+
+type preparedMessage struct {
+	Type              event.Type
+	Content           any
+	EditSource        string
+	DisableEncryption bool
+}
+
+// prepareMessage converts the text and other parameters of a message into event content, running any slash
+// commands at the start of the text. If a command performed an action instead of sending a message, nil is returned.
+func (h *HiClient) prepareMessage(
+	ctx context.Context,
+	roomID id.RoomID,
+	allowActions bool,
+	base *event.MessageEventContent,
+	extra map[string]any,
+	text string,
+	relatesTo *event.RelatesTo,
+	mentions *event.Mentions,
+) (*preparedMessage, error) {
 	origText := text
-	if strings.HasPrefix(text, "/me ") {
-		msgType = event.MsgEmote
-		text = strings.TrimPrefix(text, "/me ")
-	} else if strings.HasPrefix(text, "/notice ") {
-		msgType = event.MsgNotice
-		text = strings.TrimPrefix(text, "/notice ")
-	}
-	if strings.HasPrefix(text, "/rainbow ") {
-		text = strings.TrimPrefix(text, "/rainbow ")
-		content = format.RenderMarkdownCustom(text, rainbowWithHTML)
-		content.FormattedBody = rainbow.ApplyColor(content.FormattedBody)
-	} else if strings.HasPrefix(text, "/plain ") {
-		text = strings.TrimPrefix(text, "/plain ")
-		content = format.TextToContent(text)
-	} else if strings.HasPrefix(text, "/html ") {
-		text = strings.TrimPrefix(text, "/html ")
-		text = strings.Replace(text, "\n", "<br>", -1)
-		content = format.HTMLToContent(text)
+	cmdMsg, err := h.runCommands(ctx, roomID, text, allowActions)
+	if err != nil {
+		return nil, err
+	} else if cmdMsg.NoEvent {
+		return nil, nil
+	} else if cmdMsg.RawContent != nil {
+		return &preparedMessage{Type: cmdMsg.RawType, Content: cmdMsg.RawContent, DisableEncryption: cmdMsg.DisableEncryption}, nil
+	}
+	text = cmdMsg.Text
+	var content event.MessageEventContent
+	if cmdMsg.Render != nil {
+		content = cmdMsg.Render(text)
 	} else if text != "" {
 		content = format.RenderMarkdownCustom(text, defaultNoHTML)
 	}
-	content.MsgType = msgType
+	content.MsgType = cmdMsg.MsgType
 	if base != nil {
 		if text != "" {
 			base.Body = content.Body
@@ -158,16 +159,33 @@ func (h *HiClient) SendMessage(
 		content.MsgType = ""
 		evtType = event.EventSticker
 	}
-	return h.send(ctx, roomID, evtType, &event.Content{Parsed: content, Raw: extra}, origText, unencrypted)
+	return &preparedMessage{
+		Type:              evtType,
+		Content:           &event.Content{Parsed: content, Raw: extra},
+		EditSource:        origText,
+		DisableEncryption: cmdMsg.DisableEncryption,
+	}, nil
 }
 
-func (h *HiClient) MarkRead(ctx context.Context, roomID id.RoomID, eventID id.EventID, receiptType event.ReceiptType) error {
+// MarkRead sends a read receipt for the given event. If threadID is set, the receipt only applies to that thread,
+// and the fully read marker is not moved.
+func (h *HiClient) MarkRead(ctx context.Context, roomID id.RoomID, eventID id.EventID, receiptType event.ReceiptType, threadID event.ThreadID) error {
 	room, err := h.DB.Room.Get(ctx, roomID)
 	if err != nil {
 		return fmt.Errorf("failed to get room metadata: %w", err)
 	} else if room == nil {
 		return fmt.Errorf("unknown room")
 	}
+	if threadID != "" {
+		if receiptType != event.ReceiptTypeRead && receiptType != event.ReceiptTypeReadPrivate {
+			return fmt.Errorf("invalid receipt type: %v", receiptType)
+		}
+		err = h.Client.SendReceipt(ctx, roomID, eventID, receiptType, &mautrix.ReqSendReceipt{ThreadID: threadID.String()})
+		if err != nil {
+			return fmt.Errorf("failed to mark thread as read: %w", err)
+		}
+		return nil
+	}
 	content := &mautrix.ReqSetReadMarkers{
 		FullyRead: eventID,
 	}
@@ -240,8 +258,12 @@ func (h *HiClient) Resend(ctx context.Context, txnID string) (*database.Event, e
 	} else if room == nil {
 		return nil, fmt.Errorf("unknown room")
 	}
+	err = h.DB.Outbox.Add(ctx, dbEvt.RowID, room.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add event to outbox: %w", err)
+	}
 	dbEvt.SendError = ""
-	go h.actuallySend(context.WithoutCancel(ctx), room, dbEvt, event.Type{Type: dbEvt.Type, Class: event.MessageEventType})
+	h.WakeupOutbox()
 	return dbEvt, nil
 }
 
@@ -310,13 +332,23 @@ func (h *HiClient) send(
 			zerolog.Ctx(ctx).Err(err).Msg("Failed to stop typing while sending message")
 		}
 	}()
-	go h.actuallySend(ctx, room, dbEvt, evtType)
+	err = h.DB.Outbox.Add(ctx, dbEvt.RowID, room.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add event to outbox: %w", err)
+	}
+	h.WakeupOutbox()
 	return dbEvt, nil
 }
 
-func (h *HiClient) actuallySend(ctx context.Context, room *database.Room, dbEvt *database.Event, evtType event.Type) {
-	var err error
+// actuallySend sends the given event to the server. sent is true if the server accepted the event,
+// even if saving the returned event ID failed afterwards. Retriable errors (see isRetriableSendError)
+// are returned without marking the event as failed, as the outbox will try again later.
+func (h *HiClient) actuallySend(ctx context.Context, room *database.Room, dbEvt *database.Event, evtType event.Type) (sent bool, err error) {
 	defer func() {
+		if err != nil && !sent && isRetriableSendError(err) {
+			dbEvt.SendError = ""
+			return
+		}
 		if dbEvt.SendError != "" {
 			err2 := h.DB.Event.UpdateSendError(ctx, dbEvt.RowID, dbEvt.SendError)
 			if err2 != nil {
@@ -363,11 +395,13 @@ func (h *HiClient) actuallySend(ctx context.Context, room *database.Room, dbEvt
 		err = fmt.Errorf("failed to send event: %w", err)
 		return
 	}
+	sent = true
 	dbEvt.ID = resp.EventID
 	err = h.DB.Event.UpdateID(ctx, dbEvt.RowID, dbEvt.ID)
 	if err != nil {
 		err = fmt.Errorf("failed to update event ID in database: %w", err)
 	}
+	return
 }
 
 func (h *HiClient) Encrypt(ctx context.Context, room *database.Room, evtType event.Type, content any) (encrypted *event.EncryptedEventContent, err error) {
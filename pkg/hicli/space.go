@@ -0,0 +1,347 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+const defaultSpaceHierarchyMaxDepth = 5
+
+type SpaceHierarchyRoom struct {
+	*mautrix.ChildRoomsChunk
+	// Joined is true if the room is in the local database, i.e. the user is a member.
+	Joined bool `json:"joined"`
+	// Local is true if the server didn't include the room and it was added from local space state.
+	Local bool `json:"local,omitempty"`
+}
+
+type SpaceHierarchyResponse struct {
+	Rooms     []*SpaceHierarchyRoom `json:"rooms"`
+	NextBatch string                `json:"next_batch,omitempty"`
+	// Local is true if the server didn't return a hierarchy and it was built from local state instead.
+	Local bool `json:"local,omitempty"`
+}
+
+func isValidSpaceOrder(order string) bool {
+	if len(order) > 50 {
+		return false
+	}
+	for _, char := range []byte(order) {
+		if char < 0x20 || char > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *HiClient) processSpaceEdge(ctx context.Context, roomID id.RoomID, evt *event.Event, rowID database.EventRowID) error {
+	otherRoomID := id.RoomID(*evt.StateKey)
+	if otherRoomID == "" {
+		return nil
+	}
+	hasVia := len(gjson.GetBytes(evt.Content.VeryRaw, "via").Array()) > 0
+	switch evt.Type {
+	case event.StateSpaceChild:
+		if !hasVia {
+			return h.DB.SpaceEdge.ClearChild(ctx, roomID, otherRoomID)
+		}
+		order := gjson.GetBytes(evt.Content.VeryRaw, "order").Str
+		if !isValidSpaceOrder(order) {
+			order = ""
+		}
+		suggested := gjson.GetBytes(evt.Content.VeryRaw, "suggested").Bool()
+		return h.DB.SpaceEdge.SetChild(ctx, roomID, otherRoomID, rowID, order, suggested)
+	case event.StateSpaceParent:
+		if !hasVia {
+			return h.DB.SpaceEdge.ClearParent(ctx, otherRoomID, roomID)
+		}
+		canonical := gjson.GetBytes(evt.Content.VeryRaw, "canonical").Bool()
+		return h.DB.SpaceEdge.SetParent(ctx, otherRoomID, roomID, rowID, canonical)
+	}
+	return nil
+}
+
+// GetSpaceHierarchy fetches the rooms in the given space from the server and marks which ones are joined.
+// Locally known space children that the server response lacks (e.g. rooms the server can't see into) are
+// merged into the response. If the server request fails (e.g. because the space is too large or the server
+// doesn't support it), the hierarchy is built from locally stored space state, which only includes rooms
+// the user is in.
+func (h *HiClient) GetSpaceHierarchy(ctx context.Context, spaceID id.RoomID, req *mautrix.ReqHierarchy) (*SpaceHierarchyResponse, error) {
+	resp, err := h.Client.Hierarchy(ctx, spaceID, req)
+	if err != nil {
+		if req.From != "" {
+			return nil, fmt.Errorf("failed to get space hierarchy from server: %w", err)
+		}
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Stringer("space_id", spaceID).
+			Msg("Failed to get space hierarchy from server, using local state")
+		maxDepth := defaultSpaceHierarchyMaxDepth
+		if req.MaxDepth != nil {
+			maxDepth = *req.MaxDepth
+		}
+		return h.getLocalSpaceHierarchy(ctx, spaceID, maxDepth, req.SuggestedOnly)
+	}
+	output := &SpaceHierarchyResponse{
+		Rooms:     make([]*SpaceHierarchyRoom, len(resp.Rooms)),
+		NextBatch: resp.NextBatch,
+	}
+	included := make(map[id.RoomID]struct{}, len(resp.Rooms))
+	for i, room := range resp.Rooms {
+		dbRoom, err := h.DB.Room.Get(ctx, room.RoomID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get room %s from database: %w", room.RoomID, err)
+		}
+		output.Rooms[i] = &SpaceHierarchyRoom{ChildRoomsChunk: room, Joined: dbRoom != nil}
+		included[room.RoomID] = struct{}{}
+	}
+	err = h.mergeLocalSpaceChildren(ctx, output, included, req.SuggestedOnly)
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// mergeLocalSpaceChildren adds locally known child edges that the server didn't return to the spaces in the
+// response. The server won't return those children on any page, so the child rooms (and their own children)
+// are added from local state if the user is in them.
+func (h *HiClient) mergeLocalSpaceChildren(ctx context.Context, output *SpaceHierarchyResponse, included map[id.RoomID]struct{}, suggestedOnly bool) error {
+	serverRooms := output.Rooms
+	for _, room := range serverRooms {
+		if room.RoomType != event.RoomTypeSpace || !room.Joined {
+			continue
+		}
+		dbRoom, err := h.DB.Room.Get(ctx, room.RoomID)
+		if err != nil {
+			return fmt.Errorf("failed to get room %s from database: %w", room.RoomID, err)
+		} else if dbRoom == nil {
+			continue
+		}
+		localChunk, _, err := h.localRoomToHierarchyChunk(ctx, dbRoom, suggestedOnly)
+		if err != nil {
+			return err
+		}
+		serverChildren := make(map[string]struct{}, len(room.ChildrenState))
+		for _, child := range room.ChildrenState {
+			serverChildren[child.StateKey] = struct{}{}
+		}
+		for _, child := range localChunk.ChildrenState {
+			if _, ok := serverChildren[child.StateKey]; ok {
+				continue
+			}
+			room.ChildrenState = append(room.ChildrenState, child)
+			childID := id.RoomID(child.StateKey)
+			if _, ok := included[childID]; ok {
+				continue
+			}
+			localHierarchy, err := h.getLocalSpaceHierarchy(ctx, childID, defaultSpaceHierarchyMaxDepth, suggestedOnly)
+			if err != nil {
+				return err
+			}
+			for _, localRoom := range localHierarchy.Rooms {
+				if _, ok := included[localRoom.RoomID]; ok {
+					continue
+				}
+				included[localRoom.RoomID] = struct{}{}
+				localRoom.Local = true
+				output.Rooms = append(output.Rooms, localRoom)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *HiClient) getLocalSpaceHierarchy(ctx context.Context, spaceID id.RoomID, maxDepth int, suggestedOnly bool) (*SpaceHierarchyResponse, error) {
+	output := &SpaceHierarchyResponse{Rooms: make([]*SpaceHierarchyRoom, 0), Local: true}
+	visited := map[id.RoomID]struct{}{spaceID: {}}
+	queue := []id.RoomID{spaceID}
+	for depth := 0; len(queue) > 0 && depth <= maxDepth; depth++ {
+		var nextQueue []id.RoomID
+		for _, roomID := range queue {
+			room, err := h.DB.Room.Get(ctx, roomID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get room %s from database: %w", roomID, err)
+			} else if room == nil {
+				continue
+			}
+			chunk, children, err := h.localRoomToHierarchyChunk(ctx, room, suggestedOnly)
+			if err != nil {
+				return nil, err
+			}
+			output.Rooms = append(output.Rooms, &SpaceHierarchyRoom{ChildRoomsChunk: chunk, Joined: true})
+			for _, child := range children {
+				if _, alreadyVisited := visited[child]; !alreadyVisited {
+					visited[child] = struct{}{}
+					nextQueue = append(nextQueue, child)
+				}
+			}
+		}
+		queue = nextQueue
+	}
+	return output, nil
+}
+
+func (h *HiClient) localRoomToHierarchyChunk(ctx context.Context, room *database.Room, suggestedOnly bool) (*mautrix.ChildRoomsChunk, []id.RoomID, error) {
+	chunk := &mautrix.ChildRoomsChunk{
+		PublicRoomInfo: mautrix.PublicRoomInfo{
+			RoomID: room.ID,
+		},
+		ChildrenState: make([]mautrix.StrippedStateWithTime, 0),
+	}
+	if room.Name != nil {
+		chunk.Name = *room.Name
+	}
+	if room.Topic != nil {
+		chunk.Topic = *room.Topic
+	}
+	if room.Avatar != nil {
+		chunk.AvatarURL = room.Avatar.CUString()
+	}
+	if room.CanonicalAlias != nil {
+		chunk.CanonicalAlias = *room.CanonicalAlias
+	}
+	if room.CreationContent != nil {
+		chunk.RoomType = room.CreationContent.Type
+	}
+	if room.LazyLoadSummary != nil && room.LazyLoadSummary.JoinedMemberCount != nil {
+		chunk.NumJoinedMembers = *room.LazyLoadSummary.JoinedMemberCount
+	}
+	if chunk.RoomType != event.RoomTypeSpace {
+		return chunk, nil, nil
+	}
+	edges, err := h.DB.SpaceEdge.GetChildren(ctx, room.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get children of %s: %w", room.ID, err)
+	}
+	children := make([]id.RoomID, 0, len(edges))
+	for _, edge := range edges {
+		if suggestedOnly && !edge.Suggested {
+			continue
+		}
+		evt, err := h.DB.Event.GetByRowID(ctx, edge.ChildEventRowID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get space child event for %s: %w", edge.ChildID, err)
+		} else if evt == nil {
+			continue
+		}
+		chunk.ChildrenState = append(chunk.ChildrenState, mautrix.StrippedStateWithTime{
+			StrippedState: event.StrippedState{
+				Content:  event.Content{VeryRaw: evt.Content},
+				Type:     event.StateSpaceChild,
+				StateKey: edge.ChildID.String(),
+				Sender:   evt.Sender,
+			},
+			Timestamp: evt.Timestamp,
+		})
+		children = append(children, edge.ChildID)
+	}
+	return chunk, children, nil
+}
+
+func (h *HiClient) fillSpaceEdges(ctx context.Context, syncRoom *SyncRoom) error {
+	var err error
+	if syncRoom.Meta.CreationContent != nil && syncRoom.Meta.CreationContent.Type == event.RoomTypeSpace {
+		syncRoom.SpaceChildren, err = h.DB.SpaceEdge.GetChildren(ctx, syncRoom.Meta.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get space children: %w", err)
+		}
+	}
+	syncRoom.SpaceParents, err = h.DB.SpaceEdge.GetParents(ctx, syncRoom.Meta.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get space parents: %w", err)
+	}
+	return nil
+}
+
+// fillChangedSpaceEdges includes the current space edges of the given rooms in the sync rooms map,
+// adding entries for rooms that didn't otherwise change so that e.g. child rooms get their new parents.
+func (h *HiClient) fillChangedSpaceEdges(ctx context.Context, changed map[id.RoomID]struct{}, rooms map[id.RoomID]*SyncRoom) error {
+	for roomID := range changed {
+		syncRoom, ok := rooms[roomID]
+		if !ok {
+			room, err := h.DB.Room.Get(ctx, roomID)
+			if err != nil {
+				return fmt.Errorf("failed to get room %s from database: %w", roomID, err)
+			} else if room == nil {
+				continue
+			}
+			syncRoom = emptySyncRoom(room)
+			rooms[roomID] = syncRoom
+		}
+		err := h.fillSpaceEdges(ctx, syncRoom)
+		if err != nil {
+			return fmt.Errorf("failed to fill space edges of %s: %w", roomID, err)
+		}
+	}
+	return nil
+}
+
+// clearStaleSpaceEdges removes space edges defined by the given room which are no longer in its full state.
+// It returns the IDs of all rooms whose edges were changed.
+func (h *HiClient) clearStaleSpaceEdges(ctx context.Context, roomID id.RoomID, state []*event.Event) ([]id.RoomID, error) {
+	currentChildren := make(map[id.RoomID]struct{})
+	currentParents := make(map[id.RoomID]struct{})
+	for _, evt := range state {
+		if evt.StateKey == nil {
+			continue
+		} else if evt.Type == event.StateSpaceChild {
+			currentChildren[id.RoomID(*evt.StateKey)] = struct{}{}
+		} else if evt.Type == event.StateSpaceParent {
+			currentParents[id.RoomID(*evt.StateKey)] = struct{}{}
+		}
+	}
+	var changed []id.RoomID
+	children, err := h.DB.SpaceEdge.GetChildren(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get space children: %w", err)
+	}
+	for _, edge := range children {
+		if _, ok := currentChildren[edge.ChildID]; !ok {
+			err = h.DB.SpaceEdge.ClearChild(ctx, roomID, edge.ChildID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to clear stale space child %s: %w", edge.ChildID, err)
+			}
+			changed = append(changed, edge.ChildID)
+		}
+	}
+	parents, err := h.DB.SpaceEdge.GetParents(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get space parents: %w", err)
+	}
+	for _, edge := range parents {
+		if _, ok := currentParents[edge.SpaceID]; edge.ParentEventRowID != 0 && !ok {
+			err = h.DB.SpaceEdge.ClearParent(ctx, edge.SpaceID, roomID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to clear stale space parent %s: %w", edge.SpaceID, err)
+			}
+			changed = append(changed, edge.SpaceID)
+		}
+	}
+	return changed, nil
+}
+
+func emptySyncRoom(room *database.Room) *SyncRoom {
+	return &SyncRoom{
+		Meta:          room,
+		Timeline:      make([]database.TimelineRowTuple, 0),
+		State:         make(map[event.Type]map[string]database.EventRowID),
+		AccountData:   make(map[event.Type]*database.AccountData),
+		Events:        make([]*database.Event, 0),
+		Reset:         false,
+		Notifications: make([]SyncNotification, 0),
+		Receipts:      make(map[id.EventID][]*database.Receipt),
+	}
+}
@@ -36,7 +36,12 @@ import (
 type syncContext struct {
 	shouldWakeupRequestQueue bool
 
-	evt *SyncComplete
+	evt      *SyncComplete
+	presence []*database.Presence
+	// spaceEdgesChanged contains rooms whose space parents or children changed in this sync.
+	spaceEdgesChanged map[id.RoomID]struct{}
+	// verificationEvents contains in-room verification events to pass to the verification helper.
+	verificationEvents []*database.Event
 }
 
 func (h *HiClient) markSyncErrored(err error, permanent bool) {
@@ -61,6 +66,8 @@ var (
 func (h *HiClient) markSyncOK() {
 	if h.SyncStatus.Swap(syncOK) != syncOK {
 		h.EventHandler(syncOK)
+		// The connection is working again, so retry any failed sends immediately
+		h.retryOutbox(h.Log.WithContext(context.Background()))
 	}
 }
 
@@ -108,6 +115,9 @@ func (h *HiClient) postProcessSyncResponse(ctx context.Context, resp *mautrix.Re
 	if !syncCtx.evt.IsEmpty() {
 		h.EventHandler(syncCtx.evt)
 	}
+	if len(syncCtx.presence) > 0 {
+		h.EventHandler(&Presence{Users: syncCtx.presence})
+	}
 }
 
 func (h *HiClient) asyncPostProcessSyncResponse(ctx context.Context, resp *mautrix.RespSync, since string) {
@@ -118,9 +128,46 @@ func (h *HiClient) asyncPostProcessSyncResponse(ctx context.Context, resp *mautr
 		case *event.RoomKeyRequestEventContent:
 			h.Crypto.HandleRoomKeyRequest(ctx, evt.Sender, content)
 		}
+		h.dispatchEventTypeHandlers(ctx, evt)
+	}
+	for _, dbEvt := range ctx.Value(syncContextKey).(*syncContext).verificationEvents {
+		evt := dbEvt.AsRawMautrix()
+		err := evt.Content.ParseRaw(evt.Type)
+		if err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+			zerolog.Ctx(ctx).Warn().Err(err).
+				Stringer("event_id", evt.ID).
+				Msg("Failed to parse in-room verification event")
+			continue
+		}
+		h.dispatchEventTypeHandlers(ctx, evt)
 	}
 }
 
+func (h *HiClient) dispatchEventTypeHandlers(ctx context.Context, evt *event.Event) {
+	h.eventTypeHandlersLock.RLock()
+	handlers := h.eventTypeHandlers[evt.Type]
+	h.eventTypeHandlersLock.RUnlock()
+	for _, handler := range handlers {
+		handler(ctx, evt)
+	}
+}
+
+// isInRoomVerificationEvent checks if the given room event is a part of an in-room verification,
+// which is how other clients usually verify other users.
+func isInRoomVerificationEvent(evt *database.Event) bool {
+	if evt.StateKey != nil || evt.RedactedBy != "" {
+		return false
+	}
+	evtType, content := evt.Type, evt.Content
+	if evt.Decrypted != nil {
+		evtType, content = evt.DecryptedType, evt.Decrypted
+	}
+	if evtType == event.EventMessage.Type {
+		return gjson.GetBytes(content, "msgtype").Str == string(event.MsgVerificationRequest)
+	}
+	return strings.HasPrefix(evtType, "m.key.verification.")
+}
+
 func (h *HiClient) processSyncResponse(ctx context.Context, resp *mautrix.RespSync, since string) error {
 	if len(resp.DeviceLists.Changed) > 0 {
 		zerolog.Ctx(ctx).Debug().
@@ -152,6 +199,12 @@ func (h *HiClient) processSyncResponse(ctx context.Context, resp *mautrix.RespSy
 		}
 	}
 	ctx.Value(syncContextKey).(*syncContext).evt.AccountData = accountData
+	for _, evt := range resp.Presence.Events {
+		err = h.processPresence(ctx, evt)
+		if err != nil {
+			return fmt.Errorf("failed to process presence of %s: %w", evt.Sender, err)
+		}
+	}
 	for roomID, room := range resp.Rooms.Invite {
 		err = h.processSyncInvitedRoom(ctx, roomID, room)
 		if err != nil {
@@ -170,6 +223,16 @@ func (h *HiClient) processSyncResponse(ctx context.Context, resp *mautrix.RespSy
 			return fmt.Errorf("failed to process left room %s: %w", roomID, err)
 		}
 	}
+	syncCtx := ctx.Value(syncContextKey).(*syncContext)
+	err = h.fillChangedSpaceEdges(ctx, syncCtx.spaceEdgesChanged, syncCtx.evt.Rooms)
+	if err != nil {
+		return fmt.Errorf("failed to fill changed space edges: %w", err)
+	}
+	if !h.Verified {
+		// Unverified syncs only include to-device events, so don't store the token
+		// to make sure rooms are synced properly after the device is verified.
+		return nil
+	}
 	h.Account.NextBatch = resp.NextBatch
 	err = h.DB.Account.PutNextBatch(ctx, h.Account.UserID, resp.NextBatch)
 	if err != nil {
@@ -184,12 +247,14 @@ func (h *HiClient) receiptsToList(content *event.ReceiptEventContent) ([]*databa
 	for eventID, receipts := range *content {
 		for receiptType, users := range receipts {
 			for userID, receiptInfo := range users {
-				if userID == h.Account.UserID {
-					newOwnReceipts = append(newOwnReceipts, eventID)
-				}
 				if receiptInfo.ThreadID == event.ReadReceiptThreadMain {
 					receiptInfo.ThreadID = ""
 				}
+				// Threaded receipts only mark the thread as read, so they're not included in the list of own receipts.
+				// They're still saved in the receipt list and taken into account when recalculating unread counts.
+				if userID == h.Account.UserID && receiptInfo.ThreadID == "" {
+					newOwnReceipts = append(newOwnReceipts, eventID)
+				}
 				receiptList = append(receiptList, &database.Receipt{
 					UserID:      userID,
 					ReceiptType: receiptType,
@@ -239,6 +304,13 @@ func (h *HiClient) processSyncJoinedRoom(ctx context.Context, roomID id.RoomID,
 			// but not the same for all rooms without a timestamp.
 			SortingTimestamp: jsontime.UM(time.UnixMilli(time.Now().Unix())),
 		}
+		existingRoomData.NotificationMode = h.getRoomNotificationMode(roomID)
+		if existingRoomData.NotificationMode != database.RoomNotificationModeAll {
+			err = h.DB.Room.SetNotificationMode(ctx, roomID, existingRoomData.NotificationMode)
+			if err != nil {
+				return fmt.Errorf("failed to set initial notification mode: %w", err)
+			}
+		}
 	}
 
 	accountData := make(map[event.Type]*database.AccountData, len(room.AccountData.Events))
@@ -353,6 +425,8 @@ func (h *HiClient) decryptEventInto(ctx context.Context, evt *event.Event, dbEvt
 		dbEvt.MarkReplyFallbackRemoved()
 	}
 	dbEvt.DecryptedType = decryptedType
+	trustState := decryptedEvt.Mautrix.TrustState
+	dbEvt.TrustState = &trustState
 	return decryptedEvt, nil
 }
 
@@ -730,6 +804,10 @@ func (h *HiClient) processStateAndTimeline(
 			newUnreadCounts.AddOne(dbEvt.UnreadType)
 		}
 		if isTimeline {
+			if isInRoomVerificationEvent(dbEvt) {
+				syncCtx := ctx.Value(syncContextKey).(*syncContext)
+				syncCtx.verificationEvents = append(syncCtx.verificationEvents, dbEvt)
+			}
 			if dbEvt.CanUseForPreview() {
 				updatedRoom.PreviewEventRowID = dbEvt.RowID
 				recalculatePreviewEvent = false
@@ -751,6 +829,18 @@ func (h *HiClient) processStateAndTimeline(
 				return -1, fmt.Errorf("failed to save current state event ID %s for %s/%s: %w", evt.ID, evt.Type.Type, *evt.StateKey, err)
 			}
 			processImportantEvent(ctx, evt, room, updatedRoom)
+			if evt.Type == event.StateSpaceChild || evt.Type == event.StateSpaceParent {
+				err = h.processSpaceEdge(ctx, room.ID, evt, dbEvt.RowID)
+				if err != nil {
+					return -1, fmt.Errorf("failed to save space edge from %s: %w", evt.ID, err)
+				}
+				syncCtx := ctx.Value(syncContextKey).(*syncContext)
+				if syncCtx.spaceEdgesChanged == nil {
+					syncCtx.spaceEdgesChanged = make(map[id.RoomID]struct{})
+				}
+				syncCtx.spaceEdgesChanged[room.ID] = struct{}{}
+				syncCtx.spaceEdgesChanged[id.RoomID(*evt.StateKey)] = struct{}{}
+			}
 		}
 		allNewEvents = append(allNewEvents, dbEvt)
 		addedEvents[dbEvt.RowID] = struct{}{}
@@ -789,9 +879,12 @@ func (h *HiClient) processStateAndTimeline(
 	}
 	var timelineRowTuples []database.TimelineRowTuple
 	receiptMap := make(map[id.EventID][]*database.Receipt)
+	var newOwnThreadReceipts bool
 	for _, receipt := range receipts {
 		if receipt.UserID != h.Account.UserID {
 			receiptMap[receipt.EventID] = append(receiptMap[receipt.EventID], receipt)
+		} else if receipt.ThreadID != "" {
+			newOwnThreadReceipts = true
 		}
 	}
 	var err error
@@ -799,6 +892,7 @@ func (h *HiClient) processStateAndTimeline(
 		timelineIDs := make([]database.EventRowID, len(timeline.Events))
 		encounteredReceiptUsers := make(map[id.UserID]struct{})
 		readUpToIndex := -1
+		ownReadThreads := make(map[id.EventID]struct{})
 		for i := len(timeline.Events) - 1; i >= 0; i-- {
 			evt := timeline.Events[i]
 			for _, receipt := range receiptMap[evt.ID] {
@@ -819,7 +913,22 @@ func (h *HiClient) processStateAndTimeline(
 				receipts = append(receipts, injectedReceipt)
 				receiptMap[evt.ID] = append(receiptMap[evt.ID], injectedReceipt)
 			}
-			if readUpToIndex == -1 && (isRead || isOwnEvent) {
+			threadRoot, relType := database.GetRelatesToFromBytes(evt.Content.VeryRaw)
+			if isOwnEvent && relType == event.RelThread {
+				// Own messages in threads only mark that thread as read
+				if _, alreadyRead := ownReadThreads[threadRoot]; !alreadyRead {
+					ownReadThreads[threadRoot] = struct{}{}
+					receipts = append(receipts, &database.Receipt{
+						RoomID:      room.ID,
+						UserID:      h.Account.UserID,
+						ReceiptType: event.ReceiptTypeRead,
+						ThreadID:    threadRoot,
+						EventID:     evt.ID,
+						Timestamp:   jsontime.UM(time.UnixMilli(evt.Timestamp)),
+					})
+					newOwnThreadReceipts = true
+				}
+			} else if readUpToIndex == -1 && (isRead || isOwnEvent) {
 				readUpToIndex = i
 				// Reset unread counts if we see our own read receipt in the timeline.
 				// It'll be updated with new unreads (if any) at the end.
@@ -914,8 +1023,8 @@ func (h *HiClient) processStateAndTimeline(
 			return fmt.Errorf("failed to save receipts: %w", err)
 		}
 	}
-	if !room.UnreadCounts.IsZero() && ((len(newOwnReceipts) > 0 && newUnreadCounts.IsZero()) || unreadMessagesWereMaybeRedacted) {
-		updatedRoom.UnreadCounts, err = h.DB.Room.CalculateUnreads(ctx, room.ID, h.Account.UserID)
+	if !room.UnreadCounts.IsZero() && ((len(newOwnReceipts) > 0 && newUnreadCounts.IsZero()) || newOwnThreadReceipts || unreadMessagesWereMaybeRedacted) {
+		updatedRoom.UnreadCounts, _, err = h.DB.Room.CalculateUnreads(ctx, room.ID, h.Account.UserID)
 		if err != nil {
 			return fmt.Errorf("failed to recalculate unread counts: %w", err)
 		}
@@ -933,11 +1042,11 @@ func (h *HiClient) processStateAndTimeline(
 		}
 	}
 	// TODO why is *old* unread count sometimes zero when processing the read receipt that is making it zero?
-	if roomChanged || len(accountData) > 0 || len(newOwnReceipts) > 0 || len(receipts) > 0 || len(timelineRowTuples) > 0 || len(allNewEvents) > 0 {
+	if roomChanged || len(accountData) > 0 || len(newOwnReceipts) > 0 || newOwnThreadReceipts || len(receipts) > 0 || len(timelineRowTuples) > 0 || len(allNewEvents) > 0 {
 		for _, receipt := range receipts {
 			receipt.RoomID = ""
 		}
-		ctx.Value(syncContextKey).(*syncContext).evt.Rooms[room.ID] = &SyncRoom{
+		syncRoom := &SyncRoom{
 			Meta:          room,
 			Timeline:      timelineRowTuples,
 			AccountData:   accountData,
@@ -947,6 +1056,7 @@ func (h *HiClient) processStateAndTimeline(
 			Notifications: newNotifications,
 			Receipts:      receiptMap,
 		}
+		ctx.Value(syncContextKey).(*syncContext).evt.Rooms[room.ID] = syncRoom
 	}
 	return nil
 }
@@ -14,6 +14,7 @@ import (
 
 	"github.com/mattn/go-sqlite3"
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
 	"go.mau.fi/gomuks/pkg/hicli/database"
@@ -22,6 +23,7 @@ import (
 type hiSyncer HiClient
 
 var _ mautrix.Syncer = (*hiSyncer)(nil)
+var _ mautrix.ExtensibleSyncer = (*hiSyncer)(nil)
 
 type contextKey int
 
@@ -42,7 +44,11 @@ func (h *hiSyncer) ProcessResponse(ctx context.Context, resp *mautrix.RespSync,
 	if err != nil {
 		return err
 	}
+	syncCtx := ctx.Value(syncContextKey).(*syncContext)
 	for i := 0; ; i++ {
+		// Clear anything collected by a previous failed attempt, as the whole response is processed again
+		syncCtx.verificationEvents = nil
+		syncCtx.presence = nil
 		err = c.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
 			return c.processSyncResponse(ctx, resp, since)
 		})
@@ -84,10 +90,14 @@ func (h *hiSyncer) GetFilterJSON(_ id.UserID) *mautrix.Filter {
 			},
 		}
 	}
-	return &mautrix.Filter{
-		Presence: &mautrix.FilterPart{
+	var presenceFilter *mautrix.FilterPart
+	if !h.EnablePresence {
+		presenceFilter = &mautrix.FilterPart{
 			NotRooms: []id.RoomID{"*"},
-		},
+		}
+	}
+	return &mautrix.Filter{
+		Presence: presenceFilter,
 		Room: &mautrix.RoomFilter{
 			State: &mautrix.FilterPart{
 				LazyLoadMembers: true,
@@ -100,6 +110,25 @@ func (h *hiSyncer) GetFilterJSON(_ id.UserID) *mautrix.Filter {
 	}
 }
 
+// OnEventType registers a handler for events of the given type. It's only meant for the verification helper:
+// to-device events are dispatched to handlers, but the only room events that are dispatched are in-room
+// verification events from the live timeline.
+func (h *hiSyncer) OnEventType(eventType event.Type, callback mautrix.EventHandler) {
+	h.eventTypeHandlersLock.Lock()
+	h.eventTypeHandlers[eventType] = append(h.eventTypeHandlers[eventType], callback)
+	h.eventTypeHandlersLock.Unlock()
+}
+
+// OnSync is a no-op, as generic sync handlers are not supported by hicli.
+func (h *hiSyncer) OnSync(_ mautrix.SyncHandler) {
+	h.Log.Warn().Msg("Ignoring generic sync handler, hicli doesn't support them")
+}
+
+// OnEvent is a no-op, as generic event handlers are not supported by hicli.
+func (h *hiSyncer) OnEvent(_ mautrix.EventHandler) {
+	h.Log.Warn().Msg("Ignoring generic event handler, hicli doesn't support them")
+}
+
 type hiStore HiClient
 
 var _ mautrix.SyncStore = (*hiStore)(nil)
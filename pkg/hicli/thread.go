@@ -0,0 +1,282 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+const (
+	DefaultThreadLimit = 50
+	MaxThreadLimit     = 100
+)
+
+func clampThreadLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultThreadLimit
+	} else if limit > MaxThreadLimit {
+		return MaxThreadLimit
+	}
+	return limit
+}
+
+type ThreadListResponse struct {
+	Threads []*database.ThreadSummary `json:"threads"`
+	// Events contains the root and latest reply events of the threads.
+	Events  []*database.Event `json:"events"`
+	HasMore bool              `json:"has_more"`
+}
+
+type ThreadPaginationResponse struct {
+	*PaginationResponse
+	// NextBatch is set if the events were fetched from the server and
+	// should be passed back as the from token to continue paginating.
+	NextBatch string `json:"next_batch,omitempty"`
+}
+
+type respRelations struct {
+	Chunk     []*event.Event `json:"chunk"`
+	NextBatch string         `json:"next_batch"`
+}
+
+// GetThreads returns the threads in the given room which are known locally, ordered by latest activity.
+func (h *HiClient) GetThreads(ctx context.Context, roomID id.RoomID, before int64, limit int) (*ThreadListResponse, error) {
+	limit = clampThreadLimit(limit)
+	threads, err := h.DB.Event.GetThreads(ctx, roomID, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get threads from database: %w", err)
+	}
+	_, threadUnreads, err := h.DB.Room.CalculateUnreads(ctx, roomID, h.Account.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate thread unread counts: %w", err)
+	}
+	latestRowIDs := make([]database.EventRowID, len(threads))
+	rootIDs := make([]id.EventID, len(threads))
+	for i, thread := range threads {
+		thread.UnreadCounts = threadUnreads[thread.RootID]
+		latestRowIDs[i] = thread.LatestEventRowID
+		rootIDs[i] = thread.RootID
+	}
+	events, err := h.getThreadRoots(ctx, roomID, rootIDs)
+	if err != nil {
+		return nil, err
+	}
+	latestEvents, err := h.DB.Event.GetByRowIDs(ctx, latestRowIDs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest thread events: %w", err)
+	}
+	for _, evt := range latestEvents {
+		h.ReprocessExistingEvent(ctx, evt)
+	}
+	return &ThreadListResponse{
+		Threads: threads,
+		Events:  append(events, latestEvents...),
+		HasMore: len(threads) >= limit,
+	}, nil
+}
+
+// getThreadRoots loads the given thread root events from the database and fetches the missing ones from the server.
+// Roots that can't be fetched are logged and left out of the result.
+func (h *HiClient) getThreadRoots(ctx context.Context, roomID id.RoomID, rootIDs []id.EventID) ([]*database.Event, error) {
+	roots, err := h.DB.Event.GetByIDs(ctx, rootIDs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get thread roots from database: %w", err)
+	}
+	found := make(map[id.EventID]struct{}, len(roots))
+	for _, root := range roots {
+		h.ReprocessExistingEvent(ctx, root)
+		found[root.ID] = struct{}{}
+	}
+	var missing []id.EventID
+	for _, rootID := range rootIDs {
+		if _, ok := found[rootID]; !ok {
+			missing = append(missing, rootID)
+		}
+	}
+	if len(missing) == 0 {
+		return roots, nil
+	}
+	serverEvts := make([]*event.Event, len(missing))
+	var wg sync.WaitGroup
+	sema := make(chan struct{}, MaxParallelRequests)
+	wg.Add(len(missing))
+	for i, rootID := range missing {
+		go func() {
+			defer wg.Done()
+			sema <- struct{}{}
+			defer func() { <-sema }()
+			evt, err := h.Client.GetEvent(ctx, roomID, rootID)
+			if err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).
+					Stringer("thread_root", rootID).
+					Msg("Failed to get thread root event from server")
+				return
+			}
+			serverEvts[i] = evt
+		}()
+	}
+	wg.Wait()
+	for _, evt := range serverEvts {
+		if evt == nil {
+			continue
+		}
+		root, err := h.processEvent(ctx, evt, nil, nil, false)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).
+				Stringer("thread_root", evt.ID).
+				Msg("Failed to save thread root event")
+			continue
+		}
+		roots = append(roots, root)
+	}
+	return roots, nil
+}
+
+// PaginateThread returns events in the given thread in reverse chronological order.
+//
+// Locally stored events are returned first. Once they run out, the thread is fetched from the server
+// starting after the oldest locally returned event, and the returned next batch token must be used as
+// the from parameter for further pagination.
+func (h *HiClient) PaginateThread(
+	ctx context.Context,
+	roomID id.RoomID,
+	threadRoot id.EventID,
+	maxRowID database.EventRowID,
+	from string,
+	limit int,
+) (*ThreadPaginationResponse, error) {
+	limit = clampThreadLimit(limit)
+	resp := &ThreadPaginationResponse{PaginationResponse: &PaginationResponse{}}
+	if from == "" {
+		evts, err := h.DB.Event.GetThreadEvents(ctx, roomID, threadRoot, maxRowID, limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get thread events from database: %w", err)
+		} else if len(evts) >= limit || (maxRowID != 0 && len(evts) > 0) {
+			for _, evt := range evts {
+				h.ReprocessExistingEvent(ctx, evt)
+			}
+			resp.Events = evts
+			resp.HasMore = true
+		}
+	}
+	if resp.Events == nil {
+		var err error
+		if from == "" && maxRowID != 0 {
+			resp.Events, resp.NextBatch, err = h.paginateThreadServerBefore(ctx, roomID, threadRoot, maxRowID, limit)
+		} else {
+			resp.Events, resp.NextBatch, err = h.paginateThreadServer(ctx, roomID, threadRoot, from, limit)
+		}
+		if err != nil {
+			return nil, err
+		}
+		resp.HasMore = resp.NextBatch != ""
+	}
+	err := h.fillPaginationRelatedData(ctx, roomID, resp.PaginationResponse)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// paginateThreadServerBefore fetches thread events from the server that are older than the given local event.
+// The relations API can't start from an arbitrary event, so pages newer than the boundary are skipped.
+func (h *HiClient) paginateThreadServerBefore(ctx context.Context, roomID id.RoomID, threadRoot id.EventID, before database.EventRowID, limit int) ([]*database.Event, string, error) {
+	boundary, err := h.DB.Event.GetByRowID(ctx, before)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get pagination boundary event: %w", err)
+	} else if boundary == nil {
+		return nil, "", fmt.Errorf("pagination boundary event not found")
+	}
+	var from string
+	passedBoundary := false
+	for {
+		evts, nextBatch, err := h.paginateThreadServer(ctx, roomID, threadRoot, from, limit)
+		if err != nil {
+			return nil, "", err
+		}
+		older := make([]*database.Event, 0, len(evts))
+		for _, evt := range evts {
+			if evt.ID == boundary.ID {
+				passedBoundary = true
+			} else if passedBoundary || evt.Timestamp.Before(boundary.Timestamp.Time) {
+				older = append(older, evt)
+			}
+		}
+		if len(older) > 0 || nextBatch == "" {
+			return older, nextBatch, nil
+		}
+		from = nextBatch
+	}
+}
+
+func (h *HiClient) paginateThreadServer(ctx context.Context, roomID id.RoomID, threadRoot id.EventID, from string, limit int) ([]*database.Event, string, error) {
+	room, err := h.DB.Room.Get(ctx, roomID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get room from database: %w", err)
+	} else if room == nil {
+		return nil, "", fmt.Errorf("unknown room")
+	}
+	query := map[string]string{
+		"dir":   string(mautrix.DirectionBackward),
+		"limit": strconv.Itoa(limit),
+	}
+	if from != "" {
+		query["from"] = from
+	}
+	urlPath := h.Client.BuildURLWithQuery(mautrix.ClientURLPath{"v1", "rooms", roomID, "relations", threadRoot, string(event.RelThread)}, query)
+	var resp respRelations
+	_, err = h.Client.MakeRequest(ctx, http.MethodGet, urlPath, nil, &resp)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get thread events from server: %w", err)
+	}
+	events := make([]*database.Event, len(resp.Chunk))
+	wakeupSessionRequests := false
+	err = h.DB.DoTxn(ctx, nil, func(ctx context.Context) error {
+		decryptionQueue := make(map[id.SessionID]*database.SessionRequest)
+		for i, evt := range resp.Chunk {
+			evt.RoomID = roomID
+			events[i], err = h.processEvent(ctx, evt, room.LazyLoadSummary, decryptionQueue, true)
+			if err != nil {
+				return err
+			}
+		}
+		wakeupSessionRequests = len(decryptionQueue) > 0
+		for _, entry := range decryptionQueue {
+			err = h.DB.SessionRequest.Put(ctx, entry)
+			if err != nil {
+				return fmt.Errorf("failed to save session request for %s: %w", entry.SessionID, err)
+			}
+		}
+		err = h.DB.Event.FillReactionCounts(ctx, roomID, events)
+		if err != nil {
+			return fmt.Errorf("failed to fill reaction counts: %w", err)
+		}
+		err = h.DB.Event.FillLastEditRowIDs(ctx, roomID, events)
+		if err != nil {
+			return fmt.Errorf("failed to fill last edit row IDs: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if wakeupSessionRequests {
+		h.WakeupRequestQueue()
+	}
+	return events, resp.NextBatch, nil
+}
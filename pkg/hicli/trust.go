@@ -0,0 +1,103 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/id"
+
+	"go.mau.fi/gomuks/pkg/hicli/database"
+)
+
+// resolveSessionTrust calculates the trust state of events sent by the given user using the given megolm session.
+// This mirrors what the crypto machine does when decrypting, except that device keys are never fetched.
+func (h *HiClient) resolveSessionTrust(ctx context.Context, sender id.UserID, sess *crypto.InboundGroupSession) (id.TrustState, error) {
+	own := h.Crypto.OwnIdentity()
+	if sess.SigningKey == own.SigningKey && sess.SenderKey == own.IdentityKey && len(sess.ForwardingChains) == 0 {
+		return id.TrustStateVerified, nil
+	}
+	senderKey := sess.SenderKey
+	forwarded := len(sess.ForwardingChains) > 1 || (len(sess.ForwardingChains) == 1 && sess.ForwardingChains[0] != sess.SenderKey.String())
+	if forwarded {
+		senderKey = id.Curve25519(sess.ForwardingChains[len(sess.ForwardingChains)-1])
+	}
+	device, err := h.CryptoStore.FindDeviceByKey(ctx, sender, senderKey)
+	if err != nil {
+		return id.TrustStateUnset, err
+	} else if device == nil {
+		if forwarded {
+			return id.TrustStateForwarded, nil
+		}
+		return id.TrustStateUnknownDevice, nil
+	} else if !forwarded && (device.SigningKey != sess.SigningKey || device.IdentityKey != sess.SenderKey) {
+		return id.TrustStateUnknownDevice, nil
+	}
+	return h.Crypto.ResolveTrustContext(ctx, device)
+}
+
+// RefreshTrustStates re-evaluates the stored trust state of all decrypted events from the given users,
+// e.g. after they were verified or their cross-signing keys changed. Changed events are dispatched
+// to the event handler the same way as newly decrypted events.
+func (h *HiClient) RefreshTrustStates(ctx context.Context, userIDs ...id.UserID) {
+	for _, userID := range userIDs {
+		err := h.refreshTrustStatesForUser(ctx, userID)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("user_id", userID).Msg("Failed to refresh trust states of events")
+		}
+	}
+}
+
+// RefreshAllTrustStates re-evaluates the stored trust state of all decrypted events. It's used when this device
+// becomes cross-signed, as that changes whether the keys of every other user are signed by us.
+func (h *HiClient) RefreshAllTrustStates(ctx context.Context) {
+	senders, err := h.DB.Event.GetTrustStateSenders(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get senders to refresh trust states of")
+		return
+	}
+	h.RefreshTrustStates(ctx, senders...)
+}
+
+func (h *HiClient) refreshTrustStatesForUser(ctx context.Context, userID id.UserID) error {
+	sessions, err := h.DB.Event.GetTrustStateSessions(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get sessions of user: %w", err)
+	}
+	changedRooms := make(map[id.RoomID][]database.EventRowID)
+	for _, rs := range sessions {
+		sess, err := h.CryptoStore.GetGroupSession(ctx, rs.RoomID, rs.SessionID)
+		if err != nil {
+			return fmt.Errorf("failed to get megolm session %s: %w", rs.SessionID, err)
+		} else if sess == nil {
+			continue
+		}
+		trust, err := h.resolveSessionTrust(ctx, userID, sess)
+		if err != nil {
+			return fmt.Errorf("failed to resolve trust of megolm session %s: %w", rs.SessionID, err)
+		}
+		rowIDs, err := h.DB.Event.UpdateTrustState(ctx, rs.RoomID, rs.SessionID, userID, trust)
+		if err != nil {
+			return fmt.Errorf("failed to update trust state of events in %s: %w", rs.RoomID, err)
+		}
+		changedRooms[rs.RoomID] = append(changedRooms[rs.RoomID], rowIDs...)
+	}
+	for roomID, rowIDs := range changedRooms {
+		if len(rowIDs) == 0 {
+			continue
+		}
+		evts, err := h.DB.Event.GetByRowIDs(ctx, rowIDs...)
+		if err != nil {
+			return fmt.Errorf("failed to get events with changed trust state: %w", err)
+		}
+		h.EventHandler(&EventsDecrypted{RoomID: roomID, Events: evts})
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2024 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package hicli
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/crypto/olm"
+	"maunium.net/go/mautrix/crypto/verificationhelper"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const secretRequestTimeout = 2 * time.Minute
+
+// sasEmojiNames contains the English names of the SAS emojis as defined in the spec.
+var sasEmojiNames = map[rune]string{
+	'🐶': "Dog", '🐱': "Cat", '🦁': "Lion", '🐎': "Horse", '🦄': "Unicorn", '🐷': "Pig", '🐘': "Elephant",
+	'🐰': "Rabbit", '🐼': "Panda", '🐓': "Rooster", '🐧': "Penguin", '🐢': "Turtle", '🐟': "Fish",
+	'🐙': "Octopus", '🦋': "Butterfly", '🌷': "Flower", '🌳': "Tree", '🌵': "Cactus", '🍄': "Mushroom",
+	'🌏': "Globe", '🌙': "Moon", '☁': "Cloud", '🔥': "Fire", '🍌': "Banana", '🍎': "Apple",
+	'🍓': "Strawberry", '🌽': "Corn", '🍕': "Pizza", '🎂': "Cake", '❤': "Heart", '😀': "Smiley",
+	'🤖': "Robot", '🎩': "Hat", '👓': "Glasses", '🔧': "Spanner", '🎅': "Santa", '👍': "Thumbs Up",
+	'☂': "Umbrella", '⌛': "Hourglass", '⏰': "Clock", '🎁': "Gift", '💡': "Light Bulb", '📕': "Book",
+	'✏': "Pencil", '📎': "Paperclip", '✂': "Scissors", '🔒': "Lock", '🔑': "Key", '🔨': "Hammer",
+	'☎': "Telephone", '🏁': "Flag", '🚂': "Train", '🚲': "Bicycle", '✈': "Aeroplane", '🚀': "Rocket",
+	'🏆': "Trophy", '⚽': "Ball", '🎸': "Guitar", '🎺': "Trumpet", '🔔': "Bell", '⚓': "Anchor",
+	'🎧': "Headphones", '📁': "Folder", '📌': "Pin",
+}
+
+type hiVerificationCallbacks HiClient
+
+var (
+	_ verificationhelper.RequiredCallbacks = (*hiVerificationCallbacks)(nil)
+	_ verificationhelper.ShowSASCallbacks  = (*hiVerificationCallbacks)(nil)
+)
+
+func (h *hiVerificationCallbacks) VerificationRequested(ctx context.Context, txnID id.VerificationTransactionID, from id.UserID, fromDevice id.DeviceID) {
+	(*HiClient)(h).setVerificationPartner(txnID, from)
+	h.EventHandler(&VerificationRequested{
+		TransactionID: txnID,
+		FromUser:      from,
+		FromDevice:    fromDevice,
+	})
+}
+
+func (h *hiVerificationCallbacks) VerificationCancelled(ctx context.Context, txnID id.VerificationTransactionID, code event.VerificationCancelCode, reason string) {
+	(*HiClient)(h).popVerificationPartner(txnID)
+	h.EventHandler(&VerificationCancelled{
+		TransactionID: txnID,
+		Code:          code,
+		Reason:        reason,
+	})
+}
+
+func (h *hiVerificationCallbacks) VerificationDone(ctx context.Context, txnID id.VerificationTransactionID) {
+	hc := (*HiClient)(h)
+	partner := hc.popVerificationPartner(txnID)
+	h.EventHandler(&VerificationDone{TransactionID: txnID})
+	if partner != "" && partner != h.Account.UserID {
+		go hc.RefreshTrustStates(context.WithoutCancel(ctx), partner)
+	} else if partner == h.Account.UserID && !h.Verified {
+		go func() {
+			ctx := context.WithoutCancel(ctx)
+			err := hc.fetchCrossSigningKeysFromOtherDevice(ctx)
+			if err != nil {
+				zerolog.Ctx(ctx).Err(err).Msg("Failed to fetch cross-signing keys after interactive verification")
+				h.EventHandler(&VerificationCancelled{
+					TransactionID: txnID,
+					Code:          event.VerificationCancelCodeInternalError,
+					Reason:        fmt.Sprintf("Failed to receive cross-signing keys: %v", err),
+				})
+			}
+		}()
+	}
+}
+
+func (h *hiVerificationCallbacks) ShowSAS(ctx context.Context, txnID id.VerificationTransactionID, emojis []rune, decimals []int) {
+	evt := &VerificationSAS{
+		TransactionID: txnID,
+		Emojis:        make([]VerificationEmoji, len(emojis)),
+		Decimals:      decimals,
+	}
+	for i, emoji := range emojis {
+		evt.Emojis[i] = VerificationEmoji{Emoji: string(emoji), Description: sasEmojiNames[emoji]}
+	}
+	h.EventHandler(evt)
+}
+
+func (h *HiClient) setVerificationPartner(txnID id.VerificationTransactionID, userID id.UserID) {
+	h.verificationPartnersLock.Lock()
+	h.verificationPartners[txnID] = userID
+	h.verificationPartnersLock.Unlock()
+}
+
+func (h *HiClient) popVerificationPartner(txnID id.VerificationTransactionID) id.UserID {
+	h.verificationPartnersLock.Lock()
+	defer h.verificationPartnersLock.Unlock()
+	userID := h.verificationPartners[txnID]
+	delete(h.verificationPartners, txnID)
+	return userID
+}
+
+// StartVerification sends a verification request to all devices of the given user.
+// If the user ID is empty, the request is sent to the user's own other devices.
+func (h *HiClient) StartVerification(ctx context.Context, userID id.UserID) (id.VerificationTransactionID, error) {
+	if userID == "" {
+		userID = h.Account.UserID
+	} else if userID != h.Account.UserID && !h.Verified {
+		return "", errors.New("can't verify other users before verifying this device")
+	}
+	txnID, err := h.Verification.StartVerification(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	h.setVerificationPartner(txnID, userID)
+	return txnID, nil
+}
+
+// VerifyUser marks another user as verified without interactive verification by signing their master key
+// with our user-signing key. The given fingerprint must match the currently known master key of the user,
+// which ensures the key that gets signed is the one the user compared (e.g. from GetProfileEncryptionInfo).
+func (h *HiClient) VerifyUser(ctx context.Context, userID id.UserID, masterKeyFingerprint string) error {
+	if userID == h.Account.UserID {
+		return errors.New("can't verify own user, verify this device instead")
+	} else if !h.Verified {
+		return errors.New("can't verify other users before verifying this device")
+	}
+	keys, err := h.CryptoStore.GetCrossSigningKeys(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get cross-signing keys: %w", err)
+	}
+	masterKey, ok := keys[id.XSUsageMaster]
+	if !ok || masterKey.Key == "" {
+		return errors.New("user doesn't have cross-signing keys")
+	} else if masterKey.Key.Fingerprint() != masterKeyFingerprint {
+		return errors.New("master key fingerprint doesn't match")
+	}
+	err = h.Crypto.SignUser(ctx, userID, masterKey.Key)
+	if err != nil {
+		return fmt.Errorf("failed to sign master key: %w", err)
+	}
+	go h.RefreshTrustStates(context.WithoutCancel(ctx), userID)
+	return nil
+}
+
+func (h *HiClient) CancelVerification(ctx context.Context, txnID id.VerificationTransactionID, reason string) error {
+	if reason == "" {
+		reason = "The verification was cancelled by the user."
+	}
+	return h.Verification.CancelVerification(ctx, txnID, event.VerificationCancelCodeUser, reason)
+}
+
+func (h *HiClient) requestCrossSigningSeed(ctx context.Context, secret id.Secret, expectedKey id.Ed25519) ([]byte, error) {
+	var seed []byte
+	err := h.Crypto.GetOrRequestSecret(ctx, secret, func(data string) (bool, error) {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Stringer("secret", secret).Msg("Failed to decode received secret")
+			return false, nil
+		}
+		key, err := olm.NewPKSigningFromSeed(decoded)
+		if err != nil || key.PublicKey() != expectedKey {
+			zerolog.Ctx(ctx).Warn().Err(err).Stringer("secret", secret).Msg("Received secret doesn't match public key")
+			return false, nil
+		}
+		seed = decoded
+		return true, nil
+	}, secretRequestTimeout)
+	return seed, err
+}
+
+func (h *HiClient) fetchCrossSigningKeysFromOtherDevice(ctx context.Context) error {
+	defer h.dispatchCurrentState()
+	pubkeys := h.Crypto.GetOwnCrossSigningPublicKeys(ctx)
+	if pubkeys == nil {
+		return fmt.Errorf("own cross-signing keys not found")
+	}
+	var seeds crypto.CrossSigningSeeds
+	var err error
+	zerolog.Ctx(ctx).Debug().Msg("Requesting cross-signing private keys from other devices")
+	seeds.MasterKey, err = h.requestCrossSigningSeed(ctx, id.SecretXSMaster, pubkeys.MasterKey)
+	if err != nil {
+		return fmt.Errorf("failed to get master key: %w", err)
+	}
+	seeds.SelfSigningKey, err = h.requestCrossSigningSeed(ctx, id.SecretXSSelfSigning, pubkeys.SelfSigningKey)
+	if err != nil {
+		return fmt.Errorf("failed to get self-signing key: %w", err)
+	}
+	seeds.UserSigningKey, err = h.requestCrossSigningSeed(ctx, id.SecretXSUserSigning, pubkeys.UserSigningKey)
+	if err != nil {
+		return fmt.Errorf("failed to get user signing key: %w", err)
+	}
+	err = h.Crypto.ImportCrossSigningKeys(seeds)
+	if err != nil {
+		return fmt.Errorf("failed to import cross-signing private keys: %w", err)
+	}
+	err = h.Crypto.SignOwnDevice(ctx, h.Crypto.OwnIdentity())
+	if err != nil {
+		return fmt.Errorf("failed to sign own device: %w", err)
+	}
+	err = h.Crypto.SignOwnMasterKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to sign own master key: %w", err)
+	}
+	err = h.storeCrossSigningPrivateKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to store cross-signing private keys: %w", err)
+	}
+	zerolog.Ctx(ctx).Debug().Msg("Requesting key backup key from other devices")
+	err = h.Crypto.GetOrRequestSecret(ctx, id.SecretMegolmBackupV1, func(data string) (bool, error) {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return false, nil
+		}
+		h.KeyBackupKey, err = backup.MegolmBackupKeyFromBytes(decoded)
+		return err == nil, nil
+	}, secretRequestTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to get megolm backup key: %w", err)
+	}
+	latestVersion, err := h.Client.GetKeyBackupLatestVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get key backup latest version: %w", err)
+	}
+	h.KeyBackupVersion = latestVersion.Version
+	h.Verified = true
+	// Restart syncing to switch from the unverified to-device-only filter to the normal one
+	go h.Sync()
+	go h.RefreshAllTrustStates(ctx)
+	return nil
+}
@@ -11,10 +11,13 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
 
 	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto"
 	"maunium.net/go/mautrix/crypto/backup"
+	"maunium.net/go/mautrix/crypto/signatures"
 	"maunium.net/go/mautrix/crypto/ssss"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
@@ -160,8 +163,136 @@ func (h *HiClient) Verify(ctx context.Context, code string) error {
 		return fmt.Errorf("failed to fetch key backup key: %w", err)
 	}
 	h.Verified = true
-	if !h.IsSyncing() {
-		go h.Sync()
+	// Always restart syncing, as the unverified sync loop uses a filter that excludes rooms
+	go h.Sync()
+	go h.RefreshAllTrustStates(context.WithoutCancel(ctx))
+	return nil
+}
+
+// BootstrapSecurity sets up encryption for an account that doesn't have cross-signing or key backup yet.
+// It generates new cross-signing keys, a new SSSS key and a new key backup, uploads all of them to the
+// server and marks the current device as verified. The returned recovery key must be shown to the user,
+// as it's the only way to verify other devices later.
+//
+// If the account already has a cross-signing master key or a default SSSS key, ErrSecurityAlreadySetUp
+// is returned unless reset is true, as replacing them invalidates all existing verifications.
+//
+// If the server requires user-interactive auth for uploading cross-signing keys and no password
+// is provided, ErrPasswordRequired is returned before anything is stored on the server.
+func (h *HiClient) BootstrapSecurity(ctx context.Context, password, passphrase string, reset bool) (string, error) {
+	defer h.dispatchCurrentState()
+	log := zerolog.Ctx(ctx)
+	if !reset {
+		hasExisting, err := h.hasExistingSecuritySetup(ctx)
+		if err != nil {
+			return "", err
+		} else if hasExisting {
+			return "", ErrSecurityAlreadySetUp
+		}
+	}
+	log.Debug().Msg("Generating cross-signing keys")
+	keys, err := h.Crypto.GenerateCrossSigningKeys()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate cross-signing keys: %w", err)
+	}
+	err = h.Crypto.PublishCrossSigningKeys(ctx, keys, func(uiResp *mautrix.RespUserInteractive) any {
+		if password == "" || !uiResp.HasSingleStageFlow(mautrix.AuthTypePassword) {
+			return nil
+		}
+		return &mautrix.ReqUIAuthLogin{
+			BaseAuthData: mautrix.BaseAuthData{
+				Type:    mautrix.AuthTypePassword,
+				Session: uiResp.Session,
+			},
+			User:     h.Account.UserID.String(),
+			Password: password,
+		}
+	})
+	var httpErr mautrix.HTTPError
+	if errors.As(err, &httpErr) && httpErr.IsStatus(http.StatusUnauthorized) && password == "" {
+		return "", ErrPasswordRequired
+	} else if err != nil {
+		return "", fmt.Errorf("failed to publish cross-signing keys: %w", err)
+	}
+	// Store the private keys immediately, so they aren't lost if a later step fails
+	err = h.storeCrossSigningPrivateKeys(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to store cross-signing private keys: %w", err)
+	}
+	log.Debug().Msg("Generating SSSS key")
+	key, err := h.Crypto.SSSS.GenerateAndUploadKey(ctx, passphrase)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate and upload SSSS key: %w", err)
+	}
+	err = h.Crypto.UploadCrossSigningKeysToSSSS(ctx, key, keys)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload cross-signing keys to SSSS: %w", err)
+	}
+	err = h.Crypto.SSSS.SetDefaultKeyID(ctx, key.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to set default SSSS key: %w", err)
+	}
+	err = h.Crypto.SignOwnDevice(ctx, h.Crypto.OwnIdentity())
+	if err != nil {
+		return "", fmt.Errorf("failed to sign own device: %w", err)
+	}
+	log.Debug().Msg("Creating key backup")
+	err = h.createKeyBackup(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to create key backup: %w", err)
 	}
+	h.Verified = true
+	go h.Sync()
+	go h.RefreshAllTrustStates(context.WithoutCancel(ctx))
+	return key.RecoveryKey(), nil
+}
+
+func (h *HiClient) hasExistingSecuritySetup(ctx context.Context) (bool, error) {
+	keys, err := h.Crypto.GetCrossSigningPublicKeys(ctx, h.Account.UserID)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for existing cross-signing keys: %w", err)
+	} else if keys != nil && keys.MasterKey != "" {
+		return true, nil
+	}
+	_, _, err = h.Crypto.SSSS.GetDefaultKeyData(ctx)
+	if errors.Is(err, ssss.ErrNoDefaultKeyAccountDataEvent) || errors.Is(err, ssss.ErrNoKeyFieldInAccountDataEvent) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check for existing SSSS key: %w", err)
+	}
+	return true, nil
+}
+
+func (h *HiClient) createKeyBackup(ctx context.Context, ssssKey *ssss.Key) error {
+	key, err := backup.NewMegolmBackupKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate megolm backup key: %w", err)
+	}
+	authData := backup.MegolmAuthData{
+		PublicKey: id.Ed25519(base64.RawStdEncoding.EncodeToString(key.PublicKey().Bytes())),
+	}
+	masterKey := h.Crypto.CrossSigningKeys.MasterKey
+	sig, err := masterKey.SignJSON(authData)
+	if err != nil {
+		return fmt.Errorf("failed to sign key backup auth data: %w", err)
+	}
+	authData.Signatures = signatures.NewSingleSignature(h.Account.UserID, id.KeyAlgorithmEd25519, masterKey.PublicKey().String(), sig)
+	resp, err := h.Client.CreateKeyBackupVersion(ctx, &mautrix.ReqRoomKeysVersionCreate[backup.MegolmAuthData]{
+		Algorithm: id.KeyBackupAlgorithmMegolmBackupV1,
+		AuthData:  authData,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create key backup version: %w", err)
+	}
+	err = h.Crypto.SSSS.SetEncryptedAccountData(ctx, event.AccountDataMegolmBackupKey, key.Bytes(), ssssKey)
+	if err != nil {
+		return fmt.Errorf("failed to store megolm backup key in SSSS: %w", err)
+	}
+	err = h.CryptoStore.PutSecret(ctx, id.SecretMegolmBackupV1, base64.StdEncoding.EncodeToString(key.Bytes()))
+	if err != nil {
+		return fmt.Errorf("failed to store megolm backup key: %w", err)
+	}
+	h.KeyBackupVersion = resp.Version
+	h.KeyBackupKey = key
 	return nil
 }
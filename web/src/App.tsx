@@ -21,7 +21,7 @@ import WailsClient from "./api/wailsclient.ts"
 import WSClient from "./api/wsclient.ts"
 import ClientContext from "./ui/ClientContext.ts"
 import MainScreen from "./ui/MainScreen.tsx"
-import { LoginScreen, VerificationScreen } from "./ui/login"
+import { LoginScreen, TOTPScreen, VerificationScreen } from "./ui/login"
 import { LightboxWrapper } from "./ui/modal"
 import { useEventAsState } from "./util/eventdispatcher.ts"
 
@@ -69,7 +69,9 @@ function App() {
 		</div>
 	</div> : null
 
-	if (connState?.error && !afterConnectError) {
+	if (connState?.totpRequired) {
+		return <TOTPScreen client={client}/>
+	} else if (connState?.error && !afterConnectError) {
 		return errorOverlay
 	} else if ((!connState?.connected && !afterConnectError) || !clientState) {
 		const msg = connState?.connected ?
@@ -39,6 +39,7 @@ export default class Client {
 	#stateRequests: RoomStateGUID[] = []
 	#stateRequestPromise: Promise<void> | null = null
 	#gcInterval: number | undefined
+	#pendingDraftSaves: Map<RoomID, number> = new Map()
 
 	constructor(readonly rpc: RPCClient) {
 		this.rpc.event.listen(this.#handleEvent)
@@ -53,11 +54,16 @@ export default class Client {
 				signal,
 			})
 			if (!resp.ok && !signal.aborted) {
-				this.rpc.connect.emit({
-					connected: false,
-					reconnecting: false,
-					error: `Authentication failed: ${resp.statusText}`,
-				})
+				const errcode = await resp.json().then(data => data?.errcode, () => undefined)
+				if (errcode === "FI.MAU.GOMUKS.TOTP_REQUIRED") {
+					this.rpc.connect.emit({ connected: false, reconnecting: false, error: null, totpRequired: true })
+				} else {
+					this.rpc.connect.emit({
+						connected: false,
+						reconnecting: false,
+						error: `Authentication failed: ${resp.statusText}`,
+					})
+				}
 				return
 			}
 		} catch (err) {
@@ -66,11 +72,28 @@ export default class Client {
 		if (signal.aborted) {
 			return
 		}
+		this.#afterAuthenticate()
+	}
+
+	#afterAuthenticate() {
 		console.log("Successfully authenticated, connecting to websocket")
 		this.rpc.start()
 		this.requestNotificationPermission()
 	}
 
+	async submitTOTP(code: string): Promise<void> {
+		const resp = await fetch("_gomuks/auth", {
+			method: "POST",
+			headers: { "Content-Type": "application/json" },
+			body: JSON.stringify({ totp_code: code }),
+		})
+		if (!resp.ok) {
+			const data = await resp.json().catch(() => undefined)
+			throw new Error(data?.error ?? resp.statusText)
+		}
+		this.#afterAuthenticate()
+	}
+
 	requestNotificationPermission = (evt?: MouseEvent) => {
 		window.Notification?.requestPermission().then(permission => {
 			console.log("Notification permission:", permission)
@@ -109,6 +132,10 @@ export default class Client {
 			this.syncStatus.emit(ev.data)
 		} else if (ev.command === "init_complete") {
 			this.initComplete.emit(true)
+			this.rpc.getDrafts().then(
+				drafts => this.store.applyDrafts(drafts),
+				err => console.error("Failed to load drafts", err),
+			)
 		} else if (ev.command === "sync_complete") {
 			this.store.applySync(ev.data)
 		} else if (ev.command === "events_decrypted") {
@@ -119,7 +146,38 @@ export default class Client {
 			this.store.imageAuthToken = ev.data
 		} else if (ev.command === "typing") {
 			this.store.applyTyping(ev.data)
+		} else if (ev.command === "draft_updated") {
+			// Ignore echoes while a newer local change is waiting to be saved
+			if (!this.#pendingDraftSaves.has(ev.data.room_id)) {
+				this.store.applyDraftUpdate(ev.data)
+			}
+		}
+	}
+
+	// saveDraft updates the draft of a room locally and saves it on the server after a short delay,
+	// so that typing doesn't send a request for every keystroke. A null draft clears it.
+	saveDraft(roomID: RoomID, draft: unknown | null) {
+		const pending = this.#pendingDraftSaves.get(roomID)
+		if (pending === undefined && (draft === null
+			? !this.store.drafts.has(roomID)
+			: JSON.stringify(draft) === JSON.stringify(this.store.drafts.get(roomID)))) {
+			// Nothing changed, e.g. the composer was just updated with a draft saved in another tab
+			return
+		}
+		if (draft === null) {
+			this.store.drafts.delete(roomID)
+		} else {
+			this.store.drafts.set(roomID, draft)
 		}
+		clearTimeout(pending)
+		this.#pendingDraftSaves.set(roomID, window.setTimeout(() => {
+			this.#pendingDraftSaves.delete(roomID)
+			this.rpc.setDraft(roomID, undefined, draft).then(
+				// Drafts used to be stored in localStorage, only drop the old copy once the server has the draft
+				() => localStorage.removeItem(`draft-${roomID}`),
+				err => console.error("Failed to save draft", roomID, err),
+			)
+		}, 1000))
 	}
 
 	requestMemberEvent(room: RoomStateStore | RoomID | undefined, userID: UserID) {
@@ -211,7 +269,10 @@ export default class Client {
 			throw new Error("Room not found")
 		}
 		const dbEvent = await this.rpc.sendMessage(params)
-		this.#handleOutgoingEvent(dbEvent, room)
+		// Slash commands like /kick don't send an event
+		if (dbEvent) {
+			this.#handleOutgoingEvent(dbEvent, room)
+		}
 	}
 
 	async subscribeToEmojiPack(pack: RoomStateGUID, subscribe: boolean = true) {
@@ -331,6 +392,10 @@ export default class Client {
 		this.initComplete.emit(false)
 		this.syncStatus.emit({ type: "waiting", error_count: 0 })
 		this.state.clearCache()
+		for (const timeout of this.#pendingDraftSaves.values()) {
+			clearTimeout(timeout)
+		}
+		this.#pendingDraftSaves.clear()
 		this.store.clear()
 	}
 
@@ -14,14 +14,19 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <https://www.gnu.org/licenses/>.
 import { parseMXC } from "@/util/validation.ts"
+import { DEFAULT_ACCOUNT_ID, activeAccountID } from "./rpc.ts"
 import { ContentURI, LazyLoadSummary, RoomID, UserID, UserProfile } from "./types"
 
+export const accountQuery = activeAccountID === DEFAULT_ACCOUNT_ID
+	? ""
+	: `&account_id=${encodeURIComponent(activeAccountID)}`
+
 export const getMediaURL = (mxc?: string, encrypted: boolean = false): string | undefined => {
 	const [server, mediaID] = parseMXC(mxc)
 	if (!mediaID) {
 		return undefined
 	}
-	return `_gomuks/media/${server}/${mediaID}?encrypted=${encrypted}`
+	return `_gomuks/media/${server}/${mediaID}?encrypted=${encrypted}${accountQuery}`
 }
 
 export const getEncryptedMediaURL = (mxc?: string): string | undefined => {
@@ -86,7 +91,7 @@ export const getAvatarURL = (userID: UserID, content?: UserProfile | null): stri
 		return makeFallbackAvatar(backgroundColor, fallbackCharacter)
 	}
 	const fallback = `${backgroundColor}:${fallbackCharacter}`
-	return `_gomuks/media/${server}/${mediaID}?encrypted=false&fallback=${encodeURIComponent(fallback)}`
+	return `_gomuks/media/${server}/${mediaID}?encrypted=false&fallback=${encodeURIComponent(fallback)}${accountQuery}`
 }
 
 interface RoomForAvatarURL {
@@ -16,15 +16,38 @@
 import { CachedEventDispatcher, EventDispatcher } from "../util/eventdispatcher.ts"
 import { CancellablePromise } from "../util/promise.ts"
 import type {
+	APITokenInfo,
+	AccountInfo,
+	BootstrapSecurityResponse,
 	ClientWellKnown,
+	Command,
+	ContentURI,
+	CreateRoomParams,
+	DBDraft,
+	DBOutboxEntry,
+	DBPresence,
+	DBScheduledEvent,
+	DeviceID,
 	EventID,
 	EventRowID,
 	EventType,
+	ExportRoomParams,
+	ExportRoomResponse,
+	HistoryVisibility,
+	ImportRoomKeysResponse,
+	JoinRule,
+	JoinRuleAllow,
+	KeyBackupRestoreProgressData,
 	LoginFlowsResponse,
 	LoginRequest,
+	MembershipAction,
+	MembershipResult,
 	Mentions,
 	MessageEventContent,
+	OwnDevice,
 	PaginationResponse,
+	PowerLevelEventContent,
+	PresenceState,
 	ProfileEncryptionInfo,
 	RPCCommand,
 	RPCEvent,
@@ -32,21 +55,37 @@ import type {
 	ReceiptType,
 	RelatesTo,
 	ResolveAliasResponse,
+	RespCreateRoom,
 	RespRoomJoin,
 	RoomAlias,
 	RoomID,
+	RoomNotificationMode,
 	RoomStateGUID,
 	RoomSummary,
+	SearchParams,
+	SearchResult,
+	SpaceHierarchyResponse,
+	ThreadListResponse,
+	ThreadPaginationResponse,
 	TimelineRowID,
 	UserID,
 	UserProfile,
+	WebSession,
 } from "./types"
 
+export const DEFAULT_ACCOUNT_ID = "default"
+const ACCOUNT_ID_STORAGE_KEY = "gomuks_account_id"
+
+// The account is only switched by reloading the page, so the active account ID is constant for the page's lifetime.
+export const activeAccountID: string = localStorage.getItem(ACCOUNT_ID_STORAGE_KEY) || DEFAULT_ACCOUNT_ID
+
 export interface ConnectionEvent {
 	connected: boolean
 	reconnecting: boolean
 	error: string | null
 	nextAttempt?: string
+	// Set when the password was accepted, but the backend requires a two-factor authentication code.
+	totpRequired?: boolean
 }
 
 export class ErrorResponse extends Error {
@@ -65,6 +104,10 @@ export interface SendMessageParams {
 	mentions?: Mentions
 }
 
+export interface ScheduleMessageParams extends SendMessageParams {
+	send_at: number
+}
+
 export default abstract class RPCClient {
 	public readonly connect: CachedEventDispatcher<ConnectionEvent> = new CachedEventDispatcher()
 	public readonly event: EventDispatcher<RPCEvent> = new EventDispatcher()
@@ -73,6 +116,7 @@ export default abstract class RPCClient {
 		reject: (err: Error) => void
 	}> = new Map()
 	#requestIDCounter: number = 1
+	public readonly accountID: string = activeAccountID
 
 	protected abstract isConnected: boolean
 	protected abstract send(data: string): void
@@ -92,7 +136,7 @@ export default abstract class RPCClient {
 			} else {
 				target.reject(new ErrorResponse(data.data))
 			}
-		} else {
+		} else if (!data.account_id || data.account_id === this.accountID) {
 			this.event.emit(data as RPCEvent)
 		}
 	}
@@ -129,19 +173,86 @@ export default abstract class RPCClient {
 			this.send(JSON.stringify({
 				command,
 				request_id,
+				account_id: this.accountID,
 				data,
 			}))
 		}, this.cancelRequest.bind(this, request_id))
 	}
 
+	switchAccount(account_id: string) {
+		localStorage.setItem(ACCOUNT_ID_STORAGE_KEY, account_id)
+		window.location.reload()
+	}
+
+	listAccounts(): Promise<AccountInfo[]> {
+		return this.request("list_accounts", {})
+	}
+
+	addAccount(): Promise<AccountInfo> {
+		return this.request("add_account", {})
+	}
+
+	removeAccount(account_id: string): Promise<boolean> {
+		return this.request("remove_account", { account_id })
+	}
+
+	listAPITokens(): Promise<APITokenInfo[]> {
+		return this.request("list_api_tokens", {})
+	}
+
+	createAPIToken(name: string): Promise<APITokenInfo> {
+		return this.request("create_api_token", { name })
+	}
+
+	revokeAPIToken(name: string): Promise<boolean> {
+		return this.request("revoke_api_token", { name })
+	}
+
+	listWebSessions(): Promise<WebSession[]> {
+		return this.request("list_web_sessions", {})
+	}
+
+	/** Revoke a web session. If no session ID is given, the current session is revoked. */
+	revokeWebSession(session_id?: string): Promise<boolean> {
+		return this.request("revoke_web_session", { session_id })
+	}
+
 	logout(): Promise<boolean> {
 		return this.request("logout", {})
 	}
 
-	sendMessage(params: SendMessageParams): Promise<RawDBEvent> {
+	sendMessage(params: SendMessageParams): Promise<RawDBEvent | null> {
 		return this.request("send_message", params)
 	}
 
+	scheduleMessage(params: ScheduleMessageParams): Promise<DBScheduledEvent> {
+		return this.request("schedule_message", params)
+	}
+
+	getScheduledMessages(room_id?: RoomID): Promise<DBScheduledEvent[]> {
+		return this.request("get_scheduled_messages", { room_id })
+	}
+
+	cancelScheduledMessage(rowid: number): Promise<boolean> {
+		return this.request("cancel_scheduled_message", { rowid })
+	}
+
+	exportRoom(params: ExportRoomParams): Promise<ExportRoomResponse> {
+		return this.request("export_room", params)
+	}
+
+	getDrafts(): Promise<DBDraft[]> {
+		return this.request("get_drafts", {})
+	}
+
+	setDraft(room_id: RoomID, thread_id: EventID | undefined, content: unknown): Promise<DBDraft | null> {
+		return this.request("set_draft", { room_id, thread_id, content })
+	}
+
+	getOutbox(room_id: RoomID): Promise<DBOutboxEntry[]> {
+		return this.request("get_outbox", { room_id })
+	}
+
 	sendEvent(room_id: RoomID, type: EventType, content: unknown): Promise<RawDBEvent> {
 		return this.request("send_event", { room_id, type, content })
 	}
@@ -168,14 +279,28 @@ export default abstract class RPCClient {
 		return this.request("set_account_data", { type, content, room_id })
 	}
 
-	markRead(room_id: RoomID, event_id: EventID, receipt_type: ReceiptType = "m.read"): Promise<boolean> {
-		return this.request("mark_read", { room_id, event_id, receipt_type })
+	markRead(
+		room_id: RoomID, event_id: EventID, receipt_type: ReceiptType = "m.read", thread_id?: EventID,
+	): Promise<boolean> {
+		return this.request("mark_read", { room_id, event_id, receipt_type, thread_id })
+	}
+
+	setRoomNotificationMode(room_id: RoomID, mode: RoomNotificationMode): Promise<boolean> {
+		return this.request("set_room_notification_mode", { room_id, mode })
 	}
 
 	setTyping(room_id: RoomID, timeout: number): Promise<boolean> {
 		return this.request("set_typing", { room_id, timeout })
 	}
 
+	getPresence(user_id: UserID): Promise<DBPresence> {
+		return this.request("get_presence", { user_id })
+	}
+
+	setPresence(presence: PresenceState, status_msg: string = ""): Promise<boolean> {
+		return this.request("set_presence", { presence, status_msg })
+	}
+
 	getProfile(user_id: UserID): Promise<UserProfile> {
 		return this.request("get_profile", { user_id })
 	}
@@ -184,6 +309,18 @@ export default abstract class RPCClient {
 		return this.request("get_mutual_rooms", { user_id })
 	}
 
+	getOwnDevices(): Promise<OwnDevice[]> {
+		return this.request("get_own_devices", {})
+	}
+
+	renameDevice(device_id: DeviceID, name: string): Promise<boolean> {
+		return this.request("rename_device", { device_id, name })
+	}
+
+	deleteDevices(device_ids: DeviceID[], password?: string): Promise<boolean> {
+		return this.request("delete_devices", { device_ids, password })
+	}
+
 	getProfileEncryptionInfo(user_id: UserID): Promise<ProfileEncryptionInfo> {
 		return this.request("get_profile_encryption_info", { user_id })
 	}
@@ -222,6 +359,26 @@ export default abstract class RPCClient {
 		return this.request("paginate_server", { room_id, limit })
 	}
 
+	getThreads(room_id: RoomID, before: number, limit: number): Promise<ThreadListResponse> {
+		return this.request("get_threads", { room_id, before, limit })
+	}
+
+	paginateThread(
+		room_id: RoomID, thread_root: EventID, max_rowid: EventRowID, from: string, limit: number,
+	): Promise<ThreadPaginationResponse> {
+		return this.request("paginate_thread", { room_id, thread_root, max_rowid, from, limit })
+	}
+
+	searchMessages(params: SearchParams): Promise<SearchResult[]> {
+		return this.request("search_messages", params)
+	}
+
+	getSpaceHierarchy(
+		space_id: RoomID, from?: string, limit?: number, max_depth?: number, suggested_only?: boolean,
+	): Promise<SpaceHierarchyResponse> {
+		return this.request("get_space_hierarchy", { space_id, from, limit, max_depth, suggested_only })
+	}
+
 	getRoomSummary(room_id_or_alias: RoomID | RoomAlias, via?: string[]): Promise<RoomSummary> {
 		return this.request("get_room_summary", { room_id_or_alias, via })
 	}
@@ -234,6 +391,52 @@ export default abstract class RPCClient {
 		return this.request("leave_room", { room_id, reason })
 	}
 
+	updateMembership(
+		room_id: RoomID, action: MembershipAction, user_ids: UserID[], reason?: string,
+	): Promise<MembershipResult[]> {
+		return this.request("update_membership", { room_id, action, user_ids, reason })
+	}
+
+	acceptInvite(room_id: RoomID): Promise<RespRoomJoin> {
+		return this.request("accept_invite", { room_id })
+	}
+
+	rejectInvite(room_id: RoomID, reason?: string): Promise<boolean> {
+		return this.request("reject_invite", { room_id, reason })
+	}
+
+	listCommands(): Promise<Command[]> {
+		return this.request("list_commands", {})
+	}
+
+	createRoom(params: CreateRoomParams): Promise<RespCreateRoom> {
+		return this.request("create_room", params)
+	}
+
+	setRoomName(room_id: RoomID, name: string): Promise<EventID> {
+		return this.request("set_room_name", { room_id, name })
+	}
+
+	setRoomTopic(room_id: RoomID, topic: string): Promise<EventID> {
+		return this.request("set_room_topic", { room_id, topic })
+	}
+
+	setRoomAvatar(room_id: RoomID, url: ContentURI | ""): Promise<EventID> {
+		return this.request("set_room_avatar", { room_id, url })
+	}
+
+	setRoomJoinRules(room_id: RoomID, join_rule: JoinRule, allow?: JoinRuleAllow[]): Promise<EventID> {
+		return this.request("set_room_join_rules", { room_id, join_rule, allow })
+	}
+
+	setRoomHistoryVisibility(room_id: RoomID, history_visibility: HistoryVisibility): Promise<EventID> {
+		return this.request("set_room_history_visibility", { room_id, history_visibility })
+	}
+
+	setRoomPowerLevels(room_id: RoomID, power_levels: PowerLevelEventContent): Promise<EventID> {
+		return this.request("set_room_power_levels", { room_id, power_levels })
+	}
+
 	resolveAlias(alias: RoomAlias): Promise<ResolveAliasResponse> {
 		return this.request("resolve_alias", { alias })
 	}
@@ -257,4 +460,44 @@ export default abstract class RPCClient {
 	verify(recovery_key: string): Promise<boolean> {
 		return this.request("verify", { recovery_key })
 	}
+
+	bootstrapSecurity(password?: string, passphrase?: string, reset?: boolean): Promise<BootstrapSecurityResponse> {
+		return this.request("bootstrap_security", { password, passphrase, reset })
+	}
+
+	restoreKeyBackup(): Promise<KeyBackupRestoreProgressData> {
+		return this.request("restore_key_backup", {})
+	}
+
+	exportRoomKeys(passphrase: string, room_id?: RoomID): Promise<string> {
+		return this.request("export_room_keys", { passphrase, room_id })
+	}
+
+	importRoomKeys(passphrase: string, data: string): Promise<ImportRoomKeysResponse> {
+		return this.request("import_room_keys", { passphrase, data })
+	}
+
+	startVerification(user_id?: UserID): Promise<string> {
+		return this.request("start_verification", { user_id })
+	}
+
+	verifyUser(user_id: UserID, master_key: string): Promise<boolean> {
+		return this.request("verify_user", { user_id, master_key })
+	}
+
+	acceptVerification(transaction_id: string): Promise<boolean> {
+		return this.request("accept_verification", { transaction_id })
+	}
+
+	startSASVerification(transaction_id: string): Promise<boolean> {
+		return this.request("start_sas_verification", { transaction_id })
+	}
+
+	confirmSASVerification(transaction_id: string): Promise<boolean> {
+		return this.request("confirm_sas_verification", { transaction_id })
+	}
+
+	cancelVerification(transaction_id: string, reason?: string): Promise<boolean> {
+		return this.request("cancel_verification", { transaction_id, reason })
+	}
 }
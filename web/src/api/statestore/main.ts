@@ -22,6 +22,8 @@ import toSearchableString from "@/util/searchablestring.ts"
 import Subscribable, { MultiSubscribable, NoDataSubscribable } from "@/util/subscribable.ts"
 import {
 	ContentURI,
+	DBDraft,
+	DraftUpdatedData,
 	EventRowID,
 	EventsDecryptedData,
 	ImagePack,
@@ -75,6 +77,8 @@ export class StateStore {
 	currentRoomListFilter: string = ""
 	readonly accountData: Map<string, UnknownEventContent> = new Map()
 	readonly accountDataSubs = new MultiSubscribable()
+	readonly drafts: Map<RoomID, unknown> = new Map()
+	readonly draftSubs = new MultiSubscribable()
 	readonly emojiRoomsSub = new Subscribable()
 	readonly preferences = getPreferenceProxy(this)
 	#frequentlyUsedEmoji: Map<string, number> | null = null
@@ -415,6 +419,27 @@ export class StateStore {
 		room.applyTyping(typing.user_ids)
 	}
 
+	applyDrafts(drafts: DBDraft[]) {
+		this.drafts.clear()
+		for (const draft of drafts) {
+			// Thread drafts aren't supported by the composer yet
+			if (!draft.thread_id) {
+				this.drafts.set(draft.room_id, draft.content)
+			}
+		}
+	}
+
+	applyDraftUpdate(data: DraftUpdatedData) {
+		if (data.thread_id) {
+			return
+		} else if (data.draft) {
+			this.drafts.set(data.room_id, data.draft.content)
+		} else {
+			this.drafts.delete(data.room_id)
+		}
+		this.draftSubs.notify(data.room_id)
+	}
+
 	doGarbageCollection() {
 		const maxLastOpened = Date.now() - window.gcSettings.lastOpenedCutoff
 		let deletedEvents = 0
@@ -435,6 +460,7 @@ export class StateStore {
 		this.inviteRooms.clear()
 		this.roomList.emit([])
 		this.accountData.clear()
+		this.drafts.clear()
 		this.currentRoomListFilter = ""
 		this.#frequentlyUsedEmoji = null
 		this.#emojiPackKeys = null
@@ -15,10 +15,14 @@
 // along with this program.  If not, see <https://www.gnu.org/licenses/>.
 import {
 	DBAccountData,
+	DBDraft,
 	DBInvitedRoom,
+	DBOutboxEntry,
+	DBPresence,
 	DBReceipt,
 	DBRoom,
 	DBRoomAccountData,
+	DBSpaceEdge,
 	EventRowID,
 	RawDBEvent,
 	TimelineRowTuple,
@@ -34,6 +38,7 @@ import {
 interface BaseRPCCommand<T> {
 	command: string
 	request_id: number
+	account_id?: string
 	data: T
 }
 
@@ -46,6 +51,46 @@ export interface TypingEvent extends BaseRPCCommand<TypingEventData> {
 	command: "typing"
 }
 
+export interface PresenceEventData {
+	users: DBPresence[]
+}
+
+export interface PresenceEvent extends BaseRPCCommand<PresenceEventData> {
+	command: "presence"
+}
+
+export interface OutboxStatusData {
+	room_id: RoomID
+	entries: DBOutboxEntry[]
+}
+
+export interface OutboxStatusEvent extends BaseRPCCommand<OutboxStatusData> {
+	command: "outbox_status"
+}
+
+export interface DraftUpdatedData {
+	room_id: RoomID
+	thread_id?: EventID
+	draft: DBDraft | null
+}
+
+export interface DraftUpdatedEvent extends BaseRPCCommand<DraftUpdatedData> {
+	command: "draft_updated"
+}
+
+export interface KeyBackupRestoreProgressData {
+	rooms_total: number
+	rooms_done: number
+	sessions_imported: number
+	sessions_skipped: number
+	sessions_failed: number
+	done: boolean
+}
+
+export interface KeyBackupRestoreProgressEvent extends BaseRPCCommand<KeyBackupRestoreProgressData> {
+	command: "key_backup_restore_progress"
+}
+
 export interface SendCompleteData {
 	event: RawDBEvent
 	error: string | null
@@ -78,6 +123,8 @@ export interface SyncRoom {
 	notifications: SyncNotification[]
 	account_data: Record<EventType, DBRoomAccountData>
 	receipts: Record<EventID, DBReceipt[]>
+	space_children?: DBSpaceEdge[]
+	space_parents?: DBSpaceEdge[]
 }
 
 export interface SyncNotification {
@@ -110,6 +157,28 @@ export type ClientState = {
 	homeserver_url: string
 }
 
+export type AccountInfo = ClientState & {
+	account_id: string
+}
+
+export interface APITokenInfo {
+	name: string
+	created_at: number
+	/** Only included in the response to create_api_token */
+	token?: string
+}
+
+export interface WebSession {
+	id: string
+	label: string
+	ip: string
+	user_agent: string
+	created_at: number
+	last_seen: number
+	expires_at: number
+	current?: boolean
+}
+
 export interface ClientStateEvent extends BaseRPCCommand<ClientState> {
 	command: "client_state"
 }
@@ -138,6 +207,41 @@ export interface RunIDEvent extends BaseRPCCommand<RunData> {
 	command: "run_id"
 }
 
+export interface VerificationRequestedEvent extends BaseRPCCommand<{
+	transaction_id: string
+	from_user: UserID
+	from_device: DeviceID
+}> {
+	command: "verification_requested"
+}
+
+export interface VerificationEmoji {
+	emoji: string
+	description: string
+}
+
+export interface VerificationSASEvent extends BaseRPCCommand<{
+	transaction_id: string
+	emojis?: VerificationEmoji[]
+	decimals: number[]
+}> {
+	command: "verification_sas"
+}
+
+export interface VerificationCancelledEvent extends BaseRPCCommand<{
+	transaction_id: string
+	code: string
+	reason: string
+}> {
+	command: "verification_cancelled"
+}
+
+export interface VerificationDoneEvent extends BaseRPCCommand<{
+	transaction_id: string
+}> {
+	command: "verification_done"
+}
+
 export interface ResponseCommand extends BaseRPCCommand<unknown> {
 	command: "response"
 }
@@ -150,11 +254,19 @@ export type RPCEvent =
 	ClientStateEvent |
 	SyncStatusEvent |
 	TypingEvent |
+	PresenceEvent |
 	SendCompleteEvent |
+	OutboxStatusEvent |
+	DraftUpdatedEvent |
+	KeyBackupRestoreProgressEvent |
 	EventsDecryptedEvent |
 	SyncCompleteEvent |
 	ImageAuthTokenEvent |
 	InitCompleteEvent |
-	RunIDEvent
+	RunIDEvent |
+	VerificationRequestedEvent |
+	VerificationSASEvent |
+	VerificationCancelledEvent |
+	VerificationDoneEvent
 
 export type RPCCommand = RPCEvent | ResponseCommand | ErrorCommand
@@ -22,10 +22,12 @@ import {
 	EventID,
 	EventType,
 	LazyLoadSummary,
+	PresenceState,
 	ReceiptType,
 	RelationType,
 	RoomAlias,
 	RoomID,
+	SpaceHierarchyChunk,
 	TombstoneEventContent,
 	UserID,
 } from "./mxtypes.ts"
@@ -67,10 +69,13 @@ export interface DBRoom {
 	unread_notifications: number
 	unread_messages: number
 	marked_unread: boolean
+	notification_mode: RoomNotificationMode
 
 	prev_batch: string
 }
 
+export type RoomNotificationMode = "all" | "mentions_only" | "mute"
+
 //eslint-disable-next-line @typescript-eslint/no-explicit-any
 export type UnknownEventContent = Record<string, any>
 
@@ -131,6 +136,7 @@ export interface BaseDBEvent {
 	reactions?: Record<string, number>
 	last_edit_rowid?: EventRowID
 	unread_type: UnreadType
+	trust_state?: TrustState
 }
 
 export interface RawDBEvent extends BaseDBEvent {
@@ -179,6 +185,124 @@ export interface PaginationResponse {
 	has_more: boolean
 }
 
+export interface DBSpaceEdge {
+	space_id: RoomID
+	child_id: RoomID
+
+	child_event_rowid?: EventRowID
+	order?: string
+	suggested?: boolean
+
+	parent_event_rowid?: EventRowID
+	canonical?: boolean
+}
+
+export interface DBPresence {
+	user_id: UserID
+	presence: PresenceState
+	status_msg?: string
+	last_active_ts?: number
+	currently_active: boolean
+	updated_at: number
+}
+
+export type ExportFormat = "html" | "jsonl" | "text"
+
+export interface ExportRoomParams {
+	room_id: RoomID
+	format?: ExportFormat
+	fetch_history?: boolean
+	// Only supported by the GET /_gomuks/export/{room_id} endpoint, not the export_room command
+	include_media?: boolean
+}
+
+export interface BootstrapSecurityResponse {
+	recovery_key: string
+}
+
+export interface ExportRoomResponse {
+	file_name: string
+	mime_type: string
+	data: string
+}
+
+export interface ImportRoomKeysResponse {
+	imported: number
+	total: number
+}
+
+export interface DBOutboxEntry {
+	event_rowid: EventRowID
+	room_id: RoomID
+	attempts: number
+	next_attempt_ts: number
+	last_error?: string
+}
+
+export interface DBScheduledEvent {
+	rowid: number
+	room_id: RoomID
+	type: EventType
+	content: unknown
+	disable_encryption?: boolean
+	send_at: number
+	delay_id?: string
+	created_at: number
+}
+
+export interface DBDraft {
+	room_id: RoomID
+	thread_id?: EventID
+	content: unknown
+	updated_at: number
+}
+
+export interface SpaceHierarchyRoom extends SpaceHierarchyChunk {
+	joined: boolean
+	local?: boolean
+}
+
+export interface SpaceHierarchyResponse {
+	rooms: SpaceHierarchyRoom[]
+	next_batch?: string
+	local?: boolean
+}
+
+export interface ThreadPaginationResponse extends PaginationResponse {
+	next_batch?: string
+}
+
+export interface ThreadSummary {
+	root_id: EventID
+	reply_count: number
+	latest_event_rowid: EventRowID
+	latest_timestamp: number
+	unread_highlights: number
+	unread_notifications: number
+	unread_messages: number
+}
+
+export interface ThreadListResponse {
+	threads: ThreadSummary[]
+	events: RawDBEvent[]
+	has_more: boolean
+}
+
+export interface SearchParams {
+	query: string
+	room_id?: RoomID
+	sender?: UserID
+	after?: number
+	before?: number
+	limit?: number
+	offset?: number
+}
+
+export interface SearchResult {
+	event: RawDBEvent
+	highlights: [number, number][]
+}
+
 export interface ResolveAliasResponse {
 	room_id: RoomID
 	servers: string[]
@@ -263,6 +387,18 @@ export interface ProfileDevice {
 	trust_state: TrustState
 }
 
+export interface OwnDevice {
+	device_id: DeviceID
+	display_name: string
+	last_seen_ip?: string
+	last_seen_ts: number
+	current: boolean
+	identity_key?: string
+	signing_key?: string
+	fingerprint?: string
+	trust_state: TrustState
+}
+
 export interface ProfileEncryptionInfo {
 	devices_tracked: boolean
 	devices: ProfileDevice[]
@@ -271,3 +407,43 @@ export interface ProfileEncryptionInfo {
 	user_trusted: boolean
 	errors: string[]
 }
+
+export type RoomPreset = "private_chat" | "trusted_private_chat" | "public_chat"
+
+export interface CreateRoomParams {
+	name?: string
+	topic?: string
+	room_alias_name?: string
+	preset?: RoomPreset
+	invite?: UserID[]
+	is_direct?: boolean
+	encrypted?: boolean
+	parent_id?: RoomID
+	room_version?: string
+	visibility?: "public" | "private"
+}
+
+export type MembershipAction = "invite" | "kick" | "ban" | "unban"
+
+export interface MembershipResult {
+	user_id: UserID
+	error?: string
+}
+
+export type CommandArgumentType = "text" | "string" | "user_id" | "event_type" | "json"
+
+export interface CommandArgument {
+	name: string
+	description?: string
+	type: CommandArgumentType
+	optional?: boolean
+}
+
+export interface Command {
+	name: string
+	aliases?: string[]
+	description: string
+	arguments?: CommandArgument[]
+	modifier?: boolean
+	action?: boolean
+}
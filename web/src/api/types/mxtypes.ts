@@ -24,6 +24,7 @@ export type ReceiptType = "m.read" | "m.read.private"
 export type RoomVersion = "1" | "2" | "3" | "4" | "5" | "6" | "7" | "8" | "9" | "10" | "11"
 export type RoomType = "" | "m.space"
 export type RelationType = "m.annotation" | "m.reference" | "m.replace" | "m.thread"
+export type PresenceState = "online" | "offline" | "unavailable"
 
 export interface RoomPredecessor {
 	room_id: RoomID
@@ -255,6 +256,13 @@ export interface ElementRecentEmoji {
 
 export type JoinRule = "public" | "knock" | "restricted" | "knock_restricted" | "invite" | "private"
 
+export interface JoinRuleAllow {
+	type: "m.room_membership"
+	room_id: RoomID
+}
+
+export type HistoryVisibility = "invited" | "joined" | "shared" | "world_readable"
+
 export interface RoomSummary {
 	room_id: RoomID
 	membership?: Membership
@@ -276,6 +284,26 @@ export interface RoomSummary {
 	world_readable: boolean
 }
 
+export interface SpaceHierarchyChildState {
+	type: "m.space.child"
+	state_key: RoomID
+	sender: UserID
+	content: {
+		via?: string[]
+		order?: string
+		suggested?: boolean
+	}
+	origin_server_ts: number
+}
+
+export interface SpaceHierarchyChunk extends Omit<RoomSummary, "membership" | "room_version" | "encryption"> {
+	children_state: SpaceHierarchyChildState[]
+}
+
 export interface RespRoomJoin {
 	room_id: RoomID
 }
+
+export interface RespCreateRoom {
+	room_id: RoomID
+}
@@ -13,11 +13,12 @@
 //
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <https://www.gnu.org/licenses/>.
-import RPCClient from "./rpc.ts"
+import RPCClient, { DEFAULT_ACCOUNT_ID } from "./rpc.ts"
 import type { RPCCommand } from "./types"
 
 const PING_INTERVAL = 15_000
 const RECV_TIMEOUT = 4 * PING_INTERVAL
+const CLOSE_SESSION_REVOKED = 4003
 
 function checkUpdate(etag: string) {
 	if (!import.meta.env.PROD) {
@@ -66,11 +67,16 @@ export default class WSClient extends RPCClient {
 		try {
 			this.#stopped = false
 			this.#lastMessage = Date.now()
-			const params = new URLSearchParams({
-				run_id: this.#resumeRunID,
-				last_received_event: this.#lastReceivedEvt.toString(),
-			}).toString()
-			const addr = this.#lastReceivedEvt && this.#resumeRunID ? `${this.addr}?${params}` : this.addr
+			const params = new URLSearchParams()
+			if (this.accountID !== DEFAULT_ACCOUNT_ID) {
+				params.set("account_id", this.accountID)
+			}
+			if (this.#lastReceivedEvt && this.#resumeRunID) {
+				params.set("run_id", this.#resumeRunID)
+				params.set("last_received_event", this.#lastReceivedEvt.toString())
+			}
+			const query = params.toString()
+			const addr = query ? `${this.addr}?${query}` : this.addr
 			console.info("Connecting to websocket", addr)
 			this.#conn = new WebSocket(addr)
 			this.#conn.onmessage = this.#onMessage
@@ -176,6 +182,13 @@ export default class WSClient extends RPCClient {
 			clearInterval(this.#pingInterval)
 			this.#pingInterval = null
 		}
+		if (ev.code === CLOSE_SESSION_REVOKED) {
+			// Reloading makes the frontend authenticate again, which will prompt for the password
+			this.#stopped = true
+			this.#dispatchConnectionStatus(false, false, "Session was revoked")
+			window.location.reload()
+			return
+		}
 		const willReconnect = !this.#stopped && !this.#reconnectTimeout
 		const backoff = Math.min(2 ** (this.#connectFailures - 4), 10) * 1000
 		this.#dispatchConnectionStatus(
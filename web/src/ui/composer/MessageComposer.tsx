@@ -15,6 +15,8 @@
 // along with this program.  If not, see <https://www.gnu.org/licenses/>.
 import React, { CSSProperties, use, useCallback, useEffect, useLayoutEffect, useReducer, useRef, useState } from "react"
 import { ScaleLoader } from "react-spinners"
+import type Client from "@/api/client.ts"
+import { accountQuery } from "@/api/media.ts"
 import { useRoomEvent } from "@/api/statestore"
 import type {
 	EventID,
@@ -79,7 +81,12 @@ const composerReducer = (
 })
 
 const draftStore = {
-	get: (roomID: RoomID): ComposerState | null => {
+	get: (client: Client, roomID: RoomID): ComposerState | null => {
+		const draft = client.store.drafts.get(roomID)
+		if (draft) {
+			return draft as ComposerState
+		}
+		// Drafts used to be stored in localStorage before being synced through the backend
 		const data = localStorage.getItem(`draft-${roomID}`)
 		if (!data) {
 			return null
@@ -90,8 +97,12 @@ const draftStore = {
 			return null
 		}
 	},
-	set: (roomID: RoomID, data: ComposerState) => localStorage.setItem(`draft-${roomID}`, JSON.stringify(data)),
-	clear: (roomID: RoomID) => localStorage.removeItem(`draft-${roomID}`),
+	set: (client: Client, roomID: RoomID, data: ComposerState) => {
+		client.saveDraft(roomID, data)
+	},
+	clear: (client: Client, roomID: RoomID) => {
+		client.saveDraft(roomID, null)
+	},
 }
 
 type CaretEvent<T> = React.MouseEvent<T> | React.KeyboardEvent<T> | React.ChangeEvent<T>
@@ -138,7 +149,7 @@ const MessageComposer = () => {
 	roomCtx.setEditing = useCallback((evt: MemDBEvent | null) => {
 		if (evt === null) {
 			rawSetEditing(null)
-			setState(draftStore.get(room.roomID) ?? emptyComposer)
+			setState(draftStore.get(client, room.roomID) ?? emptyComposer)
 			return
 		}
 		const evtContent = evt.content as MessageEventContent
@@ -162,7 +173,7 @@ const MessageComposer = () => {
 			explicitReplyInThread: false,
 		})
 		textInput.current?.focus()
-	}, [room.roomID])
+	}, [client, room.roomID])
 	const canSend = Boolean(state.text || state.media || state.location)
 	const onClickSend = (evt: React.FormEvent) => {
 		evt.preventDefault()
@@ -173,7 +184,7 @@ const MessageComposer = () => {
 	}
 	const doSendMessage = (state: ComposerState) => {
 		if (editing) {
-			setState(draftStore.get(room.roomID) ?? emptyComposer)
+			setState(draftStore.get(client, room.roomID) ?? emptyComposer)
 		} else {
 			setState(emptyComposer)
 		}
@@ -355,7 +366,7 @@ const MessageComposer = () => {
 		}
 		setLoadingMedia(true)
 		const encrypt = !!room.meta.current.encryption_event
-		fetch(`_gomuks/upload?encrypt=${encrypt}&filename=${encodeURIComponent(file.name)}`, {
+		fetch(`_gomuks/upload?encrypt=${encrypt}&filename=${encodeURIComponent(file.name)}${accountQuery}`, {
 			method: "POST",
 			body: file,
 		})
@@ -391,7 +402,7 @@ const MessageComposer = () => {
 	// To ensure the cursor jumps to the end, do this in an effect rather than as the initial value of useState
 	// To try to avoid the input bar flashing, use useLayoutEffect instead of useEffect
 	useLayoutEffect(() => {
-		const draft = draftStore.get(room.roomID)
+		const draft = draftStore.get(client, room.roomID)
 		setState(draft ?? emptyComposer)
 		setAutocomplete(null)
 		return () => {
@@ -404,6 +415,12 @@ const MessageComposer = () => {
 			}
 		}
 	}, [client, room])
+	// Update the composer when the draft is changed in another tab or device, unless an edit is in progress
+	useEffect(() => client.store.draftSubs.getSubscriber(room.roomID)(() => {
+		if (!editing) {
+			setState(draftStore.get(client, room.roomID) ?? emptyComposer)
+		}
+	}), [client, room.roomID, editing])
 	useLayoutEffect(() => {
 		if (!textInput.current) {
 			return
@@ -425,18 +442,18 @@ const MessageComposer = () => {
 		// scrollToBottom needs to be called when replies/attachments/etc change,
 		// so listen to state instead of only state.text
 	}, [state, roomCtx])
-	// Saving to localStorage could be done in the reducer, but that's not very proper, so do it in an effect.
+	// Saving the draft could be done in the reducer, but that's not very proper, so do it in an effect.
 	useEffect(() => {
 		roomCtx.isEditing.emit(editing !== null)
 		if (state.uninited || editing) {
 			return
 		}
 		if (!state.text && !state.media && !state.replyTo && !state.location) {
-			draftStore.clear(room.roomID)
+			draftStore.clear(client, room.roomID)
 		} else {
-			draftStore.set(room.roomID, state)
+			draftStore.set(client, room.roomID, state)
 		}
-	}, [roomCtx, room, state, editing])
+	}, [client, roomCtx, room, state, editing])
 	const clearMedia = useCallback(() => setState({ media: null, location: null }), [])
 	const onChangeLocation = useCallback((location: ComposerLocationValue) => setState({ location }), [])
 	const closeReply = useCallback((evt: React.MouseEvent) => {
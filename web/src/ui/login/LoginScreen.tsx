@@ -36,7 +36,7 @@ export const LoginScreen = ({ client }: LoginScreenProps) => {
 	const loginSSO = () => {
 		fetch("_gomuks/sso", {
 			method: "POST",
-			body: JSON.stringify({ homeserver_url: homeserverURL }),
+			body: JSON.stringify({ homeserver_url: homeserverURL, account_id: client.rpc.accountID }),
 			headers: { "Content-Type": "application/json" },
 		}).then(resp => resp.json()).then(
 			resp => {
@@ -0,0 +1,61 @@
+// gomuks - A Matrix client written in Go.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+import React, { useState } from "react"
+import type Client from "@/api/client.ts"
+import "./LoginScreen.css"
+
+export interface TOTPScreenProps {
+	client: Client
+}
+
+export const TOTPScreen = ({ client }: TOTPScreenProps) => {
+	const [code, setCode] = useState("")
+	const [error, setError] = useState("")
+	const [loading, setLoading] = useState(false)
+
+	const submit = (evt: React.FormEvent) => {
+		evt.preventDefault()
+		setLoading(true)
+		client.submitTOTP(code.trim()).then(
+			() => {},
+			err => {
+				setError(err.message)
+				setCode("")
+				setLoading(false)
+			},
+		)
+	}
+
+	return <main className="matrix-login">
+		<h1>gomuks web</h1>
+		<form onSubmit={submit}>
+			<p>Enter the code from your authenticator app, or one of your recovery codes.</p>
+			<input
+				type="text"
+				autoComplete="one-time-code"
+				autoFocus
+				id="mxlogin-totp"
+				placeholder="Two-factor authentication code"
+				value={code}
+				onChange={evt => setCode(evt.target.value)}
+			/>
+			<button className="mx-login-button primary-color-button" type="submit" disabled={loading}>Continue</button>
+		</form>
+		{error && <div className="error">
+			{error}
+		</div>}
+	</main>
+}
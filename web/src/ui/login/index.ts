@@ -1,2 +1,3 @@
 export { LoginScreen } from "./LoginScreen.tsx"
+export { TOTPScreen } from "./TOTPScreen.tsx"
 export { VerificationScreen } from "./VerificationScreen.tsx"
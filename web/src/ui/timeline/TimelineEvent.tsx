@@ -16,7 +16,7 @@
 import React, { JSX, use, useState } from "react"
 import { getAvatarURL, getMediaURL, getUserColorIndex } from "@/api/media.ts"
 import { useRoomMember } from "@/api/statestore"
-import { MemDBEvent, MemberEventContent, UnreadType } from "@/api/types"
+import { MemDBEvent, MemberEventContent, TrustState, UnreadType } from "@/api/types"
 import { isMobileDevice } from "@/util/ismobile.ts"
 import { getDisplayname, isEventID } from "@/util/validation.ts"
 import ClientContext from "../ClientContext.ts"
@@ -28,6 +28,7 @@ import { ReplyIDBody } from "./ReplyBody.tsx"
 import URLPreviews from "./URLPreviews.tsx"
 import { ContentErrorBoundary, HiddenEvent, getBodyType, isSmallEvent } from "./content"
 import { EventFullMenu, EventHoverMenu, getModalStyleFromMouse } from "./menu"
+import EncryptedQuestionIcon from "@/icons/encrypted-question.svg?react"
 import ErrorIcon from "@/icons/error.svg?react"
 import PendingIcon from "@/icons/pending.svg?react"
 import SentIcon from "@/icons/sent.svg?react"
@@ -73,6 +74,22 @@ const EventSendStatus = ({ evt }: { evt: MemDBEvent }) => {
 	}
 }
 
+const trustWarnings: Partial<Record<TrustState, string>> = {
+	"blacklisted": "Sent by a blacklisted device",
+	"unverified": "Sent by an unverified device",
+	"unknown-device": "Sent by an unknown or deleted device",
+	"forwarded": "The authenticity of this message can't be guaranteed",
+	"cross-signed-untrusted": "The sender's identity has changed",
+}
+
+const EventTrustWarning = ({ evt }: { evt: MemDBEvent }) => {
+	const warning = evt.trust_state ? trustWarnings[evt.trust_state] : undefined
+	if (!warning) {
+		return null
+	}
+	return <span className="event-trust-warning" title={warning}><EncryptedQuestionIcon/></span>
+}
+
 const TimelineEvent = ({ evt, prevEvt, disableMenu, smallReplies }: TimelineEventProps) => {
 	const roomCtx = useRoomContext()
 	const client = use(ClientContext)!
@@ -205,6 +222,7 @@ const TimelineEvent = ({ evt, prevEvt, disableMenu, smallReplies }: TimelineEven
 				{getDisplayname(evt.sender, memberEvtContent)}
 			</span>
 			<span className="event-time" title={fullTime}>{shortTime}</span>
+			<EventTrustWarning evt={evt}/>
 			{(editEventTS && editTime) ? <span className="event-edited" title={editTime}>
 				(edited at {formatShortTime(editEventTS)})
 			</span> : null}